@@ -3,6 +3,7 @@ module git.tcp.direct/kayos/ziggs
 go 1.20
 
 require (
+	dario.cat/mergo v1.0.0
 	git.tcp.direct/Mirrors/go-prompt v0.3.0
 	git.tcp.direct/kayos/common v0.8.6
 	git.tcp.direct/tcp.direct/database v0.0.0-20230326075721-ff39591cbe05
@@ -15,6 +16,7 @@ require (
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
 	github.com/lucasb-eyer/go-colorful v1.2.0
 	github.com/manifoldco/promptui v0.9.0
+	github.com/mattn/go-isatty v0.0.18
 	github.com/mazznoer/colorgrad v0.9.1
 	github.com/muesli/termenv v0.15.1
 	github.com/rs/zerolog v1.29.1
@@ -26,7 +28,6 @@ require (
 )
 
 require (
-	dario.cat/mergo v1.0.0 // indirect
 	dmitri.shuralyov.com/gpu/mtl v0.0.0-20201218220906-28db891af037 // indirect
 	gioui.org v0.0.0-20230404150518-c0d3f67b0468 // indirect
 	gioui.org/cpu v0.0.0-20210817075930-8d6a761490d2 // indirect
@@ -54,7 +55,6 @@ require (
 	github.com/jezek/xgb v1.0.0 // indirect
 	github.com/magiconair/properties v1.8.7 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
-	github.com/mattn/go-isatty v0.0.18 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/mattn/go-tty v0.0.4 // indirect
 	github.com/mazznoer/csscolorparser v0.1.2 // indirect
@@ -0,0 +1,534 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// buttonEvents are the names we accept for button events, next to the ones the bridge uses.
+var buttonEvents = map[string]string{
+	"press":        "initial_press",
+	"hold":         "repeat",
+	"release":      "short_release",
+	"long-release": "long_release",
+}
+
+// weekdays are the names days conditions accept.
+var weekdays = map[string][]time.Weekday{
+	"sun": {time.Sunday}, "mon": {time.Monday}, "tue": {time.Tuesday}, "wed": {time.Wednesday},
+	"thu": {time.Thursday}, "fri": {time.Friday}, "sat": {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+}
+
+// automationEngine runs the enabled automations whenever one of their triggers fires.
+type automationEngine struct {
+	automations []*data.Automation
+	// temps is the last temperature of every sensor, to tell when one crosses a threshold.
+	temps map[string]float64
+	// fired is the minute each automation last fired on the clock, so it fires only once.
+	fired map[string]string
+	// running keeps two runs of the same automation from interleaving their actions.
+	running map[string]*sync.Mutex
+	run     func(cmd string) bool
+	now     func() time.Time
+	// spawn runs the actions of an automation that fired, away from the events that made it fire,
+	// so slow actions don't hold up the events that come after them.
+	spawn func(f func())
+	*sync.RWMutex
+}
+
+var automations = &automationEngine{
+	temps:   make(map[string]float64),
+	fired:   make(map[string]string),
+	running: make(map[string]*sync.Mutex),
+	run:     func(cmd string) bool { return execute(cmd, false) },
+	now:     time.Now,
+	spawn:   func(f func()) { go f() },
+	RWMutex: &sync.RWMutex{},
+}
+
+// reload picks up changes to the stored automations.
+func (e *automationEngine) reload() error {
+	all, err := data.Automations()
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	e.automations = all
+	e.Unlock()
+	return nil
+}
+
+// StartAutomations runs the stored automations until ctx is done.
+func StartAutomations(ctx context.Context) error {
+	useLogger()
+	if err := automations.reload(); err != nil {
+		return err
+	}
+	events, stop := ziggy.Events.Subscribe(256)
+	go func() {
+		defer stop()
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				automations.handle(ev)
+			case <-ticker.C:
+				automations.tick(automations.now())
+			}
+		}
+	}()
+	return nil
+}
+
+// handle fires every enabled automation that has a trigger for ev.
+func (e *automationEngine) handle(ev ziggy.BridgeEvent) {
+	e.Lock()
+	var (
+		prev  float64
+		known bool
+	)
+	if ev.Temperature != nil {
+		key := ev.Bridge + ev.IdV1 + ev.Id
+		prev, known = e.temps[key]
+		e.temps[key] = ev.Temperature.Temperature
+	}
+	all := e.automations
+	e.Unlock()
+	for _, a := range all {
+		if !a.Enabled {
+			continue
+		}
+		for _, t := range a.Triggers {
+			if triggeredBy(t, ev, prev, known) {
+				e.fire(a, t.Kind+" "+ev.Name)
+				break
+			}
+		}
+	}
+}
+
+// tick fires every enabled automation that has a time trigger for the minute now is in.
+func (e *automationEngine) tick(now time.Time) {
+	minute := now.Format("2006-01-02 15:04")
+	e.Lock()
+	var due []*data.Automation
+	for _, a := range e.automations {
+		if !a.Enabled || e.fired[a.Name] == minute {
+			continue
+		}
+		for _, t := range a.Triggers {
+			if t.Kind == "time" && t.Value == now.Format("15:04") {
+				e.fired[a.Name] = minute
+				due = append(due, a)
+				break
+			}
+		}
+	}
+	e.Unlock()
+	for _, a := range due {
+		e.fire(a, "time "+now.Format("15:04"))
+	}
+}
+
+// fire runs the actions of a if its conditions hold, see spawn.
+func (e *automationEngine) fire(a *data.Automation, reason string) {
+	now := e.now()
+	for _, c := range a.Conditions {
+		if !conditionHolds(c, now) {
+			log.Debug().Str("automation", a.Name).Str("trigger", reason).Msgf("condition %s %s doesn't hold", c.Kind, c.Value)
+			return
+		}
+	}
+	e.Lock()
+	running, ok := e.running[a.Name]
+	if !ok {
+		running = &sync.Mutex{}
+		e.running[a.Name] = running
+	}
+	e.Unlock()
+	e.spawn(func() {
+		running.Lock()
+		defer running.Unlock()
+		log.Info().Str("automation", a.Name).Str("trigger", reason).Msg("running automation")
+		for _, action := range a.Actions {
+			if !e.run(action) {
+				log.Warn().Str("automation", a.Name).Msgf("action failed: %s", action)
+				return
+			}
+		}
+	})
+}
+
+// targets reports whether target, a name, v2 ID, v1 path or bridge-qualified name, is what ev is about.
+func targets(target string, ev ziggy.BridgeEvent) bool {
+	if target == "" || target == "any" {
+		return true
+	}
+	if bridge, name, ok := strings.Cut(target, ":"); ok &&
+		(strings.EqualFold(bridge, ev.Alias) || strings.EqualFold(bridge, ev.Bridge)) {
+		target = name
+	}
+	return strings.EqualFold(target, ev.Name) || strings.EqualFold(target, ev.Id) ||
+		(ev.IdV1 != "" && strings.Trim(target, "/") == strings.Trim(ev.IdV1, "/"))
+}
+
+// triggeredBy reports whether ev fires t. prev is the last temperature of the sensor ev is about, if known.
+func triggeredBy(t data.Trigger, ev ziggy.BridgeEvent, prev float64, known bool) bool {
+	if ev.Kind != "update" || !targets(t.Target, ev) {
+		return false
+	}
+	switch t.Kind {
+	case "button":
+		if ev.Button == nil {
+			return false
+		}
+		if t.Button != 0 && (ev.Metadata == nil || ev.Metadata.ControlID != t.Button) {
+			return false
+		}
+		event := ev.Button.LastEvent
+		if ev.Button.ButtonReport.Event != "" {
+			event = ev.Button.ButtonReport.Event
+		}
+		return event == t.Event
+	case "motion":
+		if ev.Motion == nil {
+			return false
+		}
+		return ev.Motion.Motion == (t.Event != "cleared")
+	case "temperature":
+		if ev.Temperature == nil || !known {
+			return false
+		}
+		threshold, err := strconv.ParseFloat(t.Value, 64)
+		if err != nil {
+			return false
+		}
+		cur := ev.Temperature.Temperature
+		if t.Event == "below" {
+			return prev >= threshold && cur < threshold
+		}
+		return prev <= threshold && cur > threshold
+	case "light":
+		if ev.Type != "light" && ev.Type != "grouped_light" {
+			return false
+		}
+		switch t.Event {
+		case "on", "off":
+			return ev.On != nil && ev.On.On == (t.Event == "on")
+		default:
+			return ev.On != nil || ev.Dimming != nil || ev.Color != nil || ev.ColorTemperature != nil
+		}
+	}
+	return false
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day, expected e.g. 07:30: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// conditionHolds reports whether c is true at now.
+func conditionHolds(c data.Condition, now time.Time) bool {
+	switch c.Kind {
+	case "time":
+		from, to, _ := strings.Cut(c.Value, "-")
+		start, err := parseClock(from)
+		if err != nil {
+			return false
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return false
+		}
+		cur := now.Hour()*60 + now.Minute()
+		if start <= end {
+			return cur >= start && cur < end
+		}
+		// e.g. 22:00-06:00 goes past midnight.
+		return cur >= start || cur < end
+	case "days":
+		for _, name := range strings.Split(c.Value, ",") {
+			for _, day := range weekdays[name] {
+				if day == now.Weekday() {
+					return true
+				}
+			}
+		}
+		return false
+	case "light":
+		l, ok := ziggy.LookupLight(c.Target, nil)
+		if !ok {
+			return false
+		}
+		st := l.CurrentState()
+		if st == nil {
+			log.Debug().Str("light", c.Target).Msg("state unknown, the condition can't hold")
+			return false
+		}
+		return st.On == (c.Value == "on")
+	case "group":
+		g, ok := ziggy.LookupGroup(c.Target, nil)
+		if !ok {
+			return false
+		}
+		return g.AnyOn() == (c.Value == "on")
+	}
+	return false
+}
+
+func parseTrigger(fields []string) (t data.Trigger, err error) {
+	if len(fields) < 2 {
+		return t, errors.New("incomplete trigger, expected e.g. `when motion hallway`")
+	}
+	t.Kind = fields[0]
+	if t.Kind == "time" {
+		if _, err = parseClock(fields[1]); err != nil {
+			return t, err
+		}
+		t.Value = fields[1]
+		return t, nil
+	}
+	t.Target, fields = fields[1], fields[2:]
+	switch t.Kind {
+	case "button":
+		t.Event = "short_release"
+		for _, f := range fields {
+			if n, nerr := strconv.Atoi(f); nerr == nil && n > 0 {
+				t.Button = n
+				continue
+			}
+			if event, ok := buttonEvents[f]; ok {
+				f = event
+			}
+			switch f {
+			case "initial_press", "repeat", "short_release", "long_release", "long_press":
+				t.Event = f
+			default:
+				return t, fmt.Errorf("unknown button event: %s", f)
+			}
+		}
+	case "motion":
+		t.Event = "detected"
+		if len(fields) > 0 {
+			t.Event = fields[0]
+		}
+		if t.Event != "detected" && t.Event != "cleared" || len(fields) > 1 {
+			return t, errors.New("expected `when motion <sensor> [detected|cleared]`")
+		}
+	case "temperature":
+		if len(fields) != 2 || fields[0] != "above" && fields[0] != "below" {
+			return t, errors.New("expected `when temperature <sensor> above|below <degrees>`")
+		}
+		if _, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return t, fmt.Errorf("invalid temperature: %s", fields[1])
+		}
+		t.Event, t.Value = fields[0], fields[1]
+	case "light":
+		t.Event = "changed"
+		if len(fields) > 0 {
+			t.Event = fields[0]
+		}
+		if t.Event != "on" && t.Event != "off" && t.Event != "changed" || len(fields) > 1 {
+			return t, errors.New("expected `when light <light> [on|off|changed]`")
+		}
+	default:
+		return t, fmt.Errorf("unknown trigger: %s", t.Kind)
+	}
+	return t, nil
+}
+
+func parseCondition(fields []string) (c data.Condition, err error) {
+	if len(fields) < 2 {
+		return c, errors.New("incomplete condition, expected e.g. `if time 22:00-06:00`")
+	}
+	c.Kind = fields[0]
+	switch c.Kind {
+	case "time":
+		from, to, ok := strings.Cut(fields[1], "-")
+		if !ok || len(fields) > 2 {
+			return c, errors.New("expected `if time <from>-<to>`")
+		}
+		if _, err = parseClock(from); err != nil {
+			return c, err
+		}
+		if _, err = parseClock(to); err != nil {
+			return c, err
+		}
+		c.Value = fields[1]
+	case "days":
+		c.Value = strings.ToLower(fields[1])
+		for _, name := range strings.Split(c.Value, ",") {
+			if _, ok := weekdays[name]; !ok {
+				return c, fmt.Errorf("unknown day: %s", name)
+			}
+		}
+	case "light", "group":
+		if len(fields) != 3 || fields[2] != "on" && fields[2] != "off" {
+			return c, fmt.Errorf("expected `if %s <name> on|off`", c.Kind)
+		}
+		c.Target, c.Value = fields[1], fields[2]
+	default:
+		return c, fmt.Errorf("unknown condition: %s", c.Kind)
+	}
+	return c, nil
+}
+
+// parseAutomation parses `when <trigger>... [if <condition>]... do <command>...`.
+func parseAutomation(name string, fields []string) (*data.Automation, error) {
+	a := &data.Automation{Name: name, Enabled: true, Created: time.Now()}
+	var sections [][]string
+	for _, f := range fields {
+		switch f {
+		case "when", "if", "do":
+			sections = append(sections, []string{f})
+			continue
+		}
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("expected when, if or do, got %s", f)
+		}
+		sections[len(sections)-1] = append(sections[len(sections)-1], f)
+	}
+	for _, s := range sections {
+		var err error
+		switch s[0] {
+		case "when":
+			var t data.Trigger
+			t, err = parseTrigger(s[1:])
+			a.Triggers = append(a.Triggers, t)
+		case "if":
+			var c data.Condition
+			c, err = parseCondition(s[1:])
+			a.Conditions = append(a.Conditions, c)
+		case "do":
+			if len(s) < 2 {
+				return nil, errors.New("do needs a command")
+			}
+			if _, ok := Commands[s[1]]; !ok {
+				return nil, fmt.Errorf("unknown command: %s", s[1])
+			}
+			a.Actions = append(a.Actions, joinArgs(s[1:]))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(a.Triggers) == 0 || len(a.Actions) == 0 {
+		return nil, errors.New("an automation needs at least one trigger and one action")
+	}
+	return a, nil
+}
+
+// describeAutomation puts a back the way it was added.
+func describeAutomation(a *data.Automation) string {
+	var parts []string
+	for _, t := range a.Triggers {
+		p := []string{"when", t.Kind}
+		if t.Target != "" {
+			p = append(p, strconv.Quote(t.Target))
+		}
+		if t.Button != 0 {
+			p = append(p, strconv.Itoa(t.Button))
+		}
+		for _, s := range []string{t.Event, t.Value} {
+			if s != "" {
+				p = append(p, s)
+			}
+		}
+		parts = append(parts, strings.Join(p, " "))
+	}
+	for _, c := range a.Conditions {
+		p := []string{"if", c.Kind}
+		if c.Target != "" {
+			p = append(p, strconv.Quote(c.Target))
+		}
+		parts = append(parts, strings.Join(append(p, c.Value), " "))
+	}
+	for _, action := range a.Actions {
+		parts = append(parts, "do "+action)
+	}
+	return strings.Join(parts, " ")
+}
+
+// cmdAutomation manages the automations ziggs runs locally.
+//
+//	automation add <name> when <trigger>... [if <condition>]... do <command>...
+//	automation list
+//	automation enable|disable|delete <name>
+func cmdAutomation(_ *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return ErrNotEnoughArguments
+	}
+	switch args[0] {
+	case "list", "ls":
+		all, err := data.Automations()
+		if err != nil {
+			return err
+		}
+		for _, a := range all {
+			state := "enabled"
+			if !a.Enabled {
+				state = "disabled"
+			}
+			log.Info().Str("state", state).Msgf("%s: %s", a.Name, describeAutomation(a))
+		}
+		return nil
+	case "add", "enable", "disable", "delete", "rm":
+	default:
+		return fmt.Errorf("unknown automation action: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("no automation name specified")
+	}
+	var (
+		err    error
+		fields = args[1:]
+		name   = fields[0]
+	)
+	switch args[0] {
+	case "add":
+		if _, err = data.GetAutomation(name); err == nil {
+			return fmt.Errorf("automation %s already exists", name)
+		}
+		a, perr := parseAutomation(name, fields[1:])
+		if perr != nil {
+			return perr
+		}
+		if err = data.PutAutomation(a); err != nil {
+			return fmt.Errorf("failed to save automation: %w", err)
+		}
+		log.Info().Msgf("added automation %s: %s", name, describeAutomation(a))
+	case "enable", "disable":
+		a, gerr := data.GetAutomation(name)
+		if gerr != nil {
+			return gerr
+		}
+		a.Enabled = args[0] == "enable"
+		if err = data.PutAutomation(a); err != nil {
+			return fmt.Errorf("failed to save automation: %w", err)
+		}
+		log.Info().Msgf("%sd automation %s", args[0], name)
+	default:
+		if err = data.DeleteAutomation(name); err != nil {
+			return err
+		}
+		log.Info().Msgf("deleted automation %s", name)
+	}
+	return automations.reload()
+}
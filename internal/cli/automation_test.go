@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/haptic"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdAutomation(t *testing.T) {
+	data.StartTest()
+	fb, _ := newTestBridge(t)
+	var ran []string
+	automations.run = func(cmd string) bool {
+		ran = append(ran, cmd)
+		return true
+	}
+	t.Cleanup(func() { automations.run = func(cmd string) bool { return execute(cmd, false) } })
+	automations.spawn = func(f func()) { f() }
+	t.Cleanup(func() { automations.spawn = func(f func()) { go f() } })
+
+	// the executor splits command lines into words the same way.
+	add, err := splitWords(`add night when motion "hallway motion" if time 22:00-06:00 do set light lamp on do set light desk on`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAutomation(nil, add); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAutomation(nil, add); err == nil {
+		t.Error("adding the same automation twice should fail")
+	}
+	for _, bad := range []string{
+		"add x when motion hallway",
+		"add x do set light lamp on",
+		"add x when sunrise do set light lamp on",
+		"add x when time 25:00 do set light lamp on",
+		"add x when motion hallway do frobnicate",
+		"add x when motion hallway if days someday do set light lamp on",
+	} {
+		if err := cmdAutomation(nil, strings.Fields(bad)); err == nil {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	if err := cmdAutomation(nil, strings.Fields("add warm when temperature any above 25 if light lamp off do set light lamp off")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAutomation(nil, strings.Fields("add wake when time 07:30 when button dimmer 1 press do set light lamp on")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAutomation(nil, []string{"list"}); err != nil {
+		t.Error(err)
+	}
+
+	night := time.Date(2026, 1, 1, 23, 0, 0, 0, time.Local)
+	automations.now = func() time.Time { return night }
+	t.Cleanup(func() { automations.now = time.Now })
+	motion := ziggy.BridgeEvent{Bridge: fb.ID, Kind: "update", Name: "hallway motion",
+		Event: haptic.Event{IdV1: "/sensors/1", Type: "motion", Motion: &haptic.Motion{Motion: true}}}
+	automations.handle(motion)
+	if len(ran) != 2 || ran[0] != "set light lamp on" || ran[1] != "set light desk on" {
+		t.Errorf("expected both actions of night, got %v", ran)
+	}
+	ran = nil
+	automations.now = func() time.Time { return night.Add(12 * time.Hour) }
+	automations.handle(motion)
+	if len(ran) != 0 {
+		t.Errorf("night shouldn't run during the day, got %v", ran)
+	}
+	automations.now = func() time.Time { return night }
+	if err := cmdAutomation(nil, []string{"disable", "night"}); err != nil {
+		t.Fatal(err)
+	}
+	automations.handle(motion)
+	if len(ran) != 0 {
+		t.Errorf("disabled automations shouldn't run, got %v", ran)
+	}
+
+	// thresholds fire when they are crossed, not on every reading past them.
+	for _, temp := range []float64{24, 26, 27, 24, 26} {
+		automations.handle(ziggy.BridgeEvent{Bridge: fb.ID, Kind: "update", Name: "hallway temperature",
+			Event: haptic.Event{IdV1: "/sensors/2", Type: "temperature", Temperature: &haptic.Temperature{Temperature: temp}}})
+	}
+	if len(ran) != 2 {
+		t.Errorf("expected warm to run twice, got %v", ran)
+	}
+	ran = nil
+	automations.handle(ziggy.BridgeEvent{Bridge: fb.ID, Kind: "update", Name: "dimmer", Event: haptic.Event{Type: "button",
+		Metadata: &haptic.Metadata{ControlID: 2}, Button: &haptic.Button{LastEvent: "initial_press"}}})
+	automations.handle(ziggy.BridgeEvent{Bridge: fb.ID, Kind: "update", Name: "dimmer", Event: haptic.Event{Type: "button",
+		Metadata: &haptic.Metadata{ControlID: 1}, Button: &haptic.Button{LastEvent: "initial_press"}}})
+	if len(ran) != 1 {
+		t.Errorf("expected wake to run for button 1 only, got %v", ran)
+	}
+	ran = nil
+	wake := time.Date(2026, 1, 1, 7, 30, 0, 0, time.Local)
+	automations.tick(wake)
+	automations.tick(wake.Add(20 * time.Second))
+	automations.tick(wake.Add(time.Minute))
+	if len(ran) != 1 {
+		t.Errorf("expected wake to run once at 07:30, got %v", ran)
+	}
+
+	// actions go through the executor like anything typed at the prompt.
+	automations.run = func(cmd string) bool { return execute(cmd, false) }
+	if err := cmdAutomation(nil, []string{"enable", "night"}); err != nil {
+		t.Fatal(err)
+	}
+	automations.handle(motion)
+	if on, _ := fb.LightState("2")["on"].(bool); !on {
+		t.Error("night didn't turn on the desk")
+	}
+
+	// slow actions don't hold up the events that come after them.
+	automations.spawn = func(f func()) { go f() }
+	release, started := make(chan struct{}), make(chan string, 2)
+	automations.run = func(cmd string) bool {
+		started <- cmd
+		<-release
+		return true
+	}
+	handled := make(chan struct{})
+	go func() {
+		automations.handle(motion)
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handling an event waited for the actions to finish")
+	}
+	close(release)
+	<-started
+	<-started
+
+	for _, name := range []string{"night", "warm", "wake"} {
+		if err := cmdAutomation(nil, []string{"delete", name}); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := cmdAutomation(nil, []string{"delete", "night"}); err == nil {
+		t.Error("deleting an unknown automation should fail")
+	}
+}
@@ -0,0 +1,298 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func ago(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// sortedBridges returns the bridges in Lucifer sorted by the key that the `use` command expects.
+func sortedBridges() (keys []string, bridges []*ziggy.Bridge) {
+	ziggy.Lucifer.RLock()
+	defer ziggy.Lucifer.RUnlock()
+	for k := range ziggy.Lucifer.Bridges {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		bridges = append(bridges, ziggy.Lucifer.Bridges[k])
+	}
+	return
+}
+
+// cmdBridges shows the health of every bridge we're connected to, `bridges probe` checks on them first.
+// Bridges in the config that we aren't connected to are listed after them, with whether they're reachable.
+func cmdBridges(br *ziggy.Bridge, args []string) error {
+	keys, bridges := sortedBridges()
+	if len(bridges) == 0 && len(config.KnownBridges) == 0 {
+		return fmt.Errorf("no bridges connected")
+	}
+	probe := len(args) > 0 && (args[0] == "probe" || args[0] == "-p")
+	for i, c := range bridges {
+		h := c.Health()
+		if probe {
+			h = ziggy.Supervisor.Probe(c)
+		}
+		e := log.Info().Str("caller", keys[i]).Str("id", c.Info.BridgeID).
+			Str("state", h.State.String())
+		if alias := c.Alias(); alias != "" {
+			e = e.Str("alias", alias)
+		}
+		if proxy := c.Config().Proxy; proxy != "" {
+			e = e.Str("proxy", proxy)
+		}
+		if keys[i] == sel.Bridge {
+			e = e.Bool("selected", true)
+		}
+		if !h.LastSeen.IsZero() {
+			e = e.Dur("latency", h.Latency).Str("seen", ago(h.LastSeen))
+		}
+		if !h.Since.IsZero() {
+			e = e.Str("since", ago(h.Since))
+		}
+		if q := c.Queue(); q.Lights+q.Groups > 0 || q.Coalesced > 0 {
+			e = e.Int("queued_lights", q.Lights).Int("queued_groups", q.Groups).Int("coalesced", q.Coalesced)
+		}
+		if h.LastError != nil {
+			e = e.Str("last_error", h.LastError.Error()).Str("failed", ago(h.LastErrorAt))
+		}
+		e.Msg(c.Info.Name)
+	}
+	disconnectedBridges()
+	return nil
+}
+
+// disconnectedBridges lists the bridges in the config that we aren't connected to, and whether they're reachable.
+func disconnectedBridges() {
+	type result struct {
+		kb  config.KnownBridge
+		err error
+	}
+	var results []*result
+	wg := &sync.WaitGroup{}
+	for _, kb := range config.KnownBridges {
+		if _, ok := ziggy.FindBridge(kb.Hostname); ok {
+			continue
+		}
+		r := &result{kb: kb}
+		results = append(results, r)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.err = ziggy.Reachable(r.kb)
+		}()
+	}
+	wg.Wait()
+	for _, r := range results {
+		e := log.Info().Str("state", "disconnected").Bool("reachable", r.err == nil).Bool("paired", r.kb.Username != "")
+		if r.kb.Alias != "" {
+			e = e.Str("alias", r.kb.Alias)
+		}
+		if r.kb.Proxy != "" {
+			e = e.Str("proxy", r.kb.Proxy)
+		}
+		if r.err != nil {
+			e = e.Str("error", r.err.Error())
+		}
+		e.Msg(r.kb.Hostname)
+	}
+}
+
+// healthStatus summarizes the health of the selected bridge, or of every bridge when none is selected, for our prompt.
+func healthStatus() string {
+	ziggy.Lucifer.RLock()
+	br, ok := ziggy.Lucifer.Bridges[sel.Bridge]
+	ziggy.Lucifer.RUnlock()
+	if ok {
+		if h := br.Health(); h.State != ziggy.Connected {
+			return " " + h.State.String()
+		}
+		return ""
+	}
+	counts := make(map[ziggy.Health]int)
+	_, bridges := sortedBridges()
+	for _, c := range bridges {
+		counts[c.Health().State]++
+	}
+	var status []string
+	for _, state := range []ziggy.Health{ziggy.Degraded, ziggy.Offline} {
+		if counts[state] > 0 {
+			status = append(status, fmt.Sprintf("%d %s", counts[state], state))
+		}
+	}
+	if len(status) == 0 {
+		return ""
+	}
+	return " " + strings.Join(status, ", ")
+}
+
+// knownBridge returns the index in config.KnownBridges of the bridge that key refers to, by hostname, alias,
+// or anything else `use` accepts for bridges we're connected to.
+func knownBridge(key string) (int, error) {
+	if i := config.KnownBridgeIndex(key); i >= 0 {
+		return i, nil
+	}
+	if c, ok := ziggy.FindBridge(key); ok {
+		if i := config.KnownBridgeIndex(c.Config().Hostname); i >= 0 {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no bridge %s in the config", key)
+}
+
+// cmdBridge manages the bridges in our config file.
+//
+//	bridge list
+//	bridge add <host> [username] [--alias <alias>] [--proxy <proxy>]
+//	bridge remove <bridge>
+//	bridge alias <bridge> <alias>
+//	bridge set-proxy <bridge> <proxy|none>
+func cmdBridge(_ *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return ErrNotEnoughArguments
+	}
+	switch args[0] {
+	case "list", "ls":
+		return cmdBridges(nil, args[1:])
+	case "add":
+		return bridgeAdd(args[1:])
+	case "remove", "rm":
+	case "alias", "set-proxy", "proxy":
+		if len(args) < 3 {
+			return ErrNotEnoughArguments
+		}
+	default:
+		return fmt.Errorf("unknown bridge action: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("no bridge specified")
+	}
+	i, err := knownBridge(args[1])
+	if err != nil {
+		return err
+	}
+	kb := config.KnownBridges[i]
+	c, connected := ziggy.FindBridge(kb.Hostname)
+
+	switch args[0] {
+	case "remove", "rm":
+		if err = config.RemoveKnownBridge(i); err != nil {
+			return err
+		}
+		if connected {
+			ziggy.Disconnect(c)
+		}
+		log.Info().Str("host", kb.Hostname).Msg("removed bridge")
+		return nil
+	case "alias":
+		alias := args[2]
+		if err = checkAlias(alias, i); err != nil {
+			return err
+		}
+		config.KnownBridges[i].Alias = alias
+		if err = config.SaveKnownBridges(); err != nil {
+			return err
+		}
+		if connected {
+			c.SetAlias(alias)
+		}
+		log.Info().Str("host", kb.Hostname).Msgf("bridge is now known as %s", alias)
+		return nil
+	default:
+		proxy := args[2]
+		if proxy == "none" || proxy == "off" {
+			proxy = ""
+		}
+		config.KnownBridges[i].Proxy = proxy
+		if err = config.SaveKnownBridges(); err != nil {
+			return err
+		}
+		log.Info().Str("host", kb.Hostname).Str("proxy", proxy).Msg("proxy updated")
+		if !connected {
+			return nil
+		}
+		// the proxy is part of how we talk to the bridge, so reconnect with it.
+		ziggy.Disconnect(c)
+		if _, err = ziggy.Connect(config.KnownBridges[i]); err != nil {
+			return fmt.Errorf("failed to reconnect: %w", err)
+		}
+		return nil
+	}
+}
+
+// checkAlias returns an error if alias can't name a bridge, or if it already names another bridge than
+// the one at index except of config.KnownBridges.
+func checkAlias(alias string, except int) error {
+	if strings.ContainsAny(alias, ":/ ") {
+		return errors.New("aliases can't contain ':', '/' or spaces")
+	}
+	if j := config.KnownBridgeIndex(alias); j >= 0 && j != except {
+		return fmt.Errorf("%s is already used by %s", alias, config.KnownBridges[j].Hostname)
+	}
+	return nil
+}
+
+func bridgeAdd(args []string) error {
+	var kb config.KnownBridge
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--alias", "--proxy":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", args[i])
+			}
+			if args[i] == "--alias" {
+				kb.Alias = args[i+1]
+			} else {
+				kb.Proxy = args[i+1]
+			}
+			i++
+		default:
+			switch {
+			case kb.Hostname == "":
+				kb.Hostname = args[i]
+			case kb.Username == "":
+				kb.Username = args[i]
+			default:
+				return fmt.Errorf("unexpected argument: %s", args[i])
+			}
+		}
+	}
+	if kb.Hostname == "" {
+		return errors.New("no bridge host specified")
+	}
+	if !strings.Contains(kb.Hostname, "://") {
+		kb.Hostname = "http://" + kb.Hostname
+	}
+	if config.KnownBridgeIndex(kb.Hostname) >= 0 {
+		return fmt.Errorf("%s is already in the config", kb.Hostname)
+	}
+	if kb.Alias != "" {
+		if err := checkAlias(kb.Alias, -1); err != nil {
+			return err
+		}
+	}
+	if err := config.AddKnownBridge(kb); err != nil {
+		return err
+	}
+	log.Info().Str("host", kb.Hostname).Msg("added bridge")
+	if kb.Username == "" {
+		log.Info().Msgf("use 'pair %s' to log in to it", kb.Hostname)
+		return nil
+	}
+	c, err := ziggy.Connect(kb)
+	if err != nil {
+		return fmt.Errorf("added, but failed to connect: %w", err)
+	}
+	log.Info().Str("id", c.Info.BridgeID).Int("lights", len(c.Lights())).Msg("connected")
+	return nil
+}
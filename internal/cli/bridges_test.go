@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdBridges(t *testing.T) {
+	fb, br := newTestBridge(t)
+	sel.Bridge = ""
+	if err := cmdBridges(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := healthStatus(); status != "" {
+		t.Errorf("expected no health status for a connected bridge, got %q", status)
+	}
+	fb.SetOffline(true)
+	for i := 0; i < ziggy.Supervisor.OfflineAfter; i++ {
+		if err := cmdBridges(br, []string{"probe"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := healthStatus(); status != " 1 offline" {
+		t.Errorf("unexpected health status: %q", status)
+	}
+	sel.Bridge = br.Info.IPAddress
+	t.Cleanup(func() { sel.Bridge = "" })
+	if status := healthStatus(); status != " offline" {
+		t.Errorf("unexpected health status for the selected bridge: %q", status)
+	}
+}
+
+func TestCmdBridge(t *testing.T) {
+	_, c := newTestBridge(t)
+	oldConfig := config.Snek.ConfigFileUsed()
+	config.Snek.SetConfigFile(filepath.Join(t.TempDir(), "config.toml"))
+	t.Cleanup(func() { config.Snek.SetConfigFile(oldConfig) })
+	other := fakebridge.New()
+	t.Cleanup(other.Close)
+	other.AddLight("porch")
+
+	if err := cmdBridge(nil, []string{"add", other.Hostname(), other.NewUser("ziggs#test"), "--alias", "outside"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdBridge(nil, []string{"add", other.Hostname()}); err == nil {
+		t.Error("adding the same bridge twice should fail")
+	}
+	for _, alias := range []string{"outside", "in side", "out:side"} {
+		if err := cmdBridge(nil, []string{"add", "192.0.2.1", "--alias", alias}); err == nil {
+			t.Errorf("adding a bridge as %q should fail", alias)
+		}
+	}
+	if len(config.KnownBridges) != 2 || config.KnownBridges[1].Alias != "outside" {
+		t.Fatalf("bridge wasn't added: %+v", config.KnownBridges)
+	}
+	added, ok := ziggy.FindBridge("outside")
+	if !ok || added.Info.BridgeID != other.ID {
+		t.Fatal("added bridge isn't connected under its alias")
+	}
+
+	if err := cmdBridge(nil, []string{"alias", c.Host, "outside"}); err == nil {
+		t.Error("aliases should be unique")
+	}
+	if err := cmdBridge(nil, []string{"alias", c.Host, "in side"}); err == nil {
+		t.Error("aliases with spaces should be rejected")
+	}
+	if err := cmdBridge(nil, []string{"alias", c.Host, "inside"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.KnownBridges[0].Alias != "inside" || c.Alias() != "inside" {
+		t.Errorf("alias wasn't set: %+v", config.KnownBridges[0])
+	}
+	Executor("use inside")
+	if sel.Bridge != c.Info.IPAddress {
+		t.Errorf("use by alias selected %q", sel.Bridge)
+	}
+
+	if err := cmdBridge(nil, []string{"list"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmdBridge(nil, []string{"set-proxy", "outside", "socks5://127.0.0.1:1"}); err == nil {
+		t.Error("reconnecting through a dead proxy should fail")
+	}
+	if err := cmdBridge(nil, []string{"set-proxy", "outside", "none"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.KnownBridges[1].Proxy != "" {
+		t.Errorf("proxy wasn't cleared: %+v", config.KnownBridges[1])
+	}
+
+	if err := cmdBridge(nil, []string{"remove", "outside"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.KnownBridges) != 1 {
+		t.Errorf("bridge wasn't removed: %+v", config.KnownBridges)
+	}
+	if _, ok = ziggy.FindBridge(other.ID); ok {
+		t.Error("removed bridge is still connected")
+	}
+	if err := cmdBridge(nil, []string{"remove", "outside"}); err == nil {
+		t.Error("removing an unknown bridge should fail")
+	}
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// historyPruneInterval is how often changes older than config.HistoryRetention are dropped.
+var historyPruneInterval = time.Hour
+
+// changeOf turns an event into the change we keep in the history.
+func changeOf(ev ziggy.BridgeEvent) data.Change {
+	resource := ev.IdV1
+	if resource == "" {
+		resource = ev.Id
+	}
+	return data.Change{
+		Time:     ev.Time,
+		Bridge:   ev.Bridge,
+		Resource: resource,
+		Name:     ev.Name,
+		Type:     ev.Type,
+		Kind:     ev.Kind,
+		Change:   strings.Join(eventChanges(ev), " "),
+		Source:   ev.Source,
+	}
+}
+
+func pruneHistory() {
+	if config.HistoryRetention <= 0 {
+		return
+	}
+	pruned, err := data.PruneChanges(time.Now().Add(-config.HistoryRetention))
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to prune history")
+		return
+	}
+	if pruned > 0 {
+		log.Debug().Int("pruned", pruned).Msg("pruned history")
+	}
+}
+
+// StartHistory keeps every change the bridges report in the history until ctx is done,
+// forgetting changes older than config.HistoryRetention. Replayed events are left out.
+func StartHistory(ctx context.Context) {
+	useLogger()
+	pruneHistory()
+	events, stop := ziggy.Events.Subscribe(256)
+	go func() {
+		defer stop()
+		ticker := time.NewTicker(historyPruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				if ev.Replayed {
+					continue
+				}
+				if err := data.RecordChange(changeOf(ev)); err != nil {
+					log.Warn().Err(err).Msg("failed to record change")
+				}
+			case <-ticker.C:
+				pruneHistory()
+			}
+		}
+	}()
+}
+
+// parseDuration parses how far back to look or how long to wait, as a duration or a number of days, e.g. 90m or 7d.
+func parseDuration(arg string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(arg, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration: %s", arg)
+		}
+		return time.Duration(n * 24 * float64(time.Hour)), nil
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", arg)
+	}
+	return d, nil
+}
+
+// describeChange returns a line that tells a person what changed, when, and who changed it.
+func describeChange(c data.Change) string {
+	name := c.Name
+	if name == "" {
+		name = c.Resource
+	}
+	line := c.Time.Local().Format("2006-01-02 15:04:05") + " " + strconv.Quote(name) + " " + c.Type
+	if c.Kind != "update" {
+		line += " " + c.Kind
+	}
+	if c.Change != "" {
+		line += " " + c.Change
+	}
+	return line + " (" + c.Source + ")"
+}
+
+// cmdHistory lists the changes the bridges reported for a light, group or sensor, or for everything,
+// oldest first.
+//
+//	history <target|all> [--since <duration>] [--bridge <bridge>] [--json]
+func cmdHistory(_ *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return errors.New("no target specified, use a name or all")
+	}
+	var (
+		target = args[0]
+		since  = 24 * time.Hour
+		bridge string
+		asJSON bool
+	)
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--json" {
+			asJSON = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s needs a value", args[i])
+		}
+		flag, val := args[i], args[i+1]
+		i++
+		switch flag {
+		case "--since", "-s":
+			d, err := parseDuration(val)
+			if err != nil {
+				return err
+			}
+			since = d
+		case "--bridge", "-b":
+			c, ok := ziggy.FindBridge(val)
+			if !ok {
+				return fmt.Errorf("no bridge %s", val)
+			}
+			bridge = c.Info.BridgeID
+		default:
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+	if b, name, ok := strings.Cut(target, ":"); ok && bridge == "" {
+		if c, found := ziggy.FindBridge(b); found {
+			bridge, target = c.Info.BridgeID, name
+		}
+	}
+	changes, err := data.Changes(time.Now().Add(-since), func(c data.Change) bool {
+		if bridge != "" && !strings.EqualFold(bridge, c.Bridge) {
+			return false
+		}
+		return target == "all" || strings.EqualFold(target, c.Name) ||
+			strings.Trim(target, "/") == strings.Trim(c.Resource, "/")
+	})
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 && !asJSON {
+		log.Info().Msgf("no changes to %s in the last %s", target, since)
+		return nil
+	}
+	enc := json.NewEncoder(stdout)
+	for _, c := range changes {
+		if asJSON {
+			err = enc.Encode(c)
+		} else {
+			_, err = fmt.Fprintln(stdout, describeChange(c))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdHistory(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartHistory(ctx)
+
+	// ziggs turns on the lamp, then someone turns it off at the switch.
+	if err := cmdSet(br, []string{"light", "lamp", "on"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	old := ziggy.AttributionWindow
+	ziggy.AttributionWindow = 0
+	t.Cleanup(func() { ziggy.AttributionWindow = old })
+	fb.SetLightState("1", map[string]interface{}{"on": false})
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t)
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(out.String(), "\n") < 2 && time.Now().Before(deadline) {
+		out.Reset()
+		if err := cmdHistory(nil, []string{"lamp", "--since", "1h"}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], `"lamp" light on=true (ziggs)`) ||
+		!strings.HasSuffix(lines[1], `"lamp" light on=false (bridge)`) {
+		t.Errorf("unexpected history: %q", out.String())
+	}
+
+	out.Reset()
+	if err := cmdHistory(nil, []string{fb.ID + ":office", "--json"}); err != nil {
+		t.Fatal(err)
+	}
+	var change data.Change
+	if err := json.NewDecoder(strings.NewReader(out.String())).Decode(&change); err != nil || change.Resource != "/groups/1" {
+		t.Errorf("expected the office to turn on, got %q: %v", out.String(), err)
+	}
+	for _, args := range [][]string{{}, {"lamp", "--since", "yesterday"}, {"lamp", "--bridge", "nope"}} {
+		if err := cmdHistory(nil, args); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -13,8 +13,6 @@ import (
 	cli "git.tcp.direct/Mirrors/go-prompt"
 	"github.com/rs/zerolog"
 
-	"github.com/google/shlex"
-
 	"git.tcp.direct/kayos/ziggs/internal/common"
 	"git.tcp.direct/kayos/ziggs/internal/config"
 	"git.tcp.direct/kayos/ziggs/internal/ziggy"
@@ -30,73 +28,75 @@ var noHist = map[string]bool{"clear": true, "exit": true, "quit": true}
 
 // Executor executes commands
 func Executor(cmd string) {
-	if log == nil {
-		log = config.StartLogger()
-	}
+	execute(cmd, true)
+}
 
-	log.Trace().Caller().Msg("getting readlock for suggestions")
-	SuggestionMutex.RLock()
-	defer SuggestionMutex.RUnlock()
-	log.Trace().Caller().Msg("got readlock for suggestions")
+// execute runs cmd like Executor does. Only if it succeeded and remember is set it is kept in the history
+// and in the macro being recorded. It reports whether cmd succeeded.
+func execute(cmd string, remember bool) (succeeded bool) {
+	useLogger()
+	succeeded = dispatch(cmd) == statusOK
+	if _, skip := noHist[cmd]; remember && !skip && succeeded {
+		histMu.Lock()
+		history = append(history, cmd)
+		histMu.Unlock()
+		go saveHist()
+		recordMacroLine(cmd)
+	}
+	return succeeded
+}
 
-	var status = 0
+// dispatch parses cmd and runs it, returning its exit status. Commands like events run for as long as they're
+// left to, so they take SuggestionMutex themselves only if they need the suggestions, never for their whole run.
+func dispatch(cmd string) (status int) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Error().Caller(3).Msgf("PANIC: %s", r)
-		}
-		if _, ok := noHist[cmd]; !ok && status == 0 {
-			history = append(history, cmd)
-			go saveHist()
+			status = statusFailed
 		}
 	}()
 
-	// hacky bugfix
-	cmd = strings.ReplaceAll(cmd, "#", "_POUNDSIGN_")
-	args, err := shlex.Split(strings.TrimSpace(cmd))
-	for i, arg := range args {
-		args[i] = strings.ReplaceAll(arg, "_POUNDSIGN_", "#")
-	}
-
+	list, err := parseLine(cmd)
 	if err != nil {
 		log.Error().Msgf("error parsing command: %s", err)
-		status = 1
-		return
-	}
-	if len(args) == 0 {
-		return
+		return statusSyntax
 	}
+	return list.run()
+}
+
+// builtin runs the commands that the shell handles itself, ok is false if args isn't one of them.
+func builtin(args []string) (status int, ok bool) {
 	switch args[0] {
 	case "quit", "exit":
 		os.Exit(0)
 	case "use":
 		if len(ziggy.Lucifer.Bridges) < 2 {
-			return
+			return statusOK, true
 		}
 		if len(args) < 2 {
 			println("use: use <bridge>")
-			return
+			return statusOK, true
 		}
-		br, ok := ziggy.Lucifer.Bridges[args[1]]
+		br, ok := ziggy.FindBridge(args[1])
 		if !ok {
 			log.Error().Msg("invalid bridge: " + args[1])
-			status = 1
-			return
+			return statusFailed, true
 		}
-		sel.Bridge = args[1]
+		sel.Bridge = br.Info.IPAddress
 		log.Info().Str("host", br.Host).Int("lights", len(br.HueLights)).Msg("switched to bridge: " + sel.Bridge)
-		return
+		return statusOK, true
 	case "debug":
 		levelsdebug := map[string]zerolog.Level{"info": zerolog.InfoLevel, "debug": zerolog.DebugLevel, "trace": zerolog.TraceLevel}
 		debuglevels := map[zerolog.Level]string{zerolog.InfoLevel: "info", zerolog.DebugLevel: "debug", zerolog.TraceLevel: "trace"}
 		if len(args) < 2 {
 			log.Info().Msgf("current debug level: %s", debuglevels[log.GetLevel()])
-			return
+			return statusOK, true
 		}
 		if newlevel, ok := levelsdebug[args[1]]; ok {
 			zerolog.SetGlobalLevel(newlevel)
 			nl := log.Level(newlevel)
 			log = &nl
-			return
+			return statusOK, true
 		}
 		if args[1] == "debugcli" || args[1] == "cli" {
 			if extraDebug {
@@ -108,73 +108,50 @@ func Executor(cmd string) {
 								spew.Dump(suggestions)*/
 				log.Info().Msg("enabled cli debug")
 			}
-			return
+			return statusOK, true
 		}
-		return
+		return statusOK, true
 	case "help":
 		if len(args) < 2 {
 			getHelp("")
-			return
+			return statusOK, true
 		}
 		getHelp(args[len(args)-1])
+		return statusOK, true
 	case "clear":
 		print("\033[H\033[2J")
-		return
-	default:
-		if len(args) == 0 {
-			return
-		}
-
-		complete := strings.Join(args, " ")
-		log.Trace().Caller().Msgf("complete command: %s", complete)
-		if strings.Contains(complete, "&&") {
-			log.Warn().Caller().Msgf("found \"&&\" in command: %s, replacing with \";\"", complete)
-			strings.ReplaceAll(complete, "&&", ";")
-		}
-		sep := strings.Split(complete, "&")
-		log.Trace().Caller().Msgf("sep: %+s", sep)
-
-		br, ok := ziggy.Lucifer.Bridges[sel.Bridge]
-		if sel.Bridge == "" || !ok {
-			for _, b := range ziggy.Lucifer.Bridges {
-				br = ziggy.Lucifer.Bridges[b.Info.IPAddress]
-				break
-			}
-		}
-
-		wg := &sync.WaitGroup{}
-		for _, cm := range sep {
-			cm = strings.TrimSpace(cm)
-			log.Trace().Caller().Msgf("executing command: %s", cm)
-			wg.Add(1)
-			go func(c string) {
-				c = strings.TrimSpace(c)
-				defer wg.Done()
-				for _, synchro := range strings.Split(c, ";") {
-					synchro = strings.TrimSpace(synchro)
-					myArgs := strings.Split(synchro, " ")
+		return statusOK, true
+	}
+	return statusOK, false
+}
 
-					bcmd, myok := Commands[myArgs[0]]
-					if !myok {
-						log.Error().Msg("invalid command: " + myArgs[0])
-						status = 1
-						return
-					}
+// quoteArg quotes arg if it wouldn't survive being split again as part of a command line.
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t\r\n;&|()'\"\\") || strings.HasPrefix(arg, "#") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+	}
+	return arg
+}
 
-					log.Trace().Caller().Msgf("selected bridge: %s", sel.Bridge)
+// joinArgs joins args back into a command line, quoting the ones that wouldn't survive being split again.
+func joinArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
 
-					if e := bcmd.reactor(br, myArgs[1:]); e != nil {
-						log.Error().Msgf("error executing command: %s", e)
-						status = 1
-						return
-					}
-				}
-			}(cm)
+// selectedBridge returns the bridge chosen with use, or any bridge if none was.
+func selectedBridge() *ziggy.Bridge {
+	br, ok := ziggy.Lucifer.Bridges[sel.Bridge]
+	if sel.Bridge == "" || !ok {
+		for _, b := range ziggy.Lucifer.Bridges {
+			br = ziggy.Lucifer.Bridges[b.Info.IPAddress]
+			break
 		}
-
-		wg.Wait()
-
 	}
+	return br
 }
 
 func cmdScan(br *ziggy.Bridge, args []string) error {
@@ -216,9 +193,17 @@ const bulb = ``
 var (
 	history    []string
 	histLoaded bool
+	// histMu guards history and histLoaded, saveMu keeps saves from overtaking each other.
+	histMu = &sync.Mutex{}
+	saveMu = &sync.Mutex{}
 )
 
 func loadHist() {
+	histMu.Lock()
+	defer histMu.Unlock()
+	if histLoaded {
+		return
+	}
 	var histMap = make(map[string]bool)
 	pth, _ := filepath.Split(config.Filename)
 	rb, _ := os.OpenFile(filepath.Join(pth, ".ziggs_history"), os.O_RDONLY, 0644)
@@ -239,19 +224,26 @@ func loadHist() {
 }
 
 func getHist() []string {
-	if !histLoaded {
-		loadHist()
-	}
-	return history
+	loadHist()
+	histMu.Lock()
+	defer histMu.Unlock()
+	return append([]string(nil), history...)
 }
 
+// saveHist writes what the history holds right now, commands run while it writes are saved by the next call.
 func saveHist() {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+	histMu.Lock()
+	snapshot := strings.Join(history, "\n")
+	histMu.Unlock()
 	pth, _ := filepath.Split(config.Filename)
-	_ = os.WriteFile(filepath.Join(pth, ".ziggs_history"), []byte(strings.Join(history, "\n")), 0644)
+	_ = os.WriteFile(filepath.Join(pth, ".ziggs_history"), []byte(snapshot), 0644)
 }
 
 // func StartCLI(r io.Reader, w io.Writer) {
 func StartCLI() {
+	useLogger()
 	ct, _ := common.Version()
 	// cli.NewStdoutWriter().
 	prompt = cli.New(
@@ -273,7 +265,7 @@ func StartCLI() {
 						sel.Bridge = brid
 					}
 				}
-				return fmt.Sprintf("ziggs[%s] %s ", sel.String(), bulb), true
+				return fmt.Sprintf("ziggs[%s%s] %s ", sel.String(), healthStatus(), bulb), true
 			}),
 
 		cli.OptionTitle("ziggs - built "+ct),
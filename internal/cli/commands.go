@@ -15,6 +15,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/yunginnanet/huego"
 
+	"git.tcp.direct/kayos/ziggs/internal/clipv2"
 	"git.tcp.direct/kayos/ziggs/internal/ziggy"
 )
 
@@ -63,10 +64,76 @@ func cmdRefresh(br *ziggy.Bridge, args []string) error {
 	return nil
 }
 
+// listers are what ls lists, by the names that make it list only some of them.
+var listers = map[string]reactor{
+	"lights": cmdLights, "groups": cmdGroups, "scenes": cmdScenes,
+	"sensors": cmdSensors, "rules": cmdRules, "schedules": cmdSchedules,
+}
+
+// listed is a line of what the listing commands print with --json, the object as the bridge describes it.
+type listed struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Bridge string      `json:"bridge,omitempty"`
+	Object interface{} `json:"object"`
+}
+
+// jsonFlag takes --json out of args.
+func jsonFlag(args []string) (bool, []string) {
+	var (
+		asJSON bool
+		rest   = make([]string, 0, len(args))
+	)
+	for _, arg := range args {
+		if arg == "--json" {
+			asJSON = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return asJSON, rest
+}
+
+// listedOn keeps only what is on br if a bridge was picked with use or --bridge, without one ls shows what is
+// on every bridge.
+func listedOn[T interface{ Bridge() *ziggy.Bridge }](m map[string]T, br *ziggy.Bridge) map[string]T {
+	if sel.Bridge == "" {
+		return m
+	}
+	ret := make(map[string]T, len(m))
+	for name, x := range m {
+		if x.Bridge() == br {
+			ret[name] = x
+		}
+	}
+	return ret
+}
+
+func printListed(kind, name string, br *ziggy.Bridge, obj interface{}) error {
+	l := listed{Kind: kind, Name: name, Object: obj}
+	if br != nil {
+		l.Bridge = br.Info.BridgeID
+	}
+	return json.NewEncoder(stdout).Encode(l)
+}
+
+// cmdList lists lights, groups, scenes and sensors, or only the kinds it's given. -l or -a add rules and
+// schedules, --json prints one JSON object per line instead.
+//
+//	ls [lights|groups|scenes|sensors|rules|schedules]... [-la] [--json]
 func cmdList(br *ziggy.Bridge, args []string) error {
 	var runs = []reactor{cmdLights, cmdGroups, cmdScenes, cmdSensors}
-	var cont = false
+	var (
+		only []reactor
+		rest []string
+		cont = false
+	)
 	for _, arg := range args {
+		if run, ok := listers[strings.TrimSuffix(arg, "s")+"s"]; ok {
+			only = append(only, run)
+			continue
+		}
+		rest = append(rest, arg)
 		if len(arg) > 4 {
 			continue
 		}
@@ -74,13 +141,15 @@ func cmdList(br *ziggy.Bridge, args []string) error {
 			continue
 		}
 		cont = true
-		break
 	}
 	if cont {
 		runs = append(runs, cmdSchedules, cmdRules)
 	}
+	if len(only) > 0 {
+		runs = only
+	}
 	for _, run := range runs {
-		if err := run(br, args); err != nil {
+		if err := run(br, rest); err != nil {
 			return err
 		}
 	}
@@ -88,9 +157,10 @@ func cmdList(br *ziggy.Bridge, args []string) error {
 }
 
 func cmdScenes(br *ziggy.Bridge, args []string) error {
+	asJSON, args := jsonFlag(args)
 	var targGroup *ziggy.HueGroup
 	if len(args) > 0 {
-		targGroup = ziggy.GetGroupMap()[args[0]]
+		targGroup, _ = ziggy.LookupGroup(args[0], br)
 	}
 	scenes, err := br.GetScenes()
 	if err != nil {
@@ -114,35 +184,72 @@ func cmdScenes(br *ziggy.Bridge, args []string) error {
 			// lstate.
 		}*/
 
+		if asJSON {
+			if err = printListed("scene", scene.Name, br, scene); err != nil {
+				return err
+			}
+			continue
+		}
 		log.Info().Str("caller", scene.Group).
 			Str("ID", scene.ID).Msgf("Scene: %s", scene.Name)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(scene))
+		traceDump(scene)
 	}
 	return nil
 }
 
 func cmdLights(br *ziggy.Bridge, args []string) error {
-	for name, l := range ziggy.GetLightMap() {
-		log.Info().
-			Str("caller", strings.Split(br.Host, "://")[1]).Int("ID", l.ID).Str("type", l.ProductName).
-			Str("model", l.ModelID).Bool("on", l.IsOn()).Msgf("Light: %s", name)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(l))
+	lights := listedOn(ziggy.LightList(), br)
+	if asJSON, _ := jsonFlag(args); asJSON {
+		for _, name := range sortedNames(lights) {
+			if err := printListed("light", name, lights[name].Bridge(), lights[name].Light); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for name, l := range lights {
+		ev := log.Info().
+			Str("caller", strings.Split(l.Bridge().Host, "://")[1]).Int("ID", l.ID).Str("type", l.ProductName).
+			Str("model", l.ModelID).Bool("on", l.IsOn())
+		if st, err := l.Status(); err == nil {
+			ev = ev.Str("connectivity", st.Connectivity)
+		} else {
+			log.Debug().Err(err).Msgf("no v2 status for light %s", name)
+		}
+		ev.Msgf("Light: %s", name)
+		traceDump(l)
 	}
 	return nil
 }
 
+// traceDump logs all of v at trace level. v is only walked when trace logging is on, bridges and their lights
+// hold HTTP clients that are busy in the background.
+func traceDump(v interface{}) {
+	if ev := log.Trace(); ev.Enabled() {
+		ev.Caller(1).Msg(spew.Sprint(v))
+	}
+}
+
 func cmdRules(br *ziggy.Bridge, args []string) error {
 	rules, err := br.GetRules()
 	if err != nil {
 		return err
 	}
+	if asJSON, _ := jsonFlag(args); asJSON {
+		for _, r := range rules {
+			if err = printListed("rule", r.Name, br, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(rules) == 0 {
 		return errors.New("no rules found")
 	}
 	for _, r := range rules {
 		log.Info().Str("caller", strings.Split(br.Host, "://")[1]).Int("ID", r.ID).
 			Str("status", r.Status).Msgf("Rule: %s", r.Name)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(r))
+		traceDump(r)
 	}
 	return nil
 }
@@ -152,13 +259,21 @@ func cmdSchedules(br *ziggy.Bridge, args []string) error {
 	if err != nil {
 		return err
 	}
+	if asJSON, _ := jsonFlag(args); asJSON {
+		for _, sch := range schedules {
+			if err = printListed("schedule", sch.Name, br, sch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(schedules) == 0 {
 		return errors.New("no schedules found")
 	}
 	for _, s := range schedules {
 		log.Info().Str("caller", strings.Split(br.Host, "://")[1]).Int("ID", s.ID).
 			Str("desc", s.Description).Msgf("Schedule: %s", s.Name)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(s))
+		traceDump(s)
 	}
 	return nil
 }
@@ -168,19 +283,50 @@ func cmdSensors(br *ziggy.Bridge, args []string) error {
 	if err != nil {
 		return err
 	}
+	if asJSON, _ := jsonFlag(args); asJSON {
+		for _, sensor := range sensors {
+			if err = printListed("sensor", sensor.Name, br, sensor); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(sensors) == 0 {
 		return errors.New("no sensors found")
 	}
 	for _, s := range sensors {
-		log.Info().Str("caller", strings.Split(br.Host, "://")[1]).Int("ID", s.ID).
-			Str("type", s.Type).Msgf("Sensor: %s", s.Name)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(s))
+		ev := log.Info().Str("caller", strings.Split(br.Host, "://")[1]).Int("ID", s.ID).
+			Str("type", s.Type)
+		if st, err := br.DeviceStatus(fmt.Sprintf("/sensors/%d", s.ID)); err == nil {
+			ev = ev.Str("connectivity", st.Connectivity)
+			if st.Battery >= 0 {
+				ev = ev.Int("battery", st.Battery).Str("battery_state", st.BatteryState)
+			}
+		} else {
+			log.Debug().Err(err).Msgf("no v2 status for sensor %s", s.Name)
+		}
+		ev.Msgf("Sensor: %s", s.Name)
+		traceDump(s)
 	}
 	return nil
 }
 
 func cmdGroups(br *ziggy.Bridge, args []string) error {
-	groupmap := ziggy.GetGroupMap()
+	groupmap := listedOn(ziggy.GroupList(), br)
+	if asJSON, _ := jsonFlag(args); asJSON {
+		for _, name := range sortedNames(groupmap) {
+			if err := printListed("group", name, groupmap[name].Bridge(), groupmap[name].Group); err != nil {
+				return err
+			}
+		}
+		vgroups := ziggy.GetVirtualGroupMap()
+		for _, name := range sortedNames(vgroups) {
+			if err := printListed("virtual group", name, nil, vgroups[name].Members()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	if len(groupmap) == 0 {
 		return errors.New("no groups found")
 	}
@@ -190,14 +336,21 @@ func cmdGroups(br *ziggy.Bridge, args []string) error {
 			Str("class", g.Class).Bool("on", g.IsOn()).Send()
 		for _, l := range g.Lights {
 			lid, _ := strconv.Atoi(l)
-			lght, err := br.GetLight(lid)
+			lght, err := g.Bridge().GetLight(lid)
 			if err != nil {
 				log.Warn().Err(err).Msgf("failed to get light %s", l)
 				continue
 			}
 			log.Info().Msg("\t[" + strconv.Itoa(lght.ID) + "] " + lght.Name + " (" + lght.ProductName + ")")
 		}
-		log.Trace().Caller().Msgf("%v", spew.Sprint(g))
+		traceDump(g)
+	}
+	for n, vg := range ziggy.GetVirtualGroupMap() {
+		log.Info().Str("caller", n).Str("type", "Virtual").
+			Int("members", vg.Len()).Bool("on", vg.IsOn()).Send()
+		for _, m := range vg.Members() {
+			log.Info().Msg("\t" + m)
+		}
 	}
 	return nil
 }
@@ -289,7 +442,7 @@ func cmdCp(br *ziggy.Bridge, args []string) error {
 		return err
 	}
 	log.Info().Msgf("updated group %s to include light %s", targetGroup.Name, targetLight.Name)
-	log.Trace().Caller().Msgf("%v", spew.Sprint(resp))
+	traceDump(resp)
 	return nil
 }
 
@@ -345,7 +498,7 @@ func cmdDump(br *ziggy.Bridge, args []string) error {
 	if len(args) < 2 && args[0] != "all" && args[0] != "conf" && args[0] != "groups" &&
 		args[0] != "lights" && args[0] != "rules" && args[0] != "schedules" &&
 		args[0] != "sensors" && args[0] != "scenes" && args[0] != "resourcelinks" &&
-		args[0] != "config" {
+		args[0] != "config" && args[0] != "v2" {
 		return errors.New("not enough arguments")
 	}
 	var (
@@ -477,6 +630,14 @@ func cmdDump(br *ziggy.Bridge, args []string) error {
 		}
 	case "bridge", "all":
 		targets = append(targets, newTarget("bridge", br))
+	case "v2":
+		var res *clipv2.Resources
+		if res, err = br.V2Resources(); err != nil {
+			return err
+		}
+		for rtype, items := range res.Raw {
+			targets = append(targets, newTarget(rtype, items))
+		}
 	case "config":
 		var conf *huego.Config
 		conf, err = br.GetConfig()
@@ -504,6 +665,8 @@ func cmdDump(br *ziggy.Bridge, args []string) error {
 			}
 			log.Info().Msgf("created folder: %s", targetDir)
 			parentFolder = target.ParentFolder
+		} else if err = os.MkdirAll(wd, 0o755); err != nil { // #nosec
+			return err
 		}
 		var js []byte
 		if js, err = json.Marshal(target.Object); err != nil {
@@ -565,7 +728,7 @@ func cmdLoad(br *ziggy.Bridge, args []string) error {
 		if err := json.Unmarshal(js, &l); err != nil {
 			return err
 		}
-		if resp, err := br.UpdateLight(target.(*huego.Light).ID, *l); err != nil {
+		if resp, err := br.UpdateLight(target.(*ziggy.HueLight).ID, *l); err != nil {
 			return err
 		} else {
 			log.Info().Msgf("%v", resp)
@@ -653,7 +816,7 @@ func cmdAdopt(br *ziggy.Bridge, args []string) error {
 	}
 	for _, l := range newLights.Lights {
 		log.Info().Msgf("[+] %s", l)
-		log.Trace().Caller().Msgf("%v", spew.Sprint(l))
+		traceDump(l)
 	}
 	return nil
 }
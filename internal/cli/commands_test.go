@@ -0,0 +1,328 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestMain(m *testing.M) {
+	config.Init()
+	log = config.StartLogger()
+	// connecting to bridges saves what we learn about them, keep that out of the real config.
+	dir, err := os.MkdirTemp("", "ziggs")
+	if err != nil {
+		panic(err)
+	}
+	config.Snek.SetConfigFile(filepath.Join(dir, "config.toml"))
+	ziggy.Supervisor.Relocate = false
+	code := m.Run()
+	_ = os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestBridge starts a fake bridge and connects to it through ziggy.Setup, just like ziggs does on startup.
+func newTestBridge(t *testing.T) (*fakebridge.Bridge, *ziggy.Bridge) {
+	t.Helper()
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	lamp := fb.AddLight("lamp")
+	desk := fb.AddLight("desk")
+	fb.AddGroup("office", lamp, desk)
+	fb.AddSensor("hallway motion", "ZLLPresence", map[string]interface{}{"presence": false})
+	fb.AddRule("motion")
+	fb.AddSchedule("wakeup")
+
+	config.KnownBridges = []config.KnownBridge{{Hostname: fb.Hostname(), Username: fb.NewUser("ziggs#test")}}
+	ziggy.Lucifer.Lock()
+	ziggy.Lucifer.Bridges = make(map[string]*ziggy.Bridge)
+	ziggy.Lucifer.Unlock()
+	known, err := ziggy.Setup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(known) != 1 {
+		t.Fatalf("expected 1 bridge, got %d", len(known))
+	}
+	ziggy.NeedsUpdate()
+	return fb, known[0]
+}
+
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return dir
+}
+
+// captureStdout sends what commands print to the returned builder for the rest of the test.
+func captureStdout(t *testing.T) *strings.Builder {
+	t.Helper()
+	out := &strings.Builder{}
+	stdout = out
+	t.Cleanup(func() { stdout = os.Stdout })
+	return out
+}
+
+func TestCmdSet(t *testing.T) {
+	fb, br := newTestBridge(t)
+
+	if err := cmdSet(br, []string{"light", "lamp", "on"}); err != nil {
+		t.Fatal(err)
+	}
+	if fb.LightState("1")["on"] != true {
+		t.Error("lamp was not turned on")
+	}
+	if err := cmdSet(br, []string{"light", fb.ID + "/lamp", "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if fb.LightState("1")["on"] != false {
+		t.Error("lamp was not turned off by its qualified name")
+	}
+	if err := cmdSet(br, []string{"light", "desk", "brightness", "100"}); err != nil {
+		t.Fatal(err)
+	}
+	if bri := fb.LightState("2")["bri"]; bri != 100.0 {
+		t.Errorf("expected brightness 100, got %v", bri)
+	}
+	if err := cmdSet(br, []string{"group", "office", "color", "#ff0000"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range fb.IDs("lights") {
+		if mode := fb.LightState(id)["colormode"]; mode != "xy" {
+			t.Errorf("light %s: expected xy colormode, got %v", id, mode)
+		}
+	}
+	if err := cmdSet(br, []string{"group", "office", "temperature", "250"}); err != nil {
+		t.Fatal(err)
+	}
+	if ct := fb.LightState("1")["ct"]; ct != 250.0 {
+		t.Errorf("expected ct 250, got %v", ct)
+	}
+	if err := cmdSet(br, []string{"group", "office", "off"}); err != nil {
+		t.Fatal(err)
+	}
+	if fb.Object("groups", "1")["state"].(map[string]interface{})["any_on"] != false {
+		t.Error("office was not turned off")
+	}
+
+	for _, bad := range [][]string{
+		{"light", "nonexistent", "on"},
+		{"light", "lamp", "bogus"},
+		{"light", "lamp", "temperature", "9000"},
+	} {
+		if err := cmdSet(br, bad); err == nil {
+			t.Errorf("expected error for set %v", bad)
+		}
+	}
+}
+
+func TestCmdGet(t *testing.T) {
+	_, br := newTestBridge(t)
+	if err := cmdGet(br, []string{"light", "lamp"}); err != nil {
+		t.Error(err)
+	}
+	if err := cmdGet(br, []string{"group", "office"}); err != nil {
+		t.Error(err)
+	}
+	if err := cmdGet(br, []string{"light", "nonexistent"}); err == nil {
+		t.Error("expected error getting nonexistent light")
+	}
+}
+
+func TestCmdList(t *testing.T) {
+	_, br := newTestBridge(t)
+	if err := cmdList(br, []string{"-a"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCmdDumpLoad(t *testing.T) {
+	fb, br := newTestBridge(t)
+	dir := inTempDir(t)
+
+	if err := cmdDump(br, []string{"light", "lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "dump", "light", "lamp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped map[string]interface{}
+	if err = json.Unmarshal(raw, &dumped); err != nil {
+		t.Fatal(err)
+	}
+	if dumped["name"] != "lamp" {
+		t.Errorf("dumped wrong light: %v", dumped)
+	}
+
+	if err = cmdDump(br, []string{"groups"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "dump", "groups", "office.json")); err != nil {
+		t.Error(err)
+	}
+	if err = cmdDump(br, []string{"config"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "dump", "config", fb.ID+".json")); err != nil {
+		t.Error(err)
+	}
+
+	lightFile := filepath.Join(dir, "light.json")
+	if err = os.WriteFile(lightFile, []byte(`{"name":"reading lamp"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = cmdLoad(br, []string{"light", "lamp", lightFile}); err != nil {
+		t.Fatal(err)
+	}
+	if name := fb.Object("lights", "1")["name"]; name != "reading lamp" {
+		t.Errorf("light was not renamed by load: %v", name)
+	}
+
+	groupFile := filepath.Join(dir, "group.json")
+	if err = os.WriteFile(groupFile, []byte(`{"name":"study"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err = cmdLoad(br, []string{"group", "office", groupFile}); err != nil {
+		t.Fatal(err)
+	}
+	if name := fb.Object("groups", "1")["name"]; name != "study" {
+		t.Errorf("group was not renamed by load: %v", name)
+	}
+}
+
+func TestCmdCreateRename(t *testing.T) {
+	fb, br := newTestBridge(t)
+	if err := cmdCreate(br, []string{"group", "den", "1,2"}); err != nil {
+		t.Fatal(err)
+	}
+	grp := fb.Object("groups", "2")
+	if grp == nil || grp["name"] != "den" || len(grp["lights"].([]interface{})) != 2 {
+		t.Fatalf("group was not created: %v", grp)
+	}
+	if err := cmdRename(br, []string{"light", "lamp", "bulb"}); err != nil {
+		t.Fatal(err)
+	}
+	if name := fb.Object("lights", "1")["name"]; name != "bulb" {
+		t.Errorf("light was not renamed: %v", name)
+	}
+}
+
+func TestCmdSetVirtualGroup(t *testing.T) {
+	fb, br := newTestBridge(t)
+	config.VirtualGroups = map[string]config.VirtualGroup{"floor2": {Lights: []string{"lamp", "desk"}}}
+	t.Cleanup(func() { config.VirtualGroups = nil })
+
+	if err := cmdSet(br, []string{"group", "floor2", "on"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range fb.IDs("lights") {
+		if fb.LightState(id)["on"] != true {
+			t.Errorf("light %s was not turned on", id)
+		}
+	}
+	if err := cmdGet(br, []string{"group", "floor2"}); err != nil {
+		t.Error(err)
+	}
+	if err := cmdSet(br, []string{"group", "floor2", "scene", "nonexistent"}); err == nil {
+		t.Error("expected error recalling a scene on a virtual group without groups")
+	}
+}
+
+func TestCmdSetDimUsesRegistry(t *testing.T) {
+	fb, br := newTestBridge(t)
+	// change the light behind ziggs' back, the cached light in the name map is now stale.
+	fb.SetLightState("2", map[string]interface{}{"on": true, "bri": 100.0})
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSet(br, []string{"light", "desk", "dim", "dim"}); err != nil {
+		t.Fatal(err)
+	}
+	if bri := fb.LightState("2")["bri"]; bri != 90.0 {
+		t.Errorf("expected brightness 90, got %v", bri)
+	}
+}
+
+func TestCmdSetTransition(t *testing.T) {
+	fb, br := newTestBridge(t)
+	fb.ResetRequests()
+	if err := cmdSet(br, []string{"light", "lamp", "brightness", "50", "temperature", "300", "over", "1.5s"}); err != nil {
+		t.Fatal(err)
+	}
+	var reqs []fakebridge.Request
+	for _, r := range fb.Requests() {
+		if r.Method == "PUT" {
+			reqs = append(reqs, r)
+		}
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("expected the whole command to be sent at once, got %d requests", len(reqs))
+	}
+	var sent map[string]interface{}
+	if err := json.Unmarshal(reqs[0].Body, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent["transitiontime"] != 15.0 || sent["bri"] != 50.0 || sent["ct"] != 300.0 {
+		t.Errorf("unexpected request: %v", sent)
+	}
+	if st := fb.LightState("1"); st["bri"] != 50.0 || st["ct"] != 300.0 {
+		t.Errorf("unexpected state: %v", st)
+	}
+	for _, bad := range [][]string{
+		{"light", "lamp", "on", "over"},
+		{"light", "lamp", "on", "transition", "soon"},
+		{"light", "lamp", "scene", "x", "over", "1s"},
+	} {
+		if err := cmdSet(br, bad); err == nil {
+			t.Errorf("expected error for set %v", bad)
+		}
+	}
+}
+
+func TestCmdDumpV2(t *testing.T) {
+	ziggy.ClipV2Scheme = "http"
+	t.Cleanup(func() { ziggy.ClipV2Scheme = "https" })
+	_, br := newTestBridge(t)
+	dir := inTempDir(t)
+
+	if err := cmdDump(br, []string{"v2"}); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "dump", "v2", "device_power.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped []map[string]interface{}
+	if err = json.Unmarshal(raw, &dumped); err != nil {
+		t.Fatal(err)
+	}
+	if len(dumped) != 1 || dumped[0]["id_v1"] != "/sensors/1" {
+		t.Errorf("unexpected device_power dump: %v", dumped)
+	}
+	for _, rtype := range []string{"light", "device", "zone", "grouped_light", "zigbee_connectivity"} {
+		if _, err = os.Stat(filepath.Join(dir, "dump", "v2", rtype+".json")); err != nil {
+			t.Error(err)
+		}
+	}
+	if err = cmdGet(br, []string{"light", "lamp"}); err != nil {
+		t.Error(err)
+	}
+	if err = cmdList(br, nil); err != nil {
+		t.Error(err)
+	}
+}
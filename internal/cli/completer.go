@@ -114,6 +114,25 @@ func init() {
 	Commands["upgrade"] = newZiggsCommand(cmdFirmwareUpdate, "inform bridge to check for updates", 0,
 		"fwup", "upgrade", "fwupdate")
 	Commands["info"] = newZiggsCommand(cmdInfo, "show information about a bridge", 0, "uname")
+	Commands["bridges"] = newZiggsCommand(cmdBridges, "show the health of each bridge, use 'bridges probe' to check now", 0,
+		"health", "lsbr")
+	Commands["bridge"] = newZiggsCommand(cmdBridge, "list, add, remove, alias or set-proxy bridges in the config", 1, "br")
+	Commands["events"] = newZiggsCommand(cmdEvents, "print changes as they happen on every bridge, optionally filtered", 0)
+	Commands["discover"] = newZiggsCommand(cmdDiscover,
+		"find bridges with mDNS/SSDP and optionally sweep CIDRs or interfaces, e.g. 'discover all -t 2s --add'", 0)
+	Commands["pair"] = newZiggsCommand(cmdPair,
+		"pair with a bridge, waiting for its link button, e.g. 'pair 192.168.1.2 -t 1m --clientkey'", 1)
+	Commands["snapshot"] = newZiggsCommand(cmdSnapshot, "save, restore, list or delete snapshots of light states", 1, "snap")
+	Commands["history"] = newZiggsCommand(cmdHistory,
+		"list what changed on a light, group or sensor and who changed it, e.g. 'history hallway --since 24h'", 1)
+	Commands["automation"] = newZiggsCommand(cmdAutomation,
+		"add, list, enable, disable or delete local automations, e.g. 'automation add night when motion hallway if time 22:00-06:00 do set light hallway on'", 1, "auto")
+	Commands["macro"] = newZiggsCommand(cmdMacro,
+		"add, list, show, run, edit, record or delete macros, e.g. 'macro add wake \"set light lamp on; set light desk on\"'", 1)
+	Commands["seq"] = newZiggsCommand(cmdSeq,
+		"define, list, show, run or delete sequences with groups and lights bound when they run, e.g. 'seq run dim g1=kitchen l1=lamp'", 1, "sequence")
+	Commands["source"] = newZiggsCommand(cmdSource,
+		"run a ziggs script, -n only prints what it would do, e.g. 'source night.zg room=office'", 1)
 	initCompletion()
 	Commands["reboot"] = newZiggsCommand(cmdReboot, "reboot bridge", 0)
 	Commands["get-full-state"] = newZiggsCommand(cmdGetFullState, "get full state from bridge", 0)
@@ -187,6 +206,12 @@ func initCompletion() {
 		}}
 		sug.root = false
 	}
+	for _, action := range []string{"define", "run", "list", "show", "delete"} {
+		suggestions[1]["seq "+action] = &completion{
+			Suggest:  cli.Suggest{Text: action, Description: action + " sequences"},
+			requires: map[int]map[string]bool{1: {"seq": true, "sequence": true}},
+		}
+	}
 	delCompletion := []*completion{
 		{Suggest: cli.Suggest{Text: "scene", Description: "target scene"}},
 		{Suggest: cli.Suggest{Text: "schedule", Description: "target schedule"}},
@@ -214,6 +239,12 @@ func completer(in cli.Document) []cli.Suggest {
 	if extraDebug {
 		log.Trace().Int("head", head).Msgf("completing %v", infields)
 	}
+	if len(infields) > 0 && (infields[0] == "seq" || infields[0] == "sequence") {
+		if sugs := sequenceSuggestions(infields, head, in.GetWordBeforeCursor()); sugs != nil {
+			return sugs
+		}
+	}
+
 	var sugs []cli.Suggest
 	SuggestionMutex.RLock()
 	defer SuggestionMutex.RUnlock()
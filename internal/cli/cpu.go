@@ -2,11 +2,9 @@ package cli
 
 import (
 	"context"
-	"strconv"
 	"time"
 
 	"github.com/lucasb-eyer/go-colorful"
-	"github.com/yunginnanet/huego"
 
 	"git.tcp.direct/kayos/ziggs/internal/common"
 	"git.tcp.direct/kayos/ziggs/internal/system"
@@ -44,10 +42,9 @@ func cpuInit(argVal string, bridge *ziggy.Bridge, cpuTarget cmdTarget) error {
 	defer func() {
 		cpuOn = false
 	}()
-	var lights []*huego.Light
+	var lights []*ziggy.HueLight
 	for _, l := range cpuTarget.(*ziggy.HueGroup).Lights {
-		lint, _ := strconv.Atoi(l)
-		lptr, err := bridge.GetLight(lint)
+		lptr, err := bridge.FindLight(l)
 		if err != nil {
 			log.Error().Err(err).Msg("failed to get light")
 			continue
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// cmdDiscover looks for bridges without asking anything, so it works in scripts and over SSH.
+// Without targets only mDNS and SSDP are used, targets are swept on top of that.
+//
+//	discover [all | <cidr> | <interface>]... [-j <concurrency>] [-t <timeout>] [-p <port>] [--add]
+func cmdDiscover(_ *ziggy.Bridge, args []string) error {
+	var (
+		opts ziggy.ScanOptions
+		add  bool
+	)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--add", "-a":
+			add = true
+		case "-j", "--concurrency", "-p", "--port", "-t", "--timeout":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", args[i])
+			}
+			flag, val := args[i], args[i+1]
+			i++
+			switch flag {
+			case "-j", "--concurrency":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 {
+					return fmt.Errorf("invalid concurrency: %s", val)
+				}
+				opts.Concurrency = n
+				continue
+			case "-p", "--port":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 || n > 65535 {
+					return fmt.Errorf("invalid port: %s", val)
+				}
+				opts.Port = n
+				continue
+			}
+			d, err := parseTransition(val)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid timeout: %s", val)
+			}
+			opts.Timeout = d
+		default:
+			opts.Targets = append(opts.Targets, args[i])
+		}
+	}
+
+	log.Info().Strs("targets", opts.Targets).Msg("searching for bridges...")
+	started := time.Now()
+	found, err := ziggy.Scan(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return errors.New("no bridges found")
+	}
+	for _, br := range found {
+		configured := br.Configured()
+		log.Info().Str("id", br.ID).Str("host", br.Host).Str("model", br.Model).
+			Strs("via", br.Via).Bool("configured", configured).Msg(br.Name)
+		if !add || configured {
+			continue
+		}
+		if err = config.AddKnownBridge(config.KnownBridge{Hostname: br.URL(), ID: br.ID}); err != nil {
+			return fmt.Errorf("failed to add %s to the config: %w", br.ID, err)
+		}
+		log.Info().Str("id", br.ID).Msg("added to the config, it still needs a username to log in")
+	}
+	log.Info().Int("bridges", len(found)).Dur("took", time.Since(started).Round(time.Millisecond)).Msg("done")
+	return nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdDiscover(t *testing.T) {
+	fb, _ := newTestBridge(t)
+	other := fakebridge.New()
+	t.Cleanup(other.Close)
+	dir := inTempDir(t)
+	oldConfig := config.Snek.ConfigFileUsed()
+	config.Snek.SetConfigFile(filepath.Join(dir, "config.toml"))
+	t.Cleanup(func() { config.Snek.SetConfigFile(oldConfig) })
+
+	oldMDNS, oldSSDP := ziggy.MDNSAddr, ziggy.SSDPAddr
+	t.Cleanup(func() { ziggy.MDNSAddr, ziggy.SSDPAddr = oldMDNS, oldSSDP })
+	// nothing listens on the discard port, so mDNS and SSDP come up empty.
+	ziggy.MDNSAddr, ziggy.SSDPAddr = "127.0.0.1:9", "127.0.0.1:9"
+
+	port := func(fb *fakebridge.Bridge) string {
+		return strconv.Itoa(fb.Listener.Addr().(*net.TCPAddr).Port)
+	}
+	// the bridge we're connected to is already configured and mustn't be added again.
+	if err := cmdDiscover(nil, []string{"127.0.0.1/32", "-p", port(fb), "-t", "300ms", "--add"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.KnownBridges) != 1 {
+		t.Fatalf("configured bridge was added again: %+v", config.KnownBridges)
+	}
+	if err := cmdDiscover(nil, []string{"127.0.0.1/32", "-p", port(other), "-t", "300ms", "--add"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.KnownBridges) != 2 || config.KnownBridges[1].Hostname != other.Hostname() {
+		t.Fatalf("new bridge wasn't added: %+v", config.KnownBridges)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), other.Hostname()) || !strings.Contains(string(raw), fb.Hostname()) {
+		t.Errorf("config should have both bridges:\n%s", raw)
+	}
+
+	for _, bad := range [][]string{{"-j"}, {"-j", "0"}, {"-t", "soon"}, {"-p", "70000"}} {
+		if err = cmdDiscover(nil, bad); err == nil {
+			t.Errorf("expected an error for %v", bad)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// stdout is where commands print output meant for other programs rather than for the log.
+var stdout io.Writer = os.Stdout
+
+// stdin is where commands that take more than one line read them from.
+var stdin io.Reader = os.Stdin
+
+// eventFilter decides which events the events command shows, empty fields match everything.
+type eventFilter struct {
+	types  []string
+	name   string
+	id     string
+	bridge *ziggy.Bridge
+}
+
+func (f eventFilter) match(ev ziggy.BridgeEvent) bool {
+	if len(f.types) > 0 {
+		found := false
+		for _, t := range f.types {
+			if strings.EqualFold(t, ev.Type) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.name != "" && !strings.EqualFold(f.name, ev.Name) {
+		return false
+	}
+	if f.id != "" && !strings.EqualFold(f.id, ev.Id) && strings.Trim(f.id, "/") != strings.Trim(ev.IdV1, "/") {
+		return false
+	}
+	if f.bridge != nil && !strings.EqualFold(f.bridge.Info.BridgeID, ev.Bridge) {
+		return false
+	}
+	return true
+}
+
+// describeEvent returns a line that tells a person what changed.
+func describeEvent(ev ziggy.BridgeEvent) string {
+	var b strings.Builder
+	b.WriteString(ev.Time.Local().Format("15:04:05"))
+	b.WriteString(" " + ev.Bridge)
+	if ev.Alias != "" {
+		b.WriteString(" (" + ev.Alias + ")")
+	}
+	b.WriteString(" " + ev.Kind + " " + ev.Type)
+	switch {
+	case ev.Name != "":
+		b.WriteString(" " + strconv.Quote(ev.Name))
+	case ev.IdV1 != "":
+		b.WriteString(" " + ev.IdV1)
+	default:
+		b.WriteString(" " + ev.Id)
+	}
+	if changes := eventChanges(ev); len(changes) > 0 {
+		b.WriteString(": " + strings.Join(changes, " "))
+	}
+	return b.String()
+}
+
+// eventChanges lists what an event changed, e.g. on=true bri=50%.
+func eventChanges(ev ziggy.BridgeEvent) (changes []string) {
+	add := func(format string, a ...interface{}) {
+		changes = append(changes, fmt.Sprintf(format, a...))
+	}
+	if ev.On != nil {
+		add("on=%t", ev.On.On)
+	}
+	if ev.Dimming != nil {
+		add("bri=%.0f%%", ev.Dimming.Brightness)
+	}
+	if ev.ColorTemperature != nil && ev.ColorTemperature.MirekValid {
+		add("mirek=%v", ev.ColorTemperature.Mirek)
+	}
+	if ev.Color != nil {
+		add("xy=%.4f,%.4f", ev.Color.Xy.X, ev.Color.Xy.Y)
+	}
+	if ev.Motion != nil {
+		add("motion=%t", ev.Motion.Motion)
+	}
+	if ev.Temperature != nil {
+		add("temperature=%.1f", ev.Temperature.Temperature)
+	}
+	if ev.Light != nil {
+		add("light_level=%d", ev.Light.LightLevel)
+	}
+	if ev.Button != nil {
+		event := ev.Button.LastEvent
+		if ev.Button.ButtonReport.Event != "" {
+			event = ev.Button.ButtonReport.Event
+		}
+		add("button=%s", event)
+	}
+	if ev.PowerState != nil {
+		add("battery=%d%%", ev.PowerState.BatteryLevel)
+	}
+	if ev.Status != "" {
+		add("status=%s", ev.Status)
+	}
+	if ev.Metadata != nil && ev.Metadata.Name != "" {
+		add("name=%q", ev.Metadata.Name)
+	}
+	return changes
+}
+
+// interruptible returns a context that is done on interrupt, or after timeout if it's set.
+func interruptible(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if timeout <= 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// eventsRecord writes every message of every bridge to a file, until interrupted, or until it recorded
+// -n messages or ran for -t.
+//
+//	events record <file> [-n <count>] [-t <duration>]
+func eventsRecord(args []string) error {
+	if len(args) < 1 {
+		return errors.New("no file specified")
+	}
+	var (
+		count   int
+		timeout time.Duration
+	)
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s needs a value", args[i])
+		}
+		flag, val := args[i], args[i+1]
+		i++
+		switch flag {
+		case "-n":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid count: %s", val)
+			}
+			count = n
+		case "-t", "--timeout":
+			d, err := parseDuration(val)
+			if err != nil {
+				return err
+			}
+			timeout = d
+		default:
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := interruptible(timeout)
+	defer cancel()
+	watchBridges(ctx)
+	log.Info().Msgf("recording events to %s", args[0])
+	n, err := ziggy.Events.Record(ctx, f, count)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	log.Info().Msgf("recorded %d messages", n)
+	return err
+}
+
+// watchBridges has the bridges watched until ctx is done, unless they already are. The shell watches them for as
+// long as it runs, but commands ziggs was started with have to do it themselves.
+func watchBridges(ctx context.Context) {
+	if !ziggy.Registry.Watching() {
+		ziggy.Registry.WatchAll(ctx)
+	}
+}
+
+// eventsReplay feeds a recording back to everything that listens to events, as if the bridges sent it again,
+// except that the history skips replayed events.
+// --speed 2 replays twice as fast, --speed 0 as fast as possible.
+//
+//	events replay <file> [--speed <factor>]
+func eventsReplay(args []string) error {
+	if len(args) < 1 {
+		return errors.New("no file specified")
+	}
+	speed := 1.0
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s needs a value", args[i])
+		}
+		flag, val := args[i], args[i+1]
+		i++
+		switch flag {
+		case "--speed", "-s":
+			f, err := strconv.ParseFloat(val, 64)
+			if err != nil || f < 0 {
+				return fmt.Errorf("invalid speed: %s", val)
+			}
+			speed = f
+		default:
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := interruptible(0)
+	defer cancel()
+	n, err := ziggy.Events.ReplayFrom(ctx, f, speed)
+	log.Info().Msgf("replayed %d messages from %s", n, args[0])
+	return err
+}
+
+// cmdEvents prints the events of every bridge as they happen, until interrupted, or until it printed
+// -n events or ran for -t. It also records them to a file, and replays such recordings.
+//
+//	events [--type <type>]... [--name <name>] [--id <id>] [--bridge <bridge>] [--json] [-n <count>] [-t <duration>]
+//	events record <file> [-n <count>] [-t <duration>]
+//	events replay <file> [--speed <factor>]
+func cmdEvents(_ *ziggy.Bridge, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "record":
+			return eventsRecord(args[1:])
+		case "replay":
+			return eventsReplay(args[1:])
+		}
+	}
+	var (
+		filter  eventFilter
+		asJSON  bool
+		count   int
+		timeout time.Duration
+	)
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--json" {
+			asJSON = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("%s needs a value", args[i])
+		}
+		flag, val := args[i], args[i+1]
+		i++
+		switch flag {
+		case "--type", "-T":
+			filter.types = append(filter.types, strings.Split(val, ",")...)
+		case "--name":
+			filter.name = val
+		case "--id":
+			filter.id = val
+		case "--bridge", "-b":
+			c, ok := ziggy.FindBridge(val)
+			if !ok {
+				return fmt.Errorf("no bridge %s", val)
+			}
+			filter.bridge = c
+		case "-n":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid count: %s", val)
+			}
+			count = n
+		case "-t", "--timeout":
+			d, err := parseDuration(val)
+			if err != nil {
+				return err
+			}
+			timeout = d
+		default:
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+
+	ctx, cancel := interruptible(timeout)
+	defer cancel()
+	events, unsubscribe := ziggy.Events.Subscribe(64)
+	watchBridges(ctx)
+	defer unsubscribe()
+	enc := json.NewEncoder(stdout)
+	printed := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-events:
+			if !filter.match(ev) {
+				continue
+			}
+			if asJSON {
+				if err := enc.Encode(ev); err != nil {
+					return err
+				}
+			} else if _, err := fmt.Fprintln(stdout, describeEvent(ev)); err != nil {
+				return err
+			}
+			if printed++; count > 0 && printed >= count {
+				return nil
+			}
+		}
+	}
+}
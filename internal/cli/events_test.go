@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdEvents(t *testing.T) {
+	fb, br := newTestBridge(t)
+	fb.EnableEventstream()
+	ziggy.ClipV2Scheme = "http"
+	out := captureStdout(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ziggy.Registry.Watch(ctx, br)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// the registry sees every event before events does, and whatever it calls on a change, like ProcessAll,
+	// may need the suggestions while events runs.
+	ziggy.Registry.OnChange(func() {
+		SuggestionMutex.Lock()
+		SuggestionMutex.Unlock()
+	})
+	okCh := make(chan bool, 1)
+	go func() {
+		okCh <- execute("events --type light --id /lights/2 --json -n 1 -t 5s", false)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for fb.Streams() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// events are only seen by subscribers that are already listening, so give cmdEvents a moment.
+	time.Sleep(50 * time.Millisecond)
+	fb.Emit("update",
+		map[string]interface{}{"id": "m", "id_v1": "/sensors/1", "type": "motion", "motion": map[string]interface{}{"motion": true}},
+		map[string]interface{}{"id": "l1", "id_v1": "/lights/1", "type": "light", "on": map[string]interface{}{"on": true}},
+		map[string]interface{}{"id": "l2", "id_v1": "/lights/2", "type": "light", "on": map[string]interface{}{"on": true}},
+	)
+	select {
+	case ok := <-okCh:
+		if !ok {
+			t.Fatal("events failed")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("events never saw the event")
+	}
+	var ev ziggy.BridgeEvent
+	if err := json.Unmarshal([]byte(out.String()), &ev); err != nil {
+		t.Fatalf("expected a single JSON event, got %q: %v", out.String(), err)
+	}
+	if ev.IdV1 != "/lights/2" || ev.Bridge != fb.ID || ev.On == nil || !ev.On.On {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	line := describeEvent(ev)
+	if !strings.Contains(line, fb.ID+" update light") || !strings.HasSuffix(line, ": on=true") {
+		t.Errorf("unexpected description: %q", line)
+	}
+	if err := cmdEvents(nil, []string{"--bridge", "nope"}); err == nil {
+		t.Error("expected an error for an unknown bridge")
+	}
+	for _, args := range [][]string{{"-t", "soon"}, {"-t", "-5s"}, {"record", "x.jsonl", "--timeout", "0"}} {
+		if err := cmdEvents(nil, args); err == nil {
+			t.Errorf("%v: expected an error for an invalid timeout", args)
+		}
+	}
+}
+
+func TestCmdEventsRecordReplay(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "motion.jsonl")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- cmdEvents(nil, []string{"record", file, "-n", "1", "-t", "5s"})
+	}()
+	// events are only seen by subscribers that are already listening, so give cmdEvents a moment.
+	time.Sleep(50 * time.Millisecond)
+	fb.SetSensorState("1", map[string]interface{}{"presence": true})
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// the recording drives automations without the bridge doing anything.
+	ran := make(chan string, 10)
+	automations.run = func(cmd string) bool {
+		ran <- cmd
+		return true
+	}
+	t.Cleanup(func() { automations.run = func(cmd string) bool { return execute(cmd, false) } })
+	add, err := splitWords(`add replayed when motion "hallway motion" do set light lamp on`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cmdAutomation(nil, add); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cmdAutomation(nil, []string{"delete", "replayed"}) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := StartAutomations(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdEvents(nil, []string{"replay", file, "--speed", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cmd := <-ran:
+		if cmd != "set light lamp on" {
+			t.Errorf("unexpected action: %s", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the automation didn't run")
+	}
+
+	if err := cmdEvents(nil, []string{"replay", file, "--speed", "fast"}); err == nil {
+		t.Error("expected an error for an invalid speed")
+	}
+	if err := cmdEvents(nil, []string{"replay", filepath.Join(t.TempDir(), "nope")}); err == nil {
+		t.Error("expected an error for a missing recording")
+	}
+}
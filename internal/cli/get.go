@@ -21,8 +21,6 @@ func cmdGet(bridge *ziggy.Bridge, args []string) error {
 	}
 
 	var (
-		groupMap     map[string]*ziggy.HueGroup
-		lightMap     map[string]*ziggy.HueLight
 		currentState *huego.State
 		otherDetails any
 		argHead      = -1
@@ -36,27 +34,31 @@ func cmdGet(bridge *ziggy.Bridge, args []string) error {
 		log.Trace().Int("argHead", argHead).Msg(args[argHead])
 		switch args[argHead] {
 		case "group", "g":
-			groupMap = ziggy.GetGroupMap()
 			if len(args) <= argHead-1 {
 				return errors.New("no group specified")
 			}
 			argHead++
-			g, ok := groupMap[strings.TrimSpace(args[argHead])]
+			g, ok := ziggy.LookupGroup(strings.TrimSpace(args[argHead]), bridge)
+			if ok {
+				log.Trace().Str("group", g.Name).Msgf("found group %s via args[%d]",
+					args[argHead], argHead,
+				)
+				currentState = g.CurrentState()
+				otherDetails = &g.Lights
+				break
+			}
+			vg, ok := ziggy.GetVirtualGroupMap()[strings.TrimSpace(args[argHead])]
 			if !ok {
 				return fmt.Errorf("group %s not found (argHead: %d)", args[argHead], argHead)
 			}
-			log.Trace().Str("group", g.Name).Msgf("found group %s via args[%d]",
-				args[argHead], argHead,
-			)
-			currentState = g.State
-			otherDetails = &g.Lights
+			currentState = vg.State()
+			otherDetails = vg.Members()
 		case "light", "l":
-			lightMap = ziggy.GetLightMap()
 			if len(args) <= argHead-1 {
 				return errors.New("no light specified")
 			}
 			argHead++
-			l, ok := lightMap[strings.TrimSpace(args[argHead])]
+			l, ok := ziggy.LookupLight(strings.TrimSpace(args[argHead]), bridge)
 			if !ok {
 				return fmt.Errorf("light %s not found (argHead: %d)", args[argHead], argHead)
 			}
@@ -64,7 +66,12 @@ func cmdGet(bridge *ziggy.Bridge, args []string) error {
 				log.Trace().Str("group", l.Name).Msgf("found light %s via args[%d]",
 					args[argHead], argHead)
 			}
-			currentState = l.State
+			currentState = l.CurrentState()
+			if st, err := l.Status(); err == nil {
+				otherDetails = st
+			} else {
+				log.Debug().Err(err).Msgf("no v2 status for light %s", l.Name)
+			}
 		}
 	}
 
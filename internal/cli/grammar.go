@@ -0,0 +1,403 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// Command lines are parsed like a small shell:
+//
+//	line    = list
+//	list    = andor { (";" | "&") andor } [";" | "&"]
+//	andor   = command { ("&&" | "||") command }
+//	command = word { word } | "(" list ")"
+//
+// Words are split on spaces and tabs, 'single quotes' keep everything in them, "double quotes" keep everything but
+// \" and \\, and a backslash outside of quotes escapes the next character. A # that starts a word and is followed
+// by a space (or nothing) starts a comment, so colors like #ff0000 don't need quotes.
+//
+// Commands followed by & run in the background, the list they are in waits for them before it finishes.
+// && runs the next command only if the last one succeeded and || only if it failed.
+
+// Exit statuses of command lines.
+const (
+	statusOK      = 0
+	statusFailed  = 1
+	statusSyntax  = 2
+	statusUnknown = 127
+)
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokSemi
+	tokAmp
+	tokAnd
+	tokOr
+	tokLParen
+	tokRParen
+)
+
+var operators = map[tokenKind]string{
+	tokSemi: ";", tokAmp: "&", tokAnd: "&&", tokOr: "||", tokLParen: "(", tokRParen: ")",
+}
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) String() string {
+	if t.kind == tokWord {
+		return t.text
+	}
+	return operators[t.kind]
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+func isOperator(c byte) bool {
+	return strings.IndexByte(";&|()\n", c) >= 0
+}
+
+// lex splits a command line into words and operators.
+func lex(line string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case isBlank(c):
+			i++
+			continue
+		case c == '#' && (i+1 == len(line) || isBlank(line[i+1]) || line[i+1] == '\n'):
+			for i < len(line) && line[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '\n' || c == ';':
+			tokens = append(tokens, token{kind: tokSemi})
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen})
+			i++
+			continue
+		case c == '&' || c == '|':
+			double := i+1 < len(line) && line[i+1] == c
+			switch {
+			case c == '&' && double:
+				tokens = append(tokens, token{kind: tokAnd})
+			case c == '&':
+				tokens = append(tokens, token{kind: tokAmp})
+			case double:
+				tokens = append(tokens, token{kind: tokOr})
+			default:
+				return nil, errors.New("pipes are not supported")
+			}
+			i++
+			if double {
+				i++
+			}
+			continue
+		}
+
+		var word strings.Builder
+		for i < len(line) && !isBlank(line[i]) && !isOperator(line[i]) {
+			switch line[i] {
+			case '\\':
+				if i+1 < len(line) {
+					word.WriteByte(line[i+1])
+				}
+				i += 2
+			case '\'':
+				end := strings.IndexByte(line[i+1:], '\'')
+				if end < 0 {
+					return nil, errors.New("unterminated quote")
+				}
+				word.WriteString(line[i+1 : i+1+end])
+				i += end + 2
+			case '"':
+				i++
+				for ; i < len(line) && line[i] != '"'; i++ {
+					if line[i] == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+						i++
+					}
+					word.WriteByte(line[i])
+				}
+				if i >= len(line) {
+					return nil, errors.New("unterminated quote")
+				}
+				i++
+			default:
+				word.WriteByte(line[i])
+				i++
+			}
+		}
+		tokens = append(tokens, token{kind: tokWord, text: word.String()})
+	}
+	return tokens, nil
+}
+
+// splitWords splits a single command into its words, it doesn't accept operators.
+func splitWords(line string) ([]string, error) {
+	tokens, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]string, 0, len(tokens))
+	for _, t := range tokens {
+		if t.kind != tokWord {
+			return nil, fmt.Errorf("unexpected %s, only one command is allowed here", t)
+		}
+		words = append(words, t.text)
+	}
+	return words, nil
+}
+
+// shellNode is a part of a parsed command line, running it returns its exit status.
+type shellNode interface {
+	run() int
+}
+
+type shellItem struct {
+	node       shellNode
+	background bool
+}
+
+// shellList runs its commands one after the other, or in the background, and then waits for the background ones.
+type shellList struct {
+	items []shellItem
+}
+
+type shellCond struct {
+	op   tokenKind
+	node shellNode
+}
+
+// shellAndOr runs its commands depending on whether the last one succeeded.
+type shellAndOr struct {
+	first shellNode
+	rest  []shellCond
+}
+
+// shellGroup is a list in parentheses.
+type shellGroup struct {
+	list *shellList
+}
+
+// shellCommand runs a single ziggs command.
+type shellCommand struct {
+	args []string
+}
+
+type shellParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *shellParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func unexpected(t token) error {
+	return fmt.Errorf("syntax error near unexpected token `%s'", t)
+}
+
+// parseLine parses a command line, an empty line parses to an empty list.
+func parseLine(line string) (*shellList, error) {
+	tokens, err := lex(line)
+	if err != nil {
+		return nil, err
+	}
+	p := &shellParser{tokens: tokens}
+	list, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, unexpected(t)
+	}
+	return list, nil
+}
+
+func (p *shellParser) list() (*shellList, error) {
+	list := &shellList{}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind == tokRParen {
+			return list, nil
+		}
+		node, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		item := shellItem{node: node}
+		if t, ok = p.peek(); ok {
+			switch t.kind {
+			case tokAmp:
+				item.background = true
+				p.pos++
+			case tokSemi:
+				p.pos++
+			case tokRParen:
+			default:
+				return nil, unexpected(t)
+			}
+		}
+		list.items = append(list.items, item)
+	}
+}
+
+func (p *shellParser) andOr() (shellNode, error) {
+	first, err := p.command()
+	if err != nil {
+		return nil, err
+	}
+	ao := &shellAndOr{first: first}
+	for {
+		t, ok := p.peek()
+		if !ok || (t.kind != tokAnd && t.kind != tokOr) {
+			break
+		}
+		p.pos++
+		if _, ok = p.peek(); !ok {
+			return nil, fmt.Errorf("expected a command after %s", t)
+		}
+		node, cerr := p.command()
+		if cerr != nil {
+			return nil, cerr
+		}
+		ao.rest = append(ao.rest, shellCond{op: t.kind, node: node})
+	}
+	if len(ao.rest) == 0 {
+		return first, nil
+	}
+	return ao, nil
+}
+
+func (p *shellParser) command() (shellNode, error) {
+	t, _ := p.peek()
+	if t.kind == tokLParen {
+		p.pos++
+		list, err := p.list()
+		if err != nil {
+			return nil, err
+		}
+		end, ok := p.peek()
+		if !ok || end.kind != tokRParen {
+			return nil, errors.New("expected `)'")
+		}
+		p.pos++
+		if len(list.items) == 0 {
+			return nil, unexpected(end)
+		}
+		if next, more := p.peek(); more && next.kind == tokWord {
+			return nil, unexpected(next)
+		}
+		return &shellGroup{list: list}, nil
+	}
+	cmd := &shellCommand{}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokWord {
+			break
+		}
+		cmd.args = append(cmd.args, t.text)
+		p.pos++
+	}
+	if len(cmd.args) == 0 {
+		return nil, unexpected(t)
+	}
+	return cmd, nil
+}
+
+func (l *shellList) run() int {
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Int32
+		status = statusOK
+	)
+	for _, item := range l.items {
+		if !item.background {
+			status = item.node.run()
+			continue
+		}
+		wg.Add(1)
+		go func(node shellNode) {
+			defer wg.Done()
+			if s := node.run(); s != statusOK {
+				failed.CompareAndSwap(statusOK, int32(s))
+			}
+		}(item.node)
+		status = statusOK
+	}
+	wg.Wait()
+	if status == statusOK {
+		status = int(failed.Load())
+	}
+	return status
+}
+
+func (ao *shellAndOr) run() int {
+	status := ao.first.run()
+	for _, c := range ao.rest {
+		if (c.op == tokAnd) == (status == statusOK) {
+			status = c.node.run()
+		}
+	}
+	return status
+}
+
+func (g *shellGroup) run() int {
+	return g.list.run()
+}
+
+func (c *shellCommand) run() (status int) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Caller(3).Msgf("PANIC: %s", r)
+			status = statusFailed
+		}
+	}()
+	if status, ok := builtin(c.args); ok {
+		return status
+	}
+
+	bcmd, ok := Commands[c.args[0]]
+	if !ok {
+		log.Error().Msg("invalid command: " + c.args[0])
+		return statusUnknown
+	}
+
+	br := selectedBridge()
+	log.Trace().Caller().Msgf("selected bridge: %s", sel.Bridge)
+
+	if br != nil && br.Health().State == ziggy.Offline {
+		log.Warn().Str("caller", br.Info.BridgeID).Err(br.Health().LastError).
+			Msg("bridge is offline, trying anyway")
+	}
+
+	if e := bcmd.reactor(br, c.args[1:]); e != nil {
+		log.Error().Msgf("error executing command: %s", e)
+		if br != nil {
+			ziggy.Supervisor.ProbeNow(br)
+		}
+		return statusFailed
+	}
+	return statusOK
+}
@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestShellGrammar(t *testing.T) {
+	for line, want := range map[string][]string{
+		`set light "desk lamp" on`:           {"set", "light", "desk lamp", "on"},
+		`set light 'it''s' on`:               {"set", "light", "its", "on"},
+		`set light "a \"b\" c\d" on`:         {"set", "light", `a "b" c\d`, "on"},
+		`set light desk\ lamp color #ff0000`: {"set", "light", "desk lamp", "color", "#ff0000"},
+		`set light lamp on # turn it on`:     {"set", "light", "lamp", "on"},
+		`set light lamp on #`:                {"set", "light", "lamp", "on"},
+		`   `:                                {},
+	} {
+		words, err := splitWords(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if strings.Join(words, "|") != strings.Join(want, "|") || len(words) != len(want) {
+			t.Errorf("%s: expected %q, got %q", line, want, words)
+		}
+	}
+	if _, err := splitWords("set light lamp on; set light desk on"); err == nil {
+		t.Error("splitWords should not accept operators")
+	}
+
+	for _, bad := range []string{
+		`set light "lamp on`, `set light 'lamp on`, "set light lamp on &&", "&& set light lamp on",
+		"set light lamp on )", "( set light lamp on", "()", "set light lamp on | grep on",
+		"set light lamp on ;; set light desk on", "(set light lamp on) desk",
+	} {
+		if _, err := parseLine(bad); err == nil {
+			t.Errorf("%s: expected a syntax error", bad)
+		}
+		if status := dispatch(bad); status != statusSyntax {
+			t.Errorf("%s: expected status %d, got %d", bad, statusSyntax, status)
+		}
+	}
+
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	for line, want := range map[string]int{
+		"":                     statusOK,
+		"# nothing to see":     statusOK,
+		"frobnicate":           statusUnknown,
+		"set light nowhere on": statusFailed,
+		"set light lamp off":   statusOK,
+	} {
+		if status := dispatch(line); status != want {
+			t.Errorf("%q: expected status %d, got %d", line, want, status)
+		}
+	}
+
+	if !execute("set light nowhere on || set light lamp on", true) {
+		t.Error("|| should run the second command after the first one failed")
+	}
+	if fb.LightState("1")["on"] != true {
+		t.Error("expected the lamp to be on")
+	}
+	if execute("set light lamp off && frobnicate", true) {
+		t.Error("&& should fail when the last command fails")
+	}
+	if fb.LightState("1")["on"] != false {
+		t.Error("expected the lamp to be off")
+	}
+	if execute("set light nowhere on && set light lamp on", true) {
+		t.Error("&& should not run the second command after the first one failed")
+	}
+	if fb.LightState("1")["on"] != false {
+		t.Error("expected the lamp to stay off")
+	}
+	if !execute("set light lamp on || set light desk on; set light desk off", true) {
+		t.Error("a list should succeed when its last command does")
+	}
+	if fb.LightState("1")["on"] != true || fb.LightState("2")["on"] != false {
+		t.Errorf("|| should skip the desk: %v %v", fb.LightState("1"), fb.LightState("2"))
+	}
+	if !execute("(set light lamp off & set light desk on) && set light desk off", true) {
+		t.Error("group failed")
+	}
+	if fb.LightState("1")["on"] != false || fb.LightState("2")["on"] != false {
+		t.Errorf("group did not run: %v %v", fb.LightState("1"), fb.LightState("2"))
+	}
+	if status := dispatch("set light nowhere on & set light lamp on"); status != statusFailed {
+		t.Errorf("a failed background job should fail the list, got %d", status)
+	}
+}
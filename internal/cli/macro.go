@@ -0,0 +1,271 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// maxNesting bounds how deep macros and scripts can run other macros and scripts,
+// so one that runs itself gives up.
+const maxNesting = 16
+
+var macroDepth atomic.Int32
+
+type macroRecorder struct {
+	macro *data.Macro
+	*sync.Mutex
+}
+
+// recorder holds the macro that successful command lines from the Executor are added to, if any.
+var recorder = &macroRecorder{Mutex: new(sync.Mutex)}
+
+// noRecord are commands that are never recorded into a macro.
+var noRecord = map[string]bool{"macro": true, "help": true}
+
+// recordMacroLine adds cmd to the macro being recorded, unless one of its commands is in noRecord.
+// It splits cmd into words the same way the Executor does, so quoting a command doesn't sneak it in.
+func recordMacroLine(cmd string) {
+	tokens, err := lex(cmd)
+	if err != nil || len(tokens) == 0 {
+		return
+	}
+	// every operator starts another command.
+	start := true
+	for _, t := range tokens {
+		if t.kind != tokWord {
+			start = true
+			continue
+		}
+		if start && noRecord[t.text] {
+			return
+		}
+		start = false
+	}
+	recorder.Lock()
+	defer recorder.Unlock()
+	if recorder.macro != nil {
+		recorder.macro.Sequence = append(recorder.macro.Sequence, strings.TrimSpace(cmd))
+	}
+}
+
+// editFile lets the user edit the file at path, it is replaced in tests.
+var editFile = func(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// editors like "code --wait" come with their own arguments.
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// editMacro opens the lines of mcro in an editor, one command line per line, and returns what the user left there.
+func editMacro(mcro *data.Macro) ([]string, error) {
+	f, err := os.CreateTemp("", "ziggs-macro-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	_, err = fmt.Fprintf(f, "# %s: one command per line, lines starting with # are ignored.\n%s\n",
+		mcro.Name, strings.Join(mcro.Sequence, "\n"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = editFile(f.Name()); err != nil {
+		return nil, fmt.Errorf("editor failed: %w", err)
+	}
+	edited, err := os.Open(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = edited.Close() }()
+	var lines []string
+	xerox := bufio.NewScanner(edited)
+	for xerox.Scan() {
+		line := strings.TrimSpace(xerox.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, xerox.Err()
+}
+
+// runMacro runs every line of the macro called name through the same path as the Executor, stopping at the
+// first line that fails.
+func runMacro(name string) error {
+	mcro, err := data.GetMacro(name)
+	if err != nil {
+		return err
+	}
+	if macroDepth.Add(1) > maxNesting {
+		macroDepth.Add(-1)
+		return fmt.Errorf("macro %s: macros nested too deep", mcro.Name)
+	}
+	defer macroDepth.Add(-1)
+	for i, line := range mcro.Sequence {
+		log.Debug().Str("macro", mcro.Name).Msg(line)
+		if dispatch(line) != statusOK {
+			return fmt.Errorf("macro %s stopped at line %d: %s", mcro.Name, i+1, line)
+		}
+	}
+	return nil
+}
+
+// macroDescription takes a -d/--description flag off the front of args.
+func macroDescription(args []string) (string, []string, error) {
+	if len(args) == 0 || (args[0] != "-d" && args[0] != "--description") {
+		return "", args, nil
+	}
+	if len(args) < 2 {
+		return "", nil, fmt.Errorf("%s needs a value", args[0])
+	}
+	return args[1], args[2:], nil
+}
+
+// cmdMacro manages and runs macros, command lines saved under a name.
+//
+//	macro add <name> [-d <description>] <command line>
+//	macro list
+//	macro show|run|edit|delete <name>
+//	macro record <name> [-d <description>]
+//	macro stop
+func cmdMacro(_ *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return ErrNotEnoughArguments
+	}
+	switch args[0] {
+	case "list", "ls":
+		all, err := data.Macros()
+		if err != nil {
+			return err
+		}
+		for _, m := range all {
+			log.Info().Int("lines", len(m.Sequence)).Str("description", m.Description).Msg(m.Name)
+		}
+		return nil
+	case "stop":
+		recorder.Lock()
+		mcro := recorder.macro
+		recorder.macro = nil
+		recorder.Unlock()
+		if mcro == nil {
+			return errors.New("not recording a macro")
+		}
+		if len(mcro.Sequence) == 0 {
+			return fmt.Errorf("nothing was recorded, macro %s not saved", mcro.Name)
+		}
+		if err := data.PutMacro(mcro); err != nil {
+			return fmt.Errorf("failed to save macro: %w", err)
+		}
+		log.Info().Int("lines", len(mcro.Sequence)).Msgf("recorded macro %s", mcro.Name)
+		return nil
+	case "add", "show", "run", "edit", "delete", "rm", "record":
+	default:
+		return fmt.Errorf("unknown macro action: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("no macro name specified")
+	}
+	name := strings.TrimSpace(args[1])
+	switch args[0] {
+	case "add":
+		desc, rest, err := macroDescription(args[2:])
+		if err != nil {
+			return err
+		}
+		if len(rest) == 0 {
+			return errors.New("no command line specified")
+		}
+		// a command line in quotes is taken as it is, e.g. macro add wake "set light lamp on; set light desk on"
+		line := rest[0]
+		if len(rest) > 1 {
+			line = joinArgs(rest)
+		}
+		if err = data.AddMacro(name, desc, line); err != nil {
+			return err
+		}
+		log.Info().Msgf("added macro %s: %s", name, line)
+		return nil
+	case "show":
+		mcro, err := data.GetMacro(name)
+		if err != nil {
+			return err
+		}
+		if mcro.Description != "" {
+			log.Info().Msg(mcro.Description)
+		}
+		for _, line := range mcro.Sequence {
+			if _, err = fmt.Fprintln(stdout, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "run":
+		return runMacro(name)
+	case "edit":
+		mcro, err := data.GetMacro(name)
+		if errors.Is(err, data.ErrNoSuchMacro) {
+			mcro, err = &data.Macro{Name: name}, nil
+		}
+		if err != nil {
+			return err
+		}
+		lines, err := editMacro(mcro)
+		if err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("macro %s left empty, not saved", name)
+		}
+		edited := *mcro
+		edited.Sequence = lines
+		if err = data.PutMacro(&edited); err != nil {
+			return fmt.Errorf("failed to save macro: %w", err)
+		}
+		log.Info().Int("lines", len(lines)).Msgf("saved macro %s", name)
+		return nil
+	case "record":
+		desc, rest, err := macroDescription(args[2:])
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return fmt.Errorf("unexpected argument: %s", rest[0])
+		}
+		if _, err = data.GetMacro(name); err == nil {
+			return fmt.Errorf("a macro named %q already exists", name)
+		}
+		recorder.Lock()
+		defer recorder.Unlock()
+		if recorder.macro != nil {
+			return fmt.Errorf("already recording macro %s", recorder.macro.Name)
+		}
+		recorder.macro = &data.Macro{Name: name, Description: desc}
+		log.Info().Msgf("recording macro %s, use 'macro stop' to save it", name)
+		return nil
+	default:
+		if err := data.DeleteMacro(name); err != nil {
+			return err
+		}
+		log.Info().Msgf("deleted macro %s", name)
+		return nil
+	}
+}
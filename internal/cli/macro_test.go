@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"os"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdMacro(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+
+	if !execute(`macro add wake -d "morning lights" "set light lamp on; set light desk on"`, true) {
+		t.Fatal("macro add failed")
+	}
+	if execute(`macro add wake "set light lamp off"`, true) {
+		t.Error("adding the same macro twice should fail")
+	}
+	if !execute("macro run wake", true) {
+		t.Fatal("macro run failed")
+	}
+	if fb.LightState("1")["on"] != true || fb.LightState("2")["on"] != true {
+		t.Errorf("wake did not turn on both lights: %v %v", fb.LightState("1"), fb.LightState("2"))
+	}
+
+	if !execute("macro record night", true) {
+		t.Fatal("macro record failed")
+	}
+	execute("set light lamp off & set light desk off", true)
+	execute("frobnicate", true)
+	execute(`"help" set`, true)
+	execute("set light lamp off; macro show wake", true)
+	if !execute("macro stop", true) {
+		t.Fatal("macro stop failed")
+	}
+	night, err := data.GetMacro("night")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(night.Sequence) != 1 || night.Sequence[0] != "set light lamp off & set light desk off" {
+		t.Errorf("expected only the successful line to be recorded, got %q", night.Sequence)
+	}
+	if fb.LightState("1")["on"] != false || fb.LightState("2")["on"] != false {
+		t.Errorf("night did not turn off both lights")
+	}
+	if execute("macro stop", true) {
+		t.Error("stopping without recording should fail")
+	}
+
+	out := captureStdout(t)
+	if err = cmdMacro(nil, []string{"show", "night"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "set light lamp off & set light desk off\n" {
+		t.Errorf("unexpected macro: %q", out.String())
+	}
+
+	edit := editFile
+	editFile = func(path string) error {
+		return os.WriteFile(path, []byte("# replaced\nset light lamp on\n\n"), 0o600)
+	}
+	t.Cleanup(func() { editFile = edit })
+	if !execute("macro edit night", true) {
+		t.Fatal("macro edit failed")
+	}
+	if night, err = data.GetMacro("night"); err != nil || len(night.Sequence) != 1 || night.Sequence[0] != "set light lamp on" {
+		t.Errorf("unexpected edited macro: %+v %v", night, err)
+	}
+
+	// a failing line stops the macro, and a macro running itself gives up.
+	if err = data.AddMacro("broken", "", "set light nonexistent on", "set light lamp off"); err != nil {
+		t.Fatal(err)
+	}
+	fb.SetLightState("1", map[string]interface{}{"on": true})
+	if execute("macro run broken", true) {
+		t.Error("running a macro with a failing line should fail")
+	}
+	if fb.LightState("1")["on"] != true {
+		t.Error("the line after the failing one ran")
+	}
+	if err = data.AddMacro("loop", "", "macro run loop"); err != nil {
+		t.Fatal(err)
+	}
+	if execute("macro run loop", true) {
+		t.Error("a macro running itself should fail")
+	}
+
+	if err = cmdMacro(nil, []string{"list"}); err != nil {
+		t.Error(err)
+	}
+	if !execute("macro delete wake", true) {
+		t.Error("macro delete failed")
+	}
+	for _, bad := range []string{"macro run wake", "macro delete wake", "macro add", "macro add x", "macro bogus", "macro record night"} {
+		if execute(bad, true) {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+}
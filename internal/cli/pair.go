@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// cmdPair pairs with a bridge without prompting for anything, polling it until the link button is pressed.
+// The bridge is added to the config next to the ones we already know about and connected to right away.
+//
+//	pair <host> [-t <timeout>] [--clientkey] [--name <devicetype>] [--alias <alias>] [--proxy <socks5 host:port>]
+func cmdPair(_ *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return errors.New("no bridge host specified")
+	}
+	var (
+		host string
+		opts ziggy.PairOptions
+	)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--clientkey", "-k":
+			opts.ClientKey = true
+		case "-t", "--timeout", "--name", "--alias", "--proxy":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a value", args[i])
+			}
+			flag, val := args[i], args[i+1]
+			i++
+			switch flag {
+			case "--name":
+				opts.DeviceType = val
+			case "--alias":
+				opts.Alias = val
+			case "--proxy":
+				opts.Proxy = val
+			default:
+				d, err := parseTransition(val)
+				if err != nil || d <= 0 {
+					return fmt.Errorf("invalid timeout: %s", val)
+				}
+				opts.Timeout = d
+			}
+		default:
+			if host != "" {
+				return fmt.Errorf("unexpected argument: %s", args[i])
+			}
+			host = args[i]
+		}
+	}
+	if host == "" {
+		return errors.New("no bridge host specified")
+	}
+
+	kb, err := ziggy.Pair(context.Background(), host, opts)
+	if err != nil {
+		return err
+	}
+	e := log.Info().Str("host", kb.Hostname)
+	if kb.ClientKey != "" {
+		e = e.Bool("clientkey", true)
+	}
+	e.Msg("bridge added to the config")
+
+	c, err := ziggy.Connect(kb)
+	if err != nil {
+		return fmt.Errorf("paired, but failed to connect: %w", err)
+	}
+	log.Info().Str("id", c.Info.BridgeID).Int("lights", len(c.Lights())).Msg("connected")
+	return nil
+}
+
+// standalone are the commands that don't need any bridges, ziggs runs them before connecting to anything.
+var standalone = map[string]reactor{
+	"discover":  cmdDiscover,
+	"pair":      cmdPair,
+	"--help":    cmdUsage,
+	"--version": cmdVersion,
+}
+
+// RunStandalone runs args if they are a command that works without bridges, e.g. `ziggs pair 192.168.1.2`.
+// It reports whether it ran anything.
+func RunStandalone(args []string) (bool, error) {
+	useLogger()
+	if len(args) == 0 {
+		return false, nil
+	}
+	run, ok := standalone[args[0]]
+	if !ok {
+		return false, nil
+	}
+	return true, run(nil, args[1:])
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdPair(t *testing.T) {
+	newTestBridge(t)
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.AddLight("porch")
+	oldConfig := config.Snek.ConfigFileUsed()
+	config.Snek.SetConfigFile(filepath.Join(t.TempDir(), "config.toml"))
+	t.Cleanup(func() { config.Snek.SetConfigFile(oldConfig) })
+
+	if err := cmdPair(nil, []string{fb.Hostname(), "-t", "100ms"}); err == nil {
+		t.Fatal("pairing should time out without the link button")
+	}
+	fb.PressLinkButton()
+	if err := cmdPair(nil, []string{fb.Hostname(), "--alias", "porch", "-t", "1s"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.KnownBridges) != 2 || config.KnownBridges[1].Alias != "porch" {
+		t.Fatalf("bridge wasn't added next to the other: %+v", config.KnownBridges)
+	}
+	ziggy.Lucifer.RLock()
+	connected := len(ziggy.Lucifer.Bridges)
+	ziggy.Lucifer.RUnlock()
+	if connected != 2 {
+		t.Errorf("expected 2 connected bridges, got %d", connected)
+	}
+	if ran, err := RunStandalone([]string{"pair"}); !ran || err == nil {
+		t.Errorf("pair without a host should run and fail, got %v %v", ran, err)
+	}
+	if ran, _ := RunStandalone([]string{"ls"}); ran {
+		t.Error("ls needs bridges and shouldn't run standalone")
+	}
+}
@@ -1,25 +1,90 @@
 package cli
 
 import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	cli "git.tcp.direct/Mirrors/go-prompt"
+	"github.com/yunginnanet/huego"
 
 	"git.tcp.direct/kayos/ziggs/internal/config"
 	"git.tcp.direct/kayos/ziggs/internal/ziggy"
 )
 
+var logOnce = &sync.Once{}
+
+// useLogger points log at the logger config started, once. Everything ziggs calls in here starts with it,
+// so log is set before anything else runs.
+func useLogger() {
+	logOnce.Do(func() {
+		if log == nil {
+			log = config.GetLogger()
+		}
+	})
+}
+
 func init() {
-	go func() {
-		log = config.GetLogger()
-	}()
+	// keep the selected bridge selected when it turns up at a new address.
+	ziggy.OnRelocate(func(oldKey, newKey string) {
+		if sel.Bridge == oldKey {
+			sel.Bridge = newKey
+		}
+	})
+}
+
+// RefreshDelay is how long RefreshSuggestions waits for more changes before refreshing the suggestions.
+var RefreshDelay = 250 * time.Millisecond
+
+// refreshPending is set from the moment RefreshSuggestions schedules a refresh until the refresh starts.
+var refreshPending atomic.Bool
+
+// RefreshSuggestions refreshes the suggestions in the background once RefreshDelay has passed, however often
+// it's called in the meantime. ziggy.Registry calls it whenever it changes, so a burst of events costs a single
+// refresh and never holds up the eventstream while it waits for SuggestionMutex.
+func RefreshSuggestions() {
+	if !refreshPending.CompareAndSwap(false, true) {
+		return
+	}
+	time.AfterFunc(RefreshDelay, func() {
+		refreshPending.Store(false)
+		ProcessAll()
+	})
+}
+
+// ProcessAll refreshes the suggestions for everything on every bridge.
+func ProcessAll() {
+	useLogger()
+	ProcessGroups(ziggy.GetGroupMap())
+	ProcessVirtualGroups(ziggy.GetVirtualGroupMap())
+	ProcessLights(ziggy.GetLightMap())
+	ProcessScenes(ziggy.GetSceneMap())
+}
+
+func stateSuffix(st *huego.State) string {
+	switch {
+	case st == nil:
+		return ""
+	case !st.On:
+		return " [off]"
+	default:
+		return " [on " + strconv.Itoa(int(st.Bri)*100/254) + "%]"
+	}
 }
 
 func ProcessGroups(grps map[string]*ziggy.HueGroup) {
 	for grp, g := range grps {
+		// bridge-qualified names are only suggested when the bare name is ambiguous
+		if grp != g.Name && grp != strconv.Itoa(g.ID) && grps[g.Name] == g {
+			continue
+		}
 		log.Trace().Caller().Msgf("Processing group %s", grp)
 		suffix := ""
 		if g.Type != "" {
 			suffix = " (" + g.Type + ")"
 		}
+		suffix += stateSuffix(g.CurrentState())
 		SuggestionMutex.Lock()
 		suggestions[2][grp] = &completion{
 			Suggest: cli.Suggest{
@@ -36,8 +101,31 @@ func ProcessGroups(grps map[string]*ziggy.HueGroup) {
 	}
 }
 
+// ProcessVirtualGroups adds the virtual groups from our config file to the group suggestions.
+func ProcessVirtualGroups(vgrps map[string]*ziggy.Multiplex) {
+	for vgrp, vg := range vgrps {
+		log.Trace().Caller().Msgf("Processing virtual group %s", vgrp)
+		SuggestionMutex.Lock()
+		suggestions[2][vgrp] = &completion{
+			Suggest: cli.Suggest{
+				Text:        vgrp,
+				Description: "Virtual group (" + strconv.Itoa(vg.Len()) + " members)",
+			},
+			requires: map[int]map[string]bool{
+				1: {"set": true, "s": true, "get": true},
+				2: {"group": true, "g": true},
+			},
+			root: false,
+		}
+		SuggestionMutex.Unlock()
+	}
+}
+
 func ProcessScenes(scns map[string]*ziggy.HueScene) {
 	for scn, s := range scns {
+		if scn != s.Name && scns[s.Name] == s {
+			continue
+		}
 		log.Trace().Caller().Msgf("Processing scene %s", scn)
 		suffix := ""
 		if s.Type != "" {
@@ -88,11 +176,15 @@ func ProcessScenes(scns map[string]*ziggy.HueScene) {
 
 func ProcessLights(lghts map[string]*ziggy.HueLight) {
 	for lt, l := range lghts {
+		if lt != l.Name && lghts[l.Name] == l {
+			continue
+		}
 		log.Trace().Caller().Msgf("Processing light %s", lt)
 		suffix := ""
 		if l.Type != "" {
 			suffix = " (" + l.Type + ")"
 		}
+		suffix += stateSuffix(l.CurrentState())
 		SuggestionMutex.Lock()
 		suggestions[2][lt] = &completion{
 			Suggest: cli.Suggest{
@@ -110,6 +202,7 @@ func ProcessLights(lghts map[string]*ziggy.HueLight) {
 }
 
 func ProcessBridges() {
+	useLogger()
 	for brd, b := range ziggy.Lucifer.Bridges {
 		log.Trace().Caller().Msgf("Processing bridge %s", brd)
 		SuggestionMutex.Lock()
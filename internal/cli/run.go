@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"git.tcp.direct/kayos/ziggs/internal/common"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// subcommands are commands that only make sense outside of the shell, by the names ziggs takes them under.
+var subcommands = map[string]string{
+	"run": "source",
+}
+
+// invocation is what ziggs was asked to do when it was started with arguments.
+type invocation struct {
+	bridge  string
+	quiet   bool
+	help    bool
+	version bool
+	line    string
+	args    []string
+}
+
+const runUsage = `usage: ziggs [flags] <command> [args...]
+       ziggs [flags] -e <command line>
+       ziggs [flags] -- <command line>
+
+flags:
+  -b, --bridge <bridge>   run the command on this bridge, by ID, alias or address
+  -q, --quiet             only log errors
+  -e, --exec <line>       run a whole command line, e.g. "set light lamp on && set light desk on"
+  -c, --config <file>     use this config file
+      --version           print the version and exit
+
+commands are the same as in the shell, see 'ziggs help'. ziggs exits with 0 if the command
+succeeded, 1 if it failed, 2 if it was used wrong and 127 if there is no such command.`
+
+func cmdUsage(_ *ziggy.Bridge, _ []string) error {
+	_, err := fmt.Fprintln(stdout, runUsage)
+	return err
+}
+
+func cmdVersion(_ *ziggy.Bridge, _ []string) error {
+	_, version := common.Version()
+	if version == "" {
+		version = "DEVEL"
+	}
+	_, err := fmt.Fprintln(stdout, version)
+	return err
+}
+
+// parseInvocation parses the flags in front of the command ziggs was started with.
+// The flags that config handles by itself are skipped.
+func parseInvocation(args []string) (*invocation, error) {
+	inv := &invocation{}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--quiet", "-q":
+			inv.quiet = true
+		case "--help":
+			inv.help = true
+			return inv, nil
+		case "--version":
+			inv.version = true
+			return inv, nil
+		case "--genconfig", "--nocolor":
+		case "--":
+			inv.line = joinArgs(args[i+1:])
+			return inv, nil
+		case "--bridge", "-b", "--exec", "-e", "--config", "-c":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s needs a value", args[i])
+			}
+			flag, val := args[i], args[i+1]
+			i++
+			switch flag {
+			case "--bridge", "-b":
+				inv.bridge = val
+			case "--exec", "-e":
+				if i+1 < len(args) {
+					return nil, fmt.Errorf("unexpected argument after %s: %s", flag, args[i+1])
+				}
+				inv.line = val
+				return inv, nil
+			}
+		default:
+			if strings.HasPrefix(args[i], "-") {
+				return nil, fmt.Errorf("unknown flag: %s", args[i])
+			}
+			inv.args = args[i:]
+			if sub, ok := subcommands[inv.args[0]]; ok {
+				inv.args = append([]string{sub}, inv.args[1:]...)
+			}
+			return inv, nil
+		}
+	}
+	return nil, errors.New("no command specified")
+}
+
+// Run runs the command ziggs was started with, e.g. `ziggs set group kitchen off` or
+// `ziggs --bridge upstairs ls lights --json`, and returns the status ziggs should exit with.
+func Run(args []string) int {
+	useLogger()
+	inv, err := parseInvocation(args)
+	switch {
+	case err != nil:
+		log.Error().Msg(err.Error())
+		_, _ = fmt.Fprintln(os.Stderr, runUsage)
+		return statusSyntax
+	case inv.help:
+		_ = cmdUsage(nil, nil)
+		return statusOK
+	case inv.version:
+		_ = cmdVersion(nil, nil)
+		return statusOK
+	}
+	if inv.quiet {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+
+	if inv.bridge != "" {
+		br, ok := ziggy.FindBridge(inv.bridge)
+		if !ok {
+			log.Error().Msg("invalid bridge: " + inv.bridge)
+			return statusSyntax
+		}
+		sel.Bridge = br.Info.IPAddress
+	}
+	if inv.args == nil {
+		return dispatch(inv.line)
+	}
+	return (&shellCommand{args: inv.args}).run()
+}
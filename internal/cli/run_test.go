@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestRun(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	out := captureStdout(t)
+	t.Cleanup(func() { sel.Bridge = "" })
+
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{nil, statusSyntax},
+		{[]string{"-q"}, statusSyntax},
+		{[]string{"--bogus", "ls"}, statusSyntax},
+		{[]string{"ls", "--bridge"}, statusOK},
+		{[]string{"--bridge"}, statusSyntax},
+		{[]string{"--bridge", "nowhere", "ls"}, statusSyntax},
+		{[]string{"-e", "set light lamp on", "extra"}, statusSyntax},
+		{[]string{"-e", "set light lamp on &&"}, statusSyntax},
+		{[]string{"frobnicate"}, statusUnknown},
+		{[]string{"set", "light", "nowhere", "on"}, statusFailed},
+		{[]string{"--config", "ziggs.toml", "set", "light", "lamp", "on"}, statusOK},
+	} {
+		if status := Run(tc.args); status != tc.want {
+			t.Errorf("%q: expected status %d, got %d", tc.args, tc.want, status)
+		}
+	}
+	if fb.LightState("1")["on"] != true {
+		t.Error("expected the lamp to be on")
+	}
+
+	if status := Run([]string{"--bridge", br.Info.BridgeID, "set", "light", "lamp", "off"}); status != statusOK {
+		t.Errorf("expected status 0, got %d", status)
+	}
+	if sel.Bridge != br.Info.IPAddress {
+		t.Errorf("expected %s to be selected, got %s", br.Info.IPAddress, sel.Bridge)
+	}
+	if status := Run([]string{"-e", "set light lamp on && set light desk on"}); status != statusOK {
+		t.Errorf("expected status 0, got %d", status)
+	}
+	if fb.LightState("1")["on"] != true || fb.LightState("2")["on"] != true {
+		t.Error("expected both lights to be on")
+	}
+	if status := Run([]string{"-e", "set light lamp off; set light desk off"}); status != statusOK {
+		t.Errorf("expected status 0, got %d", status)
+	}
+	if fb.LightState("1")["on"] != false || fb.LightState("2")["on"] != false {
+		t.Error("expected both lights to be off")
+	}
+	// after --, the words are taken as they are, like the shell ziggs was started from split them.
+	if status := Run([]string{"--", "set", "light", "lamp", "on;", "frobnicate"}); status != statusFailed {
+		t.Errorf("expected status %d, got %d", statusFailed, status)
+	}
+	if status := Run([]string{"--", "set", "light", "lamp", "on"}); status != statusOK || fb.LightState("1")["on"] != true {
+		t.Errorf("expected the lamp to be turned on, got status %d", status)
+	}
+
+	// with another bridge around, ls only lists what is on the selected one.
+	other := fakebridge.New()
+	t.Cleanup(other.Close)
+	other.AddLight("hall")
+	c, err := ziggy.Connect(config.KnownBridge{Hostname: other.Hostname(), Username: other.NewUser("ziggs#test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ziggy.Disconnect(c) })
+	lsLights := func(args ...string) (names []string) {
+		t.Helper()
+		out.Reset()
+		if status := Run(append(args, "ls", "lights", "--json")); status != statusOK {
+			t.Fatalf("expected status 0, got %d", status)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var l listed
+			if err := json.Unmarshal([]byte(line), &l); err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			if l.Kind != "light" {
+				t.Errorf("unexpected light: %s", line)
+			}
+			names = append(names, l.Bridge+" "+l.Name)
+		}
+		return names
+	}
+	want := br.Info.BridgeID + " desk, " + br.Info.BridgeID + " lamp"
+	if names := lsLights("--bridge", br.Info.BridgeID); strings.Join(names, ", ") != want {
+		t.Errorf("expected %s, got %q", want, names)
+	}
+	if names := lsLights("--bridge", c.Info.BridgeID); strings.Join(names, ", ") != c.Info.BridgeID+" hall" {
+		t.Errorf("expected only the hall, got %q", names)
+	}
+	sel.Bridge = ""
+	if names := lsLights(); len(names) != 3 {
+		t.Errorf("expected the lights of both bridges, got %q", names)
+	}
+	sel.Bridge = br.Info.IPAddress
+
+	out.Reset()
+	if status := Run([]string{"ls", "group", "rules", "--json"}); status != statusOK {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+	if !strings.Contains(out.String(), `"kind":"group","name":"office"`) ||
+		!strings.Contains(out.String(), `"kind":"rule","name":"motion"`) ||
+		strings.Contains(out.String(), `"kind":"light"`) {
+		t.Errorf("expected only the office and the motion rule, got %s", out.String())
+	}
+
+	out.Reset()
+	if status := Run([]string{"--version"}); status != statusOK || strings.TrimSpace(out.String()) == "" {
+		t.Errorf("expected a version, got %d %q", status, out.String())
+	}
+}
@@ -0,0 +1,538 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// A ziggs script is a file of statements, one per line. Lines starting with # are comments.
+//
+//	let room = office
+//	for l in lights          (or groups, or a list of words)
+//	  set light $l off
+//	end
+//	repeat 3
+//	  set group $room on
+//	  wait 500ms
+//	  set group $room off
+//	end
+//	if not light lamp on     (on, off, reachable or unreachable; groups are on or off)
+//	  set light lamp on
+//	else
+//	  fail lamp was already on
+//	end
+//	try
+//	  set light hallway on
+//	catch err
+//	  set light lamp color #ff0000
+//	end
+//	exit
+//
+// Everything else is a ziggs command, run by its reactor with $name and ${name} replaced by variables.
+
+var (
+	scriptVariable = regexp.MustCompile(`\$\$|\$\{(\w+)\}|\$(\w+)`)
+	scriptName     = regexp.MustCompile(`^\w+$`)
+	// errScriptExit stops a script without failing it.
+	errScriptExit = errors.New("exit")
+)
+
+var scriptDepth atomic.Int32
+
+// scriptError is an error on a line of a script.
+type scriptError struct {
+	file string
+	line int
+	err  error
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.err)
+}
+
+func (e *scriptError) Unwrap() error {
+	return e.err
+}
+
+type statement struct {
+	line  int
+	words []string
+	// body is what if, for, repeat and try run, alt is the else of an if or the catch of a try.
+	body, alt []*statement
+}
+
+type scriptParser struct {
+	file  string
+	lines []*statement
+	pos   int
+}
+
+func (p *scriptParser) errorf(line int, format string, args ...interface{}) error {
+	return &scriptError{file: p.file, line: line, err: fmt.Errorf(format, args...)}
+}
+
+// parseScript reads a script from r, file is what errors call it.
+func parseScript(file string, r io.Reader) ([]*statement, error) {
+	p := &scriptParser{file: file}
+	xerox := bufio.NewScanner(r)
+	for n := 1; xerox.Scan(); n++ {
+		text := strings.TrimSpace(xerox.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		words, err := splitWords(text)
+		if err != nil {
+			return nil, p.errorf(n, "%w", err)
+		}
+		p.lines = append(p.lines, &statement{line: n, words: words})
+	}
+	if err := xerox.Err(); err != nil {
+		return nil, err
+	}
+	stmts, term, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if term != nil {
+		return nil, p.errorf(term.line, "%s without a matching statement", term.words[0])
+	}
+	return stmts, nil
+}
+
+// block parses statements until end, else or catch, which it returns as well.
+func (p *scriptParser) block() ([]*statement, *statement, error) {
+	var stmts []*statement
+	for p.pos < len(p.lines) {
+		st := p.lines[p.pos]
+		p.pos++
+		switch st.words[0] {
+		case "end", "else", "catch":
+			return stmts, st, nil
+		}
+		if err := p.check(st); err != nil {
+			return nil, nil, err
+		}
+		switch st.words[0] {
+		case "if", "for", "repeat", "try":
+			if err := p.nested(st); err != nil {
+				return nil, nil, err
+			}
+		}
+		stmts = append(stmts, st)
+	}
+	return stmts, nil, nil
+}
+
+// nested parses the body of st, and its else or catch.
+func (p *scriptParser) nested(st *statement) error {
+	body, term, err := p.block()
+	if err != nil {
+		return err
+	}
+	st.body = body
+	alt := map[string]string{"if": "else", "try": "catch"}[st.words[0]]
+	switch {
+	case term == nil:
+		return p.errorf(st.line, "%s without end", st.words[0])
+	case term.words[0] == "end":
+		if len(term.words) > 1 {
+			return p.errorf(term.line, "unexpected argument: %s", term.words[1])
+		}
+		if st.words[0] == "try" {
+			return p.errorf(st.line, "try without catch")
+		}
+		return nil
+	case term.words[0] != alt:
+		return p.errorf(term.line, "%s without a matching statement", term.words[0])
+	}
+	switch {
+	case alt == "else" && len(term.words) > 1:
+		return p.errorf(term.line, "unexpected argument: %s", term.words[1])
+	case alt == "catch" && (len(term.words) > 2 || len(term.words) == 2 && !scriptName.MatchString(term.words[1])):
+		return p.errorf(term.line, "catch takes the name of a variable to put the error in")
+	}
+	st.alt = append(st.alt, &statement{line: term.line, words: term.words})
+	alternative, end, err := p.block()
+	if err != nil {
+		return err
+	}
+	if end == nil || end.words[0] != "end" {
+		return p.errorf(st.line, "%s without end", st.words[0])
+	}
+	st.alt = append(st.alt, alternative...)
+	return nil
+}
+
+// hasVariable reports whether word has a variable in it, so it can only be checked when it runs.
+func hasVariable(word string) bool {
+	return strings.Contains(strings.ReplaceAll(word, "$$", ""), "$")
+}
+
+// check finds the mistakes in st that can be found before the script runs.
+func (p *scriptParser) check(st *statement) error {
+	w := st.words
+	switch w[0] {
+	case "let":
+		if len(w) < 3 || w[2] != "=" || !scriptName.MatchString(w[1]) {
+			return p.errorf(st.line, "usage: let <name> = <value>")
+		}
+	case "for":
+		if len(w) < 4 || w[2] != "in" || !scriptName.MatchString(w[1]) {
+			return p.errorf(st.line, "usage: for <name> in lights|groups|<word>...")
+		}
+	case "repeat":
+		if len(w) != 2 {
+			return p.errorf(st.line, "usage: repeat <count>")
+		}
+		if _, err := repeatCount(w[1]); err != nil && !hasVariable(w[1]) {
+			return p.errorf(st.line, "%w", err)
+		}
+	case "wait":
+		if len(w) != 2 {
+			return p.errorf(st.line, "usage: wait <duration>")
+		}
+		if _, err := time.ParseDuration(w[1]); err != nil && !hasVariable(w[1]) {
+			return p.errorf(st.line, "%w", err)
+		}
+	case "if":
+		for _, word := range w[1:] {
+			if hasVariable(word) {
+				return nil
+			}
+		}
+		if err := checkCondition(w[1:]); err != nil {
+			return p.errorf(st.line, "%w", err)
+		}
+	case "try", "exit":
+		if len(w) > 1 {
+			return p.errorf(st.line, "unexpected argument: %s", w[1])
+		}
+	case "fail":
+	default:
+		if _, ok := Commands[w[0]]; !ok && !hasVariable(w[0]) {
+			return p.errorf(st.line, "unknown command: %s", w[0])
+		}
+	}
+	return nil
+}
+
+func repeatCount(word string) (int, error) {
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid count: %s", word)
+	}
+	return n, nil
+}
+
+// checkCondition checks [not] light <name> on|off|reachable|unreachable or [not] group <name> on|off.
+func checkCondition(cond []string) error {
+	if len(cond) > 0 && cond[0] == "not" {
+		cond = cond[1:]
+	}
+	if len(cond) != 3 {
+		return errors.New("usage: if [not] light|group <name> <state>")
+	}
+	switch cond[0] {
+	case "light", "l":
+		switch cond[2] {
+		case "on", "off", "reachable", "unreachable":
+			return nil
+		}
+	case "group", "g":
+		switch cond[2] {
+		case "on", "off":
+			return nil
+		}
+	default:
+		return fmt.Errorf("can't test a %s, only lights and groups", cond[0])
+	}
+	return fmt.Errorf("a %s can't be %s", cond[0], cond[2])
+}
+
+// scriptRun is one run of a script.
+type scriptRun struct {
+	ctx  context.Context
+	br   *ziggy.Bridge
+	file string
+	vars map[string]string
+	// dry prints the commands instead of running them.
+	dry bool
+}
+
+func (r *scriptRun) errorf(st *statement, format string, args ...interface{}) error {
+	return &scriptError{file: r.file, line: st.line, err: fmt.Errorf(format, args...)}
+}
+
+// expand replaces the variables in the words of st.
+func (r *scriptRun) expand(st *statement, words []string) ([]string, error) {
+	ret := make([]string, len(words))
+	for i, word := range words {
+		var missing string
+		ret[i] = scriptVariable.ReplaceAllStringFunc(word, func(v string) string {
+			if v == "$$" {
+				return "$"
+			}
+			m := scriptVariable.FindStringSubmatch(v)
+			name := m[1] + m[2]
+			val, ok := r.vars[name]
+			if !ok && missing == "" {
+				missing = name
+			}
+			return val
+		})
+		if missing != "" {
+			return nil, r.errorf(st, "undefined variable: %s", missing)
+		}
+	}
+	return ret, nil
+}
+
+func (r *scriptRun) run(stmts []*statement) error {
+	for _, st := range stmts {
+		if err := r.ctx.Err(); err != nil {
+			return r.errorf(st, "%w", err)
+		}
+		if err := r.statement(st); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *scriptRun) statement(st *statement) error {
+	switch st.words[0] {
+	case "if":
+		cond, err := r.expand(st, st.words[1:])
+		if err != nil {
+			return err
+		}
+		if err = checkCondition(cond); err != nil {
+			return r.errorf(st, "%w", err)
+		}
+		holds, err := r.holds(cond)
+		if err != nil {
+			return r.errorf(st, "%w", err)
+		}
+		if holds {
+			return r.run(st.body)
+		}
+		if len(st.alt) > 0 {
+			return r.run(st.alt[1:])
+		}
+		return nil
+	case "try":
+		err := r.run(st.body)
+		if err == nil || errors.Is(err, errScriptExit) {
+			return err
+		}
+		catch := st.alt[0]
+		if len(catch.words) > 1 {
+			var se *scriptError
+			msg := err.Error()
+			if errors.As(err, &se) {
+				msg = se.err.Error()
+			}
+			r.vars[catch.words[1]] = msg
+		}
+		log.Debug().Err(err).Msgf("%s:%d: caught", r.file, catch.line)
+		return r.run(st.alt[1:])
+	}
+
+	words, err := r.expand(st, st.words)
+	if err != nil {
+		return err
+	}
+	switch words[0] {
+	case "let":
+		r.vars[words[1]] = strings.Join(words[3:], " ")
+	case "for":
+		items := words[3:]
+		if len(items) == 1 {
+			switch items[0] {
+			case "lights":
+				items = sortedNames(ziggy.LightList())
+			case "groups":
+				items = sortedNames(ziggy.GroupList())
+			}
+		}
+		for _, item := range items {
+			r.vars[words[1]] = item
+			if err = r.run(st.body); err != nil {
+				return err
+			}
+		}
+	case "repeat":
+		n, cerr := repeatCount(words[1])
+		if cerr != nil {
+			return r.errorf(st, "%w", cerr)
+		}
+		for i := 0; i < n; i++ {
+			if err = r.run(st.body); err != nil {
+				return err
+			}
+		}
+	case "wait":
+		d, perr := time.ParseDuration(words[1])
+		if perr != nil {
+			return r.errorf(st, "%w", perr)
+		}
+		if r.dry {
+			_, err = fmt.Fprintf(stdout, "%d: wait %s\n", st.line, d)
+			return err
+		}
+		select {
+		case <-r.ctx.Done():
+			return r.errorf(st, "%w", r.ctx.Err())
+		case <-time.After(d):
+		}
+	case "fail":
+		msg := strings.Join(words[1:], " ")
+		if msg == "" {
+			msg = "failed"
+		}
+		return r.errorf(st, "%s", msg)
+	case "exit":
+		return errScriptExit
+	default:
+		return r.command(st, words)
+	}
+	return nil
+}
+
+// command runs a ziggs command with the reactor from Commands.
+func (r *scriptRun) command(st *statement, words []string) error {
+	bcmd, ok := Commands[words[0]]
+	if !ok {
+		return r.errorf(st, "unknown command: %s", words[0])
+	}
+	if r.dry {
+		_, err := fmt.Fprintf(stdout, "%d: %s\n", st.line, joinArgs(words))
+		return err
+	}
+	log.Debug().Str("script", r.file).Int("line", st.line).Msg(joinArgs(words))
+	if err := bcmd.reactor(r.br, words[1:]); err != nil {
+		// errors from scripts this one sources already say where they happened.
+		var se *scriptError
+		if errors.As(err, &se) {
+			return err
+		}
+		return r.errorf(st, "%s: %w", words[0], err)
+	}
+	return nil
+}
+
+// holds evaluates the condition of an if from the last known state of the light or group.
+func (r *scriptRun) holds(cond []string) (bool, error) {
+	want := true
+	if cond[0] == "not" {
+		want, cond = false, cond[1:]
+	}
+	kind, name, state := cond[0], cond[1], cond[2]
+	var is bool
+	switch kind {
+	case "light", "l":
+		l, ok := ziggy.LookupLight(name, r.br)
+		if !ok {
+			return false, fmt.Errorf("light %s not found", name)
+		}
+		st := l.CurrentState()
+		if st == nil {
+			return false, fmt.Errorf("state of light %s unknown", name)
+		}
+		switch state {
+		case "on", "off":
+			is = st.On == (state == "on")
+		default:
+			is = st.Reachable == (state == "reachable")
+		}
+	default:
+		g, ok := ziggy.LookupGroup(name, r.br)
+		if !ok {
+			return false, fmt.Errorf("group %s not found", name)
+		}
+		is = g.AnyOn() == (state == "on")
+	}
+	return is == want, nil
+}
+
+func sortedNames[T any](m map[string]T) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runScript runs the script in the file at path with the variables in vars, given as name=value.
+func runScript(ctx context.Context, br *ziggy.Bridge, path string, dry bool, vars []string) error {
+	if scriptDepth.Add(1) > maxNesting {
+		scriptDepth.Add(-1)
+		return fmt.Errorf("%s: scripts nested too deep", path)
+	}
+	defer scriptDepth.Add(-1)
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	stmts, err := parseScript(path, f)
+	if err != nil {
+		return err
+	}
+	r := &scriptRun{ctx: ctx, br: br, file: path, vars: make(map[string]string), dry: dry}
+	for _, v := range vars {
+		name, val, ok := strings.Cut(v, "=")
+		if !ok || !scriptName.MatchString(name) {
+			return fmt.Errorf("invalid variable: %s, use name=value", v)
+		}
+		r.vars[name] = val
+	}
+	if err = r.run(stmts); errors.Is(err, errScriptExit) {
+		return nil
+	}
+	return err
+}
+
+// parseScriptArgs takes the path, -n/--dry-run and name=value variables from args.
+func parseScriptArgs(args []string) (path string, dry bool, vars []string, err error) {
+	for _, arg := range args {
+		switch {
+		case arg == "-n" || arg == "--dry-run":
+			dry = true
+		case path == "":
+			path = arg
+		default:
+			vars = append(vars, arg)
+		}
+	}
+	if path == "" {
+		return "", false, nil, errors.New("no script specified")
+	}
+	return path, dry, vars, nil
+}
+
+// cmdSource runs a ziggs script, see script.go for what goes in one.
+//
+//	source <file> [-n|--dry-run] [name=value]...
+func cmdSource(br *ziggy.Bridge, args []string) error {
+	path, dry, vars, err := parseScriptArgs(args)
+	if err != nil {
+		return err
+	}
+	ctx, stop := interruptible(0)
+	defer stop()
+	return runScript(ctx, br, path, dry, vars)
+}
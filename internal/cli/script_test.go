@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdSource(t *testing.T) {
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	script := filepath.Join(dir, "evening.zg")
+	err := os.WriteFile(script, []byte(`# everything off, then the desk on
+for l in lights
+  set light $l off
+end
+if light lamp off
+  set light desk on
+else
+  fail lamp should have been off
+end
+repeat 2
+  wait 1ms
+end
+try
+  set light nonexistent on
+catch err
+  set light lamp on
+end
+exit
+set group $room off
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t)
+	if !execute("source "+script+" --dry-run", true) {
+		t.Fatal("dry run failed")
+	}
+	for _, want := range []string{"3: set light desk off\n", "3: set light lamp off\n", "6: set light desk on\n", "11: wait 1ms\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in the dry run, got %q", want, out.String())
+		}
+	}
+	if fb.LightState("2")["on"] == true {
+		t.Fatal("the dry run turned the desk on")
+	}
+
+	if !execute("source "+script, true) {
+		t.Fatal("source failed")
+	}
+	if fb.LightState("1")["on"] != true || fb.LightState("2")["on"] != true {
+		t.Errorf("expected lamp and desk to be on, got %v %v", fb.LightState("1"), fb.LightState("2"))
+	}
+
+	// the suggestions can be refreshed while a script waits, e.g. when the bridge reports a change.
+	slow := filepath.Join(dir, "slow.zg")
+	if err = os.WriteFile(slow, []byte("wait 300ms\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	status := make(chan int, 1)
+	go func() { status <- Run([]string{"run", slow}) }()
+	time.Sleep(50 * time.Millisecond)
+	refreshed := make(chan struct{})
+	go func() {
+		ProcessAll()
+		close(refreshed)
+	}()
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("suggestions were never refreshed")
+	}
+	select {
+	case <-status:
+		t.Error("suggestions were only refreshed once the script finished")
+	default:
+		if st := <-status; st != statusOK {
+			t.Errorf("slow script exited with %d", st)
+		}
+	}
+
+	for _, tc := range []struct{ script, err string }{
+		{"repeat x\nend", "test.zg:1: invalid count: x"},
+		{"if light lamp on\n  set light lamp off", "test.zg:1: if without end"},
+		{"set light lamp on\nend", "test.zg:2: end without a matching statement"},
+		{"\nfrobnicate", "test.zg:2: unknown command: frobnicate"},
+		{"let x", "test.zg:1: usage: let <name> = <value>"},
+		{"try\n  set light lamp on\nend", "test.zg:1: try without catch"},
+		{"if sensor hallway on\nend", "test.zg:1: can't test a sensor, only lights and groups"},
+		{"wait 5\n", "test.zg:1: time: missing unit in duration \"5\""},
+	} {
+		if _, err = parseScript("test.zg", strings.NewReader(tc.script)); err == nil || err.Error() != tc.err {
+			t.Errorf("%q: expected %q, got %v", tc.script, tc.err, err)
+		}
+	}
+
+	failing := filepath.Join(dir, "failing.zg")
+	for _, tc := range []struct{ script, err string }{
+		{"set light $nope on", failing + ":1: undefined variable: nope"},
+		{"let l = lamp\n\nset light $l frobnicate", failing + ":3: set: "},
+		{"if light nonexistent on\nend", failing + ":1: light nonexistent not found"},
+		{"for l in lamp desk\n  fail stopped at $l\nend", failing + ":2: stopped at lamp"},
+		{"source " + failing, failing + ":1: source: " + failing + ": scripts nested too deep"},
+	} {
+		if err = os.WriteFile(failing, []byte(tc.script), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		err = runScript(context.Background(), br, failing, false, nil)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+			t.Errorf("%q: expected %q, got %v", tc.script, tc.err, err)
+		}
+	}
+
+	if err = os.WriteFile(failing, []byte("set group $room off"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = runScript(context.Background(), br, failing, false, []string{"room=office"}); err != nil {
+		t.Error(err)
+	}
+	if fb.LightState("1")["on"] != false {
+		t.Error("expected the office to be off")
+	}
+}
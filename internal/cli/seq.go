@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	cli "git.tcp.direct/Mirrors/go-prompt"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// readSequence reads the lines of a sequence from stdin until an empty line or "end".
+func readSequence(name string) ([]string, error) {
+	_, _ = fmt.Fprintf(stdout, "enter the lines of %s, use $g1, $l1 or $b1 for groups, lights and bridges. "+
+		"finish with an empty line or 'end'\n", name)
+	var lines []string
+	xerox := bufio.NewScanner(stdin)
+	for {
+		_, _ = fmt.Fprint(stdout, "> ")
+		if !xerox.Scan() {
+			break
+		}
+		line := strings.TrimSpace(xerox.Text())
+		if line == "" || line == "end" {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines, xerox.Err()
+}
+
+// checkBindings makes sure that every group, light and bridge bound to a variable exists.
+func checkBindings(br *ziggy.Bridge, targets map[data.TargetType]map[int]string) error {
+	for ttype, bound := range targets {
+		for id, name := range bound {
+			var ok bool
+			switch ttype {
+			case data.TargetTypeGroup:
+				if _, ok = ziggy.LookupGroup(name, br); !ok {
+					_, ok = ziggy.GetVirtualGroupMap()[name]
+				}
+				if !ok {
+					return fmt.Errorf("g%d: group %s not found", id, name)
+				}
+			case data.TargetTypeLight:
+				if _, ok = ziggy.LookupLight(name, br); !ok {
+					return fmt.Errorf("l%d: light %s not found", id, name)
+				}
+			case data.TargetTypeBridge:
+				if _, ok = ziggy.FindBridge(name); !ok {
+					return fmt.Errorf("b%d: bridge %s not found", id, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runSequence binds the variables of the sequence called name and runs its lines through the same path as
+// the Executor, stopping at the first line that fails.
+func runSequence(br *ziggy.Bridge, name string, bindings []string) error {
+	targets, err := data.ParseBindings(bindings)
+	if err != nil {
+		return err
+	}
+	if err = checkBindings(br, targets); err != nil {
+		return err
+	}
+	for _, bound := range targets {
+		for id, target := range bound {
+			bound[id] = quoteArg(target)
+		}
+	}
+	lines, err := data.RunSequence(name, targets)
+	if err != nil {
+		return err
+	}
+	for i, line := range lines {
+		log.Debug().Str("sequence", name).Msg(line)
+		if dispatch(line) != statusOK {
+			return fmt.Errorf("sequence %s stopped at line %d: %s", name, i+1, line)
+		}
+	}
+	return nil
+}
+
+// cmdSeq manages and runs sequences, command lines with groups, lights and bridges left to bind when they run.
+//
+//	seq define <name> [line...]
+//	seq run <name> [g1=<group>] [l1=<light>] [b1=<bridge>]...
+//	seq list
+//	seq show|delete <name>
+func cmdSeq(br *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return ErrNotEnoughArguments
+	}
+	switch args[0] {
+	case "list", "ls":
+		for _, name := range data.Sequences() {
+			seq, err := data.GetSequence(name)
+			if err != nil {
+				log.Warn().Err(err).Str("sequence", name).Msg("failed to load sequence")
+				continue
+			}
+			log.Info().Int("lines", len(seq.Lines)).Strs("bindings", seq.Bindings()).Msg(name)
+		}
+		return nil
+	case "define", "def", "run", "show", "delete", "rm":
+	default:
+		return fmt.Errorf("unknown seq action: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("no sequence name specified")
+	}
+	name := strings.TrimSpace(args[1])
+	switch args[0] {
+	case "define", "def":
+		lines := args[2:]
+		if len(lines) == 0 {
+			var err error
+			if lines, err = readSequence(name); err != nil {
+				return err
+			}
+		}
+		if len(lines) == 0 {
+			return fmt.Errorf("sequence %s has no lines, not saved", name)
+		}
+		if err := data.AddSequence(name, lines); err != nil {
+			return err
+		}
+		seq, err := data.GetSequence(name)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("lines", len(seq.Lines)).Strs("bindings", seq.Bindings()).Msgf("defined sequence %s", name)
+		return nil
+	case "run":
+		return runSequence(br, name, args[2:])
+	case "show":
+		seq, err := data.GetSequence(name)
+		if err != nil {
+			return err
+		}
+		for _, line := range seq.Lines {
+			if _, err = fmt.Fprintln(stdout, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if err := data.DeleteSequence(name); err != nil {
+			return err
+		}
+		log.Info().Msgf("deleted sequence %s", name)
+		return nil
+	}
+}
+
+// sequenceSuggestions completes the names and bindings of `seq run`, `seq show` and `seq delete`.
+// Bindings of groups and lights are completed from the names in the light and group maps.
+func sequenceSuggestions(args []string, head int, word string) []cli.Suggest {
+	if len(args) < 2 || head < 2 {
+		return nil
+	}
+	switch args[1] {
+	case "run", "show", "delete", "rm":
+	default:
+		return nil
+	}
+	var sugs []cli.Suggest
+	if head == 2 {
+		for _, name := range data.Sequences() {
+			if strings.Contains(name, strings.ToLower(word)) {
+				sugs = append(sugs, cli.Suggest{Text: name, Description: "Sequence"})
+			}
+		}
+		return sugs
+	}
+	if args[1] != "run" || len(args) < 3 {
+		return nil
+	}
+	seq, err := data.GetSequence(args[2])
+	if err != nil {
+		return nil
+	}
+	variable, partial, bound := strings.Cut(word, "=")
+	if !bound {
+		for _, b := range seq.Bindings() {
+			if !strings.HasPrefix(b, word) {
+				continue
+			}
+			kind := map[byte]string{'g': "group", 'l': "light", 'b': "bridge"}[b[0]]
+			sugs = append(sugs, cli.Suggest{Text: b + "=", Description: "bind a " + kind})
+		}
+		return sugs
+	}
+	if strings.TrimPrefix(variable, "$") == "" {
+		return nil
+	}
+	var names []string
+	kind := ""
+	switch strings.TrimPrefix(variable, "$")[:1] {
+	case "g":
+		kind = "Group"
+		for name := range ziggy.GroupList() {
+			names = append(names, name)
+		}
+		for name := range ziggy.GetVirtualGroupMap() {
+			names = append(names, name)
+		}
+	case "l":
+		kind = "Light"
+		for name := range ziggy.LightList() {
+			names = append(names, name)
+		}
+	case "b":
+		kind = "Bridge"
+		ziggy.Lucifer.RLock()
+		for _, c := range ziggy.Lucifer.Bridges {
+			names = append(names, c.Info.BridgeID)
+			if c.Alias() != "" {
+				names = append(names, c.Alias())
+			}
+		}
+		ziggy.Lucifer.RUnlock()
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(strings.Trim(partial, `"`))) {
+			text := variable + "=" + name
+			if strings.ContainsAny(name, " \t") {
+				text = variable + "=" + strconv.Quote(name)
+			}
+			sugs = append(sugs, cli.Suggest{Text: text, Description: kind})
+		}
+	}
+	return sugs
+}
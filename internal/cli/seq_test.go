@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdSeq(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+
+	out := captureStdout(t)
+	stdin = strings.NewReader("set group $g1 off\nset light $l1 on\n\nnot part of it\n")
+	t.Cleanup(func() { stdin = os.Stdin })
+	if !execute("seq define focus", true) {
+		t.Fatal("seq define failed")
+	}
+	seq, err := data.GetSequence("focus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seq.Lines) != 2 || strings.Join(seq.Bindings(), " ") != "g1 l1" {
+		t.Errorf("unexpected sequence: %+v", seq)
+	}
+
+	for _, bad := range []string{
+		"seq run focus g1=office",
+		"seq run focus g1=office l1=lamp l2=desk",
+		"seq run focus g1=office l1=nonexistent",
+		"seq run focus g1=office l1",
+		"seq run focus g1=office g1=office l1=lamp",
+		"seq run nonexistent",
+		"seq define",
+		"seq bogus",
+	} {
+		if execute(bad, true) {
+			t.Errorf("%s: expected an error", bad)
+		}
+	}
+	if fb.LightState("1")["on"] == true {
+		t.Fatal("a sequence that failed validation ran")
+	}
+
+	if !execute("seq run focus g1=office l1=lamp", true) {
+		t.Fatal("seq run failed")
+	}
+	if fb.LightState("1")["on"] != true || fb.LightState("2")["on"] != false {
+		t.Errorf("unexpected light states: %v %v", fb.LightState("1"), fb.LightState("2"))
+	}
+
+	out.Reset()
+	if err = cmdSeq(nil, []string{"show", "focus"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "set group $g1 off\nset light $l1 on\n" {
+		t.Errorf("unexpected sequence: %q", out.String())
+	}
+
+	sugs := sequenceSuggestions([]string{"seq", "run", "focus"}, 3, "")
+	if len(sugs) != 2 || sugs[0].Text != "g1=" || sugs[1].Text != "l1=" {
+		t.Errorf("unexpected binding suggestions: %v", sugs)
+	}
+	sugs = sequenceSuggestions([]string{"seq", "run", "focus", "l1=de"}, 3, "l1=de")
+	if len(sugs) != 1 || sugs[0].Text != "l1=desk" {
+		t.Errorf("unexpected light suggestions: %v", sugs)
+	}
+	sugs = sequenceSuggestions([]string{"seq", "run", "focus", "g1="}, 3, "g1=")
+	if len(sugs) != 1 || sugs[0].Text != "g1=office" {
+		t.Errorf("unexpected group suggestions: %v", sugs)
+	}
+
+	if err = cmdSeq(nil, []string{"list"}); err != nil {
+		t.Error(err)
+	}
+	if !execute("seq delete focus", true) {
+		t.Error("seq delete failed")
+	}
+	if execute("seq delete focus", true) {
+		t.Error("deleting a sequence twice should fail")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"image/color"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/yunginnanet/huego"
 
@@ -29,6 +30,21 @@ type cmdTarget interface {
 
 var ErrNotEnoughArguments = errors.New("not enough arguments")
 
+// parseTransition accepts a duration such as 1.5s or 10m, a bare number is taken as seconds.
+func parseTransition(arg string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(arg, 64); err == nil {
+		arg = strconv.FormatFloat(secs, 'f', -1, 64) + "s"
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, fmt.Errorf("given transition is not a valid duration: %w", err)
+	}
+	if d < 0 {
+		return 0, errors.New("transition can't be negative")
+	}
+	return d, nil
+}
+
 func cmdSet(bridge *ziggy.Bridge, args []string) error {
 	if len(args) < 3 {
 		return ErrNotEnoughArguments
@@ -39,12 +55,14 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 	)
 
 	var (
-		groupMap     map[string]*ziggy.HueGroup
-		lightMap     map[string]*ziggy.HueLight
 		actions      []action
 		currentState *huego.State
 		argHead      = -1
 		target       cmdTarget
+		// base is the target itself, target is replaced by a *ziggy.Transition when fading.
+		base   cmdTarget
+		fading bool
+		fade   time.Duration
 	)
 
 	for range args {
@@ -55,26 +73,31 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 		log.Trace().Int("argHead", argHead).Msg(args[argHead])
 		switch args[argHead] {
 		case "group", "g":
-			groupMap = ziggy.GetGroupMap()
 			if len(args) <= argHead-1 {
 				return errors.New("no group specified")
 			}
 			argHead++
-			g, ok := groupMap[strings.TrimSpace(args[argHead])]
+			g, ok := ziggy.LookupGroup(strings.TrimSpace(args[argHead]), bridge)
+			if ok {
+				log.Trace().Str("group", g.Name).Msgf("found group %s via args[%d]",
+					args[argHead], argHead,
+				)
+				target = g
+				break
+			}
+			vg, ok := ziggy.GetVirtualGroupMap()[strings.TrimSpace(args[argHead])]
 			if !ok {
 				return fmt.Errorf("group %s not found (argHead: %d)", args[argHead], argHead)
 			}
-			log.Trace().Str("group", g.Name).Msgf("found group %s via args[%d]",
-				args[argHead], argHead,
-			)
-			target = g
+			log.Trace().Str("group", vg.Name).Int("members", vg.Len()).
+				Msgf("found virtual group %s via args[%d]", args[argHead], argHead)
+			target = vg
 		case "light", "l":
-			lightMap = ziggy.GetLightMap()
 			if len(args) <= argHead-1 {
 				return errors.New("no light specified")
 			}
 			argHead++
-			l, ok := lightMap[strings.TrimSpace(args[argHead])]
+			l, ok := ziggy.LookupLight(strings.TrimSpace(args[argHead]), bridge)
 			if !ok {
 				return fmt.Errorf("light %s not found (argHead: %d)", args[argHead], argHead)
 			}
@@ -84,9 +107,19 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 			}
 			target = l
 		case "on":
-			actions = append(actions, target.On)
+			actions = append(actions, func() error { return target.On() })
 		case "off":
-			actions = append(actions, target.Off)
+			actions = append(actions, func() error { return target.Off() })
+		case "transition", "over", "tt":
+			if len(args) <= argHead+1 {
+				return errors.New("no transition specified")
+			}
+			argHead++
+			d, err := parseTransition(strings.TrimSpace(args[argHead]))
+			if err != nil {
+				return err
+			}
+			fade, fading = d, true
 		case "brightness--", "dim":
 			actions = append(actions, func() error {
 				if currentState == nil {
@@ -234,13 +267,15 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 			targetScene := strings.TrimSpace(args[argHead])
 			log.Debug().Msgf("target scene: %s", targetScene)
 			actions = append(actions, func() error {
-				zhg := target.(*ziggy.HueGroup)
-				if zhg == nil {
+				switch base.(type) {
+				case *ziggy.Multiplex:
+					return target.Scene(targetScene)
+				case *ziggy.HueGroup:
+				default:
 					return errors.New("target is not a group")
 				}
-				if ts := ziggy.GetSceneMap()[targetScene]; ts != nil {
-					ts.Recall(zhg.ID)
-					return nil
+				if ts, ok := ziggy.LookupScene(targetScene, bridge); ok {
+					return target.Scene(ts.ID)
 				}
 				return fmt.Errorf("scene %s not found", targetScene)
 			})
@@ -257,14 +292,27 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 	}
 	tg, tgok := target.(*ziggy.HueGroup)
 	tl, tlok := target.(*ziggy.HueLight)
+	tm, tmok := target.(*ziggy.Multiplex)
+	// huego updates the embedded state as our actions succeed, so start it off with the latest state we know of.
 	switch {
 	case tgok:
-		currentState = tg.State
+		currentState = tg.CurrentState()
+		tg.State = currentState
 	case tlok:
-		currentState = tl.State
+		currentState = tl.CurrentState()
+		tl.State = currentState
+	case tmok:
+		currentState = tm.State()
 	default:
 		return errors.New("unknown target")
 	}
+	base = target
+	var transition *ziggy.Transition
+	if fading {
+		// every action in the command is collected and sent together once they've all run.
+		transition = ziggy.NewTransition(target.(ziggy.Fader), fade)
+		target = transition
+	}
 	log.Trace().Caller().Msgf("current state: %v", currentState)
 	for d, act := range actions {
 		log.Trace().Caller().Msgf("running action %d", d)
@@ -277,8 +325,13 @@ func cmdSet(bridge *ziggy.Bridge, args []string) error {
 			currentState = tg.State
 		case tlok:
 			currentState = tl.State
+		case tmok:
+			currentState = tm.State()
 		}
 		log.Trace().Caller().Msgf("new state: %v", currentState)
 	}
+	if transition != nil {
+		return transition.Commit()
+	}
 	return nil
 }
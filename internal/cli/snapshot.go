@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+// snapshotTargets adds the targets named in args to snap. Targets are `light <name>`, `group <name>`,
+// `bridge` for every light on the current bridge and `all` for every light on every bridge.
+// Without any targets, the current bridge is captured.
+func snapshotTargets(snap *ziggy.Snapshot, br *ziggy.Bridge, args []string) error {
+	if len(args) == 0 {
+		args = []string{"bridge"}
+	}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "bridge", "b":
+			if br == nil {
+				return errors.New("no bridge selected")
+			}
+			if err := snap.AddBridge(br); err != nil {
+				return err
+			}
+		case "all":
+			_, bridges := sortedBridges()
+			for _, c := range bridges {
+				if err := snap.AddBridge(c); err != nil {
+					return err
+				}
+			}
+		case "light", "l", "group", "g":
+			if i+1 >= len(args) {
+				return fmt.Errorf("no %s specified", args[i])
+			}
+			kind, name := args[i], strings.TrimSpace(args[i+1])
+			i++
+			if kind == "light" || kind == "l" {
+				l, ok := ziggy.LookupLight(name, br)
+				if !ok {
+					return fmt.Errorf("light %s not found", name)
+				}
+				snap.AddLight(l)
+				continue
+			}
+			if g, ok := ziggy.LookupGroup(name, br); ok {
+				if err := snap.AddGroup(g); err != nil {
+					return err
+				}
+				continue
+			}
+			vg, ok := ziggy.GetVirtualGroupMap()[name]
+			if !ok {
+				return fmt.Errorf("group %s not found", name)
+			}
+			if err := snap.AddMultiplex(vg); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown snapshot target: %s", args[i])
+		}
+	}
+	return nil
+}
+
+// cmdSnapshot saves the state of lights, groups or bridges under a name and puts it back later.
+//
+//	snapshot save <name> [targets]
+//	snapshot restore <name>
+//	snapshot list
+//	snapshot delete <name>
+func cmdSnapshot(br *ziggy.Bridge, args []string) error {
+	if len(args) < 1 {
+		return ErrNotEnoughArguments
+	}
+	switch args[0] {
+	case "list", "ls":
+		for _, name := range data.Snapshots() {
+			snap := &ziggy.Snapshot{}
+			if err := data.GetSnapshot(name, snap); err != nil {
+				log.Warn().Err(err).Str("snapshot", name).Msg("failed to load snapshot")
+				continue
+			}
+			log.Info().Str("taken", ago(snap.Taken)).Int("lights", len(snap.Lights)).
+				Strs("targets", snap.Targets).Msg(name)
+		}
+		return nil
+	case "save", "restore", "delete", "rm":
+	default:
+		return fmt.Errorf("unknown snapshot action: %s", args[0])
+	}
+	if len(args) < 2 {
+		return errors.New("no snapshot name specified")
+	}
+	name := strings.TrimSpace(args[1])
+	switch args[0] {
+	case "save":
+		snap := ziggy.NewSnapshot(name)
+		if err := snapshotTargets(snap, br, args[2:]); err != nil {
+			return err
+		}
+		if err := data.PutSnapshot(name, snap); err != nil {
+			return fmt.Errorf("failed to save snapshot: %w", err)
+		}
+		log.Info().Int("lights", len(snap.Lights)).Strs("targets", snap.Targets).Msgf("saved snapshot %s", name)
+		return nil
+	case "restore":
+		snap := &ziggy.Snapshot{}
+		if err := data.GetSnapshot(name, snap); err != nil {
+			return err
+		}
+		if err := snap.Restore(); err != nil {
+			return err
+		}
+		log.Info().Int("lights", len(snap.Lights)).Msgf("restored snapshot %s", name)
+		return nil
+	default:
+		return data.DeleteSnapshot(name)
+	}
+}
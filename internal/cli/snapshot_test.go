@@ -0,0 +1,45 @@
+package cli
+
+import (
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/data"
+	"git.tcp.direct/kayos/ziggs/internal/ziggy"
+)
+
+func TestCmdSnapshot(t *testing.T) {
+	data.StartTest()
+	fb, br := newTestBridge(t)
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 100.0, "ct": 300.0, "colormode": "ct"})
+	if err := ziggy.Registry.Load(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSnapshot(br, []string{"save", "calm", "light", "lamp"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSet(br, []string{"light", "lamp", "color", "#ff0000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSnapshot(br, []string{"list"}); err != nil {
+		t.Error(err)
+	}
+	if err := cmdSnapshot(br, []string{"restore", "calm"}); err != nil {
+		t.Fatal(err)
+	}
+	if st := fb.LightState("1"); st["colormode"] != "ct" || st["ct"] != 300.0 {
+		t.Errorf("lamp was not restored: %v", st)
+	}
+	if err := cmdSnapshot(br, []string{"delete", "calm"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][]string{
+		{"restore", "calm"},
+		{"save"},
+		{"save", "x", "light", "nonexistent"},
+		{"bogus"},
+	} {
+		if err := cmdSnapshot(br, bad); err == nil {
+			t.Errorf("expected error for snapshot %v", bad)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package clipv2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func TestResources(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	lamp := fb.AddLight("lamp")
+	desk := fb.AddLight("desk")
+	fb.AddGroup("office", lamp, desk)
+	motion := fb.AddSensor("hallway motion", "ZLLPresence", map[string]interface{}{"presence": true})
+	fb.SetReachable("lights", desk, false)
+
+	c := NewClient(fb.Hostname(), fb.NewUser("ziggs#test"))
+	res, err := c.Resources(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Lights) != 2 || len(res.Zones) != 1 || len(res.GroupedLights) != 1 || len(res.Motion) != 1 {
+		t.Fatalf("unexpected resources: %d lights, %d zones, %d grouped lights, %d motion",
+			len(res.Lights), len(res.Zones), len(res.GroupedLights), len(res.Motion))
+	}
+	if !res.Motion[0].Motion.Motion {
+		t.Error("motion sensor should see motion")
+	}
+
+	id, ok := res.V2("/lights/"+lamp, TypeLight)
+	if !ok {
+		t.Fatal("lamp has no v2 ID")
+	}
+	if v1, _ := res.V1(id); v1 != "/lights/"+lamp {
+		t.Errorf("expected v1 ID /lights/%s, got %s", lamp, v1)
+	}
+	light, err := c.Light(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if light.Metadata.Name != "lamp" {
+		t.Errorf("fetched the wrong light: %+v", light)
+	}
+	if _, err = c.Light(context.Background(), "nope"); err == nil {
+		t.Error("expected an error for a missing light")
+	}
+
+	st, ok := res.Status("/lights/" + lamp)
+	if !ok || st.Device != "lamp" || st.Connectivity != "connected" || st.Battery != -1 {
+		t.Errorf("unexpected lamp status: %+v", st)
+	}
+	if st, _ = res.Status("/lights/" + desk); st.Connectivity != "connectivity_issue" {
+		t.Errorf("desk should have connectivity issues: %+v", st)
+	}
+	if st, ok = res.Status("/sensors/" + motion); !ok || st.Battery != 100 || st.BatteryState != "normal" {
+		t.Errorf("unexpected sensor status: %+v", st)
+	}
+	if _, ok = res.Status("/lights/9"); ok {
+		t.Error("expected no status for a missing light")
+	}
+	grouped := res.GroupedLights[0].ID
+	if name, _ := res.Name(grouped); name != "office" {
+		t.Errorf("grouped light should go by the name of its zone, got %q", name)
+	}
+	if _, ok = res.Name("nope"); ok {
+		t.Error("expected no name for a missing resource")
+	}
+	if len(res.Raw[TypeDevice]) != 3 {
+		t.Errorf("expected 3 raw devices, got %d", len(res.Raw[TypeDevice]))
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	_, err := NewClient(fb.Hostname(), "nobody").Lights(context.Background())
+	var bridgeErr *Error
+	if !errors.As(err, &bridgeErr) || bridgeErr.Status != http.StatusForbidden {
+		t.Errorf("expected a 403, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package clipv2
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Resources is everything a bridge has, sorted by type. Raw keeps every resource as the bridge sent it,
+// including the types we don't decode.
+type Resources struct {
+	Lights        []Light
+	GroupedLights []GroupedLight
+	Rooms         []Group
+	Zones         []Group
+	Devices       []Device
+	Scenes        []Scene
+	Buttons       []Button
+	Motion        []Motion
+	Temperatures  []Temperature
+	DevicePower   []DevicePower
+	Connectivity  []ZigbeeConnectivity
+	Raw           map[string][]json.RawMessage
+
+	// byV1 maps v1 paths to the ID of each resource type that has that path, e.g. a light and its device.
+	byV1 map[string]map[string]string
+	byID map[string]Resource
+	// owners maps IDs to the ID of the device that owns them.
+	owners map[string]string
+	// names maps IDs to their name, parents to their owner of any type.
+	names   map[string]string
+	parents map[string]string
+}
+
+func appendAs[T any](raw json.RawMessage, to *[]T) error {
+	var v T
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	*to = append(*to, v)
+	return nil
+}
+
+// ParseResources sorts the resources of a GET of /clip/v2/resource by type.
+func ParseResources(raw []json.RawMessage) (*Resources, error) {
+	r := &Resources{
+		Raw:     make(map[string][]json.RawMessage),
+		byV1:    make(map[string]map[string]string),
+		byID:    make(map[string]Resource),
+		owners:  make(map[string]string),
+		names:   make(map[string]string),
+		parents: make(map[string]string),
+	}
+	for _, item := range raw {
+		var res struct {
+			Resource
+			Owner    *ResourceIdentifier `json:"owner"`
+			Metadata *struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}
+		if err := json.Unmarshal(item, &res); err != nil {
+			return nil, fmt.Errorf("failed to decode resource: %w", err)
+		}
+		if res.Owner != nil {
+			r.parents[res.ID] = res.Owner.Rid
+			if res.Owner.Rtype == TypeDevice {
+				r.owners[res.ID] = res.Owner.Rid
+			}
+		}
+		if res.Metadata != nil && res.Metadata.Name != "" {
+			r.names[res.ID] = res.Metadata.Name
+		}
+		r.Raw[res.Type] = append(r.Raw[res.Type], item)
+		r.byID[res.ID] = res.Resource
+		if res.IDV1 != "" {
+			if r.byV1[res.IDV1] == nil {
+				r.byV1[res.IDV1] = make(map[string]string)
+			}
+			r.byV1[res.IDV1][res.Type] = res.ID
+		}
+		var err error
+		switch res.Type {
+		case TypeLight:
+			err = appendAs(item, &r.Lights)
+		case TypeGroupedLight:
+			err = appendAs(item, &r.GroupedLights)
+		case TypeRoom:
+			err = appendAs(item, &r.Rooms)
+		case TypeZone:
+			err = appendAs(item, &r.Zones)
+		case TypeDevice:
+			err = appendAs(item, &r.Devices)
+		case TypeScene:
+			err = appendAs(item, &r.Scenes)
+		case TypeButton:
+			err = appendAs(item, &r.Buttons)
+		case TypeMotion:
+			err = appendAs(item, &r.Motion)
+		case TypeTemperature:
+			err = appendAs(item, &r.Temperatures)
+		case TypeDevicePower:
+			err = appendAs(item, &r.DevicePower)
+		case TypeZigbeeConnectivity:
+			err = appendAs(item, &r.Connectivity)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s %s: %w", res.Type, res.ID, err)
+		}
+	}
+	return r, nil
+}
+
+// V2 returns the ID of the resource of type rtype that matches the v1 path idV1, e.g. V2("/lights/1", "light").
+func (r *Resources) V2(idV1, rtype string) (string, bool) {
+	id, ok := r.byV1[idV1][rtype]
+	return id, ok
+}
+
+// Name returns the name of the resource with the given ID. Resources without one, like a grouped_light
+// or the zigbee_connectivity of a device, go by the name of their owner.
+func (r *Resources) Name(id string) (string, bool) {
+	for i := 0; i < 3 && id != ""; i++ {
+		if name, ok := r.names[id]; ok {
+			return name, true
+		}
+		id = r.parents[id]
+	}
+	return "", false
+}
+
+// V1 returns the v1 path of the resource with the given ID.
+func (r *Resources) V1(id string) (string, bool) {
+	res, ok := r.byID[id]
+	if !ok || res.IDV1 == "" {
+		return "", false
+	}
+	return res.IDV1, true
+}
+
+// Status is what the v2 API knows about the device behind a v1 light or sensor that v1 doesn't tell us.
+type Status struct {
+	Device string `json:"device"`
+	// DeviceID is the v2 ID of the device.
+	DeviceID     string `json:"device_id"`
+	Product      string `json:"product,omitempty"`
+	Connectivity string `json:"connectivity,omitempty"`
+	// Battery is the battery level in percent, or -1 for devices without a battery.
+	Battery      int    `json:"battery"`
+	BatteryState string `json:"battery_state,omitempty"`
+}
+
+// Status returns the status of the device that owns the resource with the v1 path idV1, e.g. /sensors/2.
+func (r *Resources) Status(idV1 string) (Status, bool) {
+	var owner string
+	for rtype, id := range r.byV1[idV1] {
+		if rtype == TypeDevice {
+			owner = id
+			break
+		}
+		if owner == "" {
+			owner = r.owners[id]
+		}
+	}
+	if owner == "" {
+		return Status{}, false
+	}
+	st := Status{DeviceID: owner, Battery: -1}
+	for _, d := range r.Devices {
+		if d.ID == owner {
+			st.Device, st.Product = d.Metadata.Name, d.ProductData.ProductName
+		}
+	}
+	for _, zc := range r.Connectivity {
+		if zc.Owner.Rid == owner {
+			st.Connectivity = zc.Status
+		}
+	}
+	for _, dp := range r.DevicePower {
+		if dp.Owner.Rid == owner {
+			st.Battery, st.BatteryState = dp.PowerState.BatteryLevel, dp.PowerState.BatteryState
+		}
+	}
+	return st, true
+}
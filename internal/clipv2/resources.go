@@ -0,0 +1,118 @@
+package clipv2
+
+import (
+	"git.tcp.direct/kayos/ziggs/internal/haptic"
+)
+
+// Resource types we know how to decode.
+const (
+	TypeLight              = "light"
+	TypeGroupedLight       = "grouped_light"
+	TypeRoom               = "room"
+	TypeZone               = "zone"
+	TypeDevice             = "device"
+	TypeScene              = "scene"
+	TypeButton             = "button"
+	TypeMotion             = "motion"
+	TypeTemperature        = "temperature"
+	TypeDevicePower        = "device_power"
+	TypeZigbeeConnectivity = "zigbee_connectivity"
+)
+
+// ResourceIdentifier points at another resource, e.g. the device that owns a light.
+type ResourceIdentifier = haptic.Owner
+
+// Resource holds the fields every v2 resource has.
+type Resource struct {
+	ID string `json:"id"`
+	// IDV1 is the path of the matching v1 resource, e.g. /lights/1. Not every resource has one.
+	IDV1 string `json:"id_v1,omitempty"`
+	Type string `json:"type"`
+}
+
+type ProductData struct {
+	ModelID          string `json:"model_id"`
+	ManufacturerName string `json:"manufacturer_name"`
+	ProductName      string `json:"product_name"`
+	ProductArchetype string `json:"product_archetype"`
+	Certified        bool   `json:"certified"`
+	SoftwareVersion  string `json:"software_version"`
+}
+
+type Device struct {
+	Resource
+	Metadata    haptic.Metadata      `json:"metadata"`
+	ProductData ProductData          `json:"product_data"`
+	Services    []ResourceIdentifier `json:"services"`
+}
+
+type Light struct {
+	Resource
+	Owner            ResourceIdentifier       `json:"owner"`
+	Metadata         haptic.Metadata          `json:"metadata"`
+	On               haptic.On                `json:"on"`
+	Dimming          *haptic.Dimming          `json:"dimming,omitempty"`
+	ColorTemperature *haptic.ColorTemperature `json:"color_temperature,omitempty"`
+	Color            *haptic.Color            `json:"color,omitempty"`
+	Dynamics         *haptic.Dynamics         `json:"dynamics,omitempty"`
+	Mode             string                   `json:"mode,omitempty"`
+}
+
+type GroupedLight struct {
+	Resource
+	Owner   ResourceIdentifier `json:"owner"`
+	On      *haptic.On         `json:"on,omitempty"`
+	Dimming *haptic.Dimming    `json:"dimming,omitempty"`
+}
+
+// Group is a room or a zone. Rooms hold devices, zones hold lights.
+type Group struct {
+	Resource
+	Metadata haptic.Metadata      `json:"metadata"`
+	Children []ResourceIdentifier `json:"children"`
+	Services []ResourceIdentifier `json:"services"`
+}
+
+type Scene struct {
+	Resource
+	Metadata haptic.Metadata    `json:"metadata"`
+	Group    ResourceIdentifier `json:"group"`
+	Speed    float64            `json:"speed,omitempty"`
+}
+
+type Button struct {
+	Resource
+	Owner    ResourceIdentifier `json:"owner"`
+	Metadata struct {
+		ControlID int `json:"control_id"`
+	} `json:"metadata"`
+	Button *haptic.Button `json:"button,omitempty"`
+}
+
+type Motion struct {
+	Resource
+	Owner   ResourceIdentifier `json:"owner"`
+	Enabled bool               `json:"enabled"`
+	Motion  haptic.Motion      `json:"motion"`
+}
+
+type Temperature struct {
+	Resource
+	Owner       ResourceIdentifier `json:"owner"`
+	Enabled     bool               `json:"enabled"`
+	Temperature haptic.Temperature `json:"temperature"`
+}
+
+type DevicePower struct {
+	Resource
+	Owner      ResourceIdentifier `json:"owner"`
+	PowerState haptic.PowerState  `json:"power_state"`
+}
+
+type ZigbeeConnectivity struct {
+	Resource
+	Owner ResourceIdentifier `json:"owner"`
+	// Status is one of connected, disconnected, connectivity_issue or unidirectional_incoming.
+	Status     string `json:"status"`
+	MacAddress string `json:"mac_address,omitempty"`
+}
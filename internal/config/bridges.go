@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SameHostname compares hostnames the way they appear in the config, with or without a scheme and port 80.
+func SameHostname(a, b string) bool {
+	norm := func(h string) string {
+		if i := strings.Index(h, "://"); i >= 0 {
+			h = h[i+3:]
+		}
+		h = strings.TrimSuffix(strings.TrimSuffix(h, "/"), ":80")
+		return strings.ToLower(strings.ReplaceAll(h, "%25", "%"))
+	}
+	return norm(a) == norm(b)
+}
+
+// SameBridge reports whether a and b are the same bridge, either by ID or by hostname.
+func SameBridge(a, b KnownBridge) bool {
+	if a.ID != "" && b.ID != "" {
+		return strings.EqualFold(a.ID, b.ID)
+	}
+	return SameHostname(a.Hostname, b.Hostname)
+}
+
+// AddKnownBridge adds kb to KnownBridges and saves the config. If the same bridge is already known,
+// by ID or by hostname, it is updated instead, keeping whatever kb leaves empty.
+func AddKnownBridge(kb KnownBridge) error {
+	found := false
+	for i, known := range KnownBridges {
+		if !SameBridge(known, kb) {
+			continue
+		}
+		found = true
+		if kb.Hostname != "" {
+			KnownBridges[i].Hostname = kb.Hostname
+		}
+		if kb.ID != "" {
+			KnownBridges[i].ID = kb.ID
+		}
+		if kb.Mac != "" {
+			KnownBridges[i].Mac = kb.Mac
+		}
+		if kb.Username != "" {
+			KnownBridges[i].Username = kb.Username
+		}
+		if kb.Proxy != "" {
+			KnownBridges[i].Proxy = kb.Proxy
+		}
+		if kb.Alias != "" {
+			KnownBridges[i].Alias = kb.Alias
+		}
+		if kb.ClientKey != "" {
+			KnownBridges[i].ClientKey = kb.ClientKey
+		}
+		if kb.Fingerprint != "" {
+			KnownBridges[i].Fingerprint = kb.Fingerprint
+		}
+		if kb.HTTPS {
+			KnownBridges[i].HTTPS = true
+		}
+		if kb.Poll {
+			KnownBridges[i].Poll = true
+		}
+		if kb.Timeout != 0 {
+			KnownBridges[i].Timeout = kb.Timeout
+		}
+		if kb.ConnectTimeout != 0 {
+			KnownBridges[i].ConnectTimeout = kb.ConnectTimeout
+		}
+	}
+	if !found {
+		KnownBridges = append(KnownBridges, kb)
+	}
+	return SaveKnownBridges()
+}
+
+// SaveKnownBridges writes KnownBridges to the config file.
+func SaveKnownBridges() error {
+	bridges := make([]map[string]interface{}, 0, len(KnownBridges))
+	for _, kb := range KnownBridges {
+		if kb.Hostname == "" {
+			continue
+		}
+		bridge := map[string]interface{}{
+			"hostname": kb.Hostname,
+			"username": kb.Username,
+			"proxy":    kb.Proxy,
+			"alias":    kb.Alias,
+		}
+		if kb.ClientKey != "" {
+			bridge["clientkey"] = kb.ClientKey
+		}
+		if kb.ID != "" {
+			bridge["id"] = kb.ID
+		}
+		if kb.Mac != "" {
+			bridge["mac"] = kb.Mac
+		}
+		if kb.HTTPS {
+			bridge["https"] = true
+		}
+		if kb.Fingerprint != "" {
+			bridge["fingerprint"] = kb.Fingerprint
+		}
+		if kb.Poll {
+			bridge["poll"] = true
+		}
+		if kb.Timeout != 0 {
+			bridge["timeout"] = kb.Timeout.String()
+		}
+		if kb.ConnectTimeout != 0 {
+			bridge["connect_timeout"] = kb.ConnectTimeout.String()
+		}
+		bridges = append(bridges, bridge)
+	}
+	Snek.Set("bridges", bridges)
+	return Snek.WriteConfig()
+}
+
+// KnownBridgeIndex returns where in KnownBridges the bridge with the given hostname, alias or ID is, or -1.
+func KnownBridgeIndex(key string) int {
+	for i, kb := range KnownBridges {
+		switch {
+		case SameHostname(kb.Hostname, key),
+			kb.Alias != "" && strings.EqualFold(kb.Alias, key),
+			kb.ID != "" && strings.EqualFold(kb.ID, key):
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveKnownBridge removes the bridge at index i of KnownBridges and saves the config.
+func RemoveKnownBridge(i int) error {
+	if i < 0 || i >= len(KnownBridges) {
+		return fmt.Errorf("no known bridge at index %d", i)
+	}
+	KnownBridges = append(KnownBridges[:i:i], KnownBridges[i+1:]...)
+	return SaveKnownBridges()
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
@@ -80,7 +81,7 @@ func Init() {
 
 func setDefaults() {
 	var (
-		configSections = []string{"logger", "lights", "http", "ssh", "bridges"}
+		configSections = []string{"logger", "lights", "http", "ssh", "bridges", "history"}
 		deflogdir      = common.Home + "/.config/" + common.Title + "/logs/"
 		defNoColor     = false
 	)
@@ -112,6 +113,10 @@ func setDefaults() {
 		"host_key_dir": "~/.config/" + common.Title + "/.ssh",
 	}
 
+	Opt["history"] = map[string]interface{}{
+		"retention": "720h",
+	}
+
 	for _, def := range configSections {
 		Snek.SetDefault(def, Opt[def])
 	}
@@ -204,11 +209,18 @@ func processOpts() {
 	intOpt := map[string]*int{
 		"http.bind_port": &HTTPPort,
 	}
+	// duration options and their exported variables
+	durationOpt := map[string]*time.Duration{
+		"history.retention": &HistoryRetention,
+	}
 
 	err := Snek.UnmarshalKey("bridges", &KnownBridges)
 	if err != nil {
 		println(err.Error())
 	}
+	if err = Snek.UnmarshalKey("virtual_groups", &VirtualGroups); err != nil {
+		println(err.Error())
+	}
 
 	for key, opt := range intOpt {
 		*opt = Snek.GetInt(key)
@@ -219,6 +231,12 @@ func processOpts() {
 	for key, opt := range boolOpt {
 		*opt = Snek.GetBool(key)
 	}
+	for key, opt := range durationOpt {
+		// custom configs don't get our defaults, keep the one the variable starts with.
+		if Snek.IsSet(key) {
+			*opt = Snek.GetDuration(key)
+		}
+	}
 
 	switch {
 	case Trace:
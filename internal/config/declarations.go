@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -31,12 +32,42 @@ var (
 type KnownBridge struct {
 	Hostname string `mapstructure:"hostname"`
 	Username string `mapstructure:"username"`
-	Proxy    string `mapstructure:"proxy"`
+	// Proxy is what the bridge is reached through: socks5://[user:pass@]host:port, http://[user:pass@]host:port
+	// for an HTTP CONNECT proxy, or just host:port for SOCKS5.
+	Proxy string `mapstructure:"proxy"`
+	// HTTPS talks to the bridge over https, checking that its certificate was issued to the bridge ID.
+	HTTPS bool `mapstructure:"https"`
+	// Fingerprint is the SHA-256 of the public key the bridge presented the first time we talked to it over https,
+	// a bridge presenting any other key is refused.
+	Fingerprint string `mapstructure:"fingerprint"`
+	// Poll watches the bridge by polling it instead of through its eventstream, for bridges that don't have one
+	// or proxies that can't carry it.
+	Poll bool `mapstructure:"poll"`
+	// Timeout bounds every request to the bridge, ConnectTimeout only connecting to it (or to its proxy).
+	Timeout        time.Duration `mapstructure:"timeout"`
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// Alias can be used in place of the bridge ID to qualify names, e.g. upstairs:Kitchen.
+	Alias string `mapstructure:"alias"`
+	// ClientKey is the PSK for the entertainment API, only requested when pairing with --clientkey.
+	ClientKey string `mapstructure:"clientkey"`
+	// ID and Mac are what the bridge reported the last time we connected to it,
+	// they let us find it again after its address changes.
+	ID  string `mapstructure:"id"`
+	Mac string `mapstructure:"mac"`
 }
 
 // KnownBridges contains all of the bridges we already knew about from our config file.
 var KnownBridges []KnownBridge
 
+// VirtualGroup represents a group of lights and groups that may live on different bridges.
+type VirtualGroup struct {
+	Lights []string `mapstructure:"lights"`
+	Groups []string `mapstructure:"groups"`
+}
+
+// VirtualGroups contains the virtual groups defined in our config file, keyed by name.
+var VirtualGroups map[string]VirtualGroup
+
 // "http"
 var (
 	// HTTPBind is defined via our toml configuration file. It is the address that ziggs listens on.
@@ -53,6 +84,11 @@ var (
 	SSHPublicKeys []string
 )
 
+// "history"
+
+// HistoryRetention is how long ziggs keeps the changes it saw on the bridges, 0 keeps them forever.
+var HistoryRetention = 30 * 24 * time.Hour
+
 var (
 	Debug bool
 	Trace bool
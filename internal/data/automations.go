@@ -0,0 +1,113 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"git.tcp.direct/kayos/common/squish"
+	"git.tcp.direct/tcp.direct/database"
+)
+
+// ErrNoSuchAutomation is returned when an automation that doesn't exist is requested.
+var ErrNoSuchAutomation = errors.New("no such automation")
+
+// Automation runs ziggs command lines whenever one of its triggers fires and all of its conditions hold.
+type Automation struct {
+	Name       string      `json:"name"`
+	Enabled    bool        `json:"enabled"`
+	Triggers   []Trigger   `json:"triggers"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Actions    []string    `json:"actions"`
+	Created    time.Time   `json:"created"`
+}
+
+// Trigger is something that happens, either on a bridge or on the clock.
+type Trigger struct {
+	// Kind is one of button, motion, temperature, light or time.
+	Kind string `json:"kind"`
+	// Target is the name, v2 ID or v1 path of the resource, or "any".
+	Target string `json:"target,omitempty"`
+	// Event narrows the trigger down, e.g. short_release for a button, cleared for motion,
+	// off for a light or above for a temperature.
+	Event string `json:"event,omitempty"`
+	// Button is the number of the button on a switch, 0 for any of them.
+	Button int `json:"button,omitempty"`
+	// Value is the threshold of a temperature in degrees, or the time of day, e.g. 07:30.
+	Value string `json:"value,omitempty"`
+}
+
+// Condition is something that has to be true at the time a trigger fires.
+type Condition struct {
+	// Kind is one of time, days, light or group.
+	Kind   string `json:"kind"`
+	Target string `json:"target,omitempty"`
+	// Value is e.g. 22:00-06:00 for time, mon,tue or weekends for days, on or off for lights and groups.
+	Value string `json:"value"`
+}
+
+func kvAutomations() database.Store {
+	return kv().With("automations")
+}
+
+func automationKey(name string) []byte {
+	return []byte(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// PutAutomation stores a, replacing any automation with the same name.
+func PutAutomation(a *Automation) error {
+	raw, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to marshal automation: %w", err)
+	}
+	return kvAutomations().Put(automationKey(a.Name), squish.Gzip(raw))
+}
+
+// GetAutomation returns the automation stored under name.
+func GetAutomation(name string) (*Automation, error) {
+	if !kvAutomations().Has(automationKey(name)) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchAutomation, name)
+	}
+	packed, err := kvAutomations().Get(automationKey(name))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching automation: %w", err)
+	}
+	raw, err := squish.Gunzip(packed)
+	if err != nil {
+		return nil, fmt.Errorf("error deflating automation: %w", err)
+	}
+	a := &Automation{}
+	if err = json.Unmarshal(raw, a); err != nil {
+		return nil, fmt.Errorf("error unpacking automation: %w", err)
+	}
+	return a, nil
+}
+
+// DeleteAutomation removes the automation stored under name.
+func DeleteAutomation(name string) error {
+	if !kvAutomations().Has(automationKey(name)) {
+		return fmt.Errorf("%w: %s", ErrNoSuchAutomation, name)
+	}
+	return kvAutomations().Delete(automationKey(name))
+}
+
+// Automations returns every stored automation, sorted by name.
+func Automations() ([]*Automation, error) {
+	var names []string
+	for _, k := range kvAutomations().Keys() {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	ret := make([]*Automation, 0, len(names))
+	for _, name := range names {
+		a, err := GetAutomation(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestAutomations(t *testing.T) {
+	testMode()
+	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
+	a := &Automation{
+		Name:     "Hallway",
+		Enabled:  true,
+		Triggers: []Trigger{{Kind: "motion", Target: "hallway motion", Event: "detected"}},
+		Actions:  []string{"set light hallway on"},
+	}
+	if err := PutAutomation(a); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetAutomation("hallway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Hallway" || !got.Enabled || len(got.Triggers) != 1 || got.Actions[0] != "set light hallway on" {
+		t.Errorf("unexpected automation: %+v", got)
+	}
+	all, err := Automations()
+	if err != nil || len(all) != 1 {
+		t.Errorf("expected one automation, got %v %v", all, err)
+	}
+	if err = DeleteAutomation("HALLWAY"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetAutomation("hallway"); !errors.Is(err, ErrNoSuchAutomation) {
+		t.Errorf("expected ErrNoSuchAutomation, got %v", err)
+	}
+}
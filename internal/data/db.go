@@ -14,7 +14,7 @@ import (
 )
 
 var (
-	stores       = []string{"macros", "users", "sequences"}
+	stores       = []string{"macros", "users", "sequences", "snapshots", "automations", "history"}
 	isTest       = false
 	once         = &sync.Once{}
 	target       string
@@ -29,12 +29,17 @@ func testMode() {
 func setTarget() {
 	if !isTest {
 		target = common.Home + "/.local/share/" + common.Title + "/"
+		return
 	}
 	testLocation = filepath.Join("/tmp", common.Title, strconv.FormatInt(time.Now().UnixNano(), 10))
 	target = testLocation
 }
 
 func kv() *bitcask.DB {
+	// in test mode Start opens a fresh database every time, keep using the one StartTest opened.
+	if isTest && db != nil {
+		return db
+	}
 	Start()
 	return db
 }
@@ -68,7 +73,11 @@ func StartTest() {
 	Start()
 }
 
+// Close syncs and closes the database, if it was ever opened.
 func Close() {
+	if db == nil {
+		return
+	}
 	if err := db.SyncAndCloseAll(); err != nil {
 		log.Warn().Err(err).Msg("error syncing and closing db")
 	}
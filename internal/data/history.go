@@ -0,0 +1,102 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"git.tcp.direct/tcp.direct/database"
+)
+
+// Change is a change to a light, group or sensor as one of the bridges reported it.
+type Change struct {
+	Time   time.Time `json:"time"`
+	Bridge string    `json:"bridge"`
+	// Resource is the v1 path of what changed, e.g. /lights/1, or its v2 ID if it has no v1 path.
+	Resource string `json:"resource"`
+	Name     string `json:"name,omitempty"`
+	Type     string `json:"type"`
+	// Kind is add, update or delete.
+	Kind string `json:"kind"`
+	// Change describes what changed, e.g. on=true bri=50%.
+	Change string `json:"change,omitempty"`
+	// Source is ziggs if ziggs made the change, bridge if something else did.
+	Source string `json:"source"`
+}
+
+func kvHistory() database.Store {
+	return kv().With("history")
+}
+
+// changeSeq numbers the changes we record, bridges only report changes to the second, and a single message
+// often changes the same resource more than once, e.g. a light and its zigbee_connectivity.
+var changeSeq atomic.Uint64
+
+// keys start with the time of the change, so sorting them sorts the changes.
+func changeKey(c Change) []byte {
+	return []byte(fmt.Sprintf("%019d %020d %s%s %s", c.Time.UnixNano(), changeSeq.Add(1), c.Bridge, c.Resource, c.Type))
+}
+
+func changeTime(key []byte) (time.Time, bool) {
+	if len(key) < 19 {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(string(key[:19]), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
+
+// RecordChange adds c to the history.
+func RecordChange(c Change) error {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal change: %w", err)
+	}
+	return kvHistory().Put(changeKey(c), raw)
+}
+
+// Changes returns the changes since the given time for which match returns true, oldest first.
+// A nil match returns all of them.
+func Changes(since time.Time, match func(Change) bool) ([]Change, error) {
+	var keys []string
+	for _, k := range kvHistory().Keys() {
+		if t, ok := changeTime(k); ok && !t.Before(since) {
+			keys = append(keys, string(k))
+		}
+	}
+	sort.Strings(keys)
+	var ret []Change
+	for _, k := range keys {
+		raw, err := kvHistory().Get([]byte(k))
+		if err != nil {
+			return nil, fmt.Errorf("error fetching change: %w", err)
+		}
+		var c Change
+		if err = json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("error unpacking change: %w", err)
+		}
+		if match == nil || match(c) {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// PruneChanges forgets every change from before the given time and returns how many it forgot.
+func PruneChanges(before time.Time) (int, error) {
+	var pruned int
+	for _, k := range kvHistory().Keys() {
+		if t, ok := changeTime(k); ok && t.Before(before) {
+			if err := kvHistory().Delete(k); err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,58 @@
+package data
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	testMode()
+	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
+	now := time.Now()
+	for i, c := range []Change{
+		{Time: now.Add(-48 * time.Hour), Bridge: "b", Resource: "/lights/1", Name: "hallway", Change: "on=true"},
+		{Time: now.Add(-2 * time.Hour), Bridge: "b", Resource: "/lights/1", Name: "hallway", Change: "on=false"},
+		{Time: now.Add(-time.Hour), Bridge: "b", Resource: "/lights/2", Name: "desk", Change: "on=true"},
+		{Time: now.Add(-time.Minute), Bridge: "b", Resource: "/lights/1", Name: "hallway", Change: "bri=50%"},
+	} {
+		if err := RecordChange(c); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	got, err := Changes(now.Add(-24*time.Hour), func(c Change) bool { return c.Name == "hallway" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Change != "on=false" || got[1].Change != "bri=50%" {
+		t.Errorf("unexpected changes: %+v", got)
+	}
+	pruned, err := PruneChanges(now.Add(-24 * time.Hour))
+	if err != nil || pruned != 1 {
+		t.Errorf("expected to prune one change, pruned %d: %v", pruned, err)
+	}
+	if got, _ = Changes(time.Time{}, nil); len(got) != 3 {
+		t.Errorf("expected 3 changes after pruning, got %d", len(got))
+	}
+
+	// bridges report changes to the second, one message often has several for the same light.
+	second := now.Add(time.Minute).Truncate(time.Second)
+	for i, c := range []Change{
+		{Time: second, Bridge: "b", Resource: "/lights/3", Type: "light", Change: "on=true"},
+		{Time: second, Bridge: "b", Resource: "/lights/3", Type: "zigbee_connectivity", Change: "status=connected"},
+		{Time: second, Bridge: "b", Resource: "/lights/3", Type: "light", Change: "bri=50%"},
+	} {
+		if err := RecordChange(c); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	got, _ = Changes(second, nil)
+	if len(got) != 3 || got[0].Change != "on=true" || got[1].Change != "status=connected" || got[2].Change != "bri=50%" {
+		t.Errorf("changes within the same second should all be kept in order: %+v", got)
+	}
+}
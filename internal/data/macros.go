@@ -2,7 +2,9 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -10,6 +12,9 @@ import (
 	"git.tcp.direct/tcp.direct/database"
 )
 
+// ErrNoSuchMacro is returned when a macro that doesn't exist is requested.
+var ErrNoSuchMacro = errors.New("no such macro")
+
 func kvMacros() database.Store {
 	return kv().With("macros")
 }
@@ -60,7 +65,7 @@ func GetMacro(name string) (mcro *Macro, err error) {
 	}
 
 	if !kvMacros().Has([]byte(name)) {
-
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchMacro, name)
 	}
 
 	var packed []byte
@@ -72,19 +77,23 @@ func GetMacro(name string) (mcro *Macro, err error) {
 		return nil, fmt.Errorf("error unpacking macro: %w", err)
 	}
 
-	go updateCache(name, mcro)
+	updateCache(name, mcro)
 
 	return mcro, err
 }
 
 func DeleteMacro(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
 	macros.Lock()
 	defer macros.Unlock()
 	delete(macros.cache, name)
-	return fmt.Errorf(
-		"failed to delete macro: %w",
-		kvMacros().Delete([]byte(strings.ToLower(strings.TrimSpace(name)))),
-	)
+	if !kvMacros().Has([]byte(name)) {
+		return fmt.Errorf("%w: %s", ErrNoSuchMacro, name)
+	}
+	if err := kvMacros().Delete([]byte(name)); err != nil {
+		return fmt.Errorf("failed to delete macro: %w", err)
+	}
+	return nil
 }
 
 // AddMacro adds a macro to the database, the description is optional.
@@ -92,15 +101,42 @@ func AddMacro(name string, description string, sequence ...string) error {
 	if _, err := GetMacro(name); err == nil {
 		return fmt.Errorf("a macro named %q already exists", name)
 	}
-	mcro := Macro{
+	return PutMacro(&Macro{
 		Name:        name,
 		Description: description,
 		Sequence:    sequence,
-	}
+	})
+}
+
+// PutMacro stores mcro, replacing any macro with the same name.
+func PutMacro(mcro *Macro) error {
 	rawMacro, err := json.Marshal(mcro)
 	if err != nil {
 		return fmt.Errorf("failed to marshal macro: %w", err)
 	}
 	rawMacro = squish.Gzip(rawMacro)
-	return kvMacros().Put([]byte(strings.ToLower(strings.TrimSpace(name))), rawMacro)
+	name := strings.ToLower(strings.TrimSpace(mcro.Name))
+	if err = kvMacros().Put([]byte(name), rawMacro); err != nil {
+		return err
+	}
+	updateCache(name, mcro)
+	return nil
+}
+
+// Macros returns every stored macro, sorted by name.
+func Macros() ([]*Macro, error) {
+	var names []string
+	for _, k := range kvMacros().Keys() {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	ret := make([]*Macro, 0, len(names))
+	for _, name := range names {
+		mcro, err := GetMacro(name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, mcro)
+	}
+	return ret, nil
 }
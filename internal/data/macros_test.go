@@ -0,0 +1,55 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	testMode()
+	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
+	if _, err := GetMacro("bedtime"); !errors.Is(err, ErrNoSuchMacro) {
+		t.Fatalf("expected ErrNoSuchMacro, got %v", err)
+	}
+	if err := AddMacro("Bedtime", "lights out", "set group office off", "set light lamp on"); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddMacro("bedtime", ""); err == nil {
+		t.Error("expected an error adding a macro that already exists")
+	}
+	got, err := GetMacro(" BEDTIME ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Bedtime" || got.Description != "lights out" || len(got.Sequence) != 2 {
+		t.Errorf("unexpected macro: %+v", got)
+	}
+	if err = PutMacro(&Macro{Name: "bedtime", Sequence: []string{"set group office off"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err = GetMacro("bedtime"); err != nil || len(got.Sequence) != 1 {
+		t.Errorf("expected the replaced macro, got %+v %v", got, err)
+	}
+	if err = AddMacro("alarm", "", "set light lamp on"); err != nil {
+		t.Fatal(err)
+	}
+	all, err := Macros()
+	if err != nil || len(all) != 2 || all[0].Name != "alarm" {
+		t.Errorf("expected two macros sorted by name, got %v %v", all, err)
+	}
+	if err = DeleteMacro("Bedtime"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetMacro("bedtime"); !errors.Is(err, ErrNoSuchMacro) {
+		t.Errorf("expected ErrNoSuchMacro after delete, got %v", err)
+	}
+	if err = DeleteMacro("bedtime"); !errors.Is(err, ErrNoSuchMacro) {
+		t.Errorf("expected ErrNoSuchMacro deleting twice, got %v", err)
+	}
+}
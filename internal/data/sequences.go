@@ -2,7 +2,10 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -15,6 +18,16 @@ const (
 	PlaceHolderLight  = "$l"
 )
 
+// ErrNoSuchSequence is returned when a sequence that doesn't exist is requested.
+var ErrNoSuchSequence = errors.New("no such sequence")
+
+var (
+	// placeholder matches the placeholders in the lines of a sequence, e.g. $g1.
+	placeholder = regexp.MustCompile(`\$([gbl])(\d+)`)
+	// variable also matches placeholders that are missing their number.
+	variable = regexp.MustCompile(`\$([gbl])(\d*)`)
+)
+
 type TargetType uint16
 
 const (
@@ -35,6 +48,28 @@ func targetTypeToString(target TargetType) string {
 	return "unknown"
 }
 
+func targetTypeToPrefix(target TargetType) string {
+	switch target {
+	case TargetTypeGroup:
+		return "g"
+	case TargetTypeBridge:
+		return "b"
+	case TargetTypeLight:
+		return "l"
+	}
+	return "?"
+}
+
+func targetTypeOf(prefix string) TargetType {
+	switch prefix {
+	case "g":
+		return TargetTypeGroup
+	case "l":
+		return TargetTypeLight
+	}
+	return TargetTypeBridge
+}
+
 type Targets map[int]string
 
 type Sequence struct {
@@ -52,36 +87,48 @@ func newSequence() *Sequence {
 	}
 }
 
+// Bindings returns the variables that have to be bound to run the sequence, e.g. g1 and l2.
+func (s *Sequence) Bindings() []string {
+	var ret []string
+	for _, target := range []TargetType{TargetTypeGroup, TargetTypeLight, TargetTypeBridge} {
+		for _, id := range sortedIDs(s.TargetsNeeded[target]) {
+			ret = append(ret, targetTypeToPrefix(target)+strconv.Itoa(id))
+		}
+	}
+	return ret
+}
+
+func sortedIDs[T ~map[int]string](targets T) []int {
+	ids := make([]int, 0, len(targets))
+	for id := range targets {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
 func kvs() database.Store {
 	return kv().With("sequences")
 }
 
+func sequenceKey(sequence string) []byte {
+	return []byte(strings.ToLower(strings.TrimSpace(sequence)))
+}
+
+// AddSequence stores commands as the sequence named sequence, replacing any sequence with the same name.
+// The commands can use $g1, $l1 and $b1 and so on in place of groups, lights and bridges.
 func AddSequence(sequence string, commands []string) error {
-	seq := newSequence()
-	prepSequence := func(placeholder string, target TargetType, i int, field string) error {
-		targetID, numErr := strconv.Atoi(field[len(placeholder):])
-		if numErr != nil {
-			return fmt.Errorf("line %d: variable %s invalid: %w", i, field, numErr)
-		}
-		seq.TargetsNeeded[target][targetID] = "needed"
-		return nil
+	if len(commands) == 0 {
+		return errors.New("a sequence needs at least one line")
 	}
+	seq := newSequence()
 	for i, cmd := range commands {
-		for _, field := range strings.Fields(cmd) {
-			switch {
-			case strings.HasPrefix(field, PlaceHolderGroup):
-				if err := prepSequence(PlaceHolderGroup, TargetTypeGroup, i, field); err != nil {
-					return err
-				}
-			case strings.HasPrefix(field, PlaceHolderBridge):
-				if err := prepSequence(PlaceHolderBridge, TargetTypeBridge, i, field); err != nil {
-					return err
-				}
-			case strings.HasPrefix(field, PlaceHolderLight):
-				if err := prepSequence(PlaceHolderLight, TargetTypeLight, i, field); err != nil {
-					return err
-				}
+		for _, match := range variable.FindAllStringSubmatch(cmd, -1) {
+			targetID, numErr := strconv.Atoi(match[2])
+			if numErr != nil {
+				return fmt.Errorf("line %d: variable %s invalid: %w", i+1, match[0], numErr)
 			}
+			seq.TargetsNeeded[targetTypeOf(match[1])][targetID] = "needed"
 		}
 		seq.Lines = append(seq.Lines, cmd)
 	}
@@ -89,11 +136,15 @@ func AddSequence(sequence string, commands []string) error {
 	if err != nil {
 		return err
 	}
-	return kvs().Put([]byte(strings.ToLower(strings.TrimSpace(sequence))), seqjson)
+	return kvs().Put(sequenceKey(sequence), seqjson)
 }
 
-func getSequence(sequence string) (*Sequence, error) {
-	seqjson, err := kvs().Get([]byte(strings.ToLower(strings.TrimSpace(sequence))))
+// GetSequence returns the sequence stored under sequence.
+func GetSequence(sequence string) (*Sequence, error) {
+	if !kvs().Has(sequenceKey(sequence)) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchSequence, sequence)
+	}
+	seqjson, err := kvs().Get(sequenceKey(sequence))
 	if err != nil {
 		return nil, err
 	}
@@ -105,103 +156,104 @@ func getSequence(sequence string) (*Sequence, error) {
 	return seq, nil
 }
 
+// ParseRunSequence parses the name of a sequence followed by its bindings, e.g. "wake g1=kitchen l1:lamp".
 func ParseRunSequence(input string) (sequence string, targets map[TargetType]map[int]string, err error) {
-	targets = make(map[TargetType]map[int]string)
-	processArgument := func(ttype TargetType, arg, sep string) error {
-		if _, ok := targets[ttype]; !ok {
-			targets[ttype] = make(map[int]string)
-		}
-		if len(arg) == 0 {
-			return fmt.Errorf("invalid %s argument: empty", targetTypeToString(ttype))
-		}
-		val := strings.Split(arg[1:], sep)
-		targetID, numErr := strconv.Atoi(val[0])
-		if numErr != nil {
-			return fmt.Errorf(
-				"targetID %s invalid: %w", val[0], numErr)
-		}
-		targets[ttype][targetID] = val[1]
-		return nil
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return "", nil, errors.New("no sequence specified")
 	}
+	if targets, err = ParseBindings(fields[1:]); err != nil {
+		return "", nil, err
+	}
+	return fields[0], targets, nil
+}
 
-	for i, arg := range strings.Fields(input) {
-		if i == 0 {
-			sequence = arg
-			continue
+// ParseBindings parses bindings of groups, lights and bridges to the variables of a sequence,
+// e.g. g1=kitchen, l2:lamp or $b1=upstairs.
+func ParseBindings(args []string) (map[TargetType]map[int]string, error) {
+	targets := make(map[TargetType]map[int]string)
+	for i, arg := range args {
+		i++
+		sep := "="
+		if !strings.Contains(arg, "=") {
+			if !strings.Contains(arg, ":") {
+				return nil, fmt.Errorf(
+					"argument %d: invalid variable assignment, missing ':' or '=': %s", i, arg)
+			}
+			sep = ":"
 		}
-		arg = strings.TrimPrefix(arg, "$")
-		lenColons := strings.Count(arg, ":")
-		lenEquals := strings.Count(arg, "=")
-		var sep = ":"
-		switch {
-		case lenColons == 0 && lenEquals == 0:
-			return "", nil, fmt.Errorf(
-				"argument %d: invalid variable assignment, missing ':' or '=': %s", i, arg)
-		case lenColons > 0 && lenEquals > 0:
-			return "", nil, fmt.Errorf(
-				"argument %d: invalid variable assignment, cannot have both ':' and '=': %s", i, arg)
-		case lenColons > 1 || lenEquals > 1:
-			return "", nil, fmt.Errorf(
-				"argument %d: invalid variable assignment, cannot have more than one ':' or '=': %s", i, arg)
-		case lenColons == 0 || lenEquals == 1:
-			sep = "="
+		variable, val, _ := strings.Cut(arg, sep)
+		match := placeholder.FindStringSubmatch("$" + strings.TrimPrefix(variable, "$"))
+		if match == nil || len(match[0]) != len(strings.TrimPrefix(variable, "$"))+1 {
+			return nil, fmt.Errorf("argument %d: invalid variable assignment: %s", i, arg)
 		}
-		switch {
-		case strings.HasPrefix(arg, strings.TrimPrefix(PlaceHolderGroup, "$")):
-			if err := processArgument(TargetTypeGroup, arg, sep); err != nil {
-				return "", nil, err
-			}
-		case strings.HasPrefix(arg, strings.TrimPrefix(PlaceHolderBridge, "$")):
-			if err := processArgument(TargetTypeBridge, arg, sep); err != nil {
-				return "", nil, err
-			}
-		case strings.HasPrefix(arg, strings.TrimPrefix(PlaceHolderLight, "$")):
-			if err := processArgument(TargetTypeLight, arg, sep); err != nil {
-				return "", nil, err
-			}
-		default:
-			return "", nil, fmt.Errorf("argument %d: invalid variable assignment: %s", i, arg)
+		ttype := targetTypeOf(match[1])
+		targetID, _ := strconv.Atoi(match[2])
+		if val == "" {
+			return nil, fmt.Errorf("argument %d: %s has no %s bound to it", i, match[0][1:], targetTypeToString(ttype))
+		}
+		if _, ok := targets[ttype]; !ok {
+			targets[ttype] = make(map[int]string)
+		}
+		if _, dupe := targets[ttype][targetID]; dupe {
+			return nil, fmt.Errorf("argument %d: %s is bound more than once", i, match[0][1:])
 		}
+		targets[ttype][targetID] = val
 	}
-	return
+	return targets, nil
 }
 
+// RunSequence returns the lines of sequence with its variables replaced by the targets bound to them.
+// Every variable the sequence uses has to be bound, and nothing else may be.
 func RunSequence(sequence string, targets map[TargetType]map[int]string) ([]string, error) {
-	fetchedSequence, err := getSequence(sequence)
+	fetchedSequence, err := GetSequence(sequence)
 	if err != nil {
 		return nil, err
 	}
-	for target, ids := range fetchedSequence.TargetsNeeded {
-		for id, val := range ids {
-			if val != "needed" {
-				continue
-			}
+	var missing, extra []string
+	for _, target := range []TargetType{TargetTypeGroup, TargetTypeLight, TargetTypeBridge} {
+		for _, id := range sortedIDs(fetchedSequence.TargetsNeeded[target]) {
 			if _, ok := targets[target][id]; !ok {
-				return nil, fmt.Errorf("sequence %s requires target %s %d",
-					sequence, targetTypeToString(target), id)
+				missing = append(missing, fmt.Sprintf("%s%d=<%s>", targetTypeToPrefix(target), id, targetTypeToString(target)))
 			}
 		}
-	}
-	for li, l := range fetchedSequence.Lines {
-		for _, field := range strings.Fields(l) {
-			var newfield string
-			switch {
-			case strings.HasPrefix(field, PlaceHolderGroup):
-				targetID, _ := strconv.Atoi(field[len(PlaceHolderGroup):])
-				newfield = targets[TargetTypeGroup][targetID]
-			case strings.HasPrefix(field, PlaceHolderBridge):
-				targetID, _ := strconv.Atoi(field[len(PlaceHolderBridge):])
-				newfield = targets[TargetTypeBridge][targetID]
-			case strings.HasPrefix(field, PlaceHolderLight):
-				targetID, _ := strconv.Atoi(field[len(PlaceHolderLight):])
-				newfield = targets[TargetTypeLight][targetID]
-			default:
-				continue
-			}
-			if newfield != "" {
-				fetchedSequence.Lines[li] = strings.Replace(fetchedSequence.Lines[li], field, newfield, -1)
+		for _, id := range sortedIDs(targets[target]) {
+			if _, ok := fetchedSequence.TargetsNeeded[target][id]; !ok {
+				extra = append(extra, targetTypeToPrefix(target)+strconv.Itoa(id))
 			}
 		}
 	}
+	switch {
+	case len(missing) > 0:
+		return nil, fmt.Errorf("sequence %s is missing %s", sequence, strings.Join(missing, " "))
+	case len(extra) > 0:
+		return nil, fmt.Errorf("sequence %s doesn't use %s, it takes %s",
+			sequence, strings.Join(extra, " "), strings.Join(fetchedSequence.Bindings(), " "))
+	}
+	for li, l := range fetchedSequence.Lines {
+		fetchedSequence.Lines[li] = placeholder.ReplaceAllStringFunc(l, func(field string) string {
+			match := placeholder.FindStringSubmatch(field)
+			targetID, _ := strconv.Atoi(match[2])
+			return targets[targetTypeOf(match[1])][targetID]
+		})
+	}
 	return fetchedSequence.Lines, nil
 }
+
+// DeleteSequence removes the sequence stored under sequence.
+func DeleteSequence(sequence string) error {
+	if !kvs().Has(sequenceKey(sequence)) {
+		return fmt.Errorf("%w: %s", ErrNoSuchSequence, sequence)
+	}
+	return kvs().Delete(sequenceKey(sequence))
+}
+
+// Sequences returns the names of every stored sequence, sorted.
+func Sequences() []string {
+	var names []string
+	for _, k := range kvs().Keys() {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,8 +1,9 @@
 package data
 
-/*
 import (
 	"encoding/json"
+	"errors"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -19,6 +20,11 @@ func mustMarshalIndent(data any, prefix string) string {
 func TestAddSequence(t *testing.T) {
 	testMode()
 	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
 	type args struct {
 		Seq  string
 		Coms []string
@@ -45,7 +51,7 @@ func TestAddSequence(t *testing.T) {
 			if err := AddSequence(tt.args.Seq, tt.args.Coms); (err != nil) != tt.wantErr {
 				t.Errorf("AddSequence() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			fetch, newErr := getSequence(tt.args.Seq)
+			fetch, newErr := GetSequence(tt.args.Seq)
 			if newErr != nil && !tt.wantErr {
 				t.Errorf("getSequence() error = %v, wantErr %v", newErr, tt.wantErr)
 			}
@@ -71,7 +77,6 @@ func TestAddSequence(t *testing.T) {
 					targets: map[TargetType]map[int]string{
 						TargetTypeGroup: {
 							1: "kayos",
-							2: "flapjacks",
 							3: "kayos",
 						},
 					},
@@ -90,7 +95,6 @@ func TestAddSequence(t *testing.T) {
 					targets: map[TargetType]map[int]string{
 						TargetTypeGroup: {
 							1: "kayos",
-							2: "billy",
 							3: "flapjacks",
 						},
 					},
@@ -102,6 +106,20 @@ func TestAddSequence(t *testing.T) {
 				},
 				wantErr: false,
 			},
+			{
+				name: "RunSequenceFailExtra",
+				args: args{
+					sequence: "test",
+					targets: map[TargetType]map[int]string{
+						TargetTypeGroup: {
+							1: "kayos",
+							2: "billy",
+							3: "flapjacks",
+						},
+					},
+				},
+				wantErr: true,
+			},
 			{
 				name: "RunSequenceFailType",
 				args: args{
@@ -186,11 +204,36 @@ func TestAddSequence(t *testing.T) {
 				},
 				wantErr: false,
 			},
+			{
+				name:         "ParseRunSequenceQualified",
+				args:         args{input: "test g1=upstairs:kayos b1:upstairs"},
+				wantSequence: "test",
+				wantTargets: map[TargetType]map[int]string{
+					TargetTypeGroup:  {1: "upstairs:kayos"},
+					TargetTypeBridge: {1: "upstairs"},
+				},
+				wantErr: false,
+			},
 			{
 				name:    "ParseRunSequenceFail",
 				args:    args{input: "test x1:kayos y2=billy $g3:flapjacks"},
 				wantErr: true,
 			},
+			{
+				name:    "ParseRunSequenceFailTwice",
+				args:    args{input: "test g1=kayos g1=billy"},
+				wantErr: true,
+			},
+			{
+				name:    "ParseRunSequenceFailEmpty",
+				args:    args{input: "test g1="},
+				wantErr: true,
+			},
+			{
+				name:    "ParseRunSequenceFailNoNumber",
+				args:    args{input: "test g=kayos"},
+				wantErr: true,
+			},
 		}
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
@@ -209,4 +252,55 @@ func TestAddSequence(t *testing.T) {
 		}
 	})
 }
-*/
+
+func TestSequences(t *testing.T) {
+	testMode()
+	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
+	if err := AddSequence("dim", []string{"set g $g1 brightness 10 & set l $l10 off", "set l $l1 on"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddSequence("bad", []string{"set g $g brightness 10"}); err == nil {
+		t.Error("expected an error for a variable without a number")
+	}
+	if err := AddSequence("empty", nil); err == nil {
+		t.Error("expected an error for a sequence without lines")
+	}
+	seq, err := GetSequence("DIM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seq.Bindings(); !reflect.DeepEqual(got, []string{"g1", "l1", "l10"}) {
+		t.Errorf("unexpected bindings: %v", got)
+	}
+	lines, err := RunSequence("dim", map[TargetType]map[int]string{
+		TargetTypeGroup: {1: "kitchen"},
+		TargetTypeLight: {1: "lamp", 10: "desk"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"set g kitchen brightness 10 & set l desk off", "set l lamp on"}) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	_, err = RunSequence("dim", map[TargetType]map[int]string{TargetTypeGroup: {1: "kitchen"}})
+	if err == nil || err.Error() != "sequence dim is missing l1=<light> l10=<light>" {
+		t.Errorf("unexpected error for missing bindings: %v", err)
+	}
+	if names := Sequences(); !reflect.DeepEqual(names, []string{"dim"}) {
+		t.Errorf("unexpected sequences: %v", names)
+	}
+	if err = DeleteSequence("dim"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetSequence("dim"); !errors.Is(err, ErrNoSuchSequence) {
+		t.Errorf("expected ErrNoSuchSequence, got %v", err)
+	}
+	if err = DeleteSequence("dim"); !errors.Is(err, ErrNoSuchSequence) {
+		t.Errorf("expected ErrNoSuchSequence deleting twice, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"git.tcp.direct/kayos/common/squish"
+	"git.tcp.direct/tcp.direct/database"
+)
+
+// ErrNoSuchSnapshot is returned when a snapshot that doesn't exist is requested.
+var ErrNoSuchSnapshot = errors.New("no such snapshot")
+
+func kvSnapshots() database.Store {
+	return kv().With("snapshots")
+}
+
+func snapshotKey(name string) []byte {
+	return []byte(strings.ToLower(strings.TrimSpace(name)))
+}
+
+// PutSnapshot stores snap as JSON under name, replacing any snapshot with the same name.
+func PutSnapshot(name string, snap interface{}) error {
+	raw, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return kvSnapshots().Put(snapshotKey(name), squish.Gzip(raw))
+}
+
+// GetSnapshot unmarshals the snapshot stored under name into snap.
+func GetSnapshot(name string, snap interface{}) error {
+	if !kvSnapshots().Has(snapshotKey(name)) {
+		return fmt.Errorf("%w: %s", ErrNoSuchSnapshot, name)
+	}
+	packed, err := kvSnapshots().Get(snapshotKey(name))
+	if err != nil {
+		return fmt.Errorf("error fetching snapshot: %w", err)
+	}
+	raw, err := squish.Gunzip(packed)
+	if err != nil {
+		return fmt.Errorf("error deflating snapshot: %w", err)
+	}
+	return json.Unmarshal(raw, snap)
+}
+
+// DeleteSnapshot removes the snapshot stored under name.
+func DeleteSnapshot(name string) error {
+	if !kvSnapshots().Has(snapshotKey(name)) {
+		return fmt.Errorf("%w: %s", ErrNoSuchSnapshot, name)
+	}
+	return kvSnapshots().Delete(snapshotKey(name))
+}
+
+// Snapshots returns the names of all stored snapshots, sorted.
+func Snapshots() (names []string) {
+	for _, k := range kvSnapshots().Keys() {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSnapshots(t *testing.T) {
+	testMode()
+	Start()
+	t.Cleanup(func() {
+		if err := os.RemoveAll(testLocation); err != nil {
+			panic(err)
+		}
+	})
+	type snap struct {
+		Lights []int `json:"lights"`
+	}
+	if err := PutSnapshot("Before", snap{Lights: []int{1, 2}}); err != nil {
+		t.Fatal(err)
+	}
+	var got snap
+	if err := GetSnapshot("before", &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Lights) != 2 {
+		t.Errorf("unexpected snapshot: %+v", got)
+	}
+	if names := Snapshots(); len(names) != 1 || names[0] != "before" {
+		t.Errorf("unexpected snapshot names: %v", names)
+	}
+	if err := DeleteSnapshot("before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := GetSnapshot("before", &got); !errors.Is(err, ErrNoSuchSnapshot) {
+		t.Errorf("expected ErrNoSuchSnapshot, got %v", err)
+	}
+}
@@ -0,0 +1,453 @@
+// Package fakebridge is an in-process imitation of a Philips Hue bridge's v1 REST API, and of the read-only parts of its CLIP v2 API.
+// It is served from net/http/httptest so that ziggy and the cli commands can be tested without real hardware.
+package fakebridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	collLights        = "lights"
+	collGroups        = "groups"
+	collScenes        = "scenes"
+	collSensors       = "sensors"
+	collRules         = "rules"
+	collSchedules     = "schedules"
+	collResourceLinks = "resourcelinks"
+)
+
+var collections = []string{
+	collLights, collGroups, collScenes, collSensors, collRules, collSchedules, collResourceLinks,
+}
+
+// LinkButtonWindow is how long the simulated link button stays active after being pressed.
+var LinkButtonWindow = 30 * time.Second
+
+var bridgeCount uint32
+
+// Request is a record of a single request the fake bridge has served.
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Bridge is a fake Hue bridge. The embedded httptest.Server is already listening when New returns.
+type Bridge struct {
+	*httptest.Server
+	ID   string
+	Name string
+	Mac  string
+
+	resources  map[string]map[string]map[string]interface{}
+	nextID     map[string]int
+	config     map[string]interface{}
+	users      map[string]map[string]interface{}
+	linkUntil  time.Time
+	linkAfter  int
+	offline    bool
+	requests   []Request
+	userSerial int
+
+	eventstream bool
+	eventSerial int
+	streams     map[chan string]struct{}
+
+	*sync.RWMutex
+}
+
+// New starts a fake bridge with no lights, groups, or users.
+func New() *Bridge {
+	fb := newBridge()
+	fb.Server = httptest.NewServer(fb)
+	fb.config = fb.defaultConfig()
+	return fb
+}
+
+func newBridge() *Bridge {
+	n := atomic.AddUint32(&bridgeCount, 1)
+	fb := &Bridge{
+		ID:        fmt.Sprintf("001788FFFE%06X", n),
+		Name:      "Philips hue " + strconv.Itoa(int(n)),
+		Mac:       fmt.Sprintf("00:17:88:%02x:%02x:%02x", byte(n>>16), byte(n>>8), byte(n)),
+		resources: make(map[string]map[string]map[string]interface{}),
+		nextID:    make(map[string]int),
+		users:     make(map[string]map[string]interface{}),
+		streams:   make(map[chan string]struct{}),
+		RWMutex:   &sync.RWMutex{},
+	}
+	for _, c := range collections {
+		fb.resources[c] = make(map[string]map[string]interface{})
+		fb.nextID[c] = 1
+	}
+	return fb
+}
+
+// Hostname returns the base URL of the fake bridge, suitable for config.KnownBridge.Hostname.
+func (fb *Bridge) Hostname() string {
+	return fb.URL
+}
+
+// IPAddress is the address the fake bridge reports in its config. Unlike a real bridge,
+// it includes the port so that several fake bridges on the loopback address stay distinct.
+func (fb *Bridge) IPAddress() string {
+	return fb.Listener.Addr().String()
+}
+
+func (fb *Bridge) defaultConfig() map[string]interface{} {
+	host, _, _ := net.SplitHostPort(fb.IPAddress())
+	return map[string]interface{}{
+		"name":             fb.Name,
+		"zigbeechannel":    15,
+		"bridgeid":         fb.ID,
+		"mac":              fb.Mac,
+		"dhcp":             true,
+		"ipaddress":        fb.IPAddress(),
+		"netmask":          "255.0.0.0",
+		"gateway":          host,
+		"proxyaddress":     "none",
+		"proxyport":        0,
+		"UTC":              time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"localtime":        time.Now().Format("2006-01-02T15:04:05"),
+		"timezone":         "UTC",
+		"modelid":          "BSB002",
+		"datastoreversion": "131",
+		"swversion":        "1957060080",
+		"apiversion":       "1.56.0",
+		"swupdate2": map[string]interface{}{
+			"checkforupdate": false,
+			"lastchange":     "2023-01-01T00:00:00",
+			"bridge":         map[string]interface{}{"state": "noupdates", "lastinstall": "2023-01-01T00:00:00"},
+			"state":          "noupdates",
+			"autoinstall":    map[string]interface{}{"updatetime": "T14:00:00", "on": true},
+		},
+		"linkbutton":       false,
+		"portalservices":   false,
+		"portalconnection": "disconnected",
+		"portalstate": map[string]interface{}{
+			"signedon": false, "incoming": false, "outgoing": false, "communication": "disconnected",
+		},
+		"internetservices": map[string]interface{}{
+			"internet": "disconnected", "remoteaccess": "disconnected", "time": "disconnected", "swupdate": "disconnected",
+		},
+		"factorynew":       false,
+		"replacesbridgeid": nil,
+		"starterkitid":     "",
+	}
+}
+
+// NewUser whitelists a new username on the bridge and returns it, bypassing the link button.
+func (fb *Bridge) NewUser(devicetype string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	return fb.newUser(devicetype)
+}
+
+func (fb *Bridge) newUser(devicetype string) string {
+	fb.userSerial++
+	username := fmt.Sprintf("%s%034d", fb.ID[10:], fb.userSerial)
+	now := time.Now().UTC().Format("2006-01-02T15:04:05")
+	fb.users[username] = map[string]interface{}{
+		"name":          devicetype,
+		"create date":   now,
+		"last use date": now,
+	}
+	return username
+}
+
+// PressLinkButton simulates a press of the physical link button on the bridge.
+func (fb *Bridge) PressLinkButton() {
+	fb.Lock()
+	fb.linkUntil = time.Now().Add(LinkButtonWindow)
+	fb.Unlock()
+}
+
+// PressLinkButtonAfter simulates a user who presses the link button only after
+// the given number of unsuccessful pairing attempts have been made.
+func (fb *Bridge) PressLinkButtonAfter(attempts int) {
+	fb.Lock()
+	fb.linkAfter = attempts
+	fb.Unlock()
+}
+
+func (fb *Bridge) linkButtonActive() bool {
+	return time.Now().Before(fb.linkUntil)
+}
+
+// SetOffline makes the bridge refuse every request with a 503, simulating a bridge that has dropped off the network.
+// Going offline also disconnects everyone from the eventstream.
+func (fb *Bridge) SetOffline(offline bool) {
+	fb.Lock()
+	fb.offline = offline
+	if offline {
+		fb.dropStreams()
+	}
+	fb.Unlock()
+}
+
+func (fb *Bridge) add(coll string, obj map[string]interface{}) string {
+	id := strconv.Itoa(fb.nextID[coll])
+	fb.nextID[coll]++
+	fb.resources[coll][id] = obj
+	return id
+}
+
+// AddLight adds an extended color light to the bridge and returns its ID.
+func (fb *Bridge) AddLight(name string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	n := fb.nextID[collLights]
+	return fb.add(collLights, map[string]interface{}{
+		"state": map[string]interface{}{
+			"on":        false,
+			"bri":       254.0,
+			"hue":       8417.0,
+			"sat":       140.0,
+			"effect":    "none",
+			"xy":        []interface{}{0.4573, 0.41},
+			"ct":        366.0,
+			"alert":     "none",
+			"colormode": "ct",
+			"mode":      "homeautomation",
+			"reachable": true,
+		},
+		"type":             "Extended color light",
+		"name":             name,
+		"modelid":          "LCT015",
+		"manufacturername": "Signify Netherlands B.V.",
+		"productname":      "Hue color lamp",
+		"uniqueid":         fmt.Sprintf("%s:%02x-0b", fb.Mac, n),
+		"swversion":        "1.93.11",
+	})
+}
+
+// AddGroup adds a LightGroup containing the given light IDs and returns its ID.
+func (fb *Bridge) AddGroup(name string, lights ...string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	return fb.add(collGroups, fb.newGroup(name, "LightGroup", "", lights))
+}
+
+func (fb *Bridge) newGroup(name, typ, class string, lights []string) map[string]interface{} {
+	members := make([]interface{}, 0, len(lights))
+	for _, l := range lights {
+		members = append(members, l)
+	}
+	grp := map[string]interface{}{
+		"name":    name,
+		"lights":  members,
+		"sensors": []interface{}{},
+		"type":    typ,
+		"state":   map[string]interface{}{"all_on": false, "any_on": false},
+		"recycle": false,
+		"action": map[string]interface{}{
+			"on": false, "bri": 254.0, "hue": 8417.0, "sat": 140.0, "effect": "none",
+			"xy": []interface{}{0.4573, 0.41}, "ct": 366.0, "alert": "none", "colormode": "ct",
+		},
+	}
+	if class != "" {
+		grp["class"] = class
+	}
+	fb.refreshGroup(grp)
+	return grp
+}
+
+// AddScene stores the current state of the given lights as a GroupScene for group and returns the scene ID.
+func (fb *Bridge) AddScene(name, group string, lights ...string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	members := make([]interface{}, 0, len(lights))
+	states := make(map[string]interface{})
+	for _, l := range lights {
+		members = append(members, l)
+		if light, ok := fb.resources[collLights][l]; ok {
+			states[l] = sceneState(light["state"].(map[string]interface{}))
+		}
+	}
+	id := fmt.Sprintf("scene%04d", fb.nextID[collScenes])
+	fb.nextID[collScenes]++
+	fb.resources[collScenes][id] = map[string]interface{}{
+		"name":        name,
+		"type":        "GroupScene",
+		"group":       group,
+		"lights":      members,
+		"owner":       "",
+		"recycle":     false,
+		"locked":      false,
+		"appdata":     map[string]interface{}{},
+		"picture":     "",
+		"lastupdated": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"version":     2,
+		"lightstates": states,
+	}
+	return id
+}
+
+// AddSensor adds a sensor of the given type (e.g. ZLLPresence, ZLLTemperature, ZLLSwitch) and returns its ID.
+func (fb *Bridge) AddSensor(name, typ string, state map[string]interface{}) string {
+	fb.Lock()
+	defer fb.Unlock()
+	if state == nil {
+		state = make(map[string]interface{})
+	}
+	state["lastupdated"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	n := fb.nextID[collSensors]
+	return fb.add(collSensors, map[string]interface{}{
+		"state":            state,
+		"config":           map[string]interface{}{"on": true, "reachable": true, "battery": 100.0},
+		"name":             name,
+		"type":             typ,
+		"modelid":          "SML001",
+		"manufacturername": "Signify Netherlands B.V.",
+		"uniqueid":         fmt.Sprintf("%s:%02x-02-0406", fb.Mac, n),
+		"swversion":        "6.1.1.27575",
+	})
+}
+
+// AddRule adds a rule with no conditions or actions and returns its ID.
+func (fb *Bridge) AddRule(name string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	return fb.add(collRules, map[string]interface{}{
+		"name": name, "owner": "", "created": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"lasttriggered": "none", "timestriggered": 0, "status": "enabled", "recycle": false,
+		"conditions": []interface{}{}, "actions": []interface{}{},
+	})
+}
+
+// AddSchedule adds an enabled schedule and returns its ID.
+func (fb *Bridge) AddSchedule(name string) string {
+	fb.Lock()
+	defer fb.Unlock()
+	return fb.add(collSchedules, map[string]interface{}{
+		"name": name, "description": name, "status": "enabled", "autodelete": false,
+		"localtime": "W127/T07:00:00", "time": "W127/T07:00:00",
+		"created": time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"command": map[string]interface{}{"address": "/api/0/groups/0/action", "method": "PUT", "body": map[string]interface{}{"on": true}},
+	})
+}
+
+func copyObject(obj interface{}) map[string]interface{} {
+	if obj == nil {
+		return nil
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	var ret map[string]interface{}
+	if err = json.Unmarshal(raw, &ret); err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+// Object returns a copy of a stored resource, e.g. Object("lights", "1"), or nil if it doesn't exist.
+func (fb *Bridge) Object(collection, id string) map[string]interface{} {
+	fb.RLock()
+	defer fb.RUnlock()
+	obj, ok := fb.resources[collection][id]
+	if !ok {
+		return nil
+	}
+	return copyObject(obj)
+}
+
+// LightState returns a copy of the state object of the given light.
+func (fb *Bridge) LightState(id string) map[string]interface{} {
+	light := fb.Object(collLights, id)
+	if light == nil {
+		return nil
+	}
+	return light["state"].(map[string]interface{})
+}
+
+// SetLightState changes a light's state as if something other than ziggs (e.g. a wall switch or the Hue app) did it.
+func (fb *Bridge) SetLightState(id string, state map[string]interface{}) {
+	fb.Lock()
+	defer fb.Unlock()
+	if light, ok := fb.resources[collLights][id]; ok {
+		applyState(light["state"].(map[string]interface{}), state, "")
+		fb.refreshGroups()
+	}
+}
+
+// SetSensorState changes a sensor's state as if the physical sensor reported something.
+func (fb *Bridge) SetSensorState(id string, state map[string]interface{}) {
+	fb.Lock()
+	defer fb.Unlock()
+	if sensor, ok := fb.resources[collSensors][id]; ok {
+		st := sensor["state"].(map[string]interface{})
+		for k, v := range state {
+			st[k] = v
+		}
+		st["lastupdated"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	}
+}
+
+// SetSensorConfig changes a sensor's config, e.g. its battery level.
+func (fb *Bridge) SetSensorConfig(id string, cfg map[string]interface{}) {
+	fb.Lock()
+	defer fb.Unlock()
+	if sensor, ok := fb.resources[collSensors][id]; ok {
+		c := sensor["config"].(map[string]interface{})
+		for k, v := range cfg {
+			c[k] = v
+		}
+	}
+}
+
+// SetReachable marks a light or sensor as (un)reachable, as if it lost power or dropped off the zigbee mesh.
+func (fb *Bridge) SetReachable(collection, id string, reachable bool) {
+	fb.Lock()
+	defer fb.Unlock()
+	obj, ok := fb.resources[collection][id]
+	if !ok {
+		return
+	}
+	key := "state"
+	if collection == collSensors {
+		key = "config"
+	}
+	obj[key].(map[string]interface{})["reachable"] = reachable
+}
+
+// IDs returns the sorted IDs of every resource in the given collection.
+func (fb *Bridge) IDs(collection string) []string {
+	fb.RLock()
+	defer fb.RUnlock()
+	return fb.ids(collection)
+}
+
+func (fb *Bridge) ids(collection string) []string {
+	var ids []string
+	for id := range fb.resources[collection] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Requests returns every request served so far, oldest first.
+func (fb *Bridge) Requests() []Request {
+	fb.RLock()
+	defer fb.RUnlock()
+	ret := make([]Request, len(fb.requests))
+	copy(ret, fb.requests)
+	return ret
+}
+
+// ResetRequests clears the request log.
+func (fb *Bridge) ResetRequests() {
+	fb.Lock()
+	fb.requests = nil
+	fb.Unlock()
+}
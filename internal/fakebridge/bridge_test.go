@@ -0,0 +1,165 @@
+package fakebridge
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func call(t *testing.T, method, url string, body interface{}, out interface{}) {
+	t.Helper()
+	var rdr *bytes.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr = bytes.NewReader(raw)
+	} else {
+		rdr = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequest(method, url, rdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func firstError(resp []map[string]interface{}) map[string]interface{} {
+	for _, r := range resp {
+		if e, ok := r["error"].(map[string]interface{}); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+func TestLinkButton(t *testing.T) {
+	fb := New()
+	defer fb.Close()
+	var resp []map[string]interface{}
+	call(t, http.MethodPost, fb.URL+"/api", map[string]string{"devicetype": "ziggs#test"}, &resp)
+	if e := firstError(resp); e == nil || e["type"].(float64) != errLinkButton {
+		t.Fatalf("expected link button error, got %v", resp)
+	}
+
+	fb.PressLinkButtonAfter(2)
+	resp = nil
+	call(t, http.MethodPost, fb.URL+"/api", map[string]string{"devicetype": "ziggs#test"}, &resp)
+	if firstError(resp) == nil {
+		t.Fatal("expected link button to stay unpressed for the first attempt")
+	}
+	resp = nil
+	call(t, http.MethodPost, fb.URL+"/api", map[string]interface{}{
+		"devicetype": "ziggs#test", "generateclientkey": true,
+	}, &resp)
+	if e := firstError(resp); e != nil {
+		t.Fatalf("expected pairing to succeed, got %v", e)
+	}
+	ok := resp[0]["success"].(map[string]interface{})
+	user, _ := ok["username"].(string)
+	if len(user) != 40 {
+		t.Fatalf("expected a 40 character username, got %q", user)
+	}
+	if ok["clientkey"] == nil {
+		t.Fatal("expected a client key")
+	}
+
+	var conf map[string]interface{}
+	call(t, http.MethodGet, fb.URL+"/api/"+user+"/config", nil, &conf)
+	if _, listed := conf["whitelist"].(map[string]interface{})[user]; !listed {
+		t.Fatal("new user missing from whitelist")
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	fb := New()
+	defer fb.Close()
+	for _, path := range []string{"/api/config", "/api/nobody/config"} {
+		var conf map[string]interface{}
+		call(t, http.MethodGet, fb.URL+path, nil, &conf)
+		if conf["bridgeid"] != fb.ID || conf["factorynew"] != false || conf["whitelist"] != nil {
+			t.Fatalf("%s: expected public config, got %v", path, conf)
+		}
+	}
+	var resp []map[string]interface{}
+	call(t, http.MethodGet, fb.URL+"/api/nobody/lights", nil, &resp)
+	if e := firstError(resp); e == nil || e["type"].(float64) != errUnauthorized {
+		t.Fatalf("expected unauthorized user error, got %v", resp)
+	}
+}
+
+func TestGroupAction(t *testing.T) {
+	fb := New()
+	defer fb.Close()
+	user := fb.NewUser("ziggs#test")
+	l1 := fb.AddLight("lamp")
+	l2 := fb.AddLight("desk")
+	fb.AddLight("hallway")
+	grp := fb.AddGroup("office", l1, l2)
+
+	var resp []map[string]interface{}
+	call(t, http.MethodPut, fb.URL+"/api/"+user+"/groups/"+grp+"/action",
+		map[string]interface{}{"on": true, "xy": []float64{0.2, 0.3}, "transitiontime": 10}, &resp)
+	if e := firstError(resp); e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+	for _, id := range []string{l1, l2} {
+		st := fb.LightState(id)
+		if st["on"] != true || st["colormode"] != "xy" {
+			t.Errorf("light %s: expected on in xy mode, got %v", id, st)
+		}
+	}
+	if fb.LightState("3")["on"] != false {
+		t.Error("light outside of group was changed")
+	}
+	var group map[string]interface{}
+	call(t, http.MethodGet, fb.URL+"/api/"+user+"/groups/"+grp, nil, &group)
+	if group["state"].(map[string]interface{})["all_on"] != true {
+		t.Errorf("expected all_on, got %v", group["state"])
+	}
+
+	scene := fb.AddScene("bright", grp, l1, l2)
+	fb.SetLightState(l1, map[string]interface{}{"on": false, "ct": 200.0})
+	resp = nil
+	call(t, http.MethodPut, fb.URL+"/api/"+user+"/groups/"+grp+"/action",
+		map[string]interface{}{"scene": scene}, &resp)
+	if e := firstError(resp); e != nil {
+		t.Fatalf("unexpected error recalling scene: %v", e)
+	}
+	if st := fb.LightState(l1); st["on"] != true || st["colormode"] != "xy" {
+		t.Errorf("scene recall did not restore light %s: %v", l1, st)
+	}
+
+	resp = nil
+	call(t, http.MethodPut, fb.URL+"/api/"+user+"/lights/"+l1+"/state",
+		map[string]interface{}{"bri": 300}, &resp)
+	if e := firstError(resp); e == nil || e["type"].(float64) != errInvalidValue {
+		t.Fatalf("expected invalid value error, got %v", resp)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	fb := New()
+	defer fb.Close()
+	fb.SetOffline(true)
+	resp, err := http.Get(fb.URL + "/api/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", resp.StatusCode)
+	}
+	if len(fb.Requests()) != 1 {
+		t.Fatalf("expected 1 logged request, got %d", len(fb.Requests()))
+	}
+}
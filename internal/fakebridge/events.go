@@ -0,0 +1,103 @@
+package fakebridge
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EnableEventstream makes the bridge serve /eventstream/clip/v2. It is off by default, so that
+// clients fall back to polling like they do with bridges too old to have one.
+func (fb *Bridge) EnableEventstream() {
+	fb.Lock()
+	fb.eventstream = true
+	fb.Unlock()
+}
+
+// Streams returns how many clients are connected to the eventstream.
+func (fb *Bridge) Streams() int {
+	fb.RLock()
+	defer fb.RUnlock()
+	return len(fb.streams)
+}
+
+// Emit sends one message with an event of the given kind (add, update or delete) for every resource in data
+// to everyone connected to the eventstream. Resources need at least an id and a type.
+func (fb *Bridge) Emit(kind string, data ...map[string]interface{}) {
+	fb.Lock()
+	defer fb.Unlock()
+	fb.eventSerial++
+	msg := fmt.Sprintf("id: %d:0\ndata: %s\n\n", fb.eventSerial, jsonString([]interface{}{map[string]interface{}{
+		"creationtime": time.Now().UTC().Format(time.RFC3339),
+		"id":           fb.uuid("event", fmt.Sprint(fb.eventSerial)),
+		"type":         kind,
+		"data":         data,
+	}}))
+	for ch := range fb.streams {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// dropStreams disconnects everyone from the eventstream, the caller holds the lock.
+func (fb *Bridge) dropStreams() {
+	for ch := range fb.streams {
+		close(ch)
+		delete(fb.streams, ch)
+	}
+}
+
+// serveEventstream serves server-sent events until the client goes away or the bridge goes offline.
+func (fb *Bridge) serveEventstream(w http.ResponseWriter, r *http.Request) {
+	fb.Lock()
+	fb.requests = append(fb.requests, Request{Method: r.Method, Path: r.URL.Path})
+	switch _, authorized := fb.users[r.Header.Get("hue-application-key")]; {
+	case fb.offline:
+		fb.Unlock()
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	case !fb.eventstream:
+		fb.Unlock()
+		http.NotFound(w, r)
+		return
+	case !authorized:
+		fb.Unlock()
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	ch := make(chan string, 16)
+	fb.streams[ch] = struct{}{}
+	fb.Unlock()
+	defer func() {
+		fb.Lock()
+		delete(fb.streams, ch)
+		fb.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(": hi\n\n"))
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if _, err := w.Write([]byte(msg)); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
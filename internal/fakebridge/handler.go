@@ -0,0 +1,359 @@
+package fakebridge
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func jsonString(v interface{}) string {
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
+
+func (fb *Bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") == "eventstream/clip/v2" {
+		fb.serveEventstream(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	fb.Lock()
+	defer fb.Unlock()
+	fb.requests = append(fb.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+	if fb.offline {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	segs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segs) >= 2 && segs[0] == "clip" && segs[1] == "v2" {
+		fb.serveV2(w, r, segs[2:])
+		return
+	}
+	if segs[0] != "api" {
+		http.NotFound(w, r)
+		return
+	}
+	var resp interface{}
+	switch {
+	case len(segs) == 1:
+		resp = fb.serveCreateUser(r.Method, body)
+	case len(segs) == 2 && segs[1] == "config" && r.Method == http.MethodGet:
+		resp = fb.publicConfig()
+	default:
+		resp = fb.serveUser(r.Method, segs[1], segs[2:], body)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (fb *Bridge) publicConfig() map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, k := range []string{
+		"name", "datastoreversion", "swversion", "apiversion", "mac",
+		"bridgeid", "factorynew", "replacesbridgeid", "modelid", "starterkitid",
+	} {
+		ret[k] = fb.config[k]
+	}
+	return ret
+}
+
+func (fb *Bridge) fullConfig() map[string]interface{} {
+	ret := copyObject(fb.config)
+	ret["linkbutton"] = fb.linkButtonActive()
+	ret["whitelist"] = copyObject(fb.users)
+	ret["UTC"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	ret["localtime"] = time.Now().Format("2006-01-02T15:04:05")
+	return ret
+}
+
+func (fb *Bridge) serveCreateUser(method string, body []byte) []interface{} {
+	if method != http.MethodPost {
+		return []interface{}{apiError(errMethodNotAllowed, "/", "method, "+method+", not available for resource, /")}
+	}
+	var req struct {
+		DeviceType        string `json:"devicetype"`
+		GenerateClientKey bool   `json:"generateclientkey"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return []interface{}{apiError(errInvalidJSON, "", "body contains invalid json")}
+	}
+	if req.DeviceType == "" {
+		return []interface{}{apiError(errParamNotAvail, "/devicetype", "parameter, devicetype, not available")}
+	}
+	if fb.linkAfter > 0 {
+		fb.linkAfter--
+		if fb.linkAfter == 0 {
+			fb.linkUntil = time.Now().Add(LinkButtonWindow)
+		}
+	}
+	if !fb.linkButtonActive() {
+		return []interface{}{apiError(errLinkButton, "", "link button not pressed")}
+	}
+	ok := map[string]interface{}{"username": fb.newUser(req.DeviceType)}
+	if req.GenerateClientKey {
+		ok["clientkey"] = strings.ToUpper(strings.Repeat(fb.ID, 2))
+	}
+	return []interface{}{map[string]interface{}{"success": ok}}
+}
+
+func (fb *Bridge) serveUser(method, user string, path []string, body []byte) interface{} {
+	addr := "/" + strings.Join(path, "/")
+	if _, ok := fb.users[user]; !ok {
+		if method == http.MethodGet && addr == "/config" {
+			return fb.publicConfig()
+		}
+		return []interface{}{apiError(errUnauthorized, addr, "unauthorized user")}
+	}
+	fb.users[user]["last use date"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+
+	var change map[string]interface{}
+	if method == http.MethodPut || method == http.MethodPost {
+		if len(body) > 0 && json.Unmarshal(body, &change) != nil {
+			return []interface{}{apiError(errInvalidJSON, addr, "body contains invalid json")}
+		}
+	}
+
+	notAllowed := []interface{}{apiError(errMethodNotAllowed, addr,
+		"method, "+method+", not available for resource, "+addr)}
+
+	switch {
+	case len(path) == 0:
+		if method != http.MethodGet {
+			return notAllowed
+		}
+		full := map[string]interface{}{"config": fb.fullConfig()}
+		for _, c := range collections {
+			full[c] = fb.list(c)
+		}
+		return full
+	case path[0] == "config":
+		switch method {
+		case http.MethodGet:
+			return fb.fullConfig()
+		case http.MethodPut:
+			return fb.updateConfig(change)
+		}
+		return notAllowed
+	case path[0] == "capabilities":
+		if method != http.MethodGet {
+			return notAllowed
+		}
+		return fb.capabilities()
+	}
+
+	if _, ok := fb.resources[path[0]]; !ok {
+		return []interface{}{apiError(errNotAvailable, addr, "resource, "+addr+", not available")}
+	}
+	return fb.serveCollection(method, path[0], path[1:], change)
+}
+
+func (fb *Bridge) updateConfig(change map[string]interface{}) []interface{} {
+	var resp []interface{}
+	for _, k := range sortedKeys(change) {
+		switch k {
+		case "linkbutton":
+			if pressed, _ := change[k].(bool); pressed {
+				fb.linkUntil = time.Now().Add(LinkButtonWindow)
+			}
+		case "reboot":
+		case "bridgeid", "mac", "modelid", "apiversion", "datastoreversion":
+			resp = append(resp, apiError(errParamNotAvail, "/config/"+k, "parameter, "+k+", not modifiable"))
+			continue
+		default:
+			fb.config[k] = change[k]
+		}
+		resp = append(resp, success("/config/"+k, change[k]))
+	}
+	return resp
+}
+
+func (fb *Bridge) capabilities() map[string]interface{} {
+	avail := func(c string, total int) map[string]interface{} {
+		return map[string]interface{}{"available": total - len(fb.resources[c]), "total": total}
+	}
+	return map[string]interface{}{
+		"lights":        avail(collLights, 63),
+		"sensors":       avail(collSensors, 250),
+		"groups":        avail(collGroups, 64),
+		"scenes":        avail(collScenes, 200),
+		"schedules":     avail(collSchedules, 100),
+		"rules":         avail(collRules, 250),
+		"resourcelinks": avail(collResourceLinks, 64),
+		"streaming":     map[string]interface{}{"available": 1, "total": 1, "channels": 20},
+		"timezones":     map[string]interface{}{"values": []interface{}{"UTC"}},
+	}
+}
+
+// list renders a collection the way GET /api/<user>/<collection> does. Scenes are listed without their light states.
+func (fb *Bridge) list(coll string) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for id, obj := range fb.resources[coll] {
+		item := copyObject(obj)
+		if coll == collScenes {
+			delete(item, "lightstates")
+		}
+		ret[id] = item
+	}
+	return ret
+}
+
+func (fb *Bridge) lookup(coll, id string) (map[string]interface{}, bool) {
+	if coll == collGroups && id == "0" {
+		return fb.groupZero(), true
+	}
+	obj, ok := fb.resources[coll][id]
+	return obj, ok
+}
+
+func (fb *Bridge) serveCollection(method, coll string, rest []string, change map[string]interface{}) interface{} {
+	addr := "/" + coll
+	if len(rest) > 0 {
+		addr += "/" + strings.Join(rest, "/")
+	}
+	notAllowed := []interface{}{apiError(errMethodNotAllowed, addr,
+		"method, "+method+", not available for resource, "+addr)}
+
+	if len(rest) == 0 {
+		switch method {
+		case http.MethodGet:
+			return fb.list(coll)
+		case http.MethodPost:
+			return fb.create(coll, change)
+		}
+		return notAllowed
+	}
+
+	if rest[0] == "new" && (coll == collLights || coll == collSensors) {
+		if method != http.MethodGet {
+			return notAllowed
+		}
+		return map[string]interface{}{"lastscan": "none"}
+	}
+
+	id := rest[0]
+	obj, ok := fb.lookup(coll, id)
+	if !ok {
+		return []interface{}{apiError(errNotAvailable, addr, "resource, "+addr+", not available")}
+	}
+
+	if len(rest) == 1 {
+		switch method {
+		case http.MethodGet:
+			if coll == collGroups {
+				fb.refreshGroup(obj)
+			}
+			return copyObject(obj)
+		case http.MethodPut:
+			return fb.update(coll, id, obj, change)
+		case http.MethodDelete:
+			delete(fb.resources[coll], id)
+			return []interface{}{map[string]interface{}{"success": addr + " deleted"}}
+		}
+		return notAllowed
+	}
+
+	if method != http.MethodPut || len(rest) != 2 {
+		return notAllowed
+	}
+
+	switch {
+	case coll == collLights && rest[1] == "state":
+		resp := applyState(obj["state"].(map[string]interface{}), change, addr)
+		fb.refreshGroups()
+		return resp
+	case coll == collGroups && rest[1] == "action":
+		return fb.groupAction(obj, change, addr)
+	case coll == collSensors && (rest[1] == "state" || rest[1] == "config"):
+		sub := obj[rest[1]].(map[string]interface{})
+		var resp []interface{}
+		for _, k := range sortedKeys(change) {
+			sub[k] = change[k]
+			resp = append(resp, success(addr+"/"+k, change[k]))
+		}
+		return resp
+	}
+	return []interface{}{apiError(errNotAvailable, addr, "resource, "+addr+", not available")}
+}
+
+func (fb *Bridge) groupAction(grp, change map[string]interface{}, addr string) []interface{} {
+	var resp []interface{}
+	if sceneID, ok := change["scene"].(string); ok {
+		delete(change, "scene")
+		scene, found := fb.resources[collScenes][sceneID]
+		if !found {
+			return []interface{}{apiError(errInvalidValue, addr+"/scene",
+				"invalid value, "+sceneID+", for parameter, scene")}
+		}
+		states, _ := scene["lightstates"].(map[string]interface{})
+		for lid, st := range states {
+			if light, exists := fb.resources[collLights][lid]; exists {
+				applyState(light["state"].(map[string]interface{}), copyObject(st), "")
+			}
+		}
+		resp = append(resp, success(addr+"/scene", sceneID))
+	}
+	if len(change) > 0 {
+		action, _ := grp["action"].(map[string]interface{})
+		resp = append(resp, applyState(action, change, addr)...)
+		for _, lid := range fb.groupMembers(grp) {
+			if light, exists := fb.resources[collLights][lid]; exists {
+				applyState(light["state"].(map[string]interface{}), change, "")
+			}
+		}
+	}
+	fb.refreshGroups()
+	return resp
+}
+
+func (fb *Bridge) create(coll string, change map[string]interface{}) []interface{} {
+	var id string
+	switch coll {
+	case collLights, collSensors:
+		if coll == collSensors && change != nil {
+			id = fb.add(coll, change)
+			break
+		}
+		return []interface{}{success("/"+coll, "Searching for new devices")}
+	case collGroups:
+		name, _ := change["name"].(string)
+		typ, _ := change["type"].(string)
+		class, _ := change["class"].(string)
+		if typ == "" {
+			typ = "LightGroup"
+		}
+		var lights []string
+		raw, _ := change["lights"].([]interface{})
+		for _, l := range raw {
+			if s, ok := l.(string); ok {
+				lights = append(lights, s)
+			}
+		}
+		id = fb.add(coll, fb.newGroup(name, typ, class, lights))
+	default:
+		if change == nil {
+			change = make(map[string]interface{})
+		}
+		id = fb.add(coll, change)
+	}
+	return []interface{}{success("id", id)}
+}
+
+func (fb *Bridge) update(coll, id string, obj, change map[string]interface{}) []interface{} {
+	var resp []interface{}
+	addr := "/" + coll + "/" + id
+	for _, k := range sortedKeys(change) {
+		switch k {
+		case "state", "action", "uniqueid", "modelid", "type", "manufacturername":
+			resp = append(resp, apiError(errParamNotAvail, addr+"/"+k, "parameter, "+k+", not available"))
+			continue
+		}
+		obj[k] = change[k]
+		resp = append(resp, success(addr+"/"+k, change[k]))
+	}
+	if coll == collGroups {
+		fb.refreshGroup(obj)
+	}
+	return resp
+}
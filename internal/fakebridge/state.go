@@ -0,0 +1,201 @@
+package fakebridge
+
+import (
+	"math"
+	"sort"
+)
+
+const (
+	errUnauthorized     = 1
+	errInvalidJSON      = 2
+	errNotAvailable     = 3
+	errMethodNotAllowed = 4
+	errParamNotAvail    = 6
+	errInvalidValue     = 7
+	errLinkButton       = 101
+)
+
+func apiError(typ int, address, description string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{
+		"type":        typ,
+		"address":     address,
+		"description": description,
+	}}
+}
+
+func success(address string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{"success": map[string]interface{}{address: value}}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+// applyState merges a v1 state change into a light state or group action object the way a bridge would,
+// returning the success and error entries for the response. addr is the prefix used in those entries.
+func applyState(state, change map[string]interface{}, addr string) (resp []interface{}) {
+	invalid := func(k string, v interface{}) {
+		resp = append(resp, apiError(errInvalidValue, addr+"/"+k,
+			"invalid value, "+jsonString(v)+", for parameter, "+k))
+	}
+	for _, k := range sortedKeys(change) {
+		v := change[k]
+		switch k {
+		case "on":
+			b, ok := v.(bool)
+			if !ok {
+				invalid(k, v)
+				continue
+			}
+			state[k] = b
+		case "bri", "sat":
+			n, ok := number(v)
+			if !ok || n < 0 || n > 254 {
+				invalid(k, v)
+				continue
+			}
+			state[k] = n
+			if k == "sat" {
+				state["colormode"] = "hs"
+			}
+		case "hue":
+			n, ok := number(v)
+			if !ok || n < 0 || n > 65535 {
+				invalid(k, v)
+				continue
+			}
+			state[k] = n
+			state["colormode"] = "hs"
+		case "ct":
+			n, ok := number(v)
+			if !ok {
+				invalid(k, v)
+				continue
+			}
+			state[k] = clamp(n, 153, 500)
+			state["colormode"] = "ct"
+		case "xy":
+			xy, ok := v.([]interface{})
+			if !ok || len(xy) != 2 {
+				invalid(k, v)
+				continue
+			}
+			x, xok := number(xy[0])
+			y, yok := number(xy[1])
+			if !xok || !yok {
+				invalid(k, v)
+				continue
+			}
+			state[k] = []interface{}{clamp(x, 0, 1), clamp(y, 0, 1)}
+			state["colormode"] = "xy"
+		case "bri_inc", "sat_inc", "hue_inc", "ct_inc":
+			n, ok := number(v)
+			if !ok {
+				invalid(k, v)
+				continue
+			}
+			target := k[:len(k)-4]
+			cur, _ := number(state[target])
+			switch target {
+			case "hue":
+				state[target] = math.Mod(cur+n+65536, 65536)
+				state["colormode"] = "hs"
+			case "ct":
+				state[target] = clamp(cur+n, 153, 500)
+				state["colormode"] = "ct"
+			default:
+				state[target] = clamp(cur+n, 0, 254)
+			}
+		case "effect", "alert":
+			s, ok := v.(string)
+			if !ok {
+				invalid(k, v)
+				continue
+			}
+			state[k] = s
+		case "transitiontime":
+			// accepted, but not reflected in state; changes are applied instantly.
+			if _, ok := number(v); !ok {
+				invalid(k, v)
+				continue
+			}
+		default:
+			resp = append(resp, apiError(errParamNotAvail, addr+"/"+k, "parameter, "+k+", not available"))
+			continue
+		}
+		resp = append(resp, success(addr+"/"+k, v))
+	}
+	return resp
+}
+
+// sceneState captures the parts of a light state that a scene stores, honoring the light's colormode.
+func sceneState(state map[string]interface{}) map[string]interface{} {
+	ret := map[string]interface{}{"on": state["on"], "bri": state["bri"]}
+	switch state["colormode"] {
+	case "xy":
+		ret["xy"] = state["xy"]
+	case "hs":
+		ret["hue"] = state["hue"]
+		ret["sat"] = state["sat"]
+	default:
+		ret["ct"] = state["ct"]
+	}
+	return ret
+}
+
+func (fb *Bridge) groupMembers(grp map[string]interface{}) []string {
+	var members []string
+	raw, _ := grp["lights"].([]interface{})
+	for _, l := range raw {
+		if id, ok := l.(string); ok {
+			members = append(members, id)
+		}
+	}
+	return members
+}
+
+// refreshGroup recalculates the all_on and any_on fields of a group from its member lights.
+func (fb *Bridge) refreshGroup(grp map[string]interface{}) {
+	members := fb.groupMembers(grp)
+	allOn, anyOn := len(members) > 0, false
+	for _, id := range members {
+		light, ok := fb.resources[collLights][id]
+		if !ok {
+			continue
+		}
+		on, _ := light["state"].(map[string]interface{})["on"].(bool)
+		allOn = allOn && on
+		anyOn = anyOn || on
+	}
+	grp["state"] = map[string]interface{}{"all_on": allOn, "any_on": anyOn}
+}
+
+func (fb *Bridge) refreshGroups() {
+	for _, grp := range fb.resources[collGroups] {
+		fb.refreshGroup(grp)
+	}
+}
+
+// groupZero is the special group containing every light on the bridge.
+func (fb *Bridge) groupZero() map[string]interface{} {
+	var ids []string
+	for id := range fb.resources[collLights] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return fb.newGroup("Group 0", "LightGroup", "", ids)
+}
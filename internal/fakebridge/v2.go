@@ -0,0 +1,222 @@
+package fakebridge
+
+import (
+	"crypto/md5" // #nosec -- only used to derive stable IDs
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// uuid derives a stable v2 ID for a resource from the bridge ID, its type, and the v1 object it comes from.
+func (fb *Bridge) uuid(rtype, key string) string {
+	sum := md5.Sum([]byte(fb.ID + "/" + rtype + "/" + key)) // #nosec
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func ref(rid, rtype string) map[string]interface{} {
+	return map[string]interface{}{"rid": rid, "rtype": rtype}
+}
+
+// v2Resources renders the v1 state of the bridge the way the CLIP v2 API presents it.
+// Every light and sensor is its own device, LightGroups become zones and Rooms become rooms.
+func (fb *Bridge) v2Resources() []map[string]interface{} {
+	var ret []map[string]interface{}
+	add := func(rtype, key, idV1 string, fields map[string]interface{}) string {
+		id := fb.uuid(rtype, key)
+		fields["id"] = id
+		fields["type"] = rtype
+		if idV1 != "" {
+			fields["id_v1"] = idV1
+		}
+		ret = append(ret, fields)
+		return id
+	}
+	connectivity := func(reachable interface{}) string {
+		if reachable == false {
+			return "connectivity_issue"
+		}
+		return "connected"
+	}
+
+	lightDevices := make(map[string]string)
+	lightIDs := make(map[string]string)
+	for _, id := range fb.ids(collLights) {
+		l := fb.resources[collLights][id]
+		st := l["state"].(map[string]interface{})
+		path := "/" + collLights + "/" + id
+		device := fb.uuid("device", path)
+		lightDevices[id] = device
+		light := add("light", path, path, map[string]interface{}{
+			"owner":    ref(device, "device"),
+			"metadata": map[string]interface{}{"name": l["name"], "archetype": "sultan_bulb"},
+			"on":       map[string]interface{}{"on": st["on"]},
+			"mode":     "normal",
+		})
+		lightIDs[id] = light
+		last := ret[len(ret)-1]
+		if bri, ok := number(st["bri"]); ok {
+			last["dimming"] = map[string]interface{}{"brightness": bri / 254 * 100}
+		}
+		if ct, ok := number(st["ct"]); ok {
+			last["color_temperature"] = map[string]interface{}{"mirek": ct, "mirek_valid": st["colormode"] == "ct"}
+		}
+		if xy, ok := st["xy"].([]interface{}); ok && len(xy) == 2 {
+			last["color"] = map[string]interface{}{"xy": map[string]interface{}{"x": xy[0], "y": xy[1]}}
+		}
+		zc := add("zigbee_connectivity", path, path, map[string]interface{}{
+			"owner":       ref(device, "device"),
+			"status":      connectivity(st["reachable"]),
+			"mac_address": l["uniqueid"],
+		})
+		add("device", path, path, map[string]interface{}{
+			"metadata": map[string]interface{}{"name": l["name"], "archetype": "sultan_bulb"},
+			"product_data": map[string]interface{}{
+				"model_id": l["modelid"], "manufacturer_name": l["manufacturername"],
+				"product_name": l["productname"], "software_version": l["swversion"], "certified": true,
+			},
+			"services": []interface{}{ref(light, "light"), ref(zc, "zigbee_connectivity")},
+		})
+	}
+
+	groupOwners := make(map[string]map[string]interface{})
+	for _, id := range fb.ids(collGroups) {
+		g := fb.resources[collGroups][id]
+		path := "/" + collGroups + "/" + id
+		rtype, childType, children := "zone", "light", lightIDs
+		if g["type"] == "Room" {
+			rtype, childType, children = "room", "device", lightDevices
+		}
+		var kids []interface{}
+		members, _ := g["lights"].([]interface{})
+		for _, m := range members {
+			if kid, ok := children[fmt.Sprint(m)]; ok {
+				kids = append(kids, ref(kid, childType))
+			}
+		}
+		owner := fb.uuid(rtype, path)
+		grouped := add("grouped_light", path, path, map[string]interface{}{
+			"owner": ref(owner, rtype),
+			"on":    map[string]interface{}{"on": g["state"].(map[string]interface{})["any_on"]},
+		})
+		add(rtype, path, path, map[string]interface{}{
+			"metadata": map[string]interface{}{"name": g["name"]},
+			"children": kids,
+			"services": []interface{}{ref(grouped, "grouped_light")},
+		})
+		groupOwners[id] = ref(owner, rtype)
+	}
+
+	for _, id := range fb.ids(collScenes) {
+		sc := fb.resources[collScenes][id]
+		path := "/" + collScenes + "/" + id
+		fields := map[string]interface{}{"metadata": map[string]interface{}{"name": sc["name"]}}
+		if owner, ok := groupOwners[fmt.Sprint(sc["group"])]; ok {
+			fields["group"] = owner
+		}
+		add("scene", path, path, fields)
+	}
+
+	for _, id := range fb.ids(collSensors) {
+		s := fb.resources[collSensors][id]
+		st, _ := s["state"].(map[string]interface{})
+		cfg, _ := s["config"].(map[string]interface{})
+		path := "/" + collSensors + "/" + id
+		device := fb.uuid("device", path)
+		var services []interface{}
+		service := func(rtype string, fields map[string]interface{}) {
+			fields["owner"] = ref(device, "device")
+			services = append(services, ref(add(rtype, path, path, fields), rtype))
+		}
+		switch s["type"] {
+		case "ZLLPresence":
+			service("motion", map[string]interface{}{
+				"enabled": cfg["on"],
+				"motion":  map[string]interface{}{"motion": st["presence"] == true, "motion_valid": true},
+			})
+		case "ZLLTemperature":
+			temp, _ := number(st["temperature"])
+			service("temperature", map[string]interface{}{
+				"enabled":     cfg["on"],
+				"temperature": map[string]interface{}{"temperature": temp / 100, "temperature_valid": true},
+			})
+		case "ZLLSwitch":
+			for control := 1; control <= 4; control++ {
+				fields := map[string]interface{}{"metadata": map[string]interface{}{"control_id": control}}
+				fields["owner"] = ref(device, "device")
+				id := add("button", fmt.Sprintf("%s/%d", path, control), path, fields)
+				services = append(services, ref(id, "button"))
+			}
+		default:
+			continue
+		}
+		if battery, ok := number(cfg["battery"]); ok {
+			state := "normal"
+			if battery < 10 {
+				state = "critical"
+			} else if battery < 25 {
+				state = "low"
+			}
+			service("device_power", map[string]interface{}{
+				"power_state": map[string]interface{}{"battery_level": battery, "battery_state": state},
+			})
+		}
+		service("zigbee_connectivity", map[string]interface{}{
+			"status": connectivity(cfg["reachable"]), "mac_address": s["uniqueid"],
+		})
+		add("device", path, path, map[string]interface{}{
+			"metadata": map[string]interface{}{"name": s["name"], "archetype": "unknown_archetype"},
+			"product_data": map[string]interface{}{
+				"model_id": s["modelid"], "manufacturer_name": s["manufacturername"],
+				"product_name": s["type"], "software_version": s["swversion"], "certified": true,
+			},
+			"services": services,
+		})
+	}
+	return ret
+}
+
+// serveV2 answers GETs of /clip/v2/resource, /clip/v2/resource/<type> and /clip/v2/resource/<type>/<id>.
+func (fb *Bridge) serveV2(w http.ResponseWriter, r *http.Request, path []string) {
+	reply := func(status int, data []map[string]interface{}, errs ...string) {
+		var errList []interface{}
+		for _, e := range errs {
+			errList = append(errList, map[string]interface{}{"description": e})
+		}
+		if data == nil {
+			data = []map[string]interface{}{}
+		}
+		if errList == nil {
+			errList = []interface{}{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(jsonString(map[string]interface{}{"errors": errList, "data": data})))
+	}
+	if _, ok := fb.users[r.Header.Get("hue-application-key")]; !ok {
+		reply(http.StatusForbidden, nil, "unauthorized user")
+		return
+	}
+	if r.Method != http.MethodGet {
+		reply(http.StatusMethodNotAllowed, nil, "method not allowed")
+		return
+	}
+	if len(path) == 0 || path[0] != "resource" || len(path) > 3 {
+		reply(http.StatusNotFound, nil, "Not Found")
+		return
+	}
+	var found []map[string]interface{}
+	for _, res := range fb.v2Resources() {
+		if len(path) > 1 && res["type"] != path[1] {
+			continue
+		}
+		if len(path) > 2 && !strings.EqualFold(fmt.Sprint(res["id"]), path[2]) {
+			continue
+		}
+		found = append(found, res)
+	}
+	if len(path) > 2 && len(found) == 0 {
+		reply(http.StatusNotFound, nil, "Not Found")
+		return
+	}
+	reply(http.StatusOK, found)
+}
@@ -1,6 +1,10 @@
 package haptic
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 type Color struct {
 	Xy struct {
@@ -37,10 +41,6 @@ type ColorTemperature struct {
 	MirekValid bool        `json:"mirek_valid"`
 }
 
-type Status struct {
-	Active string `json:"active"`
-}
-
 type PowerState struct {
 	BatteryLevel int    `json:"battery_level"`
 	BatteryState string `json:"battery_state"`
@@ -55,23 +55,66 @@ type Temperature struct {
 	TemperatureValid bool `json:"temperature_valid"`
 }
 
+type On struct {
+	On bool `json:"on"`
+}
+
+type Motion struct {
+	Motion      bool `json:"motion"`
+	MotionValid bool `json:"motion_valid"`
+}
+
+type LightLevel struct {
+	LightLevel      int  `json:"light_level"`
+	LightLevelValid bool `json:"light_level_valid"`
+}
+
+type Metadata struct {
+	Name      string `json:"name"`
+	Archetype string `json:"archetype,omitempty"`
+	// ControlID is which button of a switch a button resource is, starting at 1.
+	ControlID int `json:"control_id,omitempty"`
+}
+
+// WrappedEvent is one message from the CLIP v2 eventstream, each one carries changes to one or more resources.
 type WrappedEvent struct {
 	Timestamp time.Time `json:"creationtime"`
 	Id        string    `json:"id"`
-	Type      string    `json:"type"`
+	// Type is one of "add", "update", "delete" or "error".
+	Type string `json:"type"`
 
-	Event Event `json:"data"`
+	Data []Event `json:"data"`
 }
 
+// Event describes a change to a single resource. Only the fields that changed are present.
 type Event struct {
-	IdV1             string           `json:"id_v1"`
-	Button           Button           `json:"button,omitempty"`
-	Owner            Owner            `json:"owner,omitempty"`
-	Dimming          Dimming          `json:"dimming,omitempty"`
-	Dynamics         Dynamics         `json:"dynamics,omitempty"`
-	Color            Color            `json:"color,omitempty"`
-	ColorTemperature ColorTemperature `json:"color_temperature,omitempty"`
-	Temperature      Temperature      `json:"temperature,omitempty"`
-	PowerState       PowerState       `json:"power_state,omitempty"`
-	Status           Status           `json:"status,omitempty"`
+	Id               string            `json:"id"`
+	IdV1             string            `json:"id_v1"`
+	Type             string            `json:"type"`
+	Metadata         *Metadata         `json:"metadata,omitempty"`
+	On               *On               `json:"on,omitempty"`
+	Button           *Button           `json:"button,omitempty"`
+	Owner            *Owner            `json:"owner,omitempty"`
+	Dimming          *Dimming          `json:"dimming,omitempty"`
+	Dynamics         *Dynamics         `json:"dynamics,omitempty"`
+	Color            *Color            `json:"color,omitempty"`
+	ColorTemperature *ColorTemperature `json:"color_temperature,omitempty"`
+	Temperature      *Temperature      `json:"temperature,omitempty"`
+	Motion           *Motion           `json:"motion,omitempty"`
+	Light            *LightLevel       `json:"light,omitempty"`
+	PowerState       *PowerState       `json:"power_state,omitempty"`
+	// Status is set by zigbee_connectivity resources, e.g. "connected" or "connectivity_issue".
+	Status string `json:"status,omitempty"`
+}
+
+// ParseEvents parses a line from the eventstream. Lines that don't carry data, like comments and IDs, return nil.
+func ParseEvents(line string) ([]WrappedEvent, error) {
+	if !strings.HasPrefix(line, "data:") {
+		return nil, nil
+	}
+	var events []WrappedEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &events); err != nil {
+		return nil, err
+	}
+	return events, nil
 }
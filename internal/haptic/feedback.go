@@ -1,91 +1,110 @@
 package haptic
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"golang.org/x/net/http2"
 )
 
+// EventClient reads the CLIP v2 eventstream of a bridge.
 type EventClient struct {
+	// IdleTimeout is how long the stream may stay silent before we give up on it and reconnect.
+	IdleTimeout time.Duration
+	// MinBackoff and MaxBackoff bound how long Run waits between reconnects.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError is told about every time the stream dropped, and about messages that failed to decode.
+	OnError func(error)
+
 	h             *http.Client
+	lastID        string
 	subscriptions map[string]chan string
 }
 
+// NewEventClient returns an EventClient that talks to bridges over https without verifying their certificates.
 func NewEventClient() *EventClient {
+	return NewEventClientWith(&http.Client{
+		Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, // #nosec
+		Timeout:   0,
+	})
+}
+
+// NewEventClientWith returns an EventClient that uses h to talk to the bridge, h must not have a timeout.
+func NewEventClientWith(h *http.Client) *EventClient {
 	return &EventClient{
-		h: &http.Client{
-			Transport: &http2.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
-			Timeout:   0,
-		},
+		IdleTimeout:   5 * time.Minute,
+		MinBackoff:    time.Second,
+		MaxBackoff:    time.Minute,
+		h:             h,
 		subscriptions: make(map[string]chan string),
 	}
 }
 
+// Subscribe has StartContext send every message that contains event to ch, "*" matches everything.
 func (c *EventClient) Subscribe(event string, ch chan string) {
 	c.subscriptions[event] = ch
 }
 
 func (c *EventClient) Start(hueHost, hueKey string) error {
+	return c.StartContext(context.Background(), hueHost, hueKey)
+}
+
+// httpsHost turns hueHost, with or without a scheme, into the https URL the eventstream is served on.
+func httpsHost(hueHost string) string {
 	if strings.HasPrefix(hueHost, "http") {
 		hueHost = strings.Split(hueHost, "://")[1]
 		hueHost = strings.TrimSuffix(hueHost, "/")
 	}
-	req, err := http.NewRequest("GET", "https://"+hueHost+"/eventstream/clip/v2", nil)
-	if err != nil {
-		return err
-	}
-	req.Header.Add("hue-application-key", hueKey)
-	req.Header.Add("Accept", "text/event-stream")
-	resp, err := c.h.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	xerox := bufio.NewScanner(resp.Body)
-	for xerox.Scan() {
-		if xerox.Err() != nil {
-			return xerox.Err()
-		}
-		if ch, ok := c.subscriptions["*"]; ok {
-			ch <- xerox.Text()
-		}
+	return "https://" + hueHost
+}
+
+// StartContext is like Start, but stops reading from the eventstream once ctx is done.
+// Subscribers get the data of every message as the bridge sent it, in a line like it appears in the eventstream,
+// see ParseEvents.
+func (c *EventClient) StartContext(ctx context.Context, hueHost, hueKey string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	err := c.stream(ctx, httpsHost(hueHost), hueKey, func(msg message) {
+		line := "data: " + msg.data
 		for term, ch := range c.subscriptions {
-			if strings.Contains(xerox.Text(), term) {
-				ch <- xerox.Text()
+			if term != "*" && !strings.Contains(line, term) {
+				continue
+			}
+			select {
+			case ch <- line:
+			case <-ctx.Done():
+				return
 			}
 		}
+	})
+	if err == nil {
+		return ctx.Err()
 	}
-	return io.EOF
+	return err
 }
 
-func ListenToEvents(ctx context.Context, events chan string, hueHost, hueKey string) error {
+// ListenToEvents sends the events of the bridge at hueHost to events, reconnecting whenever the eventstream drops,
+// until ctx is done.
+func ListenToEvents(ctx context.Context, events chan []WrappedEvent, hueHost, hueKey string) error {
 	if hueHost == "" {
 		return fmt.Errorf("hueHost is empty")
 	}
 	if hueKey == "" {
 		return fmt.Errorf("hueKey is empty")
 	}
-	c := NewEventClient()
-	ch := make(chan string, 5)
-	c.Subscribe("*", ch)
-	var errCh chan error
-	go func() {
-		errCh <- c.Start(hueHost, hueKey)
-	}()
-	for {
+	err := NewEventClient().Run(ctx, httpsHost(hueHost), hueKey, func(we []WrappedEvent) {
 		select {
+		case events <- we:
 		case <-ctx.Done():
-			return nil
-		case err := <-errCh:
-			return err
-		case event := <-ch:
-			events <- event
 		}
+	})
+	if ctx.Err() != nil {
+		return nil
 	}
+	return err
 }
@@ -2,15 +2,21 @@ package haptic
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
 )
 
 func TestListenToEvents(t *testing.T) {
 	if os.Getenv("ZIGGS_CI_KEY") == "" {
 		t.Skip("skipping test requiring ZIGGS_CI_KEY environment variable.")
 	}
-	events := make(chan string, 5)
+	events := make(chan []WrappedEvent, 5)
 	errCh := make(chan error, 1)
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -30,3 +36,43 @@ func TestListenToEvents(t *testing.T) {
 		}
 	}
 }
+
+func TestStartContext(t *testing.T) {
+	fb := fakebridge.NewTLS()
+	t.Cleanup(fb.Close)
+	fb.EnableEventstream()
+	key := fb.NewUser("ziggs#test")
+
+	// given as plain http, like bridges are in our config, the eventstream is still read over https.
+	host := "http://" + strings.TrimPrefix(fb.Hostname(), "https://") + "/"
+	c := NewEventClientWith(&http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec
+	}})
+	lines := make(chan string, 1)
+	c.Subscribe("*", lines)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.StartContext(ctx, host, key) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fb.Streams() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("never connected to the eventstream")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	fb.Emit("update", map[string]interface{}{"id": "x", "type": "light", "effects": map[string]interface{}{"status": "candle"}})
+	select {
+	case line := <-lines:
+		// effects isn't one of the fields we decode, subscribers should see it anyway.
+		if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"effects"`) {
+			t.Errorf("expected the message as the bridge sent it, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+}
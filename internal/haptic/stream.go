@@ -0,0 +1,166 @@
+package haptic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdle is returned by Stream when the bridge stopped sending anything, not even heartbeats, for IdleTimeout.
+var ErrIdle = errors.New("eventstream went silent")
+
+// message is one server-sent event.
+type message struct {
+	id   string
+	data string
+}
+
+// readSSE reads server-sent events from r, calling beat for every line, including comments which bridges send
+// as heartbeats, and fn for every complete event that carries data.
+func readSSE(r io.Reader, beat func(), fn func(message) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var (
+		msg  message
+		data []string
+	)
+	for scanner.Scan() {
+		beat()
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			if data != nil {
+				msg.data = strings.Join(data, "\n")
+				if err := fn(msg); err != nil {
+					return err
+				}
+			}
+			msg.data, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "id":
+			msg.id = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
+
+// eventstreamURL turns what we know a bridge by into the URL of its eventstream, bridges serve it over https.
+func eventstreamURL(base string) string {
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return strings.TrimSuffix(base, "/") + "/eventstream/clip/v2"
+}
+
+// Stream reads the eventstream at base, e.g. https://192.168.1.2, until it ends or ctx is done,
+// handing the events of every message to fn. It returns nil only when ctx is done.
+func (c *EventClient) Stream(ctx context.Context, base, key string, fn func([]WrappedEvent)) error {
+	return c.stream(ctx, base, key, func(msg message) {
+		var events []WrappedEvent
+		if err := json.Unmarshal([]byte(msg.data), &events); err != nil {
+			if c.OnError != nil {
+				c.OnError(fmt.Errorf("bad event: %w", err))
+			}
+			return
+		}
+		fn(events)
+	})
+}
+
+// stream is Stream without decoding the messages.
+func (c *EventClient) stream(ctx context.Context, base, key string, fn func(message)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, eventstreamURL(base), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("hue-application-key", key)
+	req.Header.Set("Accept", "text/event-stream")
+	if c.lastID != "" {
+		req.Header.Set("Last-Event-ID", c.lastID)
+	}
+	resp, err := c.h.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("eventstream returned %s", resp.Status)
+	}
+
+	var idle atomic.Bool
+	watchdog := time.AfterFunc(c.IdleTimeout, func() {
+		idle.Store(true)
+		cancel()
+	})
+	err = readSSE(resp.Body, func() { watchdog.Reset(c.IdleTimeout) }, func(msg message) error {
+		if msg.id != "" {
+			c.lastID = msg.id
+		}
+		fn(msg)
+		return nil
+	})
+	watchdog.Stop()
+	switch {
+	case idle.Load():
+		return ErrIdle
+	case ctx.Err() != nil:
+		return nil
+	}
+	return err
+}
+
+// Run keeps the eventstream at base open until ctx is done, reconnecting whenever it drops. Reconnects are
+// spaced out from MinBackoff to MaxBackoff, doubling each time the stream fails before it delivered anything.
+func (c *EventClient) Run(ctx context.Context, base, key string, fn func([]WrappedEvent)) error {
+	backoff := c.MinBackoff
+	for {
+		delivered := false
+		err := c.Stream(ctx, base, key, func(events []WrappedEvent) {
+			delivered = true
+			fn(events)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if delivered {
+			backoff = c.MinBackoff
+		}
+		if c.OnError != nil {
+			c.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if !delivered {
+			backoff *= 2
+			if backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+		}
+	}
+}
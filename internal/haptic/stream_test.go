@@ -0,0 +1,112 @@
+package haptic
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func TestReadSSE(t *testing.T) {
+	stream := ": hi\r\n\r\n" +
+		"id: 1:0\r\ndata: [{\"type\":\"update\",\r\ndata: \"data\":[]}]\r\n\r\n" +
+		"event: ignored\n\n" +
+		"data:[]\n\n" +
+		"data: unterminated"
+	var (
+		beats int
+		msgs  []message
+	)
+	err := readSSE(strings.NewReader(stream), func() { beats++ }, func(msg message) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %+v", msgs)
+	}
+	if msgs[0].id != "1:0" || msgs[0].data != "[{\"type\":\"update\",\n\"data\":[]}]" {
+		t.Errorf("unexpected first message: %+v", msgs[0])
+	}
+	if msgs[1].data != "[]" || msgs[1].id != "1:0" {
+		t.Errorf("unexpected second message: %+v", msgs[1])
+	}
+	if beats != 11 {
+		t.Errorf("expected a beat for every line, got %d", beats)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.EnableEventstream()
+	key := fb.NewUser("ziggs#test")
+
+	c := NewEventClientWith(fb.Client())
+	c.MinBackoff, c.MaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+	errs := make(chan error, 10)
+	c.OnError = func(err error) { errs <- err }
+	got := make(chan []WrappedEvent, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx, fb.Hostname(), key, func(we []WrappedEvent) { got <- we }) }()
+
+	emit := func(on bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for fb.Streams() == 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("never connected to the eventstream")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		fb.Emit("update", map[string]interface{}{"id": "x", "id_v1": "/lights/1", "type": "light", "on": map[string]interface{}{"on": on}})
+		select {
+		case we := <-got:
+			if len(we) != 1 || we[0].Type != "update" || len(we[0].Data) != 1 || we[0].Data[0].On.On != on {
+				t.Fatalf("unexpected events: %+v", we)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+	}
+	emit(true)
+	// the bridge drops off and comes back, Run should reconnect on its own.
+	fb.SetOffline(true)
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dropped stream wasn't reported")
+	}
+	fb.SetOffline(false)
+	emit(false)
+	if c.lastID == "" {
+		t.Error("last event ID wasn't kept for reconnecting")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to stop with ctx, got %v", err)
+	}
+}
+
+func TestStreamIdle(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.EnableEventstream()
+	c := NewEventClientWith(fb.Client())
+	c.IdleTimeout = 50 * time.Millisecond
+	err := c.Stream(context.Background(), fb.Hostname(), fb.NewUser("ziggs#test"), func([]WrappedEvent) {})
+	if !errors.Is(err, ErrIdle) {
+		t.Errorf("expected ErrIdle, got %v", err)
+	}
+	if err = c.Stream(context.Background(), fb.Hostname(), "nobody", func([]WrappedEvent) {}); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
@@ -0,0 +1,413 @@
+package ziggy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+var (
+	// MDNSAddr is where mDNS queries for _hue._tcp are sent.
+	MDNSAddr = "224.0.0.251:5353"
+	// SSDPAddr is where SSDP M-SEARCH requests are sent.
+	SSDPAddr = "239.255.255.250:1900"
+	// DiscoveryTimeout is how long Discover waits for bridges to answer.
+	DiscoveryTimeout = 3 * time.Second
+)
+
+const hueService = "_hue._tcp.local."
+
+// DiscoveredBridge is a bridge that answered on the LAN.
+type DiscoveredBridge struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	// Host is where the v1 API of the bridge is reached, an IP address with a port only if it isn't 80.
+	Host string `json:"host"`
+	// Via lists how the bridge was found: mdns, ssdp or scan.
+	Via []string `json:"via"`
+}
+
+type discoveryHit struct {
+	host string
+	via  string
+}
+
+// finder reports hosts that might be bridges until it runs out of them or ctx is done.
+type finder func(ctx context.Context, hits chan<- discoveryHit) error
+
+// Discover finds every hue bridge on the LAN with mDNS and SSDP at the same time, then asks each of them
+// for its config to make sure it is a bridge. Bridges found both ways are only reported once.
+func Discover(ctx context.Context) ([]DiscoveredBridge, error) {
+	return Scan(ctx, ScanOptions{})
+}
+
+// collect runs every finder at once and probes each host they come up with, merging hosts that turn out
+// to be the same bridge.
+func collect(ctx context.Context, timeout time.Duration, finders ...finder) ([]DiscoveredBridge, error) {
+	hits := make(chan discoveryHit, 16)
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(finders))
+	)
+	for i, find := range finders {
+		wg.Add(1)
+		go func(i int, find finder) {
+			defer wg.Done()
+			errs[i] = find(ctx, hits)
+		}(i, find)
+	}
+	go func() {
+		wg.Wait()
+		close(hits)
+	}()
+
+	var (
+		mu     = &sync.Mutex{}
+		probes sync.WaitGroup
+		seen   = make(map[string][]string)
+		found  = make(map[string]*DiscoveredBridge)
+		hosts  = make(map[string][]string)
+	)
+	for hit := range hits {
+		mu.Lock()
+		vias, probing := seen[hit.host]
+		seen[hit.host] = append(vias, hit.via)
+		mu.Unlock()
+		if probing {
+			continue
+		}
+		probes.Add(1)
+		go func(host string) {
+			defer probes.Done()
+			br, err := probeBridge(host, timeout)
+			if err != nil {
+				log.Debug().Err(err).Msgf("%s does not appear to be a hue bridge", host)
+				return
+			}
+			mu.Lock()
+			if _, ok := found[br.ID]; !ok {
+				found[br.ID] = br
+			}
+			hosts[br.ID] = append(hosts[br.ID], host)
+			mu.Unlock()
+		}(hit.host)
+	}
+	probes.Wait()
+
+	ret := make([]DiscoveredBridge, 0, len(found))
+	for id, br := range found {
+		via := make(map[string]bool)
+		for _, host := range hosts[id] {
+			for _, v := range seen[host] {
+				via[v] = true
+			}
+		}
+		for v := range via {
+			br.Via = append(br.Via, v)
+		}
+		sort.Strings(br.Via)
+		ret = append(ret, *br)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	if len(ret) == 0 {
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// probeBridge asks host for the public part of its config, which every bridge hands out without a username.
+func probeBridge(host string, timeout time.Duration) (*DiscoveredBridge, error) {
+	c := &http.Client{Timeout: timeout}
+	resp, err := c.Get(hostURL(host) + "/api/config")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", host, resp.StatusCode)
+	}
+	var conf struct {
+		Name     string `json:"name"`
+		BridgeID string `json:"bridgeid"`
+		ModelID  string `json:"modelid"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return nil, err
+	}
+	if conf.BridgeID == "" {
+		return nil, errors.New("no bridge ID in config")
+	}
+	return &DiscoveredBridge{
+		ID:    strings.ToUpper(conf.BridgeID),
+		Name:  conf.Name,
+		Model: conf.ModelID,
+		Host:  host,
+	}, nil
+}
+
+// hostPort leaves out port 80, which is where bridges serve the v1 API, unless ip is an IPv6 address.
+func hostPort(ip string, port int) string {
+	if port == 0 {
+		port = 80
+	}
+	if port == 80 && !strings.Contains(ip, ":") {
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// hostURL turns a host as returned by hostPort into a URL, escaping the zone of link-local IPv6 addresses.
+func hostURL(host string) string {
+	return "http://" + strings.ReplaceAll(host, "%", "%25")
+}
+
+// URL is where the bridge can be reached, suitable for config.KnownBridge.Hostname.
+func (d DiscoveredBridge) URL() string {
+	return hostURL(d.Host)
+}
+
+// Configured reports whether the bridge is already connected or in our config.
+func (d DiscoveredBridge) Configured() bool {
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, c := range Lucifer.Bridges {
+		if c.Info != nil && strings.EqualFold(c.Info.BridgeID, d.ID) {
+			return true
+		}
+	}
+	for _, kb := range config.KnownBridges {
+		if config.SameHostname(kb.Hostname, d.Host) {
+			return true
+		}
+		// the bridge may have moved since we saved it.
+		if id := knownID(kb); id != "" && strings.EqualFold(id, d.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// exchange sends query to addr and hands every answer to handle until ctx is done.
+func exchange(ctx context.Context, addr string, query []byte, handle func(pkt []byte, from *net.UDPAddr)) error {
+	dst, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	network := "udp4"
+	if dst.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err = conn.WriteTo(query, dst); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+	buf := make([]byte, 9000)
+	for {
+		n, from, rerr := conn.ReadFromUDP(buf)
+		if rerr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return rerr
+		}
+		handle(buf[:n], from)
+	}
+}
+
+func mdnsQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	// the top bit of the class asks for a unicast response, so we don't need to join the multicast group.
+	if err := b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(hueService),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET | 1<<15,
+	}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseMDNS returns the hosts of the hue bridges announced in an mDNS response.
+func parseMDNS(pkt []byte, from *net.UDPAddr) ([]string, error) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(pkt); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+	var (
+		instances []string
+		srv       = make(map[string]dnsmessage.SRVResource)
+		addrs     = make(map[string]net.IP)
+	)
+	additionals := false
+	for {
+		var (
+			h   dnsmessage.ResourceHeader
+			err error
+		)
+		if additionals {
+			h, err = p.AdditionalHeader()
+		} else {
+			h, err = p.AnswerHeader()
+		}
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			if additionals {
+				break
+			}
+			if err = p.SkipAllAuthorities(); err != nil {
+				return nil, err
+			}
+			additionals = true
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(h.Name.String())
+		switch h.Type {
+		case dnsmessage.TypePTR:
+			r, perr := p.PTRResource()
+			if perr != nil {
+				return nil, perr
+			}
+			if name == hueService {
+				instances = append(instances, strings.ToLower(r.PTR.String()))
+			}
+		case dnsmessage.TypeSRV:
+			r, perr := p.SRVResource()
+			if perr != nil {
+				return nil, perr
+			}
+			srv[name] = r
+		case dnsmessage.TypeA:
+			r, perr := p.AResource()
+			if perr != nil {
+				return nil, perr
+			}
+			addrs[name] = r.A[:]
+		case dnsmessage.TypeAAAA:
+			r, perr := p.AAAAResource()
+			if perr != nil {
+				return nil, perr
+			}
+			if _, ok := addrs[name]; !ok {
+				addrs[name] = r.AAAA[:]
+			}
+		default:
+			if additionals {
+				err = p.SkipAdditional()
+			} else {
+				err = p.SkipAnswer()
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	var hosts []string
+	for _, inst := range instances {
+		ip, port := from.IP.String(), 0
+		if from.Zone != "" {
+			ip += "%" + from.Zone
+		}
+		if r, ok := srv[inst]; ok {
+			// bridges announce their https port, the v1 API lives on 80.
+			if r.Port != 443 {
+				port = int(r.Port)
+			}
+			if a, ok := addrs[strings.ToLower(r.Target.String())]; ok && !a.IsLinkLocalUnicast() {
+				ip = a.String()
+			}
+		}
+		hosts = append(hosts, hostPort(ip, port))
+	}
+	return hosts, nil
+}
+
+// mdnsFinder asks for _hue._tcp over mDNS at addr.
+func mdnsFinder(addr string) finder {
+	return func(ctx context.Context, hits chan<- discoveryHit) error {
+		query, err := mdnsQuery()
+		if err != nil {
+			return err
+		}
+		return exchange(ctx, addr, query, func(pkt []byte, from *net.UDPAddr) {
+			hosts, perr := parseMDNS(pkt, from)
+			if perr != nil {
+				log.Debug().Err(perr).Msgf("bad mDNS response from %s", from)
+				return
+			}
+			for _, host := range hosts {
+				hits <- discoveryHit{host: host, via: "mdns"}
+			}
+		})
+	}
+}
+
+func ssdpSearch(addr string) []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + addr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: ssdp:all\r\n\r\n")
+}
+
+// parseSSDP returns the host of the bridge that sent an SSDP response, bridges set a hue-bridgeid header.
+func parseSSDP(pkt []byte) (string, bool) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(pkt)), nil)
+	if err != nil {
+		return "", false
+	}
+	_ = resp.Body.Close()
+	if resp.Header.Get("hue-bridgeid") == "" && !strings.Contains(resp.Header.Get("Server"), "IpBridge") {
+		return "", false
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Hostname() == "" {
+		return "", false
+	}
+	port, _ := strconv.Atoi(loc.Port())
+	return hostPort(loc.Hostname(), port), true
+}
+
+// ssdpFinder sends an M-SEARCH to addr.
+func ssdpFinder(addr string) finder {
+	return func(ctx context.Context, hits chan<- discoveryHit) error {
+		return exchange(ctx, addr, ssdpSearch(addr), func(pkt []byte, from *net.UDPAddr) {
+			if host, ok := parseSSDP(pkt); ok {
+				hits <- discoveryHit{host: host, via: "ssdp"}
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package ziggy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+// responder answers every packet it gets on a local UDP port with the packets reply returns.
+func responder(t *testing.T, reply func(query []byte) [][]byte) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, rerr := conn.ReadFromUDP(buf)
+			if rerr != nil {
+				return
+			}
+			for _, resp := range reply(buf[:n]) {
+				_, _ = conn.WriteToUDP(resp, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func mdnsAnswer(t *testing.T, instance string, port uint16) []byte {
+	t.Helper()
+	name := dnsmessage.MustNewName(instance + "." + hueService)
+	target := dnsmessage.MustNewName("001788fffe000000.local.")
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(b.StartAnswers())
+	must(b.PTRResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(hueService), Class: dnsmessage.ClassINET},
+		dnsmessage.PTRResource{PTR: name}))
+	must(b.StartAdditionals())
+	must(b.TXTResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET},
+		dnsmessage.TXTResource{TXT: []string{"bridgeid=001788fffe000000", "modelid=BSB002"}}))
+	must(b.SRVResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET},
+		dnsmessage.SRVResource{Port: port, Target: target}))
+	must(b.AResource(dnsmessage.ResourceHeader{Name: target, Class: dnsmessage.ClassINET},
+		dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}))
+	raw, err := b.Finish()
+	must(err)
+	return raw
+}
+
+func TestDiscover(t *testing.T) {
+	both, ssdpOnly := fakebridge.New(), fakebridge.New()
+	t.Cleanup(both.Close)
+	t.Cleanup(ssdpOnly.Close)
+	port := func(fb *fakebridge.Bridge) int {
+		return fb.Listener.Addr().(*net.TCPAddr).Port
+	}
+
+	oldMDNS, oldSSDP, oldTimeout := MDNSAddr, SSDPAddr, DiscoveryTimeout
+	t.Cleanup(func() { MDNSAddr, SSDPAddr, DiscoveryTimeout = oldMDNS, oldSSDP, oldTimeout })
+	DiscoveryTimeout = 500 * time.Millisecond
+
+	MDNSAddr = responder(t, func(query []byte) [][]byte {
+		var p dnsmessage.Parser
+		if _, err := p.Start(query); err != nil {
+			return nil
+		}
+		q, err := p.Question()
+		if err != nil || q.Name.String() != hueService || q.Type != dnsmessage.TypePTR {
+			return nil
+		}
+		return [][]byte{mdnsAnswer(t, "Philips Hue - 000000", uint16(port(both)))}
+	})
+	// with SSDP every device answers on its own, including ones that aren't bridges.
+	SSDPAddr = responder(t, func([]byte) [][]byte {
+		var resp [][]byte
+		for _, fb := range []*fakebridge.Bridge{both, ssdpOnly} {
+			resp = append(resp, []byte("HTTP/1.1 200 OK\r\n"+
+				"CACHE-CONTROL: max-age=100\r\n"+
+				"LOCATION: http://127.0.0.1:"+strconv.Itoa(port(fb))+"/description.xml\r\n"+
+				"SERVER: Linux/3.14.0 UPnP/1.0 IpBridge/1.56.0\r\n"+
+				"hue-bridgeid: "+fb.ID+"\r\n"+
+				"ST: upnp:rootdevice\r\n\r\n"))
+		}
+		return append(resp, []byte("HTTP/1.1 200 OK\r\nLOCATION: http://127.0.0.1:1/desc.xml\r\nSERVER: Chromecast\r\n\r\n"))
+	})
+
+	found, err := Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 bridges, got %+v", found)
+	}
+	for _, br := range found {
+		switch br.ID {
+		case both.ID:
+			if len(br.Via) != 2 || br.Via[0] != "mdns" || br.Via[1] != "ssdp" {
+				t.Errorf("expected %s to be found by mdns and ssdp, got %v", br.ID, br.Via)
+			}
+			if br.Name != both.Name || br.Model != "BSB002" || br.Host != "127.0.0.1:"+strconv.Itoa(port(both)) {
+				t.Errorf("unexpected bridge: %+v", br)
+			}
+		case ssdpOnly.ID:
+			if len(br.Via) != 1 || br.Via[0] != "ssdp" {
+				t.Errorf("expected %s to be found by ssdp only, got %v", br.ID, br.Via)
+			}
+		default:
+			t.Errorf("unexpected bridge: %+v", br)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	oldMDNS, oldSSDP := MDNSAddr, SSDPAddr
+	t.Cleanup(func() { MDNSAddr, SSDPAddr = oldMDNS, oldSSDP })
+	silent := func([]byte) [][]byte { return nil }
+	MDNSAddr, SSDPAddr = responder(t, silent), responder(t, silent)
+
+	found, err := Scan(context.Background(), ScanOptions{
+		Targets: []string{"127.0.0.0/30"},
+		Port:    fb.Listener.Addr().(*net.TCPAddr).Port,
+		Timeout: 300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != fb.ID || len(found[0].Via) != 1 || found[0].Via[0] != "scan" {
+		t.Fatalf("unexpected scan result: %+v", found)
+	}
+	if found[0].Configured() {
+		t.Error("bridge shouldn't be configured")
+	}
+	oldKnown := config.KnownBridges
+	t.Cleanup(func() { config.KnownBridges = oldKnown })
+	config.KnownBridges = []config.KnownBridge{{Hostname: "http://192.0.2.1", ID: strings.ToLower(fb.ID)}}
+	if !found[0].Configured() {
+		t.Error("a bridge we know by its ID should be configured wherever it turns up")
+	}
+	if found[0].URL() != fb.Hostname() {
+		t.Errorf("expected URL %s, got %s", fb.Hostname(), found[0].URL())
+	}
+
+	for _, bad := range []string{"10.0.0.0/8", "not-an-interface"} {
+		if _, err = Scan(context.Background(), ScanOptions{Targets: []string{bad}}); err == nil {
+			t.Errorf("expected an error scanning %s", bad)
+		}
+	}
+}
+
+func TestHostPort(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		port int
+		host string
+		url  string
+	}{
+		{"192.168.1.2", 80, "192.168.1.2", "http://192.168.1.2"},
+		{"192.168.1.2", 8080, "192.168.1.2:8080", "http://192.168.1.2:8080"},
+		{"fe80::1%eth0", 0, "[fe80::1%eth0]:80", "http://[fe80::1%25eth0]:80"},
+	} {
+		host := hostPort(tc.ip, tc.port)
+		if host != tc.host || hostURL(host) != tc.url {
+			t.Errorf("%s:%d: expected %s and %s, got %s and %s", tc.ip, tc.port, tc.host, tc.url, host, hostURL(host))
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package ziggy
+
+import (
+	"errors"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/yunginnanet/huego"
+)
+
+// Hue bridges start dropping commands when they receive more than about 10 light updates or 1 group update per second.
+// Every state change we make goes through a per-bridge queue that enforces these limits,
+// merging updates to the same light or group that are still waiting to be sent.
+var (
+	// LightUpdatesPerSecond is how many light updates we send to each bridge per second.
+	LightUpdatesPerSecond = 10.0
+	// GroupUpdatesPerSecond is how many group updates we send to each bridge per second.
+	GroupUpdatesPerSecond = 1.0
+)
+
+// tokenBucket allows rate events per second, with bursts of up to burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait returns how long until a token is available.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+type dispatchKey struct {
+	group bool
+	id    int
+}
+
+type pendingUpdate struct {
+	key     dispatchKey
+	state   huego.State
+	waiters []chan error
+}
+
+// dispatcher is the queue of state changes waiting to be sent to one bridge.
+type dispatcher struct {
+	c         *Bridge
+	lights    *tokenBucket
+	groups    *tokenBucket
+	queue     []*pendingUpdate
+	pending   map[dispatchKey]*pendingUpdate
+	fades     map[dispatchKey]chan struct{}
+	running   bool
+	coalesced int
+	*sync.Mutex
+}
+
+func newDispatcher(c *Bridge) *dispatcher {
+	return &dispatcher{
+		c:       c,
+		lights:  newTokenBucket(LightUpdatesPerSecond),
+		groups:  newTokenBucket(GroupUpdatesPerSecond),
+		pending: make(map[dispatchKey]*pendingUpdate),
+		fades:   make(map[dispatchKey]chan struct{}),
+		Mutex:   &sync.Mutex{},
+	}
+}
+
+func (d *dispatcher) bucket(k dispatchKey) *tokenBucket {
+	if k.group {
+		return d.groups
+	}
+	return d.lights
+}
+
+// QueueStats describes the dispatch queue of a bridge.
+type QueueStats struct {
+	Lights int
+	Groups int
+	// Coalesced counts updates that were merged into another update instead of being sent on their own.
+	Coalesced int
+}
+
+// Queue returns the number of light and group updates waiting to be sent to the bridge.
+func (c *Bridge) Queue() QueueStats {
+	c.queue.Lock()
+	defer c.queue.Unlock()
+	stats := QueueStats{Coalesced: c.queue.coalesced}
+	for _, p := range c.queue.queue {
+		if p.key.group {
+			stats.Groups++
+		} else {
+			stats.Lights++
+		}
+	}
+	return stats
+}
+
+// dispatch queues a state change and waits until it, or an update it was merged into, has been sent.
+func (c *Bridge) dispatch(k dispatchKey, change huego.State) error {
+	d := c.queue
+	done := make(chan error, 1)
+	d.Lock()
+	if p, ok := d.pending[k]; ok && p.state.Scene == "" && change.Scene == "" {
+		mergeState(&p.state, change)
+		p.waiters = append(p.waiters, done)
+		d.coalesced++
+	} else {
+		p = &pendingUpdate{key: k, state: change, waiters: []chan error{done}}
+		d.queue = append(d.queue, p)
+		d.pending[k] = p
+	}
+	if depth := len(d.queue); depth > 0 && depth%25 == 0 {
+		log.Warn().Str("caller", c.bridgeID()).Int("depth", depth).Msg("dispatch queue is backing up")
+	}
+	if !d.running {
+		d.running = true
+		go d.run()
+	}
+	d.Unlock()
+	return <-done
+}
+
+func (d *dispatcher) run() {
+	for {
+		d.Lock()
+		if len(d.queue) == 0 {
+			d.running = false
+			d.Unlock()
+			return
+		}
+		now := time.Now()
+		next, wait := -1, time.Duration(-1)
+		for i, p := range d.queue {
+			w := d.bucket(p.key).wait(now)
+			if w == 0 {
+				next = i
+				break
+			}
+			if wait < 0 || w < wait {
+				wait = w
+			}
+		}
+		if next < 0 {
+			d.Unlock()
+			time.Sleep(wait)
+			continue
+		}
+		p := d.queue[next]
+		d.queue = append(d.queue[:next], d.queue[next+1:]...)
+		if d.pending[p.key] == p {
+			delete(d.pending, p.key)
+		}
+		d.bucket(p.key).take()
+		d.Unlock()
+
+		var err error
+		if p.key.group {
+			_, err = d.c.SetGroupState(p.key.id, p.state)
+		} else {
+			_, err = d.c.SetLightState(p.key.id, p.state)
+		}
+		if err == nil {
+			d.c.wrote(p.key)
+		}
+		for _, w := range p.waiters {
+			w <- err
+		}
+	}
+}
+
+// mergeState folds a later change into an earlier one that hasn't been sent yet.
+func mergeState(dst *huego.State, src huego.State) {
+	dst.On = src.On
+	switch {
+	case src.Xy != nil:
+		dst.Hue, dst.Sat, dst.Ct, dst.HueInc, dst.SatInc, dst.CtInc = 0, 0, 0, 0, 0, 0
+		dst.Xy, dst.XyInc = src.Xy, 0
+	case src.Ct != 0:
+		dst.Xy, dst.Hue, dst.Sat, dst.XyInc, dst.HueInc, dst.SatInc = nil, 0, 0, 0, 0, 0
+		dst.Ct, dst.CtInc = src.Ct, 0
+	case src.Hue != 0 || src.Sat != 0:
+		dst.Xy, dst.Ct, dst.XyInc, dst.CtInc = nil, 0, 0, 0
+	}
+	if src.Hue != 0 {
+		dst.Hue, dst.HueInc = src.Hue, 0
+	}
+	if src.Sat != 0 {
+		dst.Sat, dst.SatInc = src.Sat, 0
+	}
+	if src.Bri != 0 {
+		dst.Bri, dst.BriInc = src.Bri, 0
+	}
+	dst.BriInc += src.BriInc
+	dst.SatInc += src.SatInc
+	dst.HueInc += src.HueInc
+	dst.CtInc += src.CtInc
+	dst.XyInc += src.XyInc
+	if src.Effect != "" {
+		dst.Effect = src.Effect
+	}
+	if src.Alert != "" {
+		dst.Alert = src.Alert
+	}
+	if src.TransitionTime != 0 {
+		dst.TransitionTime = src.TransitionTime
+	}
+}
+
+// recordState applies a change that was sent successfully to the state we keep for a light or group,
+// callers hold the lock of the bridge it belongs to.
+func recordState(st *huego.State, change huego.State) {
+	st.On = change.On
+	switch {
+	case change.Xy != nil:
+		st.Xy, st.ColorMode = change.Xy, "xy"
+	case change.Ct != 0:
+		st.Ct, st.ColorMode = change.Ct, "ct"
+	case change.Hue != 0 || change.Sat != 0:
+		st.ColorMode = "hs"
+	}
+	if change.Hue != 0 {
+		st.Hue = change.Hue
+	}
+	if change.Sat != 0 {
+		st.Sat = change.Sat
+	}
+	if change.Bri != 0 {
+		st.Bri = change.Bri
+	}
+	if change.Effect != "" {
+		st.Effect = change.Effect
+	}
+	if change.Alert != "" {
+		st.Alert = change.Alert
+	}
+	if change.Scene != "" {
+		st.Scene = change.Scene
+	}
+}
+
+// The methods below shadow those of huego.Light and huego.Group so that every change goes through the dispatch queue.
+
+func (hl *HueLight) update(change huego.State) error {
+	k := dispatchKey{id: hl.ID}
+	hl.controller.queue.stopFade(k)
+	if err := hl.controller.dispatch(k, change); err != nil {
+		return err
+	}
+	hl.controller.Lock()
+	if hl.State == nil {
+		hl.State = &huego.State{}
+	}
+	recordState(hl.State, change)
+	hl.controller.Unlock()
+	return nil
+}
+
+func (hl *HueLight) On() error                    { return hl.update(huego.State{On: true}) }
+func (hl *HueLight) Off() error                   { return hl.update(huego.State{On: false}) }
+func (hl *HueLight) Bri(n uint8) error            { return hl.update(huego.State{On: true, Bri: n}) }
+func (hl *HueLight) Hue(n uint16) error           { return hl.update(huego.State{On: true, Hue: n}) }
+func (hl *HueLight) Sat(n uint8) error            { return hl.update(huego.State{On: true, Sat: n}) }
+func (hl *HueLight) Xy(n []float32) error         { return hl.update(huego.State{On: true, Xy: n}) }
+func (hl *HueLight) Ct(n uint16) error            { return hl.update(huego.State{On: true, Ct: n}) }
+func (hl *HueLight) Effect(n string) error        { return hl.update(huego.State{On: true, Effect: n}) }
+func (hl *HueLight) Alert(n string) error         { return hl.update(huego.State{On: true, Alert: n}) }
+func (hl *HueLight) SetState(s huego.State) error { return hl.update(s) }
+
+func (hl *HueLight) TransitionTime(n uint16) error {
+	return hl.update(huego.State{On: hl.IsOn(), TransitionTime: n})
+}
+
+func (hl *HueLight) Col(n color.Color) error {
+	xy, bri := huego.ConvertRGBToXy(n)
+	return hl.update(huego.State{On: true, Xy: xy, Bri: bri})
+}
+
+// Scene exists so that lights and groups can be used interchangeably, only groups can recall scenes.
+func (hl *HueLight) Scene(string) error {
+	return errors.New("scenes can only be recalled on groups")
+}
+
+func (hg *HueGroup) update(change huego.State) error {
+	k := dispatchKey{group: true, id: hg.ID}
+	hg.controller.queue.stopFade(k)
+	if err := hg.controller.dispatch(k, change); err != nil {
+		return err
+	}
+	hg.controller.Lock()
+	if hg.State == nil {
+		hg.State = &huego.State{}
+	}
+	recordState(hg.State, change)
+	hg.controller.Unlock()
+	return nil
+}
+
+func (hg *HueGroup) On() error                    { return hg.update(huego.State{On: true}) }
+func (hg *HueGroup) Off() error                   { return hg.update(huego.State{On: false}) }
+func (hg *HueGroup) Bri(n uint8) error            { return hg.update(huego.State{On: true, Bri: n}) }
+func (hg *HueGroup) Hue(n uint16) error           { return hg.update(huego.State{On: true, Hue: n}) }
+func (hg *HueGroup) Sat(n uint8) error            { return hg.update(huego.State{On: true, Sat: n}) }
+func (hg *HueGroup) Xy(n []float32) error         { return hg.update(huego.State{On: true, Xy: n}) }
+func (hg *HueGroup) Ct(n uint16) error            { return hg.update(huego.State{On: true, Ct: n}) }
+func (hg *HueGroup) Effect(n string) error        { return hg.update(huego.State{On: true, Effect: n}) }
+func (hg *HueGroup) Alert(n string) error         { return hg.update(huego.State{On: true, Alert: n}) }
+func (hg *HueGroup) Scene(id string) error        { return hg.update(huego.State{On: true, Scene: id}) }
+func (hg *HueGroup) SetState(s huego.State) error { return hg.update(s) }
+
+func (hg *HueGroup) TransitionTime(n uint16) error {
+	return hg.update(huego.State{On: hg.IsOn(), TransitionTime: n})
+}
+
+func (hg *HueGroup) Col(n color.Color) error {
+	xy, bri := huego.ConvertRGBToXy(n)
+	return hg.update(huego.State{On: true, Xy: xy, Bri: bri})
+}
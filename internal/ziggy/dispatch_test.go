@@ -0,0 +1,112 @@
+package ziggy
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func countPuts(reqs []fakebridge.Request, prefix string) (n int) {
+	for _, r := range reqs {
+		if r.Method == "PUT" && strings.Contains(r.Path, prefix) {
+			n++
+		}
+	}
+	return
+}
+
+func TestDispatchRateLimit(t *testing.T) {
+	fb, c := newTestBridge(t)
+	c.queue.lights = newTokenBucket(20)
+	c.queue.lights.tokens = 1
+	lamp, err := c.FindLight("lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desk, err := c.FindLight("desk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.ResetRequests()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := lamp.Bri(uint8(10 + i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := desk.Off(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("6 light updates at 20/s should not all be sent within 200ms, took %s", elapsed)
+	}
+	if n := countPuts(fb.Requests(), "/lights/"); n != 6 {
+		t.Errorf("expected 6 light updates, got %d", n)
+	}
+	if st := fb.LightState("1"); st["bri"] != 12.0 || st["on"] != true {
+		t.Errorf("unexpected state: %v", st)
+	}
+	if !lamp.IsOn() || lamp.State.Bri != 12 || desk.IsOn() {
+		t.Errorf("local state was not updated: %+v %+v", lamp.State, desk.State)
+	}
+}
+
+func TestDispatchCoalesce(t *testing.T) {
+	fb, c := newTestBridge(t)
+	office, err := c.FindGroup("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.ResetRequests()
+
+	// the first update uses up the group token, everything queued behind it should be sent as one update.
+	if err := office.Ct(300); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for _, f := range []func() error{
+		func() error { return office.Bri(100) },
+		func() error { return office.Xy([]float32{0.3, 0.3}) },
+		func() error { return office.Hue(1000) },
+	} {
+		wg.Add(1)
+		go func(f func() error) {
+			defer wg.Done()
+			errs <- f()
+		}(f)
+	}
+	waitFor(t, func() bool { return c.Queue().Groups == 1 && c.Queue().Coalesced == 2 })
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countPuts(fb.Requests(), "/groups/"); n != 2 {
+		t.Errorf("expected 2 group updates, got %d", n)
+	}
+	if q := c.Queue(); q.Groups != 0 || q.Lights != 0 {
+		t.Errorf("queue should be empty: %+v", q)
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	st := huego.State{On: true, Ct: 300, Bri: 10, BriInc: 5}
+	mergeState(&st, huego.State{On: true, Xy: []float32{0.1, 0.2}})
+	mergeState(&st, huego.State{On: true, BriInc: 5, Sat: 20})
+	if st.Ct != 0 || st.Xy != nil || st.Sat != 20 || st.Bri != 10 || st.BriInc != 10 {
+		t.Errorf("unexpected merge result: %+v", st)
+	}
+	mergeState(&st, huego.State{On: false})
+	if st.On {
+		t.Error("later updates should decide whether the light is on")
+	}
+}
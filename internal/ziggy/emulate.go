@@ -0,0 +1,262 @@
+package ziggy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/haptic"
+)
+
+// diffStates turns the difference between two full states of a bridge into the events its eventstream would
+// have sent, so bridges we poll look the same as ones with an eventstream to everyone listening to Events.
+func diffStates(old, cur *bridgeState, now time.Time) []haptic.WrappedEvent {
+	var added, updated, deleted []haptic.Event
+	for _, id := range sortedKeys(cur.Lights, old.Lights) {
+		idV1 := "/lights/" + id
+		was, is := old.Lights[id], cur.Lights[id]
+		switch {
+		case was == nil:
+			added = append(added, haptic.Event{IdV1: idV1, Type: "light", Metadata: &haptic.Metadata{Name: is.Name}})
+		case is == nil:
+			deleted = append(deleted, haptic.Event{IdV1: idV1, Type: "light"})
+		default:
+			updated = append(updated, diffLight(idV1, was, is)...)
+		}
+	}
+	for _, id := range sortedKeys(cur.Groups, old.Groups) {
+		idV1 := "/groups/" + id
+		was, is := old.Groups[id], cur.Groups[id]
+		switch {
+		case was == nil:
+			added = append(added, haptic.Event{IdV1: idV1, Type: groupType(is), Metadata: &haptic.Metadata{Name: is.Name}})
+		case is == nil:
+			deleted = append(deleted, haptic.Event{IdV1: idV1, Type: groupType(was)})
+		default:
+			updated = append(updated, diffGroup(idV1, was, is)...)
+		}
+	}
+	for _, id := range sortedKeys(cur.Sensors, old.Sensors) {
+		idV1 := "/sensors/" + id
+		was, is := old.Sensors[id], cur.Sensors[id]
+		switch {
+		case was == nil:
+			if t := sensorType(is); t != "" {
+				added = append(added, haptic.Event{IdV1: idV1, Type: t, Metadata: &haptic.Metadata{Name: is.Name}})
+			}
+		case is == nil:
+			if t := sensorType(was); t != "" {
+				deleted = append(deleted, haptic.Event{IdV1: idV1, Type: t})
+			}
+		default:
+			updated = append(updated, diffSensor(idV1, was, is)...)
+		}
+	}
+
+	var ret []haptic.WrappedEvent
+	for _, we := range []struct {
+		kind   string
+		events []haptic.Event
+	}{{"add", added}, {"update", updated}, {"delete", deleted}} {
+		if len(we.events) == 0 {
+			continue
+		}
+		ret = append(ret, haptic.WrappedEvent{
+			Timestamp: now,
+			Id:        fmt.Sprintf("poll-%d-%s", now.UnixNano(), we.kind),
+			Type:      we.kind,
+			Data:      we.events,
+		})
+	}
+	return ret
+}
+
+// withV2IDs fills in the v2 IDs of emulated events, as far as the v2 API of c knows them.
+func (c *Bridge) withV2IDs(events []haptic.WrappedEvent) []haptic.WrappedEvent {
+	if len(events) == 0 {
+		return events
+	}
+	res, err := c.V2Resources()
+	if err != nil {
+		return events
+	}
+	for _, we := range events {
+		for i, ev := range we.Data {
+			we.Data[i].Id, _ = res.V2(ev.IdV1, ev.Type)
+		}
+	}
+	return events
+}
+
+func sortedKeys[T any](maps ...map[string]T) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// lightChanges returns a light event with only the attributes that differ between was and is, or false if none do.
+func lightChanges(ev haptic.Event, was, is *huego.State) (haptic.Event, bool) {
+	changed := false
+	if was.On != is.On {
+		ev.On, changed = &haptic.On{On: is.On}, true
+	}
+	if was.Bri != is.Bri && is.Bri > 0 {
+		ev.Dimming, changed = &haptic.Dimming{Brightness: math.Round(float64(is.Bri)*10000/254) / 100}, true
+	}
+	switch is.ColorMode {
+	case "ct":
+		if was.Ct != is.Ct || was.ColorMode != is.ColorMode {
+			ev.ColorTemperature, changed = &haptic.ColorTemperature{Mirek: float64(is.Ct), MirekValid: true}, true
+		}
+	case "xy", "hs":
+		if len(is.Xy) == 2 && (len(was.Xy) != 2 || was.Xy[0] != is.Xy[0] || was.Xy[1] != is.Xy[1] || was.ColorMode != is.ColorMode) {
+			ev.Color = &haptic.Color{}
+			ev.Color.Xy.X, ev.Color.Xy.Y = float64(is.Xy[0]), float64(is.Xy[1])
+			changed = true
+		}
+	}
+	return ev, changed
+}
+
+func connectivity(reachable bool) string {
+	if reachable {
+		return "connected"
+	}
+	return "connectivity_issue"
+}
+
+func diffLight(idV1 string, was, is *huego.Light) (events []haptic.Event) {
+	ev := haptic.Event{IdV1: idV1, Type: "light"}
+	changed := false
+	if was.State != nil && is.State != nil {
+		ev, changed = lightChanges(ev, was.State, is.State)
+		if was.State.Reachable != is.State.Reachable {
+			events = append(events, haptic.Event{IdV1: idV1, Type: "zigbee_connectivity", Status: connectivity(is.State.Reachable)})
+		}
+	}
+	if was.Name != is.Name {
+		ev.Metadata, changed = &haptic.Metadata{Name: is.Name}, true
+	}
+	if changed {
+		events = append([]haptic.Event{ev}, events...)
+	}
+	return events
+}
+
+func groupType(g *huego.Group) string {
+	if g.Type == "Room" {
+		return "room"
+	}
+	return "zone"
+}
+
+func diffGroup(idV1 string, was, is *huego.Group) (events []haptic.Event) {
+	if was.Name != is.Name {
+		events = append(events, haptic.Event{IdV1: idV1, Type: groupType(is), Metadata: &haptic.Metadata{Name: is.Name}})
+	}
+	ev := haptic.Event{IdV1: idV1, Type: "grouped_light"}
+	changed := false
+	// the eventstream reports whether any light in the group is on, not what the group was last told to do.
+	if was.GroupState != nil && is.GroupState != nil && was.GroupState.AnyOn != is.GroupState.AnyOn {
+		ev.On, changed = &haptic.On{On: is.GroupState.AnyOn}, true
+	}
+	if was.State != nil && is.State != nil && was.State.Bri != is.State.Bri && is.State.Bri > 0 {
+		ev.Dimming, changed = &haptic.Dimming{Brightness: math.Round(float64(is.State.Bri)*10000/254) / 100}, true
+	}
+	if changed {
+		events = append(events, ev)
+	}
+	return events
+}
+
+// sensorType is the v2 resource type that carries the state of a v1 sensor, if it has one.
+func sensorType(s *huego.Sensor) string {
+	switch s.Type {
+	case "ZLLPresence", "ZHAPresence":
+		return "motion"
+	case "ZLLTemperature", "ZHATemperature":
+		return "temperature"
+	case "ZLLLightLevel", "ZHALightLevel":
+		return "light_level"
+	case "ZLLSwitch", "ZGPSwitch", "ZHASwitch", "ZLLRelativeRotary":
+		return "button"
+	}
+	return ""
+}
+
+// buttonEvents are the v2 names of the last digit of v1 button events.
+var buttonEvents = []string{"initial_press", "repeat", "short_release", "long_release"}
+
+func number(v interface{}) (float64, bool) {
+	f, ok := v.(float64)
+	return f, ok
+}
+
+func diffSensor(idV1 string, was, is *huego.Sensor) (events []haptic.Event) {
+	changed := func(key string) bool {
+		return fmt.Sprint(was.State[key]) != fmt.Sprint(is.State[key])
+	}
+	switch sensorType(is) {
+	case "motion":
+		if presence, ok := is.State["presence"].(bool); ok && changed("presence") {
+			events = append(events, haptic.Event{IdV1: idV1, Type: "motion", Motion: &haptic.Motion{Motion: presence, MotionValid: true}})
+		}
+	case "temperature":
+		if temp, ok := number(is.State["temperature"]); ok && changed("temperature") {
+			events = append(events, haptic.Event{IdV1: idV1, Type: "temperature",
+				Temperature: &haptic.Temperature{Temperature: temp / 100, TemperatureValid: true}})
+		}
+	case "light_level":
+		if level, ok := number(is.State["lightlevel"]); ok && changed("lightlevel") {
+			events = append(events, haptic.Event{IdV1: idV1, Type: "light_level",
+				Light: &haptic.LightLevel{LightLevel: int(level), LightLevelValid: true}})
+		}
+	case "button":
+		// the same button can be pressed twice in a row, lastupdated tells those apart.
+		code, ok := number(is.State["buttonevent"])
+		if ok && (changed("buttonevent") || changed("lastupdated")) {
+			ev := haptic.Event{IdV1: idV1, Type: "button", Metadata: &haptic.Metadata{ControlID: int(code) / 1000}}
+			if i := int(code) % 1000; i < len(buttonEvents) {
+				ev.Button = &haptic.Button{LastEvent: buttonEvents[i]}
+				ev.Button.ButtonReport.Event = buttonEvents[i]
+				ev.Button.ButtonReport.Updated, _ = time.Parse("2006-01-02T15:04:05", fmt.Sprint(is.State["lastupdated"]))
+				events = append(events, ev)
+			}
+		}
+	}
+	if battery, ok := number(is.Config["battery"]); ok && fmt.Sprint(was.Config["battery"]) != fmt.Sprint(is.Config["battery"]) {
+		state := "normal"
+		switch {
+		case battery < 10:
+			state = "critical"
+		case battery < 25:
+			state = "low"
+		}
+		events = append(events, haptic.Event{IdV1: idV1, Type: "device_power",
+			PowerState: &haptic.PowerState{BatteryLevel: int(battery), BatteryState: state}})
+	}
+	if reachable, ok := is.Config["reachable"].(bool); ok && fmt.Sprint(was.Config["reachable"]) != fmt.Sprint(reachable) {
+		events = append(events, haptic.Event{IdV1: idV1, Type: "zigbee_connectivity", Status: connectivity(reachable)})
+	}
+	if was.Name != is.Name && len(events) == 0 {
+		events = append(events, haptic.Event{IdV1: idV1, Type: sensorType(is), Metadata: &haptic.Metadata{Name: is.Name}})
+	}
+	return events
+}
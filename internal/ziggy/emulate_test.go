@@ -0,0 +1,117 @@
+package ziggy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// collectEvents returns the events published within d, as type and name.
+func collectEvents(events <-chan BridgeEvent, d time.Duration) map[string]BridgeEvent {
+	got := make(map[string]BridgeEvent)
+	timeout := time.After(d)
+	for {
+		select {
+		case ev := <-events:
+			got[ev.Type+" "+ev.Name] = ev
+		case <-timeout:
+			return got
+		}
+	}
+}
+
+func TestEmulatedEvents(t *testing.T) {
+	fb, c := newTestBridge(t)
+	button := fb.AddSensor("dimmer", "ZLLSwitch", map[string]interface{}{"buttonevent": 1002.0})
+	temp := fb.AddSensor("hallway temperature", "ZLLTemperature", map[string]interface{}{"temperature": 2000.0})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	events, stop := Events.Subscribe(20)
+	defer stop()
+
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 127.0, "xy": []interface{}{0.3, 0.4}})
+	fb.SetReachable("lights", "2", false)
+	fb.SetSensorState("1", map[string]interface{}{"presence": true})
+	fb.SetSensorState(button, map[string]interface{}{"buttonevent": 4003.0})
+	fb.SetSensorState(temp, map[string]interface{}{"temperature": 2150.0})
+	fb.SetSensorConfig(temp, map[string]interface{}{"battery": 20.0})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	got := collectEvents(events, 200*time.Millisecond)
+
+	lamp, ok := got["light lamp"]
+	if !ok || lamp.Kind != "update" || lamp.Bridge != fb.ID || lamp.On == nil || !lamp.On.On ||
+		lamp.Dimming == nil || lamp.Dimming.Brightness != 50 ||
+		lamp.Color == nil || lamp.Color.Xy.X < 0.29 || lamp.Color.Xy.X > 0.31 {
+		t.Errorf("unexpected light event: %+v", lamp)
+	}
+	if ev, ok := got["grouped_light office"]; !ok || ev.On == nil || !ev.On.On {
+		t.Errorf("expected the office to turn on: %+v", got)
+	}
+	if ev, ok := got["zigbee_connectivity desk"]; !ok || ev.Status != "connectivity_issue" {
+		t.Errorf("expected the desk to become unreachable: %+v", got)
+	}
+	if ev, ok := got["motion hallway motion"]; !ok || ev.Motion == nil || !ev.Motion.Motion {
+		t.Errorf("expected motion: %+v", got)
+	}
+	if ev, ok := got["button dimmer"]; !ok || ev.Button == nil || ev.Button.LastEvent != "long_release" || ev.Metadata.ControlID != 4 {
+		t.Errorf("expected a long release of button 4: %+v", ev)
+	}
+	if ev, ok := got["temperature hallway temperature"]; !ok || ev.Temperature == nil || ev.Temperature.Temperature != 21.5 {
+		t.Errorf("expected 21.5 degrees: %+v", got)
+	}
+	if ev, ok := got["device_power hallway temperature"]; !ok || ev.PowerState == nil || ev.PowerState.BatteryLevel != 20 || ev.PowerState.BatteryState != "low" {
+		t.Errorf("expected a low battery: %+v", got)
+	}
+	if len(got) != 7 {
+		t.Errorf("expected 7 events, got %d: %+v", len(got), got)
+	}
+
+	// nothing changed, nothing to tell.
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	if got = collectEvents(events, 50*time.Millisecond); len(got) != 0 {
+		t.Errorf("unexpected events: %+v", got)
+	}
+}
+
+func TestPollOnly(t *testing.T) {
+	fb, c := newTestBridge(t)
+	fb.EnableEventstream()
+	c.Lock()
+	c.config.Poll = true
+	c.Unlock()
+	oldInterval := PollInterval
+	PollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { PollInterval = oldInterval })
+
+	events, stop := Events.Subscribe(10)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Registry.Watch(ctx, c)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor(t, func() bool { return !Registry.Updated(c).IsZero() })
+	fb.SetLightState("2", map[string]interface{}{"on": true})
+	select {
+	case ev := <-events:
+		if ev.Type != "light" || ev.Name != "desk" || ev.On == nil || !ev.On.On {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	if fb.Streams() != 0 {
+		t.Error("bridges that are polled shouldn't open the eventstream")
+	}
+}
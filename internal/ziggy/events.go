@@ -0,0 +1,215 @@
+package ziggy
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/haptic"
+)
+
+// BridgeEvent is a change to a single resource on one of our bridges, as reported by its eventstream.
+type BridgeEvent struct {
+	// Bridge is the ID of the bridge the event came from.
+	Bridge string `json:"bridge"`
+	Alias  string `json:"alias,omitempty"`
+	// Kind is what happened to the resource: add, update, delete or error.
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	// Name is the name of the resource, or of whatever owns it, if we know it.
+	Name string `json:"name,omitempty"`
+	// Source is "ziggs" if the change followed one ziggs made within AttributionWindow, otherwise "bridge",
+	// e.g. a switch, a sensor, the Hue app or a rule on the bridge.
+	Source string `json:"source,omitempty"`
+	// Replayed is set on events replayed from a recording, see ReplayFrom. They didn't just happen,
+	// so they are kept out of the history and don't fire automations.
+	Replayed bool `json:"replayed,omitempty"`
+	haptic.Event
+}
+
+// AttributionWindow is how long after ziggs changed a light or group the changes the bridge reports are put down to ziggs.
+var AttributionWindow = 5 * time.Second
+
+// writes remembers when ziggs last changed each light, keyed by bridge ID and v1 path.
+var writes = struct {
+	at map[string]time.Time
+	*sync.Mutex
+}{at: make(map[string]time.Time), Mutex: &sync.Mutex{}}
+
+// wrote remembers that ziggs just changed the light or group k, and for groups, their lights.
+func (c *Bridge) wrote(k dispatchKey) {
+	id := c.bridgeID()
+	paths := []string{"/lights/" + strconv.Itoa(k.id)}
+	if k.group {
+		paths = []string{"/groups/" + strconv.Itoa(k.id)}
+		for _, l := range Registry.groupLights(c, k.id) {
+			paths = append(paths, "/lights/"+l)
+		}
+	}
+	now := time.Now()
+	writes.Lock()
+	for _, p := range paths {
+		writes.at[id+p] = now
+	}
+	writes.Unlock()
+}
+
+// source tells whether the change to idV1 on c came from ziggs or from something else.
+func (c *Bridge) source(idV1 string) string {
+	writes.Lock()
+	defer writes.Unlock()
+	if at, ok := writes.at[c.bridgeID()+idV1]; ok && time.Since(at) < AttributionWindow {
+		return "ziggs"
+	}
+	return "bridge"
+}
+
+// EventBatch is one message from the eventstream of a bridge, both as the bridge sent it and as BridgeEvents.
+type EventBatch struct {
+	Bridge string              `json:"bridge"`
+	Raw    haptic.WrappedEvent `json:"raw"`
+	Events []BridgeEvent       `json:"events"`
+}
+
+// EventHub merges the eventstreams of all our bridges for whoever wants to see them.
+type EventHub struct {
+	subs    map[chan BridgeEvent]struct{}
+	batches map[chan EventBatch]struct{}
+	*sync.RWMutex
+}
+
+// Events gets every event of every bridge the Registry is watching.
+var Events = &EventHub{
+	subs:    make(map[chan BridgeEvent]struct{}),
+	batches: make(map[chan EventBatch]struct{}),
+	RWMutex: &sync.RWMutex{},
+}
+
+// Subscribe returns a channel that gets every event from now on, and a function that stops them.
+// Subscribers that fall more than buf events behind miss events rather than hold up everyone else.
+func (h *EventHub) Subscribe(buf int) (<-chan BridgeEvent, func()) {
+	ch := make(chan BridgeEvent, buf)
+	h.Lock()
+	h.subs[ch] = struct{}{}
+	h.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.Lock()
+			delete(h.subs, ch)
+			h.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// SubscribeBatches is Subscribe for whole messages rather than single events.
+func (h *EventHub) SubscribeBatches(buf int) (<-chan EventBatch, func()) {
+	ch := make(chan EventBatch, buf)
+	h.Lock()
+	h.batches[ch] = struct{}{}
+	h.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.Lock()
+			delete(h.batches, ch)
+			h.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish hands the events of c to every subscriber.
+func (h *EventHub) publish(c *Bridge, events []haptic.WrappedEvent) {
+	h.RLock()
+	listening := len(h.subs) > 0 || len(h.batches) > 0
+	h.RUnlock()
+	if !listening {
+		return
+	}
+	id, alias := c.bridgeID(), c.Alias()
+	for _, we := range events {
+		batch := EventBatch{Bridge: id, Raw: we}
+		for _, ev := range we.Data {
+			if ev.Type == "button" && (ev.Metadata == nil || ev.Metadata.ControlID == 0) {
+				ev.Metadata = c.buttonMetadata(ev)
+			}
+			bev := BridgeEvent{Bridge: id, Alias: alias, Kind: we.Type, Time: we.Timestamp, Event: ev}
+			bev.Name = c.resourceName(ev)
+			bev.Source = c.source(ev.IdV1)
+			batch.Events = append(batch.Events, bev)
+		}
+		h.Replay(batch)
+	}
+}
+
+// Replay hands b to every subscriber as if the bridge it came from had just sent it.
+func (h *EventHub) Replay(b EventBatch) {
+	h.RLock()
+	defer h.RUnlock()
+	for ch := range h.batches {
+		select {
+		case ch <- b:
+		default:
+			log.Debug().Str("caller", b.Bridge).Msg("event subscriber is falling behind, dropping event")
+		}
+	}
+	for _, bev := range b.Events {
+		for ch := range h.subs {
+			select {
+			case ch <- bev:
+			default:
+				log.Debug().Str("caller", b.Bridge).Msg("event subscriber is falling behind, dropping event")
+			}
+		}
+	}
+}
+
+// resourceName returns the name of the resource an event is about, new names come with the event itself.
+func (c *Bridge) resourceName(ev haptic.Event) string {
+	if ev.Metadata != nil && ev.Metadata.Name != "" {
+		return ev.Metadata.Name
+	}
+	if ev.Id != "" {
+		if res, err := c.V2Resources(); err == nil {
+			if name, ok := res.Name(ev.Id); ok {
+				return name
+			}
+		}
+	}
+	// bridges without a v2 API only have the v1 names.
+	return Registry.name(c, ev.IdV1)
+}
+
+// buttonMetadata adds which button of its switch a button is to the metadata of ev, the eventstream leaves it out.
+func (c *Bridge) buttonMetadata(ev haptic.Event) *haptic.Metadata {
+	md := &haptic.Metadata{}
+	if ev.Metadata != nil {
+		*md = *ev.Metadata
+	}
+	res, err := c.V2Resources()
+	if err != nil {
+		return md
+	}
+	for _, b := range res.Buttons {
+		if b.ID == ev.Id {
+			md.ControlID = b.Metadata.ControlID
+			break
+		}
+	}
+	return md
+}
+
+// eventClient returns a client for the eventstream of c that goes through the same proxy as everything else.
+func (c *Bridge) eventClient() *haptic.EventClient {
+	c.RLock()
+	client := c.client
+	c.RUnlock()
+	if client == nil {
+		return haptic.NewEventClient()
+	}
+	// the eventstream never ends, so it can't share the timeout of c.client.
+	return haptic.NewEventClientWith(&http.Client{Transport: client.Transport})
+}
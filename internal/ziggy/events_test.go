@@ -0,0 +1,56 @@
+package ziggy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	fb, c := newTestBridge(t)
+	fb.EnableEventstream()
+	oldScheme := ClipV2Scheme
+	ClipV2Scheme = "http"
+	t.Cleanup(func() { ClipV2Scheme = oldScheme })
+	res, err := c.V2Resources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lamp, _ := res.V2("/lights/1", "light")
+
+	events, stop := Events.Subscribe(10)
+	defer stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Registry.Watch(ctx, c)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	waitFor(t, func() bool { return fb.Streams() == 1 })
+
+	fb.Emit("update", map[string]interface{}{
+		"id": lamp, "id_v1": "/lights/1", "type": "light",
+		"on": map[string]interface{}{"on": true}, "dimming": map[string]interface{}{"brightness": 100.0},
+	})
+	select {
+	case ev := <-events:
+		if ev.Bridge != fb.ID || ev.Kind != "update" || ev.Type != "light" || ev.Name != "lamp" || !ev.On.On {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	if st, ok := Registry.LightState(c, 1); !ok || !st.On || st.Bri != 254 {
+		t.Errorf("registry didn't apply the event: %+v", st)
+	}
+
+	stop()
+	stop()
+	if _, open := <-events; open {
+		t.Error("channel should be closed after unsubscribing")
+	}
+}
@@ -0,0 +1,303 @@
+package ziggy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yunginnanet/huego"
+)
+
+// Health describes how well we can talk to a bridge.
+type Health uint8
+
+const (
+	// Connected bridges answer our probes in a timely manner.
+	Connected Health = iota
+	// Degraded bridges are slow to answer, or failed a probe recently.
+	Degraded
+	// Offline bridges failed enough probes in a row that we consider them gone until they answer again.
+	Offline
+)
+
+func (h Health) String() string {
+	switch h {
+	case Connected:
+		return "connected"
+	case Degraded:
+		return "degraded"
+	case Offline:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
+
+// BridgeHealth is a snapshot of what the HealthSupervisor knows about a bridge.
+type BridgeHealth struct {
+	State Health
+	// Since is when the bridge entered its current State.
+	Since time.Time
+	// Latency is how long the last successful probe took.
+	Latency  time.Duration
+	LastSeen time.Time
+	// LastError is the error from the most recent failed probe, it is kept after the bridge recovers.
+	LastError   error
+	LastErrorAt time.Time
+	// Failures counts consecutive failed probes.
+	Failures int
+}
+
+// Health returns the last known health of the bridge. Bridges that were never probed are considered connected.
+func (c *Bridge) Health() BridgeHealth {
+	c.RLock()
+	defer c.RUnlock()
+	return c.health
+}
+
+// HealthSupervisor probes the bridges in Lucifer, tracks their health, and reconnects them when they come back.
+type HealthSupervisor struct {
+	// Interval is how often connected bridges are probed.
+	Interval time.Duration
+	// MinBackoff and MaxBackoff bound how often unhealthy bridges are probed, the delay doubles with each failure.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout is how long a probe may take before it counts as a failure.
+	Timeout time.Duration
+	// SlowLatency is how long a probe may take before the bridge is considered degraded.
+	SlowLatency time.Duration
+	// OfflineAfter is how many consecutive failures it takes for a bridge to be considered offline.
+	OfflineAfter int
+	// Relocate makes the supervisor look for bridges that went offline at a new address, see Bridge.relocate.
+	Relocate bool
+
+	ctx        context.Context
+	kicks      map[*Bridge]chan struct{}
+	stops      map[*Bridge]context.CancelFunc
+	relocating map[*Bridge]bool
+	*sync.Mutex
+}
+
+// Supervisor watches over the health of every bridge we are connected to once it has been started.
+var Supervisor = NewHealthSupervisor()
+
+// NewHealthSupervisor returns a HealthSupervisor with our default timings.
+func NewHealthSupervisor() *HealthSupervisor {
+	return &HealthSupervisor{
+		Interval:     30 * time.Second,
+		MinBackoff:   time.Second,
+		MaxBackoff:   time.Minute,
+		Timeout:      5 * time.Second,
+		SlowLatency:  time.Second,
+		OfflineAfter: 3,
+		Relocate:     true,
+		kicks:        make(map[*Bridge]chan struct{}),
+		stops:        make(map[*Bridge]context.CancelFunc),
+		relocating:   make(map[*Bridge]bool),
+		Mutex:        &sync.Mutex{},
+	}
+}
+
+// Start supervises every bridge in Lucifer until ctx is done.
+func (s *HealthSupervisor) Start(ctx context.Context) {
+	s.Lock()
+	s.ctx = ctx
+	s.Unlock()
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, c := range Lucifer.Bridges {
+		s.Supervise(c)
+	}
+}
+
+// Supervise starts probing c, if the supervisor has been started and isn't probing it already.
+func (s *HealthSupervisor) Supervise(c *Bridge) {
+	s.Lock()
+	defer s.Unlock()
+	if s.ctx == nil {
+		return
+	}
+	if _, ok := s.kicks[c]; ok {
+		return
+	}
+	kick := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.kicks[c] = kick
+	s.stops[c] = cancel
+	go s.run(ctx, c, kick)
+}
+
+// Forget stops probing c.
+func (s *HealthSupervisor) Forget(c *Bridge) {
+	s.Lock()
+	defer s.Unlock()
+	if stop, ok := s.stops[c]; ok {
+		stop()
+	}
+	delete(s.kicks, c)
+	delete(s.stops, c)
+}
+
+// ProbeNow asks the supervisor to probe c right away, e.g. because a command against it just failed.
+func (s *HealthSupervisor) ProbeNow(c *Bridge) {
+	s.Lock()
+	kick, ok := s.kicks[c]
+	s.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case kick <- struct{}{}:
+	default:
+	}
+}
+
+func (s *HealthSupervisor) run(ctx context.Context, c *Bridge, kick chan struct{}) {
+	defer func() {
+		s.Lock()
+		if s.kicks[c] == kick {
+			delete(s.kicks, c)
+			delete(s.stops, c)
+		}
+		s.Unlock()
+	}()
+	timer := time.NewTimer(s.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-kick:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		timer.Reset(s.next(s.Probe(c)))
+	}
+}
+
+// next returns how long to wait before probing a bridge with the given health again.
+func (s *HealthSupervisor) next(h BridgeHealth) time.Duration {
+	if h.Failures == 0 {
+		return s.Interval
+	}
+	backoff := s.MinBackoff
+	for i := 1; i < h.Failures && backoff < s.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.MaxBackoff {
+		backoff = s.MaxBackoff
+	}
+	return backoff
+}
+
+// Probe checks on c once and records the result, reconnecting c if it just came back online.
+func (s *HealthSupervisor) Probe(c *Bridge) BridgeHealth {
+	type result struct {
+		cfg *huego.Config
+		err error
+	}
+	done := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		cfg, err := c.GetConfig()
+		done <- result{cfg: cfg, err: err}
+	}()
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(s.Timeout):
+		res.err = fmt.Errorf("no response after %s", s.Timeout)
+	}
+	now := time.Now()
+
+	c.Lock()
+	old := c.health
+	h := old
+	switch {
+	case res.err != nil:
+		h.Failures++
+		h.LastError = res.err
+		h.LastErrorAt = now
+		h.State = Degraded
+		if h.Failures >= s.OfflineAfter {
+			h.State = Offline
+		}
+	default:
+		h.Failures = 0
+		h.Latency = now.Sub(start)
+		h.LastSeen = now
+		h.State = Connected
+		if h.Latency > s.SlowLatency {
+			h.State = Degraded
+		}
+	}
+	if h.State != old.State {
+		h.Since = now
+	}
+	c.health = h
+	c.Unlock()
+
+	// the bridge may only get its new address a while after it went offline, so keep looking for as long as it's gone.
+	if h.State == Offline && s.Relocate {
+		go s.relocate(c)
+	}
+	if h.State == old.State {
+		return h
+	}
+	l := c.Log().With().Str("caller", c.bridgeID()).Str("was", old.State.String()).Logger()
+	switch {
+	case h.State == Offline:
+		l.Warn().Err(h.LastError).Msg("bridge went offline")
+	case old.State == Offline:
+		l.Info().Dur("latency", h.Latency).Msg("bridge is back, reconnecting")
+		s.reconnect(c, res.cfg)
+	default:
+		l.Debug().Msgf("bridge is now %s", h.State)
+	}
+	return h
+}
+
+// relocate looks for c at a new address, in case it went offline because it got a new one.
+// It does nothing if c is already being looked for.
+func (s *HealthSupervisor) relocate(c *Bridge) {
+	s.Lock()
+	ctx := s.ctx
+	if s.relocating[c] {
+		s.Unlock()
+		return
+	}
+	s.relocating[c] = true
+	s.Unlock()
+	defer func() {
+		s.Lock()
+		delete(s.relocating, c)
+		s.Unlock()
+	}()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := c.relocate(ctx); err != nil {
+		c.Log().Debug().Err(err).Msg("bridge not found elsewhere")
+		return
+	}
+	s.ProbeNow(c)
+}
+
+// reconnect refreshes everything we know about c after it was offline.
+func (s *HealthSupervisor) reconnect(c *Bridge, cfg *huego.Config) {
+	if cfg != nil {
+		c.Lock()
+		c.Info = cfg
+		c.Unlock()
+	}
+	if err := Registry.Load(c); err != nil {
+		c.Log().Warn().Err(err).Msg("failed to reload state after reconnecting")
+	}
+	NeedsUpdate()
+}
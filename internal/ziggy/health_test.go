@@ -0,0 +1,104 @@
+package ziggy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHealthProbe(t *testing.T) {
+	fb, c := newTestBridge(t)
+	s := NewHealthSupervisor()
+	s.Relocate = false
+	if c.Health().State != Connected {
+		t.Fatal("bridges should start out connected")
+	}
+	h := s.Probe(c)
+	if h.State != Connected || h.LastSeen.IsZero() || h.Latency <= 0 {
+		t.Fatalf("unexpected health: %+v", h)
+	}
+
+	fb.SetOffline(true)
+	for i, want := range []Health{Degraded, Degraded, Offline, Offline} {
+		if h = s.Probe(c); h.State != want {
+			t.Fatalf("probe %d: expected %s, got %s", i+1, want, h.State)
+		}
+	}
+	if h.Failures != 4 || h.LastError == nil {
+		t.Errorf("unexpected health: %+v", h)
+	}
+	offlineSince := h.Since
+
+	// while it's gone, something changes on the bridge, reconnecting should pick it up.
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 77.0})
+	fb.SetOffline(false)
+	if h = s.Probe(c); h.State != Connected || h.Failures != 0 {
+		t.Fatalf("expected bridge to recover, got %+v", h)
+	}
+	if h.LastError == nil || !h.Since.After(offlineSince) {
+		t.Errorf("recovery should keep the last error and reset since: %+v", h)
+	}
+	if st, ok := Registry.LightState(c, 1); !ok || st.Bri != 77 {
+		t.Errorf("registry was not reloaded after reconnecting: %+v", st)
+	}
+
+	s.SlowLatency = -1
+	if h = s.Probe(c); h.State != Degraded {
+		t.Errorf("slow bridge should be degraded, got %s", h.State)
+	}
+}
+
+func TestHealthBackoff(t *testing.T) {
+	s := NewHealthSupervisor()
+	s.MinBackoff = time.Second
+	s.MaxBackoff = 5 * time.Second
+	for failures, want := range []time.Duration{s.Interval, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := s.next(BridgeHealth{Failures: failures}); got != want {
+			t.Errorf("%d failures: expected %s, got %s", failures, want, got)
+		}
+	}
+}
+
+func TestHealthSupervise(t *testing.T) {
+	fb, c := newTestBridge(t)
+	s := NewHealthSupervisor()
+	s.Relocate = false
+	s.Interval = time.Hour
+	s.MinBackoff = time.Millisecond
+	s.MaxBackoff = 5 * time.Millisecond
+	s.OfflineAfter = 2
+
+	s.Supervise(c)
+	s.ProbeNow(c)
+	if len(s.kicks) != 0 {
+		t.Fatal("supervisor should not run before it is started")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	fb.SetOffline(true)
+	s.ProbeNow(c)
+	waitFor(t, func() bool { return c.Health().State == Offline })
+	fb.SetOffline(false)
+	waitFor(t, func() bool { return c.Health().State == Connected })
+
+	cancel()
+	waitFor(t, func() bool {
+		s.Lock()
+		defer s.Unlock()
+		return len(s.kicks) == 0
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("timed out")
+}
@@ -4,17 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"git.tcp.direct/kayos/common/entropy"
 	tui "github.com/manifoldco/promptui"
 	"github.com/rs/zerolog"
 	"github.com/yunginnanet/huego"
-	"golang.org/x/net/proxy"
 
 	"git.tcp.direct/kayos/ziggs/internal/common"
 	"git.tcp.direct/kayos/ziggs/internal/config"
@@ -41,6 +38,10 @@ type Bridge struct {
 	Info      *huego.Config
 	log       *zerolog.Logger
 	HueLights []*HueLight
+	health    BridgeHealth
+	queue     *dispatcher
+	v2        *v2Cache
+	client    *http.Client
 	*huego.Bridge
 	*sync.RWMutex
 }
@@ -56,6 +57,16 @@ func (c *Bridge) Log() *zerolog.Logger {
 	return c.log
 }
 
+// bridgeID returns the ID the bridge reported in its configuration, falling back to its host.
+func (c *Bridge) bridgeID() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Info != nil && c.Info.BridgeID != "" {
+		return c.Info.BridgeID
+	}
+	return c.Host
+}
+
 type HueSensor struct {
 	*huego.Sensor
 	controller *Bridge
@@ -84,10 +95,6 @@ type HueScene struct {
 	group      *HueGroup
 }
 
-func (hl *HueLight) Scene(s string) error {
-	return hl.Scene(s)
-}
-
 func (hl *HueLight) Log() *zerolog.Logger {
 	l := log.With().
 		Int("caller", hl.ID).
@@ -100,16 +107,24 @@ func (hl *HueLight) GetPtr() (*huego.Light, error) {
 	return hl.controller.GetLight(hl.ID)
 }
 
-func getProxiedBridge(cridge *config.KnownBridge) *huego.Bridge {
-	cridge.Proxy = strings.TrimPrefix(cridge.Proxy, "socks5://")
-	newTransport := http.DefaultTransport.(*http.Transport).Clone()
-	proxyDialer, _ := proxy.SOCKS5("tcp", cridge.Proxy, nil, proxy.Direct)
-	newTransport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-		return proxyDialer.Dial(network, addr)
-	}
-	newClient := http.DefaultClient
-	newClient.Transport = newTransport
-	return huego.NewWithClient(cridge.Hostname, cridge.Username, newClient)
+// Bridge returns the bridge the light belongs to.
+func (hl *HueLight) Bridge() *Bridge {
+	return hl.controller
+}
+
+// Bridge returns the bridge the group belongs to.
+func (hg *HueGroup) Bridge() *Bridge {
+	return hg.controller
+}
+
+// Bridge returns the bridge the sensor belongs to.
+func (hs *HueSensor) Bridge() *Bridge {
+	return hs.controller
+}
+
+// Bridge returns the bridge the scene belongs to.
+func (hs *HueScene) Bridge() *Bridge {
+	return hs.controller
 }
 
 func newController(cridge *config.KnownBridge) (*Bridge, error) {
@@ -117,17 +132,24 @@ func newController(cridge *config.KnownBridge) (*Bridge, error) {
 		config:  cridge,
 		RWMutex: &sync.RWMutex{},
 	}
-	if c.config.Proxy == "" {
-		c.Bridge = huego.New(c.config.Hostname, c.config.Username)
-	} else {
-		c.Bridge = getProxiedBridge(cridge)
+	c.queue = newDispatcher(c)
+	h, pin, err := newHTTPClient(*cridge)
+	if err != nil {
+		return nil, err
 	}
+	c.client = h
+	c.Bridge = huego.NewWithClient(bridgeURL(*cridge), cridge.Username, h)
 
-	var err error
 	c.Info, err = c.GetConfig()
 	if err != nil {
 		return nil, err
 	}
+	if err = pin.learn(c.Info.BridgeID); err != nil {
+		return nil, err
+	}
+	if cridge.Fingerprint == "" {
+		cridge.Fingerprint = pin.pinned()
+	}
 
 	l := log.With().
 		//		Uint8("zigbee_channel", c.Info.ZigbeeChannel).
@@ -139,16 +161,27 @@ func newController(cridge *config.KnownBridge) (*Bridge, error) {
 
 func GetControllers(bridges []config.KnownBridge) (br []*Bridge) {
 	for _, lightConfig := range bridges {
+		lightConfig := lightConfig
 		if lightConfig.Hostname == "" {
 			continue
 		}
 		log.Debug().Str("caller", lightConfig.Hostname).Str("proxy", lightConfig.Proxy).Msg("attempting connection")
 		c, err := newController(&lightConfig)
+		if err == nil && !isBridge(c, lightConfig) {
+			err = fmt.Errorf("found bridge %s instead of %s", c.Info.BridgeID, knownID(lightConfig))
+		}
 		if err != nil {
 			log.Error().Str("caller", lightConfig.Hostname).Err(err).Msg("unsuccessful connection")
-			continue
+			if knownID(lightConfig) == "" {
+				continue
+			}
+			if c, err = connectMoved(context.Background(), &lightConfig); err != nil {
+				log.Error().Str("caller", knownID(lightConfig)).Err(err).Msg("bridge not found")
+				continue
+			}
 		}
-		c.Log().Debug().Str("caller", strings.Split(lightConfig.Hostname, "http://")[1]).Msg("connected")
+		remember(c)
+		c.Log().Debug().Str("caller", strings.TrimPrefix(lightConfig.Hostname, "http://")).Msg("connected")
 		br = append(br, c)
 	}
 	return
@@ -327,14 +360,12 @@ func promptForUser(cnt *Bridge) bool {
 	choice, _, _ := confirmPrompt.Run()
 	switch choice {
 	case 0:
-		println("press the link button on your bridge, then press enter")
-		fmt.Scanln()
-		newuser, err := cnt.CreateUser("ziggs" + strconv.Itoa(entropy.RNG(5)))
+		kb, err := pairUser(context.Background(), cnt.Host, PairOptions{})
 		if err != nil {
 			log.Error().Err(err).Msg("failed")
 			return false
 		}
-		cnt.User = newuser
+		cnt.User = kb.Username
 	case 1:
 		userEntry := tui.Prompt{
 			Label: "Username",
@@ -364,11 +395,7 @@ func promptForUser(cnt *Bridge) bool {
 		log.Error().Err(err).Msg("failed to verify that we are logged in")
 		return false
 	}
-	config.Snek.Set("bridges", map[string]interface{}{
-		"hostname": cnt.Host,
-		"username": cnt.User,
-	})
-	if err = config.Snek.WriteConfig(); err != nil {
+	if err = config.AddKnownBridge(config.KnownBridge{Hostname: cnt.Host, Username: cnt.User}); err != nil {
 		log.Warn().Msg("failed to write config")
 	} else {
 		log.Info().Msg("configuration saved!")
@@ -376,17 +403,21 @@ func promptForUser(cnt *Bridge) bool {
 	return true
 }
 
-func getBridgeInfo(c *Bridge) error {
-	log.Trace().Msg("getting bridge config...")
-	conf, err := c.GetConfig()
+// connectDiscovered connects to a bridge we just logged in to, the same way we connect to the ones from our config.
+func connectDiscovered(host, user string) (*Bridge, error) {
+	kb := config.KnownBridge{Hostname: host, Username: user}
+	if i := config.KnownBridgeIndex(host); i >= 0 {
+		kb = config.KnownBridges[i]
+	}
+	c, err := newController(&kb)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.Info = conf
-	c.config = &config.KnownBridge{
-		Hostname: conf.IPAddress,
+	remember(c)
+	if err = c.register(); err != nil {
+		return nil, err
 	}
-	return c.getLights()
+	return c, nil
 }
 
 func Setup() (known []*Bridge, err error) {
@@ -394,34 +425,120 @@ func Setup() (known []*Bridge, err error) {
 	log.Debug().Int("count", len(config.KnownBridges)).Msg("trying bridges...")
 	known = GetControllers(config.KnownBridges)
 	if len(known) < 1 {
-		err := promptForDiscovery()
+		// without a terminal nobody can answer the prompts, e.g. when ziggs runs from a script or cron.
+		if !interactive() {
+			return []*Bridge{}, fmt.Errorf("%w, run ziggs in a terminal or pair with a bridge first", errNoBridges)
+		}
+		// the bridges we find are registered as we connect to them.
+		known, err = promptForDiscovery()
 		if err != nil {
 			return []*Bridge{}, err
 		}
-		for _, cnt := range Lucifer.Bridges {
+		for _, cnt := range known {
 			cnt.RLock()
 			log.Trace().Str("caller", cnt.Info.BridgeID).Int("lights", len(cnt.HueLights)).Msg("done")
 			cnt.RUnlock()
 		}
+		return known, nil
 	}
 
 	for _, bridge := range known {
-		bridge.Log().Trace().Str("caller", bridge.ID).Str("mac", bridge.Info.Mac).Msg("getting lights..")
-		err = bridge.getLights()
-		if err != nil {
-			bridge.Log().Warn().Err(err).Msg("failed to get lights")
-			continue
+		err = bridge.register()
+	}
+	return
+}
+
+// register loads the lights of a bridge we just connected to and makes it one of ours.
+func (c *Bridge) register() error {
+	c.Log().Trace().Str("caller", c.ID).Str("mac", c.Info.Mac).Msg("getting lights..")
+	if err := c.getLights(); err != nil {
+		c.Log().Warn().Err(err).Msg("failed to get lights")
+		return err
+	}
+	caps, err := c.GetCapabilities()
+	if err != nil {
+		c.Log().Warn().Err(err).Msg("failed to get caps")
+		return err
+	}
+	c.Log().Trace().Interface("supported", caps).Msg("capabilities")
+	Lucifer.Lock()
+	Lucifer.Bridges[c.Info.IPAddress] = c
+	Lucifer.Unlock()
+	if err = Registry.Load(c); err != nil {
+		c.Log().Warn().Err(err).Msg("failed to load state")
+	}
+	return nil
+}
+
+// Connect connects to a bridge from our config while ziggs is already running.
+func Connect(kb config.KnownBridge) (*Bridge, error) {
+	c, err := newController(&kb)
+	if err != nil {
+		return nil, err
+	}
+	remember(c)
+	if err = c.register(); err != nil {
+		return nil, err
+	}
+	Supervisor.Supervise(c)
+	Registry.watch(c)
+	return c, nil
+}
+
+// Disconnect stops using c, e.g. because it was removed from our config.
+func Disconnect(c *Bridge) {
+	Supervisor.Forget(c)
+	Registry.unwatch(c)
+	c.queue.stopAll()
+	Lucifer.Lock()
+	for k, b := range Lucifer.Bridges {
+		if b == c {
+			delete(Lucifer.Bridges, k)
 		}
-		var caps *huego.Capabilities
-		caps, err = bridge.GetCapabilities()
-		if err != nil {
-			bridge.Log().Warn().Err(err).Msg("failed to get caps")
-			continue
+	}
+	Lucifer.Unlock()
+	NeedsUpdate()
+}
+
+// Config returns the entry in our config file that c was connected with.
+func (c *Bridge) Config() config.KnownBridge {
+	c.RLock()
+	defer c.RUnlock()
+	if c.config == nil {
+		return config.KnownBridge{Hostname: c.Host, Username: c.User}
+	}
+	return *c.config
+}
+
+// SetAlias changes the alias of c, see config.KnownBridge.
+func (c *Bridge) SetAlias(alias string) {
+	c.Lock()
+	if c.config == nil {
+		c.config = &config.KnownBridge{Hostname: c.Host, Username: c.User}
+	}
+	c.config.Alias = alias
+	c.Unlock()
+	NeedsUpdate()
+}
+
+// Reachable checks whether the bridge in kb answers, through its proxy if it has one.
+// Bridges we're connected to are judged by their health instead.
+func Reachable(kb config.KnownBridge) error {
+	if c, ok := FindBridge(kb.Hostname); ok {
+		if h := c.Health(); h.State == Offline {
+			return h.LastError
 		}
-		bridge.Log().Trace().Interface("supported", caps).Msg("capabilities")
-		Lucifer.Lock()
-		Lucifer.Bridges[bridge.Info.IPAddress] = bridge
-		Lucifer.Unlock()
+		return nil
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := newController(&kb)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(Supervisor.Timeout):
+		return errors.New("timed out")
 	}
-	return
 }
@@ -0,0 +1,149 @@
+package ziggy
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func init() {
+	nop := zerolog.Nop()
+	log = &nop
+}
+
+// newTestBridge starts a fake bridge with two lights in one group and registers it with Lucifer.
+func newTestBridge(t *testing.T) (*fakebridge.Bridge, *Bridge) {
+	t.Helper()
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	lamp := fb.AddLight("lamp")
+	desk := fb.AddLight("desk")
+	fb.AddGroup("office", lamp, desk)
+	fb.AddSensor("hallway motion", "ZLLPresence", map[string]interface{}{"presence": false})
+
+	c, err := newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: fb.NewUser("ziggs#test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lucifer.Lock()
+	Lucifer.Bridges = map[string]*Bridge{c.Info.IPAddress: c}
+	Lucifer.Unlock()
+	t.Cleanup(func() {
+		Lucifer.Lock()
+		Lucifer.Bridges = make(map[string]*Bridge)
+		Lucifer.Unlock()
+	})
+	NeedsUpdate()
+	return fb, c
+}
+
+func TestNewController(t *testing.T) {
+	fb, c := newTestBridge(t)
+	if c.Info.BridgeID != fb.ID {
+		t.Errorf("expected bridge ID %s, got %s", fb.ID, c.Info.BridgeID)
+	}
+	if _, err := newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: "nobody"}); err != nil {
+		t.Errorf("unauthenticated config should still be readable: %v", err)
+	}
+	if err := c.getLights(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Lights()) != 2 {
+		t.Errorf("expected 2 lights, got %d", len(c.Lights()))
+	}
+}
+
+func TestConnectDiscovered(t *testing.T) {
+	oldKnown := config.KnownBridges
+	config.KnownBridges = nil
+	t.Cleanup(func() {
+		config.KnownBridges = oldKnown
+		Lucifer.Lock()
+		Lucifer.Bridges = make(map[string]*Bridge)
+		Lucifer.Unlock()
+	})
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.AddLight("lamp")
+
+	c, err := connectDiscovered(fb.Hostname(), fb.NewUser("ziggs#test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lucifer.RLock()
+	registered := Lucifer.Bridges[c.Info.IPAddress] == c
+	Lucifer.RUnlock()
+	if !registered {
+		t.Error("a discovered bridge should be registered with Lucifer")
+	}
+	lamp, err := c.FindLight("lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = lamp.SetState(huego.State{On: true, Bri: 42}); err != nil {
+		t.Fatal(err)
+	}
+	if st := fb.LightState("1"); st["bri"] != 42.0 || st["on"] != true {
+		t.Errorf("unexpected state: %v", st)
+	}
+}
+
+func TestFind(t *testing.T) {
+	fb, c := newTestBridge(t)
+	if len(GetLightMap()) != 2 || len(GetSensorMap()) != 1 {
+		t.Fatalf("unexpected maps: %v %v", GetLightMap(), GetSensorMap())
+	}
+	l, err := c.FindLight("desk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strconv.Itoa(l.ID) != fb.IDs("lights")[1] {
+		t.Errorf("found wrong light: %d", l.ID)
+	}
+	if l, err = c.FindLight("1"); err != nil || l.Name != "lamp" {
+		t.Errorf("failed to find light by ID: %v", err)
+	}
+	g, err := c.FindGroup("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Lights) != 2 {
+		t.Errorf("expected group with 2 lights, got %v", g.Lights)
+	}
+	if _, err = c.FindSensor("hallway motion"); err != nil {
+		t.Error(err)
+	}
+	if _, err = c.FindLight("nonexistent"); err == nil {
+		t.Error("expected error finding nonexistent light")
+	}
+}
+
+func TestAssert(t *testing.T) {
+	fb, _ := newTestBridge(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	wg := &sync.WaitGroup{}
+	for _, l := range GetLightMap() {
+		wg.Add(1)
+		go func(l *HueLight) {
+			defer wg.Done()
+			if err := Assert(ctx, l, ToggleOn); err != nil {
+				t.Error(err)
+			}
+		}(l)
+	}
+	wg.Wait()
+	for _, id := range fb.IDs("lights") {
+		if fb.LightState(id)["on"] != true {
+			t.Errorf("light %s was not turned on", id)
+		}
+	}
+}
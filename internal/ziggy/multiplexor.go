@@ -1,154 +1,447 @@
 package ziggy
 
-import "strconv"
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+// muxTarget is what a Multiplex needs from its members, both *HueLight and *HueGroup satisfy it.
+type muxTarget interface {
+	On() error
+	Off() error
+	Bri(uint8) error
+	Ct(uint16) error
+	Hue(uint16) error
+	Sat(uint8) error
+	Col(color.Color) error
+	Effect(string) error
+	Alert(string) error
+	SetState(huego.State) error
+	Fade(huego.State, time.Duration) error
+	IsOn() bool
+}
+
+type muxMember struct {
+	muxTarget
+	name       string
+	controller *Bridge
+}
 
 // Multiplex is all of the lights (all of the lights).
 // I'll see myself out.
+//
+// It controls any number of lights and groups, regardless of which bridge they belong to.
+// Every action is sent to all members concurrently and their errors are merged.
 type Multiplex struct {
-	bridges []*Bridge
+	Name    string
+	members []muxMember
+	*sync.RWMutex
+}
+
+// NewMultiplex returns an empty Multiplex.
+func NewMultiplex(name string) *Multiplex {
+	return &Multiplex{
+		Name:    name,
+		RWMutex: &sync.RWMutex{},
+	}
+}
+
+// AddLight adds a light from any bridge to the Multiplex.
+func (mx *Multiplex) AddLight(l *HueLight) {
+	mx.Lock()
+	mx.members = append(mx.members, muxMember{muxTarget: l, name: "light " + l.Name, controller: l.controller})
+	mx.Unlock()
+}
+
+// AddGroup adds a group from any bridge to the Multiplex.
+func (mx *Multiplex) AddGroup(g *HueGroup) {
+	mx.Lock()
+	mx.members = append(mx.members, muxMember{muxTarget: g, name: "group " + g.Name, controller: g.controller})
+	mx.Unlock()
+}
+
+// Len returns the number of lights and groups in the Multiplex.
+func (mx *Multiplex) Len() int {
+	mx.RLock()
+	defer mx.RUnlock()
+	return len(mx.members)
+}
+
+// Members returns a description of each member, e.g. "light lamp (001788FFFE000001)".
+func (mx *Multiplex) Members() []string {
+	mx.RLock()
+	defer mx.RUnlock()
+	var ret []string
+	for _, m := range mx.members {
+		ret = append(ret, m.name+" ("+m.controller.bridgeID()+")")
+	}
+	return ret
+}
+
+// Bridges returns every distinct bridge that owns a member of the Multiplex.
+func (mx *Multiplex) Bridges() []*Bridge {
+	mx.RLock()
+	defer mx.RUnlock()
+	var ret []*Bridge
+	seen := make(map[*Bridge]bool)
+	for _, m := range mx.members {
+		if seen[m.controller] {
+			continue
+		}
+		seen[m.controller] = true
+		ret = append(ret, m.controller)
+	}
+	return ret
+}
+
+// Lights returns the lights that were added to the Multiplex directly.
+func (mx *Multiplex) Lights() (lights []*HueLight) {
+	mx.RLock()
+	defer mx.RUnlock()
+	for _, m := range mx.members {
+		if l, ok := m.muxTarget.(*HueLight); ok {
+			lights = append(lights, l)
+		}
+	}
+	return
+}
+
+// Groups returns the groups that were added to the Multiplex.
+func (mx *Multiplex) Groups() (groups []*HueGroup) {
+	mx.RLock()
+	defer mx.RUnlock()
+	for _, m := range mx.members {
+		if g, ok := m.muxTarget.(*HueGroup); ok {
+			groups = append(groups, g)
+		}
+	}
+	return
+}
+
+func (mx *Multiplex) fanOut(action func(muxTarget) error) error {
+	mx.RLock()
+	members := make([]muxMember, len(mx.members))
+	copy(members, mx.members)
+	mx.RUnlock()
+	if len(members) == 0 {
+		return fmt.Errorf("%s has no members", mx.Name)
+	}
+	errs := make([]error, len(members))
+	wg := &sync.WaitGroup{}
+	for i, m := range members {
+		wg.Add(1)
+		go func(i int, m muxMember) {
+			defer wg.Done()
+			if err := action(m.muxTarget); err != nil {
+				errs[i] = fmt.Errorf("%s on bridge %s: %w", m.name, m.controller.bridgeID(), err)
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (mx *Multiplex) On() error {
+	return mx.fanOut(func(t muxTarget) error { return t.On() })
+}
+
+func (mx *Multiplex) Off() error {
+	return mx.fanOut(func(t muxTarget) error { return t.Off() })
+}
+
+func (mx *Multiplex) Bri(bri uint8) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Bri(bri) })
+}
+
+func (mx *Multiplex) Ct(ct uint16) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Ct(ct) })
+}
+
+func (mx *Multiplex) Hue(hue uint16) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Hue(hue) })
+}
+
+func (mx *Multiplex) Sat(sat uint8) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Sat(sat) })
+}
+
+func (mx *Multiplex) Col(c color.Color) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Col(c) })
+}
+
+func (mx *Multiplex) Effect(effect string) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Effect(effect) })
+}
+
+func (mx *Multiplex) Alert(alert string) error {
+	return mx.fanOut(func(t muxTarget) error { return t.Alert(alert) })
+}
+
+func (mx *Multiplex) SetState(s huego.State) error {
+	return mx.fanOut(func(t muxTarget) error { return t.SetState(s) })
+}
+
+// Scene recalls the scene with the given name or ID on every group in the Multiplex that has it.
+// Scene IDs are unique to each bridge, so a name is usually what you want here.
+func (mx *Multiplex) Scene(scene string) error {
+	if len(mx.Groups()) == 0 {
+		return fmt.Errorf("%s has no groups to recall a scene on", mx.Name)
+	}
+	return mx.fanOut(func(t muxTarget) error {
+		g, ok := t.(*HueGroup)
+		if !ok {
+			return nil
+		}
+		id, err := g.sceneID(scene)
+		if err != nil {
+			return err
+		}
+		return g.Scene(id)
+	})
+}
+
+// sceneID returns the ID of the group's scene with the given name or ID.
+func (hg *HueGroup) sceneID(scene string) (string, error) {
+	scenes, err := hg.Scenes()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range scenes {
+		if s.ID == scene || strings.EqualFold(s.Name, scene) {
+			return s.ID, nil
+		}
+	}
+	return "", fmt.Errorf("scene %s not found", scene)
+}
+
+// IsOn returns true if any member of the Multiplex is on.
+func (mx *Multiplex) IsOn() bool {
+	mx.RLock()
+	defer mx.RUnlock()
+	for _, m := range mx.members {
+		if m.IsOn() {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the current state of the first member of the Multiplex, or nil if it is empty.
+func (mx *Multiplex) State() *huego.State {
+	mx.RLock()
+	defer mx.RUnlock()
+	if len(mx.members) == 0 {
+		return nil
+	}
+	switch t := mx.members[0].muxTarget.(type) {
+	case *HueLight:
+		return t.CurrentState()
+	case *HueGroup:
+		return t.CurrentState()
+	}
+	return nil
+}
+
+// Everything returns a Multiplex containing group 0, which holds every light, of each connected bridge.
+func Everything() *Multiplex {
+	mx := NewMultiplex("all")
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, c := range Lucifer.Bridges {
+		g, err := c.GetGroup(0)
+		if err != nil {
+			log.Warn().Msgf("failed to get group 0 on bridge %s: %v", c.bridgeID(), err)
+			continue
+		}
+		g.Name = "all"
+		mx.AddGroup(&HueGroup{Group: g, controller: c})
+	}
+	return mx
+}
+
+// GetVirtualGroupMap resolves the virtual groups defined in our configuration file.
+// Members that can't be found on any bridge are logged and skipped.
+func GetVirtualGroupMap() map[string]*Multiplex {
+	vgroups := make(map[string]*Multiplex)
+	if len(config.VirtualGroups) == 0 {
+		return vgroups
+	}
+	lights := GetLightMap()
+	groups := GetGroupMap()
+	for name, vg := range config.VirtualGroups {
+		mx := NewMultiplex(name)
+		for _, l := range vg.Lights {
+			hl, ok := lights[l]
+			if !ok {
+				log.Warn().Msgf("virtual group %s: light %s not found", name, l)
+				continue
+			}
+			mx.AddLight(hl)
+		}
+		for _, g := range vg.Groups {
+			hg, ok := groups[g]
+			if !ok {
+				log.Warn().Msgf("virtual group %s: group %s not found", name, g)
+				continue
+			}
+			mx.AddGroup(hg)
+		}
+		vgroups[name] = mx
+	}
+	return vgroups
 }
 
 var (
-	lightMap    map[string]*HueLight
-	groupMap    map[string]*HueGroup
-	sensorMap   map[string]*HueSensor
-	sceneMap    map[string]*HueScene
-	needsUpdate = 4
+	lightMap  map[string]*HueLight
+	groupMap  map[string]*HueGroup
+	sensorMap map[string]*HueSensor
+	sceneMap  map[string]*HueScene
+	// each map is rebuilt the next time it is requested after NeedsUpdate is called.
+	lightsFresh, groupsFresh, sensorsFresh, scenesFresh bool
+	mapMu                                               = &sync.Mutex{}
 )
 
+// NeedsUpdate marks our name maps as stale, the Registry calls it whenever something is added, removed or renamed.
 func NeedsUpdate() {
-	needsUpdate = 4
+	mapMu.Lock()
+	lightsFresh, groupsFresh, sensorsFresh, scenesFresh = false, false, false, false
+	mapMu.Unlock()
 }
 
 func GetLightMap() map[string]*HueLight {
-	if needsUpdate == 0 {
+	mapMu.Lock()
+	defer mapMu.Unlock()
+	if lightsFresh {
 		return lightMap
 	}
 
-	defer func() {
-		needsUpdate--
-	}()
-
 	lightMap = make(map[string]*HueLight)
+	found := make(map[string][]*HueLight)
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
 	for _, c := range Lucifer.Bridges {
 		ls, err := c.GetLights()
 		if err != nil {
 			log.Warn().Msgf("error getting lights on bridge %s: %v", c.ID, err)
 			continue
 		}
-		for _, l := range ls {
-			light, lerr := c.GetLight(l.ID)
-			if lerr != nil {
-				log.Warn().Msgf("failed to get pointer for light %s on bridge %s: %v", l.Name, c.ID, lerr)
-				continue
-			}
-			if _, ok := lightMap[l.Name]; ok {
+		for i := range ls {
+			l := &ls[i]
+			if onBridge(found[l.Name], c) {
 				log.Warn().Msgf("duplicate light name %s on bridge %s - please rename", l.Name, c.ID)
 				continue
 			}
-			lightMap[l.Name] = &HueLight{Light: light, controller: c}
+			hl := &HueLight{Light: l, controller: c}
+			found[l.Name] = append(found[l.Name], hl)
 		}
 	}
+	addNames(lightMap, found, "light")
+	lightsFresh = true
 	return lightMap
 }
 
 func GetGroupMap() map[string]*HueGroup {
-	if needsUpdate == 0 {
+	mapMu.Lock()
+	defer mapMu.Unlock()
+	if groupsFresh {
 		return groupMap
 	}
 
-	defer func() {
-		needsUpdate--
-	}()
-
 	groupMap = make(map[string]*HueGroup)
+	found := make(map[string][]*HueGroup)
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
 	for _, c := range Lucifer.Bridges {
 		gs, err := c.GetGroups()
 		if err != nil {
 			log.Warn().Msgf("error getting groups on bridge %s: %v", c.ID, err)
 			continue
 		}
-		for _, g := range gs {
-			group, gerr := c.GetGroup(g.ID)
-			if gerr != nil {
-				log.Warn().Msgf("failed to get pointer for group %s on bridge %s: %v", g.Name, c.ID, gerr)
-				continue
-			}
-			if _, ok := groupMap[g.Name]; ok {
+		for i := range gs {
+			g := &gs[i]
+			if onBridge(found[g.Name], c) {
 				log.Warn().Msgf("duplicate group name %s on bridge %s - please rename", g.Name, c.ID)
 				continue
 			}
-			hg := &HueGroup{Group: group, controller: c}
-			groupMap[g.Name] = hg
-			groupMap[strconv.Itoa(g.ID)] = hg
+			hg := &HueGroup{Group: g, controller: c}
+			found[g.Name] = append(found[g.Name], hg)
+			found[strconv.Itoa(g.ID)] = append(found[strconv.Itoa(g.ID)], hg)
 		}
 	}
+	addNames(groupMap, found, "group")
+	groupsFresh = true
 	return groupMap
 }
 
 func GetSensorMap() map[string]*HueSensor {
-	if needsUpdate == 0 {
+	mapMu.Lock()
+	defer mapMu.Unlock()
+	if sensorsFresh {
 		return sensorMap
 	}
 
-	defer func() {
-		needsUpdate--
-	}()
-
 	sensorMap = make(map[string]*HueSensor)
+	found := make(map[string][]*HueSensor)
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
 	for _, c := range Lucifer.Bridges {
 		ss, err := c.GetSensors()
 		if err != nil {
-			log.Warn().Msgf("error getting groups on bridge %s: %v", c.ID, err)
+			log.Warn().Msgf("error getting sensors on bridge %s: %v", c.ID, err)
 			continue
 		}
-		for _, s := range ss {
-			sensor, gerr := c.GetSensor(s.ID)
-			if gerr != nil {
-				log.Warn().Msgf("failed to get pointer for sensor %s on bridge %s: %v", s.Name, c.ID, gerr)
-				continue
-			}
-			if _, ok := sensorMap[s.Name]; ok {
+		for i := range ss {
+			s := &ss[i]
+			if onBridge(found[s.Name], c) {
 				log.Warn().Msgf("duplicate sensor name %s on bridge %s - please rename", s.Name, c.ID)
 				continue
 			}
-			sensorMap[s.Name] = &HueSensor{Sensor: sensor, controller: c}
+			hs := &HueSensor{Sensor: s, controller: c}
+			found[s.Name] = append(found[s.Name], hs)
 		}
 	}
+	addNames(sensorMap, found, "sensor")
+	sensorsFresh = true
 	return sensorMap
 }
 
 func GetSceneMap() map[string]*HueScene {
-	if needsUpdate == 0 {
+	mapMu.Lock()
+	defer mapMu.Unlock()
+	if scenesFresh {
 		return sceneMap
 	}
 
-	defer func() {
-		needsUpdate--
-	}()
-
 	sceneMap = make(map[string]*HueScene)
+	found := make(map[string][]*HueScene)
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
 	for _, c := range Lucifer.Bridges {
 		scs, err := c.GetScenes()
 		if err != nil {
-			log.Warn().Msgf("error getting groups on bridge %s: %v", c.ID, err)
+			log.Warn().Msgf("error getting scenes on bridge %s: %v", c.ID, err)
 			continue
 		}
-		for _, s := range scs {
-			group, gerr := c.GetScene(s.ID)
-			if gerr != nil {
-				log.Warn().Msgf("failed to get pointer for scene %s on bridge %s: %v", s.Name, c.ID, gerr)
-				continue
-			}
-			if _, ok := sceneMap[s.Name]; !ok {
-				sceneMap[s.Name] = &HueScene{Scene: group, controller: c}
-				continue
-			}
-			if _, ok := sceneMap[s.Name+"-2"]; ok {
-				log.Warn().Msgf("duplicate scene name %s on bridge %s - please rename", s.Name, c.ID)
+		for i := range scs {
+			s := &scs[i]
+			if onBridge(found[s.Name], c) {
+				log.Debug().Msgf("duplicate scene name %s on bridge %s", s.Name, c.ID)
 				continue
 			}
+			hs := &HueScene{Scene: s, controller: c}
+			found[s.Name] = append(found[s.Name], hs)
 		}
 	}
+	addNames(sceneMap, found, "scene")
+	scenesFresh = true
 	return sceneMap
 }
@@ -0,0 +1,97 @@
+package ziggy
+
+import (
+	"strings"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+// newTestBridges registers two fake bridges with Lucifer, each with its own lights and group.
+func newTestBridges(t *testing.T) (upstairs, downstairs *fakebridge.Bridge) {
+	t.Helper()
+	upstairs, _ = newTestBridge(t)
+	downstairs = fakebridge.New()
+	t.Cleanup(downstairs.Close)
+	couch := downstairs.AddLight("couch")
+	tv := downstairs.AddLight("tv")
+	downstairs.AddGroup("living room", couch, tv)
+
+	c, err := newController(&config.KnownBridge{Hostname: downstairs.Hostname(), Username: downstairs.NewUser("ziggs#test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lucifer.Lock()
+	Lucifer.Bridges[c.Info.IPAddress] = c
+	Lucifer.Unlock()
+	NeedsUpdate()
+	return upstairs, downstairs
+}
+
+func TestMultiplex(t *testing.T) {
+	upstairs, downstairs := newTestBridges(t)
+
+	all := Everything()
+	if len(all.Bridges()) != 2 {
+		t.Fatalf("expected 2 bridges, got %d", len(all.Bridges()))
+	}
+	if err := all.On(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fb := range []*fakebridge.Bridge{upstairs, downstairs} {
+		for _, id := range fb.IDs("lights") {
+			if fb.LightState(id)["on"] != true {
+				t.Errorf("bridge %s: light %s was not turned on", fb.ID, id)
+			}
+		}
+	}
+
+	config.VirtualGroups = map[string]config.VirtualGroup{
+		"floor2": {Lights: []string{"lamp", "couch", "nonexistent"}, Groups: []string{"living room"}},
+	}
+	t.Cleanup(func() { config.VirtualGroups = nil })
+	floor2, ok := GetVirtualGroupMap()["floor2"]
+	if !ok {
+		t.Fatal("virtual group floor2 not found")
+	}
+	if floor2.Len() != 3 || len(floor2.Lights()) != 2 || len(floor2.Groups()) != 1 {
+		t.Fatalf("unexpected members: %v", floor2.Members())
+	}
+	if err := floor2.Off(); err != nil {
+		t.Fatal(err)
+	}
+	if upstairs.LightState("1")["on"] != false || upstairs.LightState("2")["on"] != true {
+		t.Errorf("wrong lights changed upstairs: %v %v", upstairs.LightState("1"), upstairs.LightState("2"))
+	}
+	for _, id := range downstairs.IDs("lights") {
+		if downstairs.LightState(id)["on"] != false {
+			t.Errorf("downstairs light %s was not turned off", id)
+		}
+	}
+	if floor2.IsOn() {
+		t.Error("floor2 should be off")
+	}
+	if err := floor2.Bri(120); err != nil {
+		t.Fatal(err)
+	}
+	if bri := downstairs.LightState("1")["bri"]; bri != 120.0 {
+		t.Errorf("expected brightness 120, got %v", bri)
+	}
+
+	downstairs.SetOffline(true)
+	err := floor2.On()
+	if err == nil {
+		t.Fatal("expected an error from the offline bridge")
+	}
+	if !strings.Contains(err.Error(), downstairs.ID) || strings.Contains(err.Error(), upstairs.ID) {
+		t.Errorf("error should only blame the offline bridge: %v", err)
+	}
+	if upstairs.LightState("1")["on"] != true {
+		t.Error("reachable bridge should still have been changed")
+	}
+
+	if err = NewMultiplex("empty").On(); err == nil {
+		t.Error("expected error from empty multiplex")
+	}
+}
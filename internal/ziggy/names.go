@@ -0,0 +1,186 @@
+package ziggy
+
+import (
+	"strconv"
+	"strings"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+// Lights, groups, sensors and scenes only need unique names within one bridge.
+// Besides its bare name, everything can be addressed as <bridgeID>/<name>,
+// or as <alias>:<name> when the bridge has an alias in our config file.
+// Bare names that exist on more than one bridge are kept in the maps as <bridgeID>/<name> only, and must be qualified.
+
+// Alias returns the alias given to the bridge in our config file, if any.
+func (c *Bridge) Alias() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.config == nil {
+		return ""
+	}
+	return c.config.Alias
+}
+
+// QualifiedName returns the bridge-qualified form of name, e.g. 001788FFFE123456/Kitchen.
+func (c *Bridge) QualifiedName(name string) string {
+	return c.bridgeID() + "/" + name
+}
+
+// bridged is anything that lives on a bridge.
+type bridged interface {
+	comparable
+	Bridge() *Bridge
+}
+
+// FindBridge returns the bridge we're connected to that key refers to. Keys are what `use` accepts:
+// the key in Lucifer.Bridges, a bridge ID, an alias or the hostname from our config.
+func FindBridge(key string) (*Bridge, bool) {
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	if c, ok := Lucifer.Bridges[key]; ok {
+		return c, true
+	}
+	for _, c := range Lucifer.Bridges {
+		switch {
+		case strings.EqualFold(c.bridgeID(), key),
+			c.Alias() != "" && strings.EqualFold(c.Alias(), key),
+			config.SameHostname(c.Host, key):
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// SplitQualifiedName returns the bridge that a bridge-qualified name refers to and the name on that bridge.
+// If input is not qualified with a known bridge ID or alias, it returns nil and input.
+func SplitQualifiedName(input string) (*Bridge, string) {
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, sep := range []string{"/", ":"} {
+		i := strings.Index(input, sep)
+		if i < 1 {
+			continue
+		}
+		qualifier, name := input[:i], input[i+1:]
+		for _, c := range Lucifer.Bridges {
+			switch {
+			case sep == "/" && strings.EqualFold(c.bridgeID(), qualifier):
+				return c, name
+			case sep == ":" && c.Alias() != "" && strings.EqualFold(c.Alias(), qualifier):
+				return c, name
+			}
+		}
+	}
+	return nil, input
+}
+
+// addNames adds everything in found to m under its bare name, or under its bridge-qualified name
+// when the bare name exists on more than one bridge.
+func addNames[T bridged](m map[string]T, found map[string][]T, kind string) {
+	for name, targets := range found {
+		if len(targets) == 1 {
+			m[name] = targets[0]
+			continue
+		}
+		log.Debug().Msgf("%s name %s exists on %d bridges, qualify it with a bridge ID or alias",
+			kind, name, len(targets))
+		for _, t := range targets {
+			m[t.Bridge().QualifiedName(name)] = t
+		}
+	}
+}
+
+// onBridge reports whether any of targets lives on c.
+func onBridge[T bridged](targets []T, c *Bridge) bool {
+	for _, t := range targets {
+		if t.Bridge() == c {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupOn finds what c calls name in m, whether its name is unique or qualified.
+func lookupOn[T bridged](m map[string]T, c *Bridge, name string) (T, bool) {
+	if t, ok := m[name]; ok && t.Bridge() == c {
+		return t, true
+	}
+	t, ok := m[c.QualifiedName(name)]
+	return t, ok
+}
+
+// lookup finds name in m. Qualified names are resolved on their bridge, bare names that are ambiguous
+// are resolved on the preferred bridge, if one is given. IDs are only unique per bridge, so when there is
+// a preferred bridge they are only resolved on that one.
+func lookup[T bridged](m map[string]T, name string, preferred *Bridge) (T, bool) {
+	var zero T
+	if c, bare := SplitQualifiedName(name); c != nil {
+		return lookupOn(m, c, bare)
+	}
+	if preferred == nil {
+		t, ok := m[name]
+		return t, ok
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		if t, ok := lookupOn(m, preferred, name); ok {
+			return t, true
+		}
+		return zero, false
+	}
+	if t, ok := m[name]; ok {
+		return t, true
+	}
+	return lookupOn(m, preferred, name)
+}
+
+// LookupLight finds a light by its bare or bridge-qualified name.
+func LookupLight(name string, preferred *Bridge) (*HueLight, bool) {
+	return lookup(GetLightMap(), name, preferred)
+}
+
+// LookupGroup finds a group by its bare or bridge-qualified name.
+func LookupGroup(name string, preferred *Bridge) (*HueGroup, bool) {
+	return lookup(GetGroupMap(), name, preferred)
+}
+
+// LookupSensor finds a sensor by its bare or bridge-qualified name.
+func LookupSensor(name string, preferred *Bridge) (*HueSensor, bool) {
+	return lookup(GetSensorMap(), name, preferred)
+}
+
+// LookupScene finds a scene by its bare or bridge-qualified name.
+func LookupScene(name string, preferred *Bridge) (*HueScene, bool) {
+	return lookup(GetSceneMap(), name, preferred)
+}
+
+// preferredNames returns every distinct value of m once, keyed by its bare name,
+// or by its bridge-qualified name when the bare name is ambiguous.
+func preferredNames[T comparable](m map[string]T, nameOf func(T) (string, *Bridge)) map[string]T {
+	ret := make(map[string]T)
+	for key, t := range m {
+		bare, c := nameOf(t)
+		switch {
+		case key == bare:
+		case key == c.QualifiedName(bare) && m[bare] != t:
+		default:
+			continue
+		}
+		ret[key] = t
+	}
+	return ret
+}
+
+// LightList returns every light on every bridge exactly once, see preferredNames.
+func LightList() map[string]*HueLight {
+	return preferredNames(GetLightMap(), func(l *HueLight) (string, *Bridge) {
+		return l.Name, l.controller
+	})
+}
+
+// GroupList returns every group on every bridge exactly once, see preferredNames.
+func GroupList() map[string]*HueGroup {
+	return preferredNames(GetGroupMap(), func(g *HueGroup) (string, *Bridge) {
+		return g.Name, g.controller
+	})
+}
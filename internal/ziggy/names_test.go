@@ -0,0 +1,117 @@
+package ziggy
+
+import (
+	"strconv"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func TestQualifiedNames(t *testing.T) {
+	upstairs, up := newTestBridge(t)
+	downstairs := fakebridge.New()
+	t.Cleanup(downstairs.Close)
+	downstairs.AddLight("couch")
+	lamp := downstairs.AddLight("lamp")
+	downstairs.AddGroup("office", lamp)
+	down, err := newController(&config.KnownBridge{
+		Hostname: downstairs.Hostname(), Username: downstairs.NewUser("ziggs#test"), Alias: "down",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lucifer.Lock()
+	Lucifer.Bridges[down.Info.IPAddress] = down
+	Lucifer.Unlock()
+	NeedsUpdate()
+
+	if _, ok := GetLightMap()["lamp"]; ok {
+		t.Error("ambiguous light name should not be in the map")
+	}
+	if l, ok := GetLightMap()["couch"]; !ok || l.controller != down {
+		t.Error("unique light name should resolve without a qualifier")
+	}
+	for name, want := range map[string]*Bridge{
+		upstairs.ID + "/lamp":   up,
+		downstairs.ID + "/lamp": down,
+		"down:lamp":             down,
+		"down:couch":            down,
+	} {
+		l, ok := LookupLight(name, nil)
+		if !ok {
+			t.Errorf("%s not found", name)
+			continue
+		}
+		if l.controller != want {
+			t.Errorf("%s resolved on the wrong bridge", name)
+		}
+	}
+
+	NeedsUpdate()
+	g, ok := LookupGroup("office", down)
+	if !ok || g.controller != down {
+		t.Error("ambiguous group should resolve on the preferred bridge")
+	}
+	if _, ok = LookupGroup("office", nil); ok {
+		t.Error("ambiguous group should not resolve without a preferred bridge")
+	}
+	if g, err = up.FindGroup("down:office"); err != nil || g.controller != down {
+		t.Errorf("FindGroup ignored the alias: %v", err)
+	}
+	l, err := up.FindLight(downstairs.ID + "/" + lamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strconv.Itoa(l.ID) != lamp || l.controller != down {
+		t.Errorf("qualified light ID resolved to light %d", l.ID)
+	}
+	if l, err = up.FindLight("lamp"); err != nil || l.controller != up {
+		t.Errorf("bare name should resolve on the bridge it was looked up on: %v", err)
+	}
+
+	lights := LightList()
+	if len(lights) != 4 {
+		t.Errorf("expected 4 lights, got %d", len(lights))
+	}
+	for _, name := range []string{"desk", "couch", upstairs.ID + "/lamp", downstairs.ID + "/lamp"} {
+		if _, ok = lights[name]; !ok {
+			t.Errorf("%s missing from light list", name)
+		}
+	}
+}
+
+func TestLookupIDs(t *testing.T) {
+	_, up := newTestBridge(t)
+	downstairs := fakebridge.New()
+	t.Cleanup(downstairs.Close)
+	lamp := downstairs.AddLight("lamp")
+	downstairs.AddGroup("den", lamp)
+	kitchen := downstairs.AddGroup("kitchen", lamp)
+	down, err := newController(&config.KnownBridge{
+		Hostname: downstairs.Hostname(), Username: downstairs.NewUser("ziggs#test"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lucifer.Lock()
+	Lucifer.Bridges[down.Info.IPAddress] = down
+	Lucifer.Unlock()
+	NeedsUpdate()
+
+	if g, ok := LookupGroup(kitchen, nil); !ok || g.controller != down {
+		t.Errorf("group %s only exists downstairs and should resolve there", kitchen)
+	}
+	if g, ok := LookupGroup(kitchen, up); ok {
+		t.Errorf("group %s resolved to %s on a bridge that isn't the preferred one", kitchen, g.Name)
+	}
+	if g, ok := LookupGroup("1", down); !ok || g.controller != down || g.Name != "den" {
+		t.Error("group 1 should resolve on the preferred bridge")
+	}
+	if g, ok := LookupGroup("1", up); !ok || g.controller != up || g.Name != "office" {
+		t.Error("group 1 should resolve on the preferred bridge")
+	}
+	if g, ok := LookupGroup("den", up); !ok || g.controller != down {
+		t.Error("unique names should resolve on any bridge")
+	}
+}
@@ -0,0 +1,149 @@
+package ziggy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+// ErrLinkButton is returned by Pair when nobody pressed the link button before the timeout.
+var ErrLinkButton = errors.New("link button not pressed")
+
+// PairOptions controls how Pair asks a bridge for a new user.
+type PairOptions struct {
+	// DeviceType is how the user shows up in the hue app, ziggs#<hostname> by default.
+	DeviceType string
+	// ClientKey also requests a PSK for the entertainment API.
+	ClientKey bool
+	// Timeout is how long to wait for the link button, 30 seconds by default.
+	Timeout time.Duration
+	// Interval is how often the bridge is asked, every second by default.
+	Interval time.Duration
+	// Proxy is what the bridge is reached through, stored along with it, see config.KnownBridge.
+	Proxy string
+	// Alias is stored along with the bridge, see config.KnownBridge.
+	Alias string
+}
+
+type pairResponse []struct {
+	Success *struct {
+		Username  string `json:"username"`
+		ClientKey string `json:"clientkey"`
+	} `json:"success"`
+	Error *struct {
+		Type        int    `json:"type"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// createUser asks for a user once, the link button error comes back as ErrLinkButton.
+func createUser(ctx context.Context, h *http.Client, host string, opts PairOptions) (user, key string, err error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"devicetype":        opts.DeviceType,
+		"generateclientkey": opts.ClientKey,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(host, "/")+"/api", bytes.NewReader(body))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	var pr pairResponse
+	if err = json.NewDecoder(resp.Body).Decode(&pr); err != nil {
+		return "", "", fmt.Errorf("%s doesn't look like a hue bridge: %w", host, err)
+	}
+	for _, r := range pr {
+		switch {
+		case r.Success != nil:
+			return r.Success.Username, r.Success.ClientKey, nil
+		case r.Error != nil && r.Error.Type == 101:
+			return "", "", ErrLinkButton
+		case r.Error != nil:
+			return "", "", fmt.Errorf("bridge returned error %d: %s", r.Error.Type, r.Error.Description)
+		}
+	}
+	return "", "", errors.New("empty response from bridge")
+}
+
+// pairUser asks host for a new user until the link button is pressed, the timeout expires or ctx is done.
+func pairUser(ctx context.Context, host string, opts PairOptions) (config.KnownBridge, error) {
+	if opts.DeviceType == "" {
+		name, _ := os.Hostname()
+		opts.DeviceType = "ziggs#" + name
+	}
+	// the bridge only takes 20 characters for the device part.
+	if parts := strings.SplitN(opts.DeviceType, "#", 2); len(parts) == 2 && len(parts[1]) > 19 {
+		opts.DeviceType = parts[0] + "#" + parts[1][:19]
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	kb := config.KnownBridge{Hostname: host, Proxy: opts.Proxy, Alias: opts.Alias}
+	h, _, err := newHTTPClient(kb)
+	if err != nil {
+		return kb, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	waiting := false
+	for {
+		user, key, err := createUser(ctx, h, host, opts)
+		switch {
+		case err == nil:
+			kb.Username, kb.ClientKey = user, key
+			return kb, nil
+		case errors.Is(err, ErrLinkButton):
+			if !waiting {
+				log.Info().Str("caller", host).Dur("timeout", opts.Timeout).Msg("press the link button on the bridge")
+				waiting = true
+			}
+		case ctx.Err() != nil:
+			return kb, ErrLinkButton
+		default:
+			return kb, err
+		}
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return kb, ErrLinkButton
+			}
+			return kb, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pair asks the bridge at host for a new user until its link button is pressed or the timeout expires,
+// then adds the bridge to the config next to the ones we already know about. It never prompts for anything.
+func Pair(ctx context.Context, host string, opts PairOptions) (config.KnownBridge, error) {
+	kb, err := pairUser(ctx, host, opts)
+	if err != nil {
+		return kb, err
+	}
+	log.Info().Str("caller", kb.Hostname).Msg("paired!")
+	if err = config.AddKnownBridge(kb); err != nil {
+		return kb, fmt.Errorf("paired, but failed to save the config: %w", err)
+	}
+	return kb, nil
+}
@@ -0,0 +1,52 @@
+package ziggy
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func TestPair(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	oldConfig, oldKnown := config.Snek.ConfigFileUsed(), config.KnownBridges
+	config.Snek.SetConfigFile(filepath.Join(t.TempDir(), "config.toml"))
+	t.Cleanup(func() {
+		config.Snek.SetConfigFile(oldConfig)
+		config.KnownBridges = oldKnown
+	})
+	config.KnownBridges = []config.KnownBridge{{Hostname: "http://192.168.1.2", Username: "someone"}}
+
+	opts := PairOptions{Timeout: 200 * time.Millisecond, Interval: 10 * time.Millisecond}
+	if _, err := Pair(context.Background(), fb.Hostname(), opts); !errors.Is(err, ErrLinkButton) {
+		t.Fatalf("expected %v, got %v", ErrLinkButton, err)
+	}
+	if len(config.KnownBridges) != 1 {
+		t.Fatalf("failed pairing shouldn't touch the config: %+v", config.KnownBridges)
+	}
+
+	fb.PressLinkButtonAfter(3)
+	opts.ClientKey, opts.Alias, opts.Timeout = true, "upstairs", time.Second
+	kb, err := Pair(context.Background(), fb.Hostname(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb.Username == "" || kb.ClientKey == "" || kb.Alias != "upstairs" {
+		t.Errorf("unexpected bridge: %+v", kb)
+	}
+	if len(config.KnownBridges) != 2 || config.KnownBridges[0].Username != "someone" || config.KnownBridges[1] != kb {
+		t.Errorf("pairing should add the bridge next to the others: %+v", config.KnownBridges)
+	}
+	c, err := newController(&kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetCapabilities(); err != nil {
+		t.Errorf("new user can't log in: %v", err)
+	}
+}
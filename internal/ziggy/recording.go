@@ -0,0 +1,79 @@
+package ziggy
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// recorded is one line of a recording: a batch of events and when we got it.
+type recorded struct {
+	At time.Time `json:"at"`
+	EventBatch
+}
+
+// Record writes every batch of events to w, one JSON object per line, until ctx is done or it wrote n batches.
+// n <= 0 records until ctx is done. It returns how many batches it wrote.
+func (h *EventHub) Record(ctx context.Context, w io.Writer, n int) (int, error) {
+	batches, stop := h.SubscribeBatches(256)
+	defer stop()
+	enc := json.NewEncoder(w)
+	count := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return count, nil
+		case b := <-batches:
+			if err := enc.Encode(recorded{At: time.Now(), EventBatch: b}); err != nil {
+				return count, err
+			}
+			if count++; n > 0 && count >= n {
+				return count, nil
+			}
+		}
+	}
+}
+
+// ReplayFrom reads a recording made by Record and replays it to every subscriber, keeping the time between batches
+// divided by speed. With a speed of 0 or less everything is replayed at once. The events carry the time they are
+// replayed at and are marked as Replayed. It returns how many batches it replayed.
+func (h *EventHub) ReplayFrom(ctx context.Context, r io.Reader, speed float64) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var first time.Time
+	start := time.Now()
+	count := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec recorded
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return count, fmt.Errorf("line %d: %w", line, err)
+		}
+		if first.IsZero() {
+			first = rec.At
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(rec.At.Sub(first)) / speed))
+			select {
+			case <-ctx.Done():
+				return count, nil
+			case <-time.After(time.Until(due)):
+			}
+		} else if ctx.Err() != nil {
+			return count, nil
+		}
+		now := time.Now()
+		for i := range rec.Events {
+			rec.Events[i].Time = now
+			rec.Events[i].Replayed = true
+		}
+		h.Replay(rec.EventBatch)
+		count++
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,69 @@
+package ziggy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	fb, c := newTestBridge(t)
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := Events.Record(context.Background(), &buf, 2)
+		done <- err
+	}()
+	waitFor(t, func() bool {
+		Events.RLock()
+		defer Events.RUnlock()
+		return len(Events.batches) == 1
+	})
+	fb.SetLightState("1", map[string]interface{}{"on": true})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	fb.SetSensorState("1", map[string]interface{}{"presence": true})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Fatalf("expected 2 recorded batches, got %d: %s", lines, buf.String())
+	}
+
+	events, stop := Events.Subscribe(10)
+	defer stop()
+	began := time.Now()
+	n, err := Events.ReplayFrom(context.Background(), bytes.NewReader(buf.Bytes()), 2)
+	if err != nil || n != 2 {
+		t.Fatalf("replayed %d batches: %v", n, err)
+	}
+	if took := time.Since(began); took < 40*time.Millisecond {
+		t.Errorf("replay at double speed should keep half the time between batches, took %s", took)
+	}
+	// the lamp turning on also turned on the office.
+	got := collectEvents(events, 50*time.Millisecond)
+	if lamp, ok := got["light lamp"]; !ok || lamp.On == nil || !lamp.On.On || lamp.Bridge != fb.ID {
+		t.Errorf("lamp didn't replay: %+v", got)
+	}
+	if motion, ok := got["motion hallway motion"]; !ok || motion.Time.Before(began) {
+		t.Errorf("motion didn't replay as if it just happened: %+v", got)
+	}
+	if len(got) != 3 {
+		t.Errorf("expected 3 events, got %+v", got)
+	}
+
+	if _, err = Events.ReplayFrom(context.Background(), strings.NewReader("{\n"), 0); err == nil {
+		t.Error("expected an error for a broken recording")
+	}
+}
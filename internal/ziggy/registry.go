@@ -0,0 +1,537 @@
+package ziggy
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/haptic"
+)
+
+var (
+	// PollInterval is how often the registry polls a bridge while its eventstream is unavailable.
+	PollInterval = 5 * time.Second
+	// EventstreamBackoff is how long the registry polls a bridge before trying its eventstream again the first time,
+	// EventstreamRetry the longest it waits after the eventstream failed again and again.
+	EventstreamBackoff = time.Second
+	EventstreamRetry   = time.Minute
+)
+
+// StateRegistry keeps the last known state of the lights, groups and sensors on every bridge.
+// It is loaded once per bridge from GetFullState, then kept current by the CLIP v2 eventstream,
+// falling back to polling whenever the eventstream is unavailable.
+type StateRegistry struct {
+	bridges map[string]*bridgeState
+	hooks   []func()
+	// watching is what WatchAll was given, bridges connected to later are watched until it is done too.
+	watching context.Context
+	watches  map[*Bridge]context.CancelFunc
+	*sync.RWMutex
+}
+
+type bridgeState struct {
+	Lights  map[string]*huego.Light  `json:"lights"`
+	Groups  map[string]*huego.Group  `json:"groups"`
+	Sensors map[string]*huego.Sensor `json:"sensors"`
+	Scenes  map[string]*huego.Scene  `json:"scenes"`
+	updated time.Time
+}
+
+// Registry holds the state of every bridge we are connected to.
+var Registry = &StateRegistry{
+	bridges: make(map[string]*bridgeState),
+	watches: make(map[*Bridge]context.CancelFunc),
+	RWMutex: &sync.RWMutex{},
+}
+
+// OnChange registers f to be called whenever the registry has been updated.
+func (r *StateRegistry) OnChange(f func()) {
+	r.Lock()
+	r.hooks = append(r.hooks, f)
+	r.Unlock()
+}
+
+func (r *StateRegistry) changed(structural bool) {
+	if structural {
+		NeedsUpdate()
+	}
+	r.RLock()
+	hooks := r.hooks
+	r.RUnlock()
+	for _, f := range hooks {
+		f()
+	}
+}
+
+// names summarizes everything on the bridge by ID and name, if it changes then our name maps are stale.
+func (bs *bridgeState) names() string {
+	var names []string
+	for id, l := range bs.Lights {
+		names = append(names, "lights/"+id+"="+l.Name)
+	}
+	for id, g := range bs.Groups {
+		names = append(names, "groups/"+id+"="+g.Name)
+	}
+	for id, s := range bs.Sensors {
+		names = append(names, "sensors/"+id+"="+s.Name)
+	}
+	for id, s := range bs.Scenes {
+		names = append(names, "scenes/"+id+"="+s.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n")
+}
+
+// Load replaces everything the registry knows about c with its full state. Whatever changed since the last
+// time is handed to Events as if the eventstream of c had reported it.
+func (r *StateRegistry) Load(c *Bridge) error {
+	events, err := r.load(c)
+	if err != nil {
+		return err
+	}
+	Events.publish(c, events)
+	return nil
+}
+
+// load replaces everything the registry knows about c with its full state and returns what changed.
+func (r *StateRegistry) load(c *Bridge) ([]haptic.WrappedEvent, error) {
+	full, err := c.GetFullState()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(full)
+	if err != nil {
+		return nil, err
+	}
+	bs := &bridgeState{}
+	if err = json.Unmarshal(raw, bs); err != nil {
+		return nil, err
+	}
+	bs.updated = time.Now()
+	r.Lock()
+	old, known := r.bridges[c.bridgeID()]
+	r.bridges[c.bridgeID()] = bs
+	r.Unlock()
+	r.changed(!known || old.names() != bs.names())
+	if !known {
+		return nil, nil
+	}
+	return c.withV2IDs(diffStates(old, bs, bs.updated)), nil
+}
+
+// Updated returns when the registry last heard from c.
+func (r *StateRegistry) Updated(c *Bridge) time.Time {
+	r.RLock()
+	defer r.RUnlock()
+	if bs, ok := r.bridges[c.bridgeID()]; ok {
+		return bs.updated
+	}
+	return time.Time{}
+}
+
+// name returns the last known name of the v1 resource at idV1 on bridge c, e.g. /lights/1.
+func (r *StateRegistry) name(c *Bridge, idV1 string) string {
+	r.RLock()
+	defer r.RUnlock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		return ""
+	}
+	kind, id, _ := strings.Cut(strings.Trim(idV1, "/"), "/")
+	switch kind {
+	case "lights":
+		if l, ok := bs.Lights[id]; ok {
+			return l.Name
+		}
+	case "groups":
+		if g, ok := bs.Groups[id]; ok {
+			return g.Name
+		}
+	case "sensors":
+		if s, ok := bs.Sensors[id]; ok {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// groupLights returns the IDs of the lights in group id on bridge c.
+func (r *StateRegistry) groupLights(c *Bridge, id int) []string {
+	r.RLock()
+	defer r.RUnlock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		return nil
+	}
+	if g, ok := bs.Groups[strconv.Itoa(id)]; ok {
+		return append([]string(nil), g.Lights...)
+	}
+	return nil
+}
+
+func copyState(st *huego.State) *huego.State {
+	if st == nil {
+		return nil
+	}
+	cp := *st
+	cp.Xy = append([]float32(nil), st.Xy...)
+	return &cp
+}
+
+// LightState returns a copy of the last known state of light id on bridge c.
+func (r *StateRegistry) LightState(c *Bridge, id int) (*huego.State, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		return nil, false
+	}
+	l, ok := bs.Lights[strconv.Itoa(id)]
+	if !ok || l.State == nil {
+		return nil, false
+	}
+	return copyState(l.State), true
+}
+
+// GroupState returns a copy of the last known action and state of group id on bridge c.
+func (r *StateRegistry) GroupState(c *Bridge, id int) (*huego.State, *huego.GroupState, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		return nil, nil, false
+	}
+	g, ok := bs.Groups[strconv.Itoa(id)]
+	if !ok || g.State == nil {
+		return nil, nil, false
+	}
+	var gs *huego.GroupState
+	if g.GroupState != nil {
+		cp := *g.GroupState
+		gs = &cp
+	}
+	return copyState(g.State), gs, true
+}
+
+// SensorState returns a copy of the last known state of sensor id on bridge c.
+func (r *StateRegistry) SensorState(c *Bridge, id int) (map[string]interface{}, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		return nil, false
+	}
+	s, ok := bs.Sensors[strconv.Itoa(id)]
+	if !ok {
+		return nil, false
+	}
+	st := make(map[string]interface{}, len(s.State))
+	for k, v := range s.State {
+		st[k] = v
+	}
+	return st, true
+}
+
+// CurrentState returns the last known state of the light from the registry,
+// or the state we fetched it with if the registry doesn't know about it.
+func (hl *HueLight) CurrentState() *huego.State {
+	if st, ok := Registry.LightState(hl.controller, hl.ID); ok {
+		return st
+	}
+	hl.controller.RLock()
+	defer hl.controller.RUnlock()
+	if hl.State == nil {
+		return nil
+	}
+	st := *hl.State
+	return &st
+}
+
+// CurrentState returns the last known action of the group from the registry,
+// or the action we fetched it with if the registry doesn't know about it.
+func (hg *HueGroup) CurrentState() *huego.State {
+	if st, _, ok := Registry.GroupState(hg.controller, hg.ID); ok {
+		return st
+	}
+	hg.controller.RLock()
+	defer hg.controller.RUnlock()
+	if hg.State == nil {
+		return nil
+	}
+	st := *hg.State
+	return &st
+}
+
+// AnyOn reports whether any light in the group is on, from the registry if it knows about the group.
+func (hg *HueGroup) AnyOn() bool {
+	if _, gs, ok := Registry.GroupState(hg.controller, hg.ID); ok && gs != nil {
+		return gs.AnyOn
+	}
+	return hg.GroupState != nil && hg.GroupState.AnyOn
+}
+
+// apply applies a line from the CLIP v2 eventstream of c to the registry.
+func (r *StateRegistry) apply(c *Bridge, line string) error {
+	events, err := haptic.ParseEvents(line)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	return r.applyEvents(c, events)
+}
+
+// applyEvents applies a message from the CLIP v2 eventstream of c to the registry.
+func (r *StateRegistry) applyEvents(c *Bridge, events []haptic.WrappedEvent) error {
+	var structural, reload bool
+	r.Lock()
+	bs, ok := r.bridges[c.bridgeID()]
+	if !ok {
+		r.Unlock()
+		return r.Load(c)
+	}
+	for _, we := range events {
+		switch we.Type {
+		case "add", "delete":
+			reload = true
+			continue
+		case "update":
+		default:
+			continue
+		}
+		for _, ev := range we.Data {
+			if bs.applyEvent(ev) {
+				structural = true
+			}
+		}
+	}
+	bs.updated = time.Now()
+	r.Unlock()
+	if reload {
+		// the eventstream already told everyone about whatever was added or deleted.
+		_, err := r.load(c)
+		return err
+	}
+	r.changed(structural)
+	return nil
+}
+
+// applyEvent updates the state of the v1 resource that ev refers to, it returns true if a name changed.
+func (bs *bridgeState) applyEvent(ev haptic.Event) (renamed bool) {
+	parts := strings.Split(strings.Trim(ev.IdV1, "/"), "/")
+	if len(parts) != 2 {
+		return false
+	}
+	id := parts[1]
+	switch parts[0] {
+	case "lights":
+		l, ok := bs.Lights[id]
+		if !ok || l.State == nil {
+			return false
+		}
+		if ev.Type == "zigbee_connectivity" {
+			l.State.Reachable = ev.Status == "connected"
+			return false
+		}
+		applyLightEvent(l.State, ev)
+		if ev.Metadata != nil && ev.Metadata.Name != l.Name {
+			l.Name = ev.Metadata.Name
+			renamed = true
+		}
+	case "groups":
+		g, ok := bs.Groups[id]
+		if !ok {
+			return false
+		}
+		if ev.Metadata != nil && ev.Metadata.Name != g.Name {
+			g.Name = ev.Metadata.Name
+			renamed = true
+		}
+		if ev.Type != "grouped_light" || g.State == nil {
+			return
+		}
+		applyLightEvent(g.State, ev)
+		if ev.On != nil && g.GroupState != nil {
+			g.GroupState.AnyOn = ev.On.On
+			if !ev.On.On {
+				g.GroupState.AllOn = false
+			}
+		}
+	case "sensors":
+		s, ok := bs.Sensors[id]
+		if !ok {
+			return false
+		}
+		if s.State == nil {
+			s.State = make(map[string]interface{})
+		}
+		switch {
+		case ev.Motion != nil:
+			s.State["presence"] = ev.Motion.Motion
+		case ev.Temperature != nil:
+			s.State["temperature"] = math.Round(ev.Temperature.Temperature * 100)
+		case ev.Light != nil:
+			s.State["lightlevel"] = float64(ev.Light.LightLevel)
+		case ev.PowerState != nil:
+			if s.Config == nil {
+				s.Config = make(map[string]interface{})
+			}
+			s.Config["battery"] = float64(ev.PowerState.BatteryLevel)
+			return false
+		default:
+			return false
+		}
+		s.State["lastupdated"] = time.Now().UTC().Format("2006-01-02T15:04:05")
+	}
+	return
+}
+
+// applyLightEvent translates the CLIP v2 light attributes in ev to their v1 counterparts in st.
+func applyLightEvent(st *huego.State, ev haptic.Event) {
+	if ev.On != nil {
+		st.On = ev.On.On
+	}
+	if ev.Dimming != nil {
+		st.Bri = uint8(math.Max(1, math.Round(ev.Dimming.Brightness*254/100)))
+	}
+	if ev.Color != nil {
+		st.Xy = []float32{float32(ev.Color.Xy.X), float32(ev.Color.Xy.Y)}
+		st.ColorMode = "xy"
+	}
+	if ev.ColorTemperature != nil && ev.ColorTemperature.MirekValid {
+		if mirek, ok := ev.ColorTemperature.Mirek.(float64); ok {
+			st.Ct = uint16(mirek)
+			st.ColorMode = "ct"
+		}
+	}
+}
+
+// Watch keeps the registry current for c until ctx is done. Whenever the eventstream of c drops, c is polled
+// while we wait to reconnect, waiting twice as long each time it fails right away, up to EventstreamRetry.
+// Bridges configured to Poll are only ever polled.
+func (r *StateRegistry) Watch(ctx context.Context, c *Bridge) {
+	if c.Config().Poll {
+		for ctx.Err() == nil {
+			r.poll(ctx, c, EventstreamRetry)
+		}
+		return
+	}
+	backoff := EventstreamBackoff
+	for ctx.Err() == nil {
+		delivered := false
+		// made anew each time, c may have been relocated since.
+		err := r.follow(ctx, c, c.eventClient(), func() { delivered = true })
+		if ctx.Err() != nil {
+			return
+		}
+		if delivered {
+			backoff = EventstreamBackoff
+		}
+		log.Debug().Str("caller", c.bridgeID()).Err(err).Dur("retry", backoff).Msg("eventstream unavailable, polling instead")
+		r.poll(ctx, c, backoff)
+		if !delivered {
+			backoff *= 2
+			if backoff > EventstreamRetry {
+				backoff = EventstreamRetry
+			}
+		}
+	}
+}
+
+// WatchAll keeps the registry current for every bridge we're connected to until ctx is done,
+// including the ones connected to later on with Connect.
+func (r *StateRegistry) WatchAll(ctx context.Context) {
+	r.Lock()
+	r.watching = ctx
+	r.Unlock()
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, c := range Lucifer.Bridges {
+		r.watch(c)
+	}
+}
+
+// Watching reports whether WatchAll is keeping the registry current.
+func (r *StateRegistry) Watching() bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.watching != nil && r.watching.Err() == nil
+}
+
+// watch starts watching c if WatchAll was called and c isn't watched already.
+func (r *StateRegistry) watch(c *Bridge) {
+	r.Lock()
+	defer r.Unlock()
+	if r.watching == nil || r.watching.Err() != nil {
+		return
+	}
+	if _, ok := r.watches[c]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.watching)
+	r.watches[c] = cancel
+	go func() {
+		r.Watch(ctx, c)
+		r.unwatch(c)
+	}()
+}
+
+// unwatch stops watching c.
+func (r *StateRegistry) unwatch(c *Bridge) {
+	r.Lock()
+	defer r.Unlock()
+	if cancel, ok := r.watches[c]; ok {
+		cancel()
+		delete(r.watches, c)
+	}
+}
+
+// follow reloads c and then applies its eventstream to the registry, and hands it to Events, until the eventstream fails.
+func (r *StateRegistry) follow(ctx context.Context, c *Bridge, events *haptic.EventClient, delivered func()) error {
+	if err := r.Load(c); err != nil {
+		return err
+	}
+	c.RLock()
+	base, user := c.v2Base(), c.User
+	c.RUnlock()
+	err := events.Stream(ctx, base, user, func(we []haptic.WrappedEvent) {
+		delivered()
+		if err := r.applyEvents(c, we); err != nil {
+			log.Warn().Str("caller", c.bridgeID()).Err(err).Msg("failed to apply event")
+		}
+		Events.publish(c, we)
+	})
+	if err == nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// poll reloads c right away and then every PollInterval for the given duration.
+func (r *StateRegistry) poll(ctx context.Context, c *Bridge, d time.Duration) {
+	// the first backoffs are shorter than PollInterval, without this they would never poll at all.
+	if err := r.Load(c); err != nil {
+		log.Debug().Str("caller", c.bridgeID()).Err(err).Msg("failed to poll bridge")
+	}
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(d)
+	defer deadline.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			return
+		case <-ticker.C:
+			if err := r.Load(c); err != nil {
+				log.Debug().Str("caller", c.bridgeID()).Err(err).Msg("failed to poll bridge")
+			}
+		}
+	}
+}
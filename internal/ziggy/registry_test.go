@@ -0,0 +1,182 @@
+package ziggy
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/yunginnanet/huego"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+func TestRegistryLoad(t *testing.T) {
+	fb, c := newTestBridge(t)
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 100.0})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	st, ok := Registry.LightState(c, 1)
+	if !ok {
+		t.Fatal("light 1 missing from registry")
+	}
+	if !st.On || st.Bri != 100 {
+		t.Errorf("unexpected state: %+v", st)
+	}
+	st.Bri = 1
+	if again, _ := Registry.LightState(c, 1); again.Bri != 100 {
+		t.Error("registry handed out its own state instead of a copy")
+	}
+	if _, gs, ok := Registry.GroupState(c, 1); !ok || !gs.AnyOn || gs.AllOn {
+		t.Errorf("unexpected group state: %+v", gs)
+	}
+	if ss, ok := Registry.SensorState(c, 1); !ok || ss["presence"] != false {
+		t.Errorf("unexpected sensor state: %v", ss)
+	}
+	if Registry.Updated(c).IsZero() {
+		t.Error("registry did not record when it was updated")
+	}
+}
+
+func TestRegistryEvents(t *testing.T) {
+	_, c := newTestBridge(t)
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	Registry.OnChange(func() { changes++ })
+	t.Cleanup(func() {
+		Registry.Lock()
+		Registry.hooks = nil
+		Registry.Unlock()
+	})
+
+	for _, line := range []string{
+		": hi",
+		"id: 1680000000:0",
+		`data: [{"creationtime":"2023-03-28T00:00:00Z","id":"a","type":"update","data":[` +
+			`{"id":"x","id_v1":"/lights/1","type":"light","on":{"on":true},"dimming":{"brightness":50.0}},` +
+			`{"id":"y","id_v1":"/lights/2","type":"light","color_temperature":{"mirek":250,"mirek_valid":true}},` +
+			`{"id":"z","id_v1":"/groups/1","type":"grouped_light","on":{"on":true}},` +
+			`{"id":"m","id_v1":"/sensors/1","type":"motion","motion":{"motion":true,"motion_valid":true}}]}]`,
+	} {
+		if err := Registry.apply(c, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changes != 1 {
+		t.Errorf("expected 1 change, got %d", changes)
+	}
+	hl := &HueLight{Light: &huego.Light{ID: 1}, controller: c}
+	if st := hl.CurrentState(); !st.On || st.Bri != 127 {
+		t.Errorf("light 1: unexpected state %+v", st)
+	}
+	if st, _ := Registry.LightState(c, 2); st.Ct != 250 || st.ColorMode != "ct" {
+		t.Errorf("light 2: unexpected state %+v", st)
+	}
+	if st, gs, _ := Registry.GroupState(c, 1); !st.On || !gs.AnyOn {
+		t.Errorf("group 1: unexpected state %+v %+v", st, gs)
+	}
+	if ss, _ := Registry.SensorState(c, 1); ss["presence"] != true {
+		t.Errorf("sensor 1: unexpected state %v", ss)
+	}
+
+	GetLightMap()
+	rename := `data: [{"creationtime":"2023-03-28T00:00:01Z","id":"b","type":"update","data":[` +
+		`{"id":"x","id_v1":"/lights/1","type":"light","metadata":{"name":"bulb"}}]}]`
+	if err := Registry.apply(c, rename); err != nil {
+		t.Fatal(err)
+	}
+	mapMu.Lock()
+	stale := !lightsFresh
+	mapMu.Unlock()
+	if !stale {
+		t.Error("renaming a light should mark the name maps as stale")
+	}
+	if err := Registry.apply(c, "data: [{"); err == nil {
+		t.Error("expected an error for a malformed event")
+	}
+}
+
+func TestRegistryPolling(t *testing.T) {
+	fb, c := newTestBridge(t)
+	oldInterval := PollInterval
+	PollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { PollInterval = oldInterval })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Registry.Watch(ctx, c)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// the fake bridge has no eventstream, so the registry has to notice this by polling.
+	fb.SetLightState("2", map[string]interface{}{"on": true, "bri": 42.0})
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st, ok := Registry.LightState(c, 2); ok && st.On && st.Bri == 42 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("registry never picked up the change")
+}
+
+func TestRegistryPollShortWindow(t *testing.T) {
+	fb, c := newTestBridge(t)
+	oldInterval := PollInterval
+	PollInterval = time.Hour
+	t.Cleanup(func() { PollInterval = oldInterval })
+
+	// backoffs shorter than PollInterval still poll once.
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 7.0})
+	Registry.poll(context.Background(), c, 10*time.Millisecond)
+	if st, ok := Registry.LightState(c, 1); !ok || !st.On || st.Bri != 7 {
+		t.Errorf("a short poll window should still poll, got %+v", st)
+	}
+}
+
+func TestRegistryWatchConnected(t *testing.T) {
+	newTestBridge(t)
+	oldInterval := PollInterval
+	PollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { PollInterval = oldInterval })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	Registry.WatchAll(ctx)
+
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	couch := fb.AddLight("couch")
+	c, err := Connect(config.KnownBridge{Hostname: fb.Hostname(), Username: fb.NewUser("ziggs#test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.SetLightState(couch, map[string]interface{}{"on": true, "bri": 42.0})
+	id, _ := strconv.Atoi(couch)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, ok := Registry.LightState(c, id); ok && st.On && st.Bri == 42 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("a bridge connected after WatchAll was never watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Disconnect(c)
+	Registry.RLock()
+	_, watched := Registry.watches[c]
+	Registry.RUnlock()
+	if watched {
+		t.Error("a disconnected bridge should not be watched")
+	}
+}
@@ -0,0 +1,178 @@
+package ziggy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+var (
+	relocateHooks []func(oldKey, newKey string)
+	relocateMu    = &sync.Mutex{}
+)
+
+// OnRelocate registers f to be called with the old and the new key of a bridge in Lucifer whenever it was found at a new address.
+func OnRelocate(f func(oldKey, newKey string)) {
+	relocateMu.Lock()
+	relocateHooks = append(relocateHooks, f)
+	relocateMu.Unlock()
+}
+
+func relocated(oldKey, newKey string) {
+	relocateMu.Lock()
+	hooks := relocateHooks
+	relocateMu.Unlock()
+	for _, f := range hooks {
+		f(oldKey, newKey)
+	}
+}
+
+// idFromMac derives a bridge ID from its MAC address, bridges insert FFFE in the middle of it.
+func idFromMac(mac string) string {
+	mac = strings.ToUpper(strings.NewReplacer(":", "", "-", "").Replace(mac))
+	if len(mac) != 12 {
+		return ""
+	}
+	return mac[:6] + "FFFE" + mac[6:]
+}
+
+// knownID returns the ID of the bridge in kb, or an empty string if we never connected to it.
+func knownID(kb config.KnownBridge) string {
+	if kb.ID != "" {
+		return strings.ToUpper(kb.ID)
+	}
+	return idFromMac(kb.Mac)
+}
+
+// isBridge reports whether c is the bridge in kb, bridges we know nothing about are given the benefit of the doubt.
+func isBridge(c *Bridge, kb config.KnownBridge) bool {
+	id := knownID(kb)
+	return id == "" || c.Info == nil || strings.EqualFold(c.Info.BridgeID, id)
+}
+
+// locate looks for the bridge with the given ID on the LAN, first with mDNS and SSDP, then by
+// sweeping the networks of every interface.
+func locate(ctx context.Context, id string) (DiscoveredBridge, error) {
+	find := func(found []DiscoveredBridge) (DiscoveredBridge, bool) {
+		for _, br := range found {
+			if strings.EqualFold(br.ID, id) {
+				return br, true
+			}
+		}
+		return DiscoveredBridge{}, false
+	}
+	found, err := Discover(ctx)
+	if br, ok := find(found); ok {
+		return br, nil
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("discovery failed")
+	}
+	if found, err = Scan(ctx, ScanOptions{Targets: []string{"all"}}); err != nil {
+		return DiscoveredBridge{}, err
+	}
+	if br, ok := find(found); ok {
+		return br, nil
+	}
+	return DiscoveredBridge{}, fmt.Errorf("bridge %s not found on the LAN", id)
+}
+
+// connectMoved finds the bridge in kb at its new address and connects to it with the username we already have.
+// On success kb and our config point to the new address.
+func connectMoved(ctx context.Context, kb *config.KnownBridge) (*Bridge, error) {
+	id := knownID(*kb)
+	if id == "" {
+		return nil, fmt.Errorf("%s: bridge ID unknown, can't look for it", kb.Hostname)
+	}
+	log.Info().Str("caller", id).Msgf("%s isn't answering, looking for the bridge elsewhere...", kb.Hostname)
+	br, err := locate(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	old := kb.Hostname
+	kb.Hostname = br.URL()
+	c, err := newController(kb)
+	if err != nil {
+		return nil, err
+	}
+	if !isBridge(c, *kb) {
+		return nil, fmt.Errorf("%s is not bridge %s", br.Host, id)
+	}
+	remember(c)
+	log.Info().Str("caller", id).Str("was", old).Msgf("bridge moved to %s", kb.Hostname)
+	return c, nil
+}
+
+// knownIndex returns where in our config the bridge in kb is, by ID first since its address may have changed.
+func knownIndex(kb config.KnownBridge) int {
+	if id := knownID(kb); id != "" {
+		for i, known := range config.KnownBridges {
+			if knownID(known) == id {
+				return i
+			}
+		}
+	}
+	return config.KnownBridgeIndex(kb.Hostname)
+}
+
+// remember records the ID, MAC and address of the bridge c in our config, so we can find it again if it moves,
+// and the key it is pinned to if we talk to it over https.
+func remember(c *Bridge) {
+	c.Lock()
+	if c.config == nil || c.Info == nil {
+		c.Unlock()
+		return
+	}
+	c.config.ID, c.config.Mac = strings.ToUpper(c.Info.BridgeID), c.Info.Mac
+	kb := *c.config
+	c.Unlock()
+	i := knownIndex(kb)
+	if i < 0 {
+		return
+	}
+	known := config.KnownBridges[i]
+	if known.Hostname == kb.Hostname && known.ID == kb.ID && known.Mac == kb.Mac && known.Fingerprint == kb.Fingerprint {
+		return
+	}
+	config.KnownBridges[i].Hostname, config.KnownBridges[i].ID, config.KnownBridges[i].Mac = kb.Hostname, kb.ID, kb.Mac
+	config.KnownBridges[i].Fingerprint = kb.Fingerprint
+	if err := config.SaveKnownBridges(); err != nil {
+		log.Warn().Err(err).Msg("failed to write config")
+	}
+}
+
+// relocate looks for c at a new address after it went offline, and points c there if it finds it.
+func (c *Bridge) relocate(ctx context.Context) error {
+	kb := c.Config()
+	if knownID(kb) == "" {
+		kb.ID = c.bridgeID()
+	}
+	moved, err := connectMoved(ctx, &kb)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	oldKey := ""
+	if c.Info != nil {
+		oldKey = c.Info.IPAddress
+	}
+	c.config = &kb
+	c.Info = moved.Info
+	// everything that talks to the bridge was made for its old address, start over with the ones made for the new one.
+	c.Bridge = moved.Bridge
+	c.client = moved.client
+	c.v2 = nil
+	c.Unlock()
+	Lucifer.Lock()
+	if Lucifer.Bridges[oldKey] == c {
+		delete(Lucifer.Bridges, oldKey)
+	}
+	Lucifer.Bridges[moved.Info.IPAddress] = c
+	Lucifer.Unlock()
+	relocated(oldKey, moved.Info.IPAddress)
+	NeedsUpdate()
+	return nil
+}
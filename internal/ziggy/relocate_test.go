@@ -0,0 +1,107 @@
+package ziggy
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+// answerMDNS makes fb the only bridge that answers mDNS and SSDP queries.
+func answerMDNS(t *testing.T, fb *fakebridge.Bridge) {
+	t.Helper()
+	oldMDNS, oldSSDP, oldTimeout := MDNSAddr, SSDPAddr, DiscoveryTimeout
+	t.Cleanup(func() { MDNSAddr, SSDPAddr, DiscoveryTimeout = oldMDNS, oldSSDP, oldTimeout })
+	DiscoveryTimeout = 300 * time.Millisecond
+	port := uint16(fb.Listener.Addr().(*net.TCPAddr).Port)
+	MDNSAddr = responder(t, func(query []byte) [][]byte {
+		var p dnsmessage.Parser
+		if _, err := p.Start(query); err != nil {
+			return nil
+		}
+		return [][]byte{mdnsAnswer(t, "Philips Hue - "+fb.ID[10:], port)}
+	})
+	SSDPAddr = responder(t, func([]byte) [][]byte { return nil })
+}
+
+func TestRelocate(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.AddLight("lamp")
+	answerMDNS(t, fb)
+	oldConfig, oldKnown := config.Snek.ConfigFileUsed(), config.KnownBridges
+	config.Snek.SetConfigFile(filepath.Join(t.TempDir(), "config.toml"))
+	t.Cleanup(func() {
+		config.Snek.SetConfigFile(oldConfig)
+		config.KnownBridges = oldKnown
+	})
+
+	// nothing listens on port 1, as if the bridge got a new address from DHCP.
+	user := fb.NewUser("ziggs#test")
+	config.KnownBridges = []config.KnownBridge{
+		{Hostname: "http://127.0.0.1:1", Username: user, Alias: "moved", Mac: fb.Mac},
+		{Hostname: "http://127.0.0.1:2", Username: user},
+	}
+	found := GetControllers(config.KnownBridges)
+	if len(found) != 1 || found[0].Info.BridgeID != fb.ID {
+		t.Fatalf("expected to find the bridge that moved, got %d bridges", len(found))
+	}
+	kb := config.KnownBridges[0]
+	if kb.Hostname != fb.Hostname() || kb.ID != fb.ID || kb.Username != user || kb.Alias != "moved" {
+		t.Errorf("config wasn't updated with the new address: %+v", kb)
+	}
+	if config.KnownBridges[1].Hostname != "http://127.0.0.1:2" || config.KnownBridges[1].ID != "" {
+		t.Errorf("bridge without an ID shouldn't change: %+v", config.KnownBridges[1])
+	}
+
+	// the same thing while we're connected to it.
+	c := found[0]
+	c.Host = "http://127.0.0.1:1"
+	info := *c.Info
+	info.IPAddress = "127.0.0.1:1"
+	c.Info = &info
+	Lucifer.Lock()
+	Lucifer.Bridges = map[string]*Bridge{"127.0.0.1:1": c}
+	Lucifer.Unlock()
+	t.Cleanup(func() {
+		Lucifer.Lock()
+		Lucifer.Bridges = make(map[string]*Bridge)
+		Lucifer.Unlock()
+	})
+	var moves []string
+	OnRelocate(func(oldKey, newKey string) { moves = append(moves, oldKey+" "+newKey) })
+	stale := c.v2cache()
+	if err := c.relocate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(moves) != 1 || moves[0] != "127.0.0.1:1 "+fb.IPAddress() {
+		t.Errorf("relocation hooks weren't told about the new address: %v", moves)
+	}
+	if c.v2cache() == stale {
+		t.Error("v2 client still points at the old address")
+	}
+	if c.Host != fb.Hostname() || c.Config().Alias != "moved" {
+		t.Errorf("bridge wasn't pointed at its new address: %s %+v", c.Host, c.Config())
+	}
+	if b, ok := FindBridge(fb.IPAddress()); !ok || b != c {
+		t.Error("bridge should be known by its new address")
+	}
+	if _, err := c.GetConfig(); err != nil {
+		t.Errorf("relocated bridge doesn't answer: %v", err)
+	}
+}
+
+func TestIDFromMac(t *testing.T) {
+	if id := idFromMac("00:17:88:01:02:0a"); id != "001788FFFE01020A" {
+		t.Errorf("unexpected ID %s", id)
+	}
+	if id := idFromMac("nope"); id != "" {
+		t.Errorf("expected no ID, got %s", id)
+	}
+}
@@ -3,20 +3,21 @@ package ziggy
 import (
 	"context"
 	"errors"
-	"io"
+	"fmt"
 	"net"
-	"net/http"
 	"net/netip"
-	"strings"
+	"os"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	tui "github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
 	"github.com/yunginnanet/huego"
 	"go4.org/netipx"
 
 	"git.tcp.direct/kayos/ziggs/internal/common"
+	"git.tcp.direct/kayos/ziggs/internal/config"
 )
 
 func filterCandidateInterfaces(interfaces []net.Interface) []net.Interface {
@@ -51,41 +52,154 @@ addrIter:
 	return candidates
 }
 
-func enumerateBridge(a netip.Addr, ctx context.Context) interface{} {
-	var err error
-	if _, err = net.DialTimeout("tcp", a.String()+":80", 2*time.Second); err != nil {
-		select {
-		case <-ctx.Done():
-			//
-		default:
-			log.Debug().Err(err).Msgf("failed to dial %s", a.String())
+// ScanOptions controls a search of the network for bridges.
+type ScanOptions struct {
+	// Targets are CIDRs or interface names to sweep, or "all" for every interface that looks like a LAN.
+	// Interfaces with IPv6 link-local addresses are also asked over mDNS and SSDP on that link.
+	// Without targets only mDNS and SSDP are used.
+	Targets []string
+	// Concurrency is how many addresses are tried at once, 25 by default.
+	Concurrency int
+	// Timeout is how long each address, and mDNS and SSDP as a whole, get to answer. DiscoveryTimeout by default.
+	Timeout time.Duration
+	// Port is where bridges are expected to serve the v1 API, 80 by default.
+	Port int
+}
+
+// maxScanBits caps how many addresses a single target may expand to, 2^16.
+const maxScanBits = 16
+
+// scanTargets resolves the targets of a scan to prefixes to sweep and interfaces to ask on.
+func scanTargets(targets []string) (prefixes []netip.Prefix, links []net.Interface, err error) {
+	var ifaces []net.Interface
+	for _, target := range targets {
+		if target == "all" {
+			var all []net.Interface
+			if all, err = net.Interfaces(); err != nil {
+				return nil, nil, err
+			}
+			ifaces = append(ifaces, filterCandidateInterfaces(all)...)
+			continue
+		}
+		if p, perr := netip.ParsePrefix(target); perr == nil {
+			if p.Addr().BitLen()-p.Bits() > maxScanBits {
+				return nil, nil, fmt.Errorf("%s is too big to scan", target)
+			}
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		if a, perr := netip.ParseAddr(target); perr == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		iface, ierr := net.InterfaceByName(target)
+		if ierr != nil {
+			return nil, nil, fmt.Errorf("%s is not a CIDR, address or interface", target)
+		}
+		ifaces = append(ifaces, *iface)
+	}
+	for _, iface := range ifaces {
+		addrs, aerr := iface.Addrs()
+		if aerr != nil {
+			log.Debug().Err(aerr).Msgf("failed to get addresses of %s", iface.Name)
+			continue
+		}
+		for _, a := range addrs {
+			ipn, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, _ := netipx.FromStdIP(ipn.IP)
+			ones, _ := ipn.Mask.Size()
+			switch {
+			case addr.Is6() && addr.IsLinkLocalUnicast():
+				links = append(links, iface)
+			case addr.BitLen()-ones > maxScanBits:
+				log.Debug().Msgf("skipping %s on %s, too big to scan", ipn, iface.Name)
+			default:
+				prefixes = append(prefixes, netip.PrefixFrom(addr, ones).Masked())
+			}
+		}
+	}
+	return prefixes, links, nil
+}
+
+// sweepFinder tries to connect to every address in prefixes, reporting those that accept.
+func sweepFinder(prefixes []netip.Prefix, opts ScanOptions) finder {
+	return func(ctx context.Context, hits chan<- discoveryHit) error {
+		var (
+			wg  sync.WaitGroup
+			sem = make(chan struct{}, opts.Concurrency)
+		)
+		defer wg.Wait()
+		for _, p := range prefixes {
+			last := netipx.PrefixLastIP(p)
+			for a := p.Addr(); p.Contains(a); a = a.Next() {
+				// skip the network and broadcast addresses of IPv4 subnets.
+				if a.Is4() && p.Bits() < 31 && (a == p.Addr() || a == last) {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case sem <- struct{}{}:
+				}
+				wg.Add(1)
+				go func(a netip.Addr) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+					host := hostPort(a.String(), opts.Port)
+					d := net.Dialer{Timeout: opts.Timeout}
+					conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(a.String(), strconv.Itoa(opts.Port)))
+					if err != nil {
+						return
+					}
+					_ = conn.Close()
+					log.Trace().Msgf("%s is listening", host)
+					hits <- discoveryHit{host: host, via: "scan"}
+				}(a)
+			}
 		}
 		return nil
 	}
-	var resp *http.Response
-	c := http.DefaultClient
-	c.Timeout = 2 * time.Second
-	resp, err = c.Get("http://" + a.String() + "/api/config")
+}
+
+// Scan looks for bridges with mDNS and SSDP, and sweeps the given targets at the same time.
+// Unlike the interactive scan it doesn't stop at the first bridge it finds.
+func Scan(ctx context.Context, opts ScanOptions) ([]DiscoveredBridge, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 25
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DiscoveryTimeout
+	}
+	if opts.Port == 0 {
+		opts.Port = 80
+	}
+	prefixes, links, err := scanTargets(opts.Targets)
 	if err != nil {
-		log.Debug().Err(err).Msgf("failed to get %s", a.String())
-		return nil
+		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		log.Debug().Msgf("%s returned %d", a.String(), resp.StatusCode)
-		return nil
+	multicast := func(find finder) finder {
+		return func(ctx context.Context, hits chan<- discoveryHit) error {
+			ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+			defer cancel()
+			return find(ctx, hits)
+		}
 	}
-	var ret []byte
-	if ret, err = io.ReadAll(resp.Body); err != nil {
-		log.Warn().Err(err).Msg("failed to read response")
-		return nil
+	finders := []finder{multicast(mdnsFinder(MDNSAddr)), multicast(ssdpFinder(SSDPAddr))}
+	for _, link := range links {
+		finders = append(finders,
+			multicast(mdnsFinder("[ff02::fb%"+link.Name+"]:5353")),
+			multicast(ssdpFinder("[ff02::c%"+link.Name+"]:1900")),
+		)
 	}
-	if !strings.Contains(string(ret), "factorynew") || !strings.Contains(string(ret), "bridgeid") {
-		log.Debug().Msgf("%s does not appear to be a hue bridge", a.String())
-		return nil
+	if len(prefixes) > 0 {
+		finders = append(finders, sweepFinder(prefixes, opts))
 	}
-
-	br, _ := huego.NewCustom(ret, a.String(), http.DefaultClient)
-	return br
+	return collect(ctx, opts.Timeout, finders...)
 }
 
 func scanChoicePrompt(interfaces []net.Interface) net.Interface {
@@ -106,41 +220,8 @@ func scanChoicePrompt(interfaces []net.Interface) net.Interface {
 	return interfaces[ifaceMap[choice]]
 }
 
-func checkAddrs(ctx context.Context, addrs []net.Addr, working *int32, resChan chan interface{}) {
-	var init = &sync.Once{}
-	log.Trace().Msg("checking addresses")
-	for _, a := range addrs {
-		log.Trace().Caller().Msgf("checking %s", a.String())
-		rng := netipx.MustParseIPRange(a.String())
-		for ipa := rng.From(); ipa != rng.To(); ipa = ipa.Next() {
-			init.Do(func() { resChan <- &huego.Bridge{} })
-		ctxLoop:
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					if atomic.LoadInt32(working) > 25 {
-						time.Sleep(100 * time.Millisecond)
-						continue
-					}
-					break ctxLoop
-				}
-			}
-			log.Trace().Caller().Msgf("checking %s", ipa.String())
-			atomic.AddInt32(working, 1)
-			go func(ip netip.Addr) {
-				resChan <- enumerateBridge(ip, ctx)
-				time.Sleep(100 * time.Millisecond)
-				atomic.AddInt32(working, -1)
-			}(ipa)
-		}
-	}
-}
-
 // Determine the LAN network, then look for http servers on all of the local IPs.
 func scanForBridges() ([]*huego.Bridge, error) {
-	var hueIPs []*huego.Bridge
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return nil, err
@@ -150,48 +231,48 @@ func scanForBridges() ([]*huego.Bridge, error) {
 		return nil, errors.New("no viable interfaces found")
 	}
 	chosen := scanChoicePrompt(interfaces)
-	var addrs []net.Addr
-	if addrs, err = chosen.Addrs(); err != nil {
-		log.Debug().Err(err).Msg("failed to get addresses")
+	found, err := Scan(context.Background(), ScanOptions{Targets: []string{chosen.Name}})
+	if err != nil {
 		return nil, err
 	}
-	var working int32
-	resChan := make(chan interface{}, 55)
-	ctx, cancel := context.WithCancel(context.Background())
-	log.Trace().Interface("addresses", addrs).Msg("checkAddrs()")
-	go checkAddrs(ctx, addrs, &working, resChan)
-	<-resChan // wait for sync.Once to throw us a nil
-
-resultLoop:
-	for {
-		select {
-		case res := <-resChan:
-			bridge, ok := res.(*huego.Bridge)
-			if ok && bridge != nil {
-				log.Info().Msgf("found %T: %v", bridge, bridge)
-				hueIPs = append(hueIPs, bridge)
-				cancel()
-				atomic.StoreInt32(&working, 0)
-			}
-		case <-ctx.Done():
-			cancel()
-			break resultLoop
-		default:
-			if atomic.LoadInt32(&working) <= 0 {
-				cancel()
-				break resultLoop
-			}
-		}
+	if len(found) == 0 {
+		return nil, errors.New("no bridges found")
 	}
+	return hueBridges(found), nil
+}
 
-	if len(hueIPs) == 0 {
-		return nil, errors.New("no bridges found")
+// hueBridges logs what was found and turns it into bridges we can log in to.
+func hueBridges(found []DiscoveredBridge) []*huego.Bridge {
+	var bridges []*huego.Bridge
+	for _, br := range found {
+		log.Info().Str("caller", br.Host).Str("id", br.ID).Str("model", br.Model).
+			Strs("via", br.Via).Msgf("found %s", br.Name)
+		h, _, _ := newHTTPClient(config.KnownBridge{Hostname: br.URL(), ID: br.ID})
+		bridges = append(bridges, huego.NewWithClient(br.URL(), "", h))
 	}
+	return bridges
+}
 
-	return hueIPs, nil
+// findBridges looks for bridges with mDNS and SSDP first, and only falls back to port scanning when neither finds any.
+func findBridges() ([]*huego.Bridge, error) {
+	found, err := Discover(context.Background())
+	if err != nil {
+		log.Debug().Err(err).Msg("mDNS/SSDP discovery failed")
+	}
+	if len(found) == 0 {
+		log.Info().Msg("no bridges answered mDNS or SSDP, scanning the network instead...")
+		return scanForBridges()
+	}
+	return hueBridges(found), nil
 }
 
-func promptForDiscovery() error {
+// interactive reports whether someone is at the terminal to answer our prompts, it is replaced in tests.
+var interactive = func() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
+
+// promptForDiscovery searches for bridges, asks how to log in to each of them and connects to the ones we could log in to.
+func promptForDiscovery() ([]*Bridge, error) {
 	log.Warn().Msg("failed to connect to known bridges from configuration file.")
 	confirmPrompt := tui.Select{
 		Label:     "Search for bridges?",
@@ -202,35 +283,40 @@ func promptForDiscovery() error {
 	}
 	choice, _, _ := confirmPrompt.Run()
 	if choice != 0 {
-		return errNoBridges
+		return nil, errNoBridges
 	}
 	log.Info().Msg("searching for bridges...")
-	bridges, err := scanForBridges()
+	bridges, err := findBridges()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if len(bridges) < 1 {
-		return errNoBridges
+		return nil, errNoBridges
 	}
 	var cs []*huego.Bridge
 	for _, brd := range bridges {
 		cs = append(cs, brd)
 	}
 
+	var connected []*Bridge
 	for _, c := range cs {
 		cnt := &Bridge{
 			Bridge:  c,
 			RWMutex: &sync.RWMutex{},
 		}
-		if promptForUser(cnt) {
-			log.Info().Str("caller", cnt.Host).Msg("login sucessful!")
-			if err = getBridgeInfo(cnt); err != nil {
-				return err
-			}
+		if !promptForUser(cnt) {
+			continue
+		}
+		log.Info().Str("caller", cnt.Host).Msg("login sucessful!")
+		br, err := connectDiscovered(cnt.Host, cnt.User)
+		if err != nil {
+			log.Error().Str("caller", cnt.Host).Err(err).Msg("unsuccessful connection")
+			continue
 		}
-		Lucifer.Lock()
-		Lucifer.Bridges[cnt.Info.IPAddress] = cnt
-		Lucifer.Unlock()
+		connected = append(connected, br)
+	}
+	if len(connected) < 1 {
+		return nil, errNoBridges
 	}
-	return nil
+	return connected, nil
 }
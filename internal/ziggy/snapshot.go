@@ -0,0 +1,188 @@
+package ziggy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yunginnanet/huego"
+)
+
+// SnapshotLight is the state of a single light when a Snapshot was taken.
+type SnapshotLight struct {
+	Bridge string      `json:"bridge"`
+	ID     int         `json:"id"`
+	Name   string      `json:"name"`
+	State  huego.State `json:"state"`
+}
+
+// Snapshot records the state of lights so that it can be put back later, e.g. after flashing them.
+// Groups and bridges are recorded light by light, which is what lets us restore them exactly.
+type Snapshot struct {
+	Name  string    `json:"name"`
+	Taken time.Time `json:"taken"`
+	// Targets describes what was captured, e.g. "group office".
+	Targets []string        `json:"targets"`
+	Lights  []SnapshotLight `json:"lights"`
+}
+
+// NewSnapshot returns an empty Snapshot.
+func NewSnapshot(name string) *Snapshot {
+	return &Snapshot{Name: name, Taken: time.Now()}
+}
+
+func (s *Snapshot) add(c *Bridge, l *huego.Light) {
+	entry := SnapshotLight{Bridge: c.bridgeID(), ID: l.ID, Name: l.Name}
+	switch st, ok := Registry.LightState(c, l.ID); {
+	case ok:
+		entry.State = *st
+	case l.State != nil:
+		entry.State = *l.State
+	}
+	for i, existing := range s.Lights {
+		if existing.Bridge == entry.Bridge && existing.ID == entry.ID {
+			s.Lights[i] = entry
+			return
+		}
+	}
+	s.Lights = append(s.Lights, entry)
+}
+
+// AddLight records the state of a light.
+func (s *Snapshot) AddLight(hl *HueLight) {
+	s.add(hl.controller, hl.Light)
+	s.Targets = append(s.Targets, "light "+hl.controller.QualifiedName(hl.Name))
+}
+
+// AddGroup records the state of every light in a group.
+func (s *Snapshot) AddGroup(hg *HueGroup) error {
+	if err := s.addGroupLights(hg); err != nil {
+		return err
+	}
+	s.Targets = append(s.Targets, "group "+hg.controller.QualifiedName(hg.Name))
+	return nil
+}
+
+func (s *Snapshot) addGroupLights(hg *HueGroup) error {
+	lights, err := hg.controller.GetLights()
+	if err != nil {
+		return fmt.Errorf("failed to get lights of group %s: %w", hg.Name, err)
+	}
+	members := make(map[string]bool)
+	for _, id := range hg.Lights {
+		members[id] = true
+	}
+	for i := range lights {
+		// group 0 holds every light, but doesn't list them.
+		if hg.ID == 0 || members[fmt.Sprint(lights[i].ID)] {
+			s.add(hg.controller, &lights[i])
+		}
+	}
+	return nil
+}
+
+// AddMultiplex records the state of every light in a Multiplex, including the lights of its groups.
+func (s *Snapshot) AddMultiplex(mx *Multiplex) error {
+	for _, hl := range mx.Lights() {
+		s.add(hl.controller, hl.Light)
+	}
+	for _, hg := range mx.Groups() {
+		if err := s.addGroupLights(hg); err != nil {
+			return err
+		}
+	}
+	s.Targets = append(s.Targets, "group "+mx.Name)
+	return nil
+}
+
+// AddBridge records the state of every light on a bridge.
+func (s *Snapshot) AddBridge(c *Bridge) error {
+	lights, err := c.GetLights()
+	if err != nil {
+		return fmt.Errorf("failed to get lights of bridge %s: %w", c.bridgeID(), err)
+	}
+	for i := range lights {
+		s.add(c, &lights[i])
+	}
+	s.Targets = append(s.Targets, "bridge "+c.bridgeID())
+	return nil
+}
+
+// restoreState returns the change that puts a light back into st. Only the attributes of its colormode are sent,
+// as the others are stale and sending them would switch the light to a different mode.
+// Lights that were off get their attributes back too, see restoreLight.
+func restoreState(st huego.State) huego.State {
+	ret := huego.State{On: st.On, Bri: st.Bri, Effect: st.Effect}
+	switch st.ColorMode {
+	case "xy":
+		ret.Xy = st.Xy
+	case "ct":
+		ret.Ct = st.Ct
+	case "hs":
+		ret.Hue, ret.Sat = st.Hue, st.Sat
+		// zero values are left out of requests, but these wrap around and clamp respectively.
+		if ret.Hue == 0 {
+			ret.Hue = 65535
+		}
+		if ret.Sat == 0 {
+			ret.SatInc = -254
+		}
+	}
+	if ret.Bri == 0 {
+		ret.BriInc = -254
+	}
+	return ret
+}
+
+// restoreLight applies change to hl. A light has to be on for anything else to be changed, so lights that
+// were off are turned on to get their attributes back and then turned off, so that they come back exactly
+// the way they were the next time they are turned on.
+func restoreLight(hl *HueLight, change huego.State) error {
+	if change.On {
+		return hl.SetState(change)
+	}
+	change.On = true
+	if err := hl.SetState(change); err != nil {
+		return err
+	}
+	return hl.Off()
+}
+
+func bridgeByID(id string) (*Bridge, bool) {
+	Lucifer.RLock()
+	defer Lucifer.RUnlock()
+	for _, c := range Lucifer.Bridges {
+		if strings.EqualFold(c.bridgeID(), id) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Restore puts every light in the snapshot back the way it was, the lights are updated concurrently.
+func (s *Snapshot) Restore() error {
+	if len(s.Lights) == 0 {
+		return fmt.Errorf("snapshot %s is empty", s.Name)
+	}
+	errs := make([]error, len(s.Lights))
+	wg := &sync.WaitGroup{}
+	for i, entry := range s.Lights {
+		c, ok := bridgeByID(entry.Bridge)
+		if !ok {
+			errs[i] = fmt.Errorf("light %s: bridge %s is not connected", entry.Name, entry.Bridge)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, entry SnapshotLight) {
+			defer wg.Done()
+			hl := &HueLight{Light: &huego.Light{ID: entry.ID, Name: entry.Name, State: &huego.State{}}, controller: c}
+			if err := restoreLight(hl, restoreState(entry.State)); err != nil {
+				errs[i] = fmt.Errorf("light %s on bridge %s: %w", entry.Name, entry.Bridge, err)
+			}
+		}(i, entry)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
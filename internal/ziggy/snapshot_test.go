@@ -0,0 +1,85 @@
+package ziggy
+
+import (
+	"testing"
+
+	"github.com/yunginnanet/huego"
+)
+
+func TestSnapshot(t *testing.T) {
+	fb, c := newTestBridge(t)
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 100.0, "ct": 300.0, "colormode": "ct"})
+	fb.SetLightState("2", map[string]interface{}{"on": true, "bri": 50.0, "hue": 0.0, "sat": 0.0, "colormode": "hs"})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	office, err := c.FindGroup("office")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap := NewSnapshot("before")
+	if err := snap.AddGroup(office); err != nil {
+		t.Fatal(err)
+	}
+	lamp, err := c.FindLight("lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.AddLight(lamp)
+	if len(snap.Lights) != 2 || len(snap.Targets) != 2 {
+		t.Fatalf("expected 2 lights from 2 targets, got %+v", snap)
+	}
+
+	// flash red, then put everything back.
+	fb.SetLightState("1", map[string]interface{}{"xy": []interface{}{0.7, 0.3}, "colormode": "xy", "effect": "colorloop"})
+	fb.SetLightState("2", map[string]interface{}{"hue": 20000.0, "sat": 254.0, "bri": 254.0})
+	if err := snap.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if st := fb.LightState("1"); st["colormode"] != "ct" || st["ct"] != 300.0 || st["bri"] != 100.0 {
+		t.Errorf("light 1 was not restored: %v", st)
+	}
+	if st := fb.LightState("2"); st["colormode"] != "hs" || st["sat"] != 0.0 || st["bri"] != 50.0 {
+		t.Errorf("light 2 was not restored: %v", st)
+	}
+	if hue := fb.LightState("2")["hue"].(float64); hue != 65535 && hue != 0 {
+		t.Errorf("light 2 hue was not restored: %v", hue)
+	}
+
+	// lights that were off come back on the way they were.
+	fb.SetLightState("1", map[string]interface{}{"on": false, "bri": 20.0, "ct": 400.0, "colormode": "ct"})
+	if err = Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	dark := NewSnapshot("dark")
+	dark.AddLight(lamp)
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 254.0, "xy": []interface{}{0.7, 0.3}, "colormode": "xy"})
+	if err := dark.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	if st := fb.LightState("1"); st["on"] != false || st["colormode"] != "ct" || st["ct"] != 400.0 || st["bri"] != 20.0 {
+		t.Errorf("light 1 was not restored while off: %v", st)
+	}
+
+	whole := NewSnapshot("whole")
+	if err := whole.AddBridge(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(whole.Lights) != 2 {
+		t.Errorf("expected every light on the bridge, got %d", len(whole.Lights))
+	}
+	if err := NewSnapshot("empty").Restore(); err == nil {
+		t.Error("expected an error restoring an empty snapshot")
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	off := restoreState(huego.State{On: false, Bri: 100, Ct: 300, Hue: 5, ColorMode: "ct"})
+	if off.On || off.Bri != 100 || off.Ct != 300 || off.Hue != 0 {
+		t.Errorf("lights that were off should get their attributes back: %+v", off)
+	}
+	xy := restoreState(huego.State{On: true, Bri: 10, Xy: []float32{0.1, 0.2}, Ct: 300, Hue: 5, ColorMode: "xy"})
+	if xy.Ct != 0 || xy.Hue != 0 || len(xy.Xy) != 2 {
+		t.Errorf("only xy should be restored: %+v", xy)
+	}
+}
@@ -0,0 +1,260 @@
+package ziggy
+
+import (
+	"errors"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/yunginnanet/huego"
+)
+
+// MaxTransition is the longest fade a bridge can do in one go, transitiontime is a uint16 counting 100ms steps.
+// Fades longer than this are split into steps that are chained in the background.
+var MaxTransition = time.Duration(math.MaxUint16) * 100 * time.Millisecond
+
+// Fader can apply a state change gradually.
+type Fader interface {
+	Fade(change huego.State, d time.Duration) error
+}
+
+// transitionTime converts d to the 100ms units of transitiontime. Zero is left out of requests by huego,
+// so the shortest transition we can ask for is 100ms.
+func transitionTime(d time.Duration) uint16 {
+	units := (d + 50*time.Millisecond) / (100 * time.Millisecond)
+	switch {
+	case units < 1:
+		return 1
+	case units > math.MaxUint16:
+		return math.MaxUint16
+	}
+	return uint16(units)
+}
+
+func lerp(from, to float64, frac float64) float64 {
+	return from + (to-from)*frac
+}
+
+// fadeStep returns step i of n for a fade from one state to another.
+// The last step is the change itself, the others move each attribute the change touches part of the way there.
+func fadeStep(from *huego.State, to huego.State, i, n int, d time.Duration) huego.State {
+	to.TransitionTime = transitionTime(d)
+	if i >= n || from == nil {
+		return to
+	}
+	frac := float64(i) / float64(n)
+	st := huego.State{On: from.On || to.On, TransitionTime: to.TransitionTime}
+	if i == 1 {
+		st.Effect, st.Alert = to.Effect, to.Alert
+	}
+	if to.Bri != 0 {
+		st.Bri = uint8(math.Round(lerp(float64(from.Bri), float64(to.Bri), frac)))
+	}
+	if to.Sat != 0 {
+		st.Sat = uint8(math.Round(lerp(float64(from.Sat), float64(to.Sat), frac)))
+	}
+	if to.Ct != 0 {
+		start := float64(from.Ct)
+		if start == 0 {
+			start = float64(to.Ct)
+		}
+		st.Ct = uint16(math.Round(lerp(start, float64(to.Ct), frac)))
+	}
+	if to.Hue != 0 {
+		// take the short way around the color wheel.
+		delta := float64(to.Hue) - float64(from.Hue)
+		if delta > 32768 {
+			delta -= 65536
+		} else if delta < -32768 {
+			delta += 65536
+		}
+		st.Hue = uint16(math.Mod(float64(from.Hue)+delta*frac+65536, 65536))
+	}
+	if len(to.Xy) == 2 {
+		st.Xy = to.Xy
+		if len(from.Xy) == 2 {
+			st.Xy = []float32{
+				float32(lerp(float64(from.Xy[0]), float64(to.Xy[0]), frac)),
+				float32(lerp(float64(from.Xy[1]), float64(to.Xy[1]), frac)),
+			}
+		}
+	}
+	return st
+}
+
+// fade sends change to k so that it completes over d. Fades longer than MaxTransition are split into steps,
+// the first step is sent right away and the rest are chained in the background until they finish or are superseded.
+// It returns the step that was sent.
+func (c *Bridge) fade(k dispatchKey, from *huego.State, change huego.State, d time.Duration) (huego.State, error) {
+	c.queue.stopFade(k)
+	steps := 1
+	// scenes are recalled as a whole, so they can't be split up.
+	if d > MaxTransition && change.Scene == "" {
+		steps = int((d + MaxTransition - 1) / MaxTransition)
+	}
+	if steps == 1 && d > MaxTransition {
+		d = MaxTransition
+	}
+	step := d / time.Duration(steps)
+	first := fadeStep(from, change, 1, steps, step)
+	if err := c.dispatch(k, first); err != nil || steps == 1 {
+		return first, err
+	}
+	if from != nil {
+		start := *from
+		from = &start
+	}
+	stop := c.queue.startFade(k)
+	go func() {
+		defer c.queue.endFade(k, stop)
+		for i := 2; i <= steps; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(step):
+			}
+			if err := c.dispatch(k, fadeStep(from, change, i, steps, step)); err != nil {
+				c.Log().Warn().Err(err).Int("step", i).Int("steps", steps).Msg("fade interrupted")
+				return
+			}
+		}
+	}()
+	return first, nil
+}
+
+func (d *dispatcher) startFade(k dispatchKey) chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	stop := make(chan struct{})
+	d.fades[k] = stop
+	return stop
+}
+
+// stopFade cancels the chained fade running on k, if any.
+func (d *dispatcher) stopFade(k dispatchKey) {
+	d.Lock()
+	defer d.Unlock()
+	if stop, ok := d.fades[k]; ok {
+		close(stop)
+		delete(d.fades, k)
+	}
+}
+
+// stopAll cancels every chained fade, e.g. because we're done with the bridge.
+func (d *dispatcher) stopAll() {
+	d.Lock()
+	defer d.Unlock()
+	for k, stop := range d.fades {
+		close(stop)
+		delete(d.fades, k)
+	}
+}
+
+func (d *dispatcher) endFade(k dispatchKey, stop chan struct{}) {
+	d.Lock()
+	defer d.Unlock()
+	if d.fades[k] == stop {
+		delete(d.fades, k)
+	}
+}
+
+// Fade applies change to the light over d.
+func (hl *HueLight) Fade(change huego.State, d time.Duration) error {
+	sent, err := hl.controller.fade(dispatchKey{id: hl.ID}, hl.CurrentState(), change, d)
+	if err != nil {
+		return err
+	}
+	if hl.State == nil {
+		hl.State = &huego.State{}
+	}
+	recordState(hl.State, sent)
+	return nil
+}
+
+// Fade applies change to the group over d.
+func (hg *HueGroup) Fade(change huego.State, d time.Duration) error {
+	sent, err := hg.controller.fade(dispatchKey{group: true, id: hg.ID}, hg.CurrentState(), change, d)
+	if err != nil {
+		return err
+	}
+	if hg.State == nil {
+		hg.State = &huego.State{}
+	}
+	recordState(hg.State, sent)
+	return nil
+}
+
+// Fade applies change to every member of the Multiplex over d. A scene in change is looked up by name or ID
+// on each group and left out for lights.
+func (mx *Multiplex) Fade(change huego.State, d time.Duration) error {
+	return mx.fanOut(func(t muxTarget) error {
+		if change.Scene == "" {
+			return t.Fade(change, d)
+		}
+		mine := change
+		mine.Scene = ""
+		if g, ok := t.(*HueGroup); ok {
+			id, err := g.sceneID(change.Scene)
+			if err != nil {
+				return err
+			}
+			mine.Scene = id
+		}
+		return t.Fade(mine, d)
+	})
+}
+
+// Transition collects changes to a target and then applies all of them together over Duration.
+// It has the same methods as the targets it wraps, so it can stand in for them while a command is being built.
+type Transition struct {
+	Duration time.Duration
+	target   Fader
+	change   huego.State
+	pending  bool
+}
+
+// NewTransition returns a Transition that fades target over d.
+func NewTransition(target Fader, d time.Duration) *Transition {
+	return &Transition{Duration: d, target: target}
+}
+
+func (tr *Transition) add(change huego.State) error {
+	mergeState(&tr.change, change)
+	tr.pending = true
+	return nil
+}
+
+func (tr *Transition) On() error                    { return tr.add(huego.State{On: true}) }
+func (tr *Transition) Off() error                   { return tr.add(huego.State{On: false}) }
+func (tr *Transition) Bri(n uint8) error            { return tr.add(huego.State{On: true, Bri: n}) }
+func (tr *Transition) Hue(n uint16) error           { return tr.add(huego.State{On: true, Hue: n}) }
+func (tr *Transition) Sat(n uint8) error            { return tr.add(huego.State{On: true, Sat: n}) }
+func (tr *Transition) Xy(n []float32) error         { return tr.add(huego.State{On: true, Xy: n}) }
+func (tr *Transition) Ct(n uint16) error            { return tr.add(huego.State{On: true, Ct: n}) }
+func (tr *Transition) Effect(n string) error        { return tr.add(huego.State{On: true, Effect: n}) }
+func (tr *Transition) Alert(n string) error         { return tr.add(huego.State{On: true, Alert: n}) }
+func (tr *Transition) SetState(s huego.State) error { return tr.add(s) }
+
+func (tr *Transition) Col(n color.Color) error {
+	xy, bri := huego.ConvertRGBToXy(n)
+	return tr.add(huego.State{On: true, Xy: xy, Bri: bri})
+}
+
+// Scene recalls a scene as part of the transition, only groups and multiplexes can do this.
+func (tr *Transition) Scene(id string) error {
+	if _, ok := tr.target.(*HueLight); ok {
+		return errors.New("scenes can only be recalled on groups")
+	}
+	tr.change.Scene = id
+	tr.change.On = true
+	tr.pending = true
+	return nil
+}
+
+// Commit fades the target to the collected changes.
+func (tr *Transition) Commit() error {
+	if !tr.pending {
+		return errors.New("nothing to transition")
+	}
+	return tr.target.Fade(tr.change, tr.Duration)
+}
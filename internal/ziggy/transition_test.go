@@ -0,0 +1,77 @@
+package ziggy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/yunginnanet/huego"
+)
+
+func TestFadeStep(t *testing.T) {
+	from := &huego.State{On: false, Bri: 0, Hue: 65000, Ct: 153}
+	to := huego.State{On: true, Bri: 200, Hue: 1000, Ct: 453}
+	st := fadeStep(from, to, 1, 2, time.Second)
+	if !st.On || st.Bri != 100 || st.Ct != 303 || st.TransitionTime != 10 {
+		t.Errorf("unexpected step: %+v", st)
+	}
+	// 65000 -> 1000 goes forward through 0 rather than all the way back.
+	if st.Hue < 65000 && st.Hue > 1000 {
+		t.Errorf("hue took the long way around: %d", st.Hue)
+	}
+	if last := fadeStep(from, to, 2, 2, time.Second); last.Bri != 200 || last.Hue != 1000 {
+		t.Errorf("last step should be the change itself: %+v", last)
+	}
+	if tt := transitionTime(0); tt != 1 {
+		t.Errorf("expected the shortest transition to be 100ms, got %d", tt)
+	}
+}
+
+func TestFadeChained(t *testing.T) {
+	fb, c := newTestBridge(t)
+	old := MaxTransition
+	MaxTransition = 100 * time.Millisecond
+	t.Cleanup(func() { MaxTransition = old })
+	lamp, err := c.FindLight("lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.SetLightState("1", map[string]interface{}{"on": true, "bri": 10.0})
+	if err := Registry.Load(c); err != nil {
+		t.Fatal(err)
+	}
+	fb.ResetRequests()
+
+	if err := lamp.Fade(huego.State{On: true, Bri: 210}, 400*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return fb.LightState("1")["bri"] == 210.0 })
+	var bris []float64
+	for _, r := range fb.Requests() {
+		var sent map[string]interface{}
+		if r.Method != "PUT" || json.Unmarshal(r.Body, &sent) != nil {
+			continue
+		}
+		if sent["transitiontime"] != 1.0 {
+			t.Errorf("steps should each take 100ms: %v", sent)
+		}
+		bris = append(bris, sent["bri"].(float64))
+	}
+	if len(bris) != 4 || bris[0] != 60 || bris[3] != 210 {
+		t.Errorf("unexpected steps: %v", bris)
+	}
+
+	// anything else sent to the light stops a fade that is still going.
+	if err := lamp.Fade(huego.State{On: true, Bri: 10}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := lamp.Off(); err != nil {
+		t.Fatal(err)
+	}
+	c.queue.Lock()
+	running := len(c.queue.fades)
+	c.queue.Unlock()
+	if running != 0 {
+		t.Error("fade was not stopped")
+	}
+}
@@ -0,0 +1,211 @@
+package ziggy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+)
+
+var (
+	// DefaultTimeout bounds requests to bridges that don't set a timeout in our config.
+	DefaultTimeout = 10 * time.Second
+	// DefaultConnectTimeout bounds connecting to bridges, or their proxy, that don't set one in our config.
+	DefaultConnectTimeout = 5 * time.Second
+)
+
+// certPin pins the public key of a bridge on first use, see config.KnownBridge.Fingerprint. Its certificate
+// must also be issued to the bridge ID, which bridges use as the common name. Until we know the ID, the first
+// certificate we see is remembered and checked once the bridge tells us its ID.
+type certPin struct {
+	id          string
+	fingerprint string
+	seen        string
+	*sync.Mutex
+}
+
+// keyFingerprint is the hex encoded SHA-256 of the public key of cert.
+func keyFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *certPin) verify(raw [][]byte, _ [][]*x509.Certificate) error {
+	if len(raw) == 0 {
+		return errors.New("bridge presented no certificate")
+	}
+	cert, err := x509.ParseCertificate(raw[0])
+	if err != nil {
+		return err
+	}
+	cn, fp := cert.Subject.CommonName, keyFingerprint(cert)
+	p.Lock()
+	defer p.Unlock()
+	if p.fingerprint != "" && !strings.EqualFold(fp, p.fingerprint) {
+		return fmt.Errorf("certificate key %s doesn't match the pinned key %s of the bridge", fp, p.fingerprint)
+	}
+	if p.id != "" && !strings.EqualFold(cn, p.id) {
+		return fmt.Errorf("certificate was issued to %q, expected bridge %s", cn, p.id)
+	}
+	p.fingerprint, p.seen = fp, cn
+	return nil
+}
+
+// pinned returns the fingerprint of the key the bridge is pinned to, or an empty string if it never presented one.
+func (p *certPin) pinned() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.fingerprint
+}
+
+// learn pins id once the bridge told us what it is, failing if it presented a certificate for another bridge.
+func (p *certPin) learn(id string) error {
+	p.Lock()
+	defer p.Unlock()
+	if p.seen != "" && !strings.EqualFold(p.seen, id) {
+		return fmt.Errorf("certificate was issued to %q, but the bridge says it is %s", p.seen, id)
+	}
+	p.id = id
+	return nil
+}
+
+// bridgeURL is where the v1 API of the bridge in kb lives.
+func bridgeURL(kb config.KnownBridge) string {
+	host := kb.Hostname
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	if kb.HTTPS {
+		host = "https://" + strings.TrimPrefix(host, "http://")
+	}
+	return host
+}
+
+// newHTTPClient returns the client used to talk to the bridge in kb. Every bridge gets its own,
+// so proxies and timeouts of one bridge never leak into another.
+func newHTTPClient(kb config.KnownBridge) (*http.Client, *certPin, error) {
+	timeout, connectTimeout := kb.Timeout, kb.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	pin := &certPin{id: knownID(kb), fingerprint: strings.ToLower(kb.Fingerprint), Mutex: &sync.Mutex{}}
+	direct := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	tr := &http.Transport{
+		DialContext:         direct.DialContext,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: connectTimeout,
+		// bridges sign their own certificates, so instead of the usual checks we pin them to the right bridge.
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify:    true, // #nosec -- see certPin
+			VerifyPeerCertificate: pin.verify,
+		},
+	}
+	if kb.Proxy != "" {
+		dial, err := proxyDialer(kb.Proxy, direct)
+		if err != nil {
+			return nil, nil, err
+		}
+		tr.DialContext = dial
+	}
+	return &http.Client{Transport: tr, Timeout: timeout}, pin, nil
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// proxyDialer parses a proxy from our config, see config.KnownBridge.Proxy.
+func proxyDialer(raw string, direct *net.Dialer) (dialFunc, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "socks5://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("bad proxy %q: %w", raw, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bad proxy %q: no host", raw)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		d, perr := proxy.FromURL(u, direct)
+		if perr != nil {
+			return nil, perr
+		}
+		if cd, ok := d.(proxy.ContextDialer); ok {
+			return cd.DialContext, nil
+		}
+		return func(_ context.Context, network, addr string) (net.Conn, error) {
+			return d.Dial(network, addr)
+		}, nil
+	case "http":
+		return connectDialer(u, direct), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, use socks5 or http", u.Scheme)
+	}
+}
+
+// connectDialer tunnels every connection through the HTTP proxy at u with CONNECT, even plain http ones,
+// so the proxy never sees our requests to the bridge.
+func connectDialer(u *url.URL, direct *net.Dialer) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := direct.DialContext(ctx, network, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		} else {
+			_ = conn.SetDeadline(time.Now().Add(direct.Timeout))
+		}
+		req := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			creds := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pass))
+			req.Header.Set("Proxy-Authorization", "Basic "+creds)
+		}
+		if err = req.Write(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			_ = conn.Close()
+			return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", u.Host, addr, resp.Status)
+		}
+		if br.Buffered() > 0 {
+			_ = conn.Close()
+			return nil, fmt.Errorf("proxy %s sent data before the tunnel was up", u.Host)
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
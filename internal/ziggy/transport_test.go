@@ -0,0 +1,207 @@
+package ziggy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"git.tcp.direct/kayos/ziggs/internal/config"
+	"git.tcp.direct/kayos/ziggs/internal/fakebridge"
+)
+
+// listen accepts connections on a local port and hands each of them to serve.
+func listen(t *testing.T, serve func(conn net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, aerr := l.Accept()
+			if aerr != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		_, _ = io.Copy(a, b)
+		_ = a.Close()
+	}()
+	_, _ = io.Copy(b, a)
+	_ = b.Close()
+}
+
+// socks5Proxy is just enough of a SOCKS5 server with username/password auth to reach IPv4 addresses.
+func socks5Proxy(t *testing.T, user, pass string, used *int32) string {
+	return listen(t, func(conn net.Conn) {
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(r, make([]byte, hdr[1])); err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte{5, 2})
+		// RFC 1929: version, username, password.
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return
+		}
+		u := make([]byte, hdr[1])
+		_, _ = io.ReadFull(r, u)
+		plen, _ := r.ReadByte()
+		p := make([]byte, plen)
+		_, _ = io.ReadFull(r, p)
+		if string(u) != user || string(p) != pass {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+		req := make([]byte, 10)
+		if _, err := io.ReadFull(r, req); err != nil || req[3] != 1 {
+			return
+		}
+		addr := net.JoinHostPort(net.IP(req[4:8]).String(), strconv.Itoa(int(binary.BigEndian.Uint16(req[8:]))))
+		upstream, err := net.Dial("tcp", addr)
+		if err != nil {
+			_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		atomic.AddInt32(used, 1)
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		pipe(upstream, conn)
+	})
+}
+
+// connectProxy is an HTTP proxy that only does CONNECT, with basic auth.
+func connectProxy(t *testing.T, user, pass string, used *int32) string {
+	return listen(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != http.MethodConnect {
+			_ = conn.Close()
+			return
+		}
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+		if req.Header.Get("Proxy-Authorization") != want {
+			_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			_ = conn.Close()
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+			_ = conn.Close()
+			return
+		}
+		atomic.AddInt32(used, 1)
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(upstream, conn)
+	})
+}
+
+func TestProxies(t *testing.T) {
+	fb := fakebridge.New()
+	t.Cleanup(fb.Close)
+	fb.AddLight("lamp")
+	user := fb.NewUser("ziggs#test")
+	var socksUsed, connectUsed int32
+	socks := socks5Proxy(t, "kayos", "hunter2", &socksUsed)
+	connect := connectProxy(t, "kayos", "hunter2", &connectUsed)
+
+	for _, tc := range []struct {
+		proxy string
+		used  *int32
+		ok    bool
+	}{
+		{"socks5://kayos:hunter2@" + socks, &socksUsed, true},
+		{"socks5://kayos:wrong@" + socks, &socksUsed, false},
+		{"http://kayos:hunter2@" + connect, &connectUsed, true},
+		{"http://kayos:wrong@" + connect, &connectUsed, false},
+		{"ftp://" + connect, &connectUsed, false},
+	} {
+		before := atomic.LoadInt32(tc.used)
+		c, err := newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: user, Proxy: tc.proxy})
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.proxy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.proxy, err)
+		}
+		if c.Info.BridgeID != fb.ID || atomic.LoadInt32(tc.used) == before {
+			t.Errorf("%s: bridge wasn't reached through the proxy", tc.proxy)
+		}
+	}
+	if http.DefaultClient.Transport != nil {
+		t.Error("proxies shouldn't touch the default client")
+	}
+
+	// a proxy for one bridge has nothing to do with another.
+	before := atomic.LoadInt32(&socksUsed)
+	if _, err := newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: user}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&socksUsed) != before {
+		t.Error("bridge without a proxy went through one")
+	}
+}
+
+func TestHTTPSPinned(t *testing.T) {
+	fb := fakebridge.NewTLS()
+	t.Cleanup(fb.Close)
+	fb.AddLight("lamp")
+	user := fb.NewUser("ziggs#test")
+	plain := "http://" + strings.TrimPrefix(fb.Hostname(), "https://")
+
+	kb := &config.KnownBridge{Hostname: plain, Username: user, HTTPS: true}
+	c, err := newController(kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kb.Fingerprint) != 64 {
+		t.Errorf("key of the bridge wasn't pinned: %q", kb.Fingerprint)
+	}
+	if !strings.HasPrefix(c.Host, "https://") {
+		t.Errorf("expected https, got %s", c.Host)
+	}
+	// the v2 API goes through the same pinned client.
+	if _, err = c.V2Resources(); err != nil {
+		t.Errorf("v2 API over https: %v", err)
+	}
+	if _, err = newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: user, ID: fb.ID}); err != nil {
+		t.Errorf("certificate of the right bridge should be accepted: %v", err)
+	}
+	_, err = newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: user, ID: "001788FFFE999999"})
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("certificate of another bridge should be rejected, got %v", err)
+	}
+
+	// a bridge that claims the address, but not with the key we pinned.
+	impostor := fakebridge.NewTLS()
+	t.Cleanup(impostor.Close)
+	_, err = newController(&config.KnownBridge{Hostname: impostor.Hostname(), Username: impostor.NewUser("ziggs#test"), Fingerprint: kb.Fingerprint})
+	if err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("certificate with another key should be rejected, got %v", err)
+	}
+	if _, err = newController(&config.KnownBridge{Hostname: fb.Hostname(), Username: user, Fingerprint: kb.Fingerprint}); err != nil {
+		t.Errorf("pinned key should be accepted: %v", err)
+	}
+}
@@ -7,14 +7,16 @@ import (
 	"github.com/yunginnanet/huego"
 )
 
+// FindLight resolves a light by its ID on this bridge, or by its bare or bridge-qualified name.
+// IDs may be qualified too, e.g. 001788FFFE123456/3 is light 3 on that bridge.
 func (c *Bridge) FindLight(input string) (light *HueLight, err error) {
+	if targ, ok := LookupLight(input, c); ok {
+		return targ, nil
+	}
+	c, input = c.qualifier(input)
 	var lightID int
 	if lightID, err = strconv.Atoi(input); err != nil {
-		targ, ok := GetLightMap()[input]
-		if !ok {
-			return nil, fmt.Errorf("unable to resolve light ID from input: %s", input)
-		}
-		return targ, nil
+		return nil, fmt.Errorf("unable to resolve light ID from input: %s", input)
 	}
 	l, err := c.GetLight(lightID)
 	if err != nil {
@@ -23,14 +25,15 @@ func (c *Bridge) FindLight(input string) (light *HueLight, err error) {
 	return &HueLight{Light: l, controller: c}, nil
 }
 
+// FindGroup resolves a group by its ID on this bridge, or by its bare or bridge-qualified name.
 func (c *Bridge) FindGroup(input string) (light *HueGroup, err error) {
+	if targ, ok := LookupGroup(input, c); ok {
+		return targ, nil
+	}
+	c, input = c.qualifier(input)
 	var groupID int
 	if groupID, err = strconv.Atoi(input); err != nil {
-		targ, ok := GetGroupMap()[input]
-		if !ok {
-			return nil, fmt.Errorf("unable to resolve light ID from input: %s", input)
-		}
-		return targ, nil
+		return nil, fmt.Errorf("unable to resolve group ID from input: %s", input)
 	}
 	var hg *huego.Group
 	if hg, err = c.GetGroup(groupID); err != nil {
@@ -40,14 +43,15 @@ func (c *Bridge) FindGroup(input string) (light *HueGroup, err error) {
 	return &HueGroup{Group: hg, controller: c}, nil
 }
 
+// FindSensor resolves a sensor by its ID on this bridge, or by its bare or bridge-qualified name.
 func (c *Bridge) FindSensor(input string) (light *HueSensor, err error) {
+	if targ, ok := LookupSensor(input, c); ok {
+		return targ, nil
+	}
+	c, input = c.qualifier(input)
 	var sensorID int
 	if sensorID, err = strconv.Atoi(input); err != nil {
-		targ, ok := GetSensorMap()[input]
-		if !ok {
-			return nil, fmt.Errorf("unable to resolve light ID from input: %s", input)
-		}
-		return targ, nil
+		return nil, fmt.Errorf("unable to resolve sensor ID from input: %s", input)
 	}
 	var hs *huego.Sensor
 	if hs, err = c.GetSensor(sensorID); err != nil {
@@ -57,6 +61,15 @@ func (c *Bridge) FindSensor(input string) (light *HueSensor, err error) {
 	return &HueSensor{Sensor: hs, controller: c}, nil
 }
 
+// qualifier returns the bridge that input is qualified with and the rest of input.
+// Unqualified input belongs to c.
+func (c *Bridge) qualifier(input string) (*Bridge, string) {
+	if qc, name := SplitQualifiedName(input); qc != nil {
+		return qc, name
+	}
+	return c, input
+}
+
 func (hg *HueGroup) Scenes() ([]*HueScene, error) {
 	scenes, err := hg.controller.GetScenes()
 	if err != nil {
@@ -0,0 +1,120 @@
+package ziggy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"git.tcp.direct/kayos/ziggs/internal/clipv2"
+)
+
+var (
+	// ClipV2Scheme is how we reach the CLIP v2 API of bridges, real bridges only serve it over https.
+	ClipV2Scheme = "https"
+	// V2CacheTTL is how long we reuse the resources we fetched from the v2 API of a bridge, or the error we got instead.
+	V2CacheTTL = 10 * time.Second
+)
+
+type v2Cache struct {
+	client  *clipv2.Client
+	res     *clipv2.Resources
+	err     error
+	fetched time.Time
+	*sync.Mutex
+}
+
+// v2Base is where the CLIP v2 API of c lives, the caller holds at least a read lock.
+func (c *Bridge) v2Base() string {
+	host := c.Host
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	return ClipV2Scheme + "://" + strings.SplitN(host, "/", 2)[0]
+}
+
+func (c *Bridge) v2cache() *v2Cache {
+	c.Lock()
+	defer c.Unlock()
+	if c.v2 == nil {
+		client := clipv2.NewClient(c.v2Base(), c.User)
+		if c.client != nil {
+			client = clipv2.NewClientWith(c.v2Base(), c.User, c.client)
+		}
+		c.v2 = &v2Cache{client: client, Mutex: &sync.Mutex{}}
+	}
+	return c.v2
+}
+
+// V2 returns a CLIP v2 client for the bridge.
+func (c *Bridge) V2() *clipv2.Client {
+	return c.v2cache().client
+}
+
+// V2Resources returns every resource on the bridge according to the v2 API, fetching them at most once per V2CacheTTL.
+func (c *Bridge) V2Resources() (*clipv2.Resources, error) {
+	cache := c.v2cache()
+	cache.Lock()
+	defer cache.Unlock()
+	if !cache.fetched.IsZero() && time.Since(cache.fetched) < V2CacheTTL {
+		return cache.res, cache.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cache.res, cache.err = cache.client.Resources(ctx)
+	if cache.err != nil {
+		cache.err = fmt.Errorf("v2 API of %s unavailable: %w", c.bridgeID(), cache.err)
+	}
+	cache.fetched = time.Now()
+	return cache.res, cache.err
+}
+
+// DeviceStatus returns what the v2 API knows about the device behind the v1 resource at idV1, e.g. /lights/1.
+func (c *Bridge) DeviceStatus(idV1 string) (clipv2.Status, error) {
+	res, err := c.V2Resources()
+	if err != nil {
+		return clipv2.Status{}, err
+	}
+	st, ok := res.Status(idV1)
+	if !ok {
+		return st, fmt.Errorf("no v2 device found for %s", idV1)
+	}
+	return st, nil
+}
+
+// Status returns the device status of the light from the v2 API, e.g. its zigbee connectivity.
+func (hl *HueLight) Status() (clipv2.Status, error) {
+	return hl.controller.DeviceStatus(fmt.Sprintf("/lights/%d", hl.ID))
+}
+
+// Status returns the device status of the sensor from the v2 API, e.g. its battery level.
+func (hs *HueSensor) Status() (clipv2.Status, error) {
+	return hs.controller.DeviceStatus(fmt.Sprintf("/sensors/%d", hs.ID))
+}
+
+// V2ID returns the v2 ID of the light.
+func (hl *HueLight) V2ID() (string, error) {
+	res, err := hl.controller.V2Resources()
+	if err != nil {
+		return "", err
+	}
+	id, ok := res.V2(fmt.Sprintf("/lights/%d", hl.ID), clipv2.TypeLight)
+	if !ok {
+		return "", fmt.Errorf("light %d has no v2 ID", hl.ID)
+	}
+	return id, nil
+}
+
+// V2ID returns the v2 ID of the group's grouped_light, which is what v2 controls groups through.
+func (hg *HueGroup) V2ID() (string, error) {
+	res, err := hg.controller.V2Resources()
+	if err != nil {
+		return "", err
+	}
+	id, ok := res.V2(fmt.Sprintf("/groups/%d", hg.ID), clipv2.TypeGroupedLight)
+	if !ok {
+		return "", fmt.Errorf("group %d has no v2 ID", hg.ID)
+	}
+	return id, nil
+}
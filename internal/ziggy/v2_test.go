@@ -0,0 +1,41 @@
+package ziggy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceStatus(t *testing.T) {
+	ClipV2Scheme = "http"
+	t.Cleanup(func() { ClipV2Scheme = "https" })
+	fb, c := newTestBridge(t)
+
+	lamp, ok := LookupLight("lamp", c)
+	if !ok {
+		t.Fatal("lamp not found")
+	}
+	st, err := lamp.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Device != "lamp" || st.Connectivity != "connected" {
+		t.Errorf("unexpected status: %+v", st)
+	}
+	if _, err = lamp.V2ID(); err != nil {
+		t.Error(err)
+	}
+
+	// resources are cached, so the change only shows up once the cache expires.
+	fb.SetReachable("lights", "1", false)
+	if st, _ = lamp.Status(); st.Connectivity != "connected" {
+		t.Errorf("expected the cached status, got %+v", st)
+	}
+	V2CacheTTL = time.Nanosecond
+	t.Cleanup(func() { V2CacheTTL = 10 * time.Second })
+	if st, _ = lamp.Status(); st.Connectivity != "connectivity_issue" {
+		t.Errorf("expected a fresh status, got %+v", st)
+	}
+	if _, err = c.DeviceStatus("/lights/9"); err == nil {
+		t.Error("expected an error for a missing light")
+	}
+}
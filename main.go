@@ -2,11 +2,12 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"git.tcp.direct/kayos/common/squish"
@@ -18,7 +19,6 @@ import (
 	"git.tcp.direct/kayos/ziggs/internal/common"
 	"git.tcp.direct/kayos/ziggs/internal/config"
 	"git.tcp.direct/kayos/ziggs/internal/data"
-	"git.tcp.direct/kayos/ziggs/internal/haptic"
 	"git.tcp.direct/kayos/ziggs/internal/ziggy"
 )
 
@@ -59,23 +59,36 @@ func init() {
 	aesthetic()
 }
 
-func TurnAll(Known []*ziggy.Bridge, mode ziggy.ToggleMode) {
+// TurnAll sets every light to mode and waits until they all got there, or gave up trying.
+func TurnAll(Known []*ziggy.Bridge, mode ziggy.ToggleMode) error {
+	var (
+		wg     sync.WaitGroup
+		failed atomic.Int32
+	)
 	for _, bridge := range Known {
-		for _, l := range ziggy.GetLightMap() {
+		for _, l := range ziggy.LightList() {
+			wg.Add(1)
 			go func(l *ziggy.HueLight, b *ziggy.Bridge) {
+				defer wg.Done()
 				log.Debug().
 					Str("caller", b.Host).
 					Str("type", l.ProductName).
 					Bool("on", l.IsOn()).Msg(l.ModelID)
 				ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
+				defer cancel()
 				err := ziggy.Assert(ctx, l, mode)
 				if err != nil {
 					log.Error().Err(err).Msg("failed to assert state")
+					failed.Add(1)
 				}
-				defer cancel()
 			}(l, bridge)
 		}
 	}
+	wg.Wait()
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d lights failed", n)
+	}
+	return nil
 }
 
 func FindLights(ctx context.Context, c *ziggy.Bridge) error {
@@ -143,89 +156,63 @@ func selSensor(Sensors []*huego.Sensor) huego.Sensor {
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run does what ziggs was started to do and returns the status it exits with.
+func run() int {
 	var Known []*ziggy.Bridge
 	var err error
+
+	// discovery and pairing don't need any bridges to be set up, and shouldn't prompt for them.
+	if ran, serr := cli.RunStandalone(os.Args[1:]); ran {
+		if serr != nil {
+			log.Error().Err(serr).Msg(os.Args[1] + " failed")
+			return 1
+		}
+		return 0
+	}
+
 	Known, err = ziggy.Setup()
 
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to get bridges")
+		log.Error().Err(err).Msg("failed to get bridges")
+		return 1
 	}
 
 	log = config.GetLogger()
 	cli.ProcessBridges()
-	go func() {
-		cli.ProcessGroups(ziggy.GetGroupMap())
-		cli.ProcessLights(ziggy.GetLightMap())
-		cli.ProcessScenes(ziggy.GetSceneMap())
-	}()
-
-	data.Start()
 	defer data.Close()
 
-	if len(os.Args) < 2 {
+	// watching the bridges, history and automations are for as long as the shell runs,
+	// a single command is done before any of them would get to do anything.
+	if len(os.Args) < 2 || os.Args[1] == "shell" {
+		go cli.ProcessAll()
+		ziggy.Registry.OnChange(cli.RefreshSuggestions)
+		ziggy.Registry.WatchAll(context.Background())
+		ziggy.Supervisor.Start(context.Background())
+		data.Start()
+		cli.StartHistory(context.Background())
+		if err = cli.StartAutomations(context.Background()); err != nil {
+			log.Warn().Err(err).Msg("failed to load automations")
+		}
 		cli.StartCLI()
+		return 0
 	}
 
-	for i, arg := range os.Args {
-		switch arg {
-		case "events":
-			evch := make(chan string, 10)
-			go func() {
-				log.Info().Msg("starting event client")
-				defer log.Warn().Msg("event client stopped")
-				for e := range evch {
-					e = strings.TrimPrefix(e, "data: ")
-					os.Stdout.WriteString(e)
-					os.Stdout.WriteString("\n")
-				}
-			}()
-
-			evc := haptic.NewEventClient()
-			evc.Subscribe("*", evch)
-			if err = evc.Start(config.KnownBridges[0].Hostname, config.KnownBridges[0].Username); err != nil &&
-				!errors.Is(err, context.Canceled) && !errors.Is(err, io.EOF) {
-				log.Fatal().Err(err).Msg("failed to start event client")
-			}
-			close(evch)
-			return
-		case "discover":
-
-		case "on":
-			log.Debug().Msg("turning all " + arg)
-			TurnAll(Known, ziggy.ToggleOn)
-		case "off":
-			log.Debug().Msg("turning all " + arg)
-			TurnAll(Known, ziggy.ToggleOff)
-		case "rainbow":
-			log.Debug().Msg("turning all " + arg)
-			TurnAll(Known, ziggy.ToggleRainbow)
-		case "scan":
-			log.Debug().Msg("executing " + arg)
-			if len(os.Args) < 2 {
-				for _, k := range Known {
-					FindLights(context.TODO(), k)
-				}
-			}
-		case "shell":
-			cli.StartCLI()
-		case "newsensor":
-			getNewSensors(Known[0])
-		case "sensors":
-			sens, err := Known[0].GetSensors()
-			if err != nil {
-				log.Fatal().Err(err).Msg("-")
-			}
-			var sensptr []*huego.Sensor
-			for _, s := range sens {
-				sensptr = append(sensptr, &s)
-			}
-			selSensor(sensptr)
-		case "--":
-			log.Debug().Msg("executing " + strings.Join(os.Args[i+1:], " "))
-			cli.Executor(strings.Join(os.Args[i+1:], " "))
+	switch arg := os.Args[1]; arg {
+	case "on", "off", "rainbow":
+		log.Debug().Msg("turning all " + arg)
+		modes := map[string]ziggy.ToggleMode{"on": ziggy.ToggleOn, "off": ziggy.ToggleOff, "rainbow": ziggy.ToggleRainbow}
+		if err = TurnAll(Known, modes[arg]); err != nil {
+			log.Error().Err(err).Msg("failed to turn all " + arg)
+			return 1
 		}
+		return 0
+	case "newsensor":
+		getNewSensors(Known[0])
+		return 0
 	}
 
-	done := make(chan struct{}, 1)
-	<-done
+	return cli.Run(os.Args[1:])
 }